@@ -35,6 +35,7 @@ import "encoding/binary"
 import "sort"
 import "bytes"
 import "fmt"
+import "time"
 
 var EExist = fmt.Errorf("EExist")
 
@@ -45,6 +46,19 @@ func (aexp) Retain(b []byte) bool {
 	if v.decode(b)!=nil { return true }
 	return current < v.Expire
 }
+func (aexp) RetainKV(k, b []byte, now time.Time) bool {
+	var v value
+	if v.decode(b)!=nil { return true }
+	return uint64(now.Unix()) < v.Expire
+}
+func (aexp) ExpireAt(k, b []byte) (time.Time,bool) {
+	var v value
+	if v.decode(b)!=nil { return time.Time{},false }
+	return time.Unix(int64(v.Expire),0),true
+}
+
+// Expired entries are hidden from readers as well.
+var idxConfig = &leveldb.Config{Expiration: leveldb.Expiration{AutoExpire: aexp{}, OnRead: true}}
 
 
 type value struct{
@@ -104,9 +118,9 @@ type Store struct {
 func OpenStore(idx, data storage.Storage) (s *Store,err error) {
 	s = new(Store)
 	
-	s.db,err = leveldb.Open(idx,nil,aexp{})
+	s.db,err = leveldb.OpenWithConfig(idx,nil,idxConfig)
 	if errors.IsCorrupted(err) {
-		s.db,err = leveldb.Recover(idx,nil,aexp{})
+		s.db,err = leveldb.RecoverWithConfig(idx,nil,idxConfig)
 	}
 	
 	s.data = data
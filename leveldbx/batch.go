@@ -10,6 +10,7 @@ import (
 	"encoding/binary"
 	"fmt"
 	"io"
+	"time"
 
 	"github.com/syndtr/goleveldb/leveldb/errors"
 	"github.com/syndtr/goleveldb/leveldb/memdb"
@@ -34,6 +35,15 @@ const (
 	batchHeaderLen = 8 + 4
 	batchGrowRec   = 3000
 	batchBufioSize = 16
+
+	// Flags a journaled batch, whose records were committed to tables, see
+	// DB.writeJournalCommitted.
+	batchCommitted = 1 << 31
+
+	// Flags a record of type keyTypeValTTL, whose value is prefixed by a
+	// time-to-live instead of a deadline. Such records live in a Batch only,
+	// until it is written, see Batch.PutTTL.
+	batchRelTTL = 0x80
 )
 
 // BatchReplay wraps basic batch operations.
@@ -42,8 +52,41 @@ type BatchReplay interface {
 	Delete(key []byte)
 }
 
+// BatchReplayTTL is implemented by a BatchReplay, that wants to receive the
+// expiration deadline of records written with a time-to-live. Otherwise
+// such records are replayed using Put.
+type BatchReplayTTL interface {
+	BatchReplay
+	PutExpireAt(key, value []byte, expireAt time.Time)
+}
+
+// BatchReplayRelTTL is implemented by a BatchReplay, that wants to receive
+// the time-to-live of records written by Batch.PutTTL, which are not resolved
+// until the batch is written. Otherwise such records are replayed using Put.
+type BatchReplayRelTTL interface {
+	BatchReplay
+	PutTTL(key, value []byte, ttl time.Duration)
+}
+
+// BatchReplayMerge is implemented by a BatchReplay, that supports merge
+// operands. Replaying a batch containing merge operands into a BatchReplay,
+// that doesn't, fails with ErrNoMergeOperator.
+type BatchReplayMerge interface {
+	BatchReplay
+	Merge(key, operand []byte)
+}
+
+// BatchReplayRange is implemented by a BatchReplay, that supports range
+// deletions. Replaying a batch containing range deletions into a BatchReplay,
+// that doesn't, fails with ErrNoDeleteRange.
+type BatchReplayRange interface {
+	BatchReplay
+	DeleteRange(start, limit []byte)
+}
+
 type batchIndex struct {
 	keyType            keyType
+	relTTL             bool
 	keyPos, keyLen     int
 	valuePos, valueLen int
 }
@@ -70,6 +113,9 @@ type Batch struct {
 
 	// internalLen is sums of key/value pair length plus 8-bytes internal key.
 	internalLen int
+
+	// relTTL is the number of records with an unresolved time-to-live.
+	relTTL int
 }
 
 func (b *Batch) grow(n int) {
@@ -87,7 +133,7 @@ func (b *Batch) grow(n int) {
 
 func (b *Batch) appendRec(kt keyType, key, value []byte) {
 	n := 1 + binary.MaxVarintLen32 + len(key)
-	if kt == keyTypeVal {
+	if kt != keyTypeDel {
 		n += binary.MaxVarintLen32 + len(value)
 	}
 	b.grow(n)
@@ -100,7 +146,7 @@ func (b *Batch) appendRec(kt keyType, key, value []byte) {
 	index.keyPos = o
 	index.keyLen = len(key)
 	o += copy(data[o:], key)
-	if kt == keyTypeVal {
+	if kt != keyTypeDel {
 		o += binary.PutUvarint(data[o:], uint64(len(value)))
 		index.valuePos = o
 		index.valueLen = len(value)
@@ -118,6 +164,30 @@ func (b *Batch) Put(key, value []byte) {
 	b.appendRec(keyTypeVal, key, value)
 }
 
+// PutTTL appends 'put operation' of the given key/value pair to the batch,
+// which expires after the given time-to-live. The deadline is computed from
+// the clock of the database (see Expiration.Clock), when the batch is
+// written; a batch written more than once gets a new deadline each time.
+// It is safe to modify the contents of the argument after PutTTL returns but
+// not before.
+func (b *Batch) PutTTL(key, value []byte, ttl time.Duration) {
+	o := len(b.data)
+	b.appendRec(keyTypeValTTL, key, appendTTLValue(nil, int64(ttl), value))
+	b.data[o] |= batchRelTTL
+	b.index[len(b.index)-1].relTTL = true
+	b.relTTL++
+}
+
+// PutExpireAt appends 'put operation' of the given key/value pair to the
+// batch, which expires at the given deadline. The deadline is compared against
+// the clock of the database (see Expiration.Clock); PutTTL takes a
+// time-to-live relative to that clock instead.
+// It is safe to modify the contents of the argument after PutExpireAt returns
+// but not before.
+func (b *Batch) PutExpireAt(key, value []byte, expireAt time.Time) {
+	b.appendRec(keyTypeValTTL, key, appendTTLValue(nil, expireAt.UnixNano(), value))
+}
+
 // Delete appends 'delete operation' of the given key to the batch.
 // It is safe to modify the contents of the argument after Delete returns but
 // not before.
@@ -125,6 +195,24 @@ func (b *Batch) Delete(key []byte) {
 	b.appendRec(keyTypeDel, key, nil)
 }
 
+// Merge appends 'merge operand' operation of the given key/operand pair to
+// the batch. The operand is combined with the value of the key by the
+// MergeOperator of the DB (see Config).
+// It is safe to modify the contents of the argument after Merge returns but
+// not before.
+func (b *Batch) Merge(key, operand []byte) {
+	b.appendRec(keyTypeMerge, key, operand)
+}
+
+// DeleteRange appends 'range deletion' of the keys start <= key < limit to
+// the batch. It deletes the keys written before, including those written
+// earlier in the same batch; keys written after it are not affected.
+// It is safe to modify the contents of the arguments after DeleteRange
+// returns but not before.
+func (b *Batch) DeleteRange(start, limit []byte) {
+	b.appendRec(keyTypeRangeDel, start, limit)
+}
+
 // Dump dumps batch contents. The returned slice can be loaded into the
 // batch using Load method.
 // The returned slice is not its own copy, so the contents should not be
@@ -147,8 +235,33 @@ func (b *Batch) Replay(r BatchReplay) error {
 		switch index.keyType {
 		case keyTypeVal:
 			r.Put(index.k(b.data), index.v(b.data))
+		case keyTypeValTTL:
+			deadline, value := splitValue(index.keyType, index.v(b.data))
+			if index.relTTL {
+				if rt, ok := r.(BatchReplayRelTTL); ok {
+					rt.PutTTL(index.k(b.data), value, time.Duration(deadline))
+				} else {
+					r.Put(index.k(b.data), value)
+				}
+			} else if rt, ok := r.(BatchReplayTTL); ok {
+				rt.PutExpireAt(index.k(b.data), value, time.Unix(0, deadline))
+			} else {
+				r.Put(index.k(b.data), value)
+			}
 		case keyTypeDel:
 			r.Delete(index.k(b.data))
+		case keyTypeMerge:
+			rm, ok := r.(BatchReplayMerge)
+			if !ok {
+				return ErrNoMergeOperator
+			}
+			rm.Merge(index.k(b.data), index.v(b.data))
+		case keyTypeRangeDel:
+			rr, ok := r.(BatchReplayRange)
+			if !ok {
+				return ErrNoDeleteRange
+			}
+			rr.DeleteRange(index.k(b.data), index.v(b.data))
 		}
 	}
 	return nil
@@ -164,6 +277,7 @@ func (b *Batch) Reset() {
 	b.data = b.data[:0]
 	b.index = b.index[:0]
 	b.internalLen = 0
+	b.relTTL = 0
 }
 
 func (b *Batch) replayInternal(fn func(i int, kt keyType, k, v []byte) error) error {
@@ -175,12 +289,31 @@ func (b *Batch) replayInternal(fn func(i int, kt keyType, k, v []byte) error) er
 	return nil
 }
 
+// Returns the batch with the time-to-live of its records resolved into
+// deadlines relative to now, or the batch itself, if there are none.
+func (b *Batch) resolveTTL(now time.Time) *Batch {
+	if b.relTTL == 0 {
+		return b
+	}
+	rb := &Batch{data: make([]byte, 0, len(b.data))}
+	for _, index := range b.index {
+		k, v := index.kv(b.data)
+		if index.relTTL {
+			ttl, value := splitValue(index.keyType, v)
+			v = appendTTLValue(nil, now.Add(time.Duration(ttl)).UnixNano(), value)
+		}
+		rb.appendRec(index.keyType, k, v)
+	}
+	return rb
+}
+
 func (b *Batch) append(p *Batch) {
 	ob := len(b.data)
 	oi := len(b.index)
 	b.data = append(b.data, p.data...)
 	b.index = append(b.index, p.index...)
 	b.internalLen += p.internalLen
+	b.relTTL += p.relTTL
 
 	// Updating index offset.
 	if ob != 0 {
@@ -198,9 +331,13 @@ func (b *Batch) decode(data []byte, expectedLen int) error {
 	b.data = data
 	b.index = b.index[:0]
 	b.internalLen = 0
+	b.relTTL = 0
 	err := decodeBatch(data, func(i int, index batchIndex) error {
 		b.index = append(b.index, index)
 		b.internalLen += index.keyLen + index.valueLen + 8
+		if index.relTTL {
+			b.relTTL++
+		}
 		return nil
 	})
 	if err != nil {
@@ -212,18 +349,21 @@ func (b *Batch) decode(data []byte, expectedLen int) error {
 	return nil
 }
 
-func (b *Batch) putMem(seq uint64, mdb *memdb.DB) error {
+func (b *Batch) putMem(seq uint64, mdb *memDB) error {
 	var ik []byte
 	for i, index := range b.index {
 		ik = makeInternalKey(ik, index.k(b.data), seq+uint64(i), index.keyType)
 		if err := mdb.Put(ik, index.v(b.data)); err != nil {
 			return err
 		}
+		if index.keyType == keyTypeRangeDel {
+			mdb.addRangeDel(index.k(b.data), index.v(b.data), seq+uint64(i))
+		}
 	}
 	return nil
 }
 
-func (b *Batch) revertMem(seq uint64, mdb *memdb.DB) error {
+func (b *Batch) revertMem(seq uint64, mdb *memDB) error {
 	var ik []byte
 	for i, index := range b.index {
 		ik = makeInternalKey(ik, index.k(b.data), seq+uint64(i), index.keyType)
@@ -231,6 +371,7 @@ func (b *Batch) revertMem(seq uint64, mdb *memdb.DB) error {
 			return err
 		}
 	}
+	mdb.revertRangeDels(seq)
 	return nil
 }
 
@@ -242,9 +383,10 @@ func decodeBatch(data []byte, fn func(i int, index batchIndex) error) error {
 	var index batchIndex
 	for i, o := 0, 0; o < len(data); i++ {
 		// Key type.
-		index.keyType = keyType(data[o])
-		if index.keyType > keyTypeVal {
-			return newErrBatchCorrupted(fmt.Sprintf("bad record: invalid type %#x", uint(index.keyType)))
+		index.keyType = keyType(data[o] &^ batchRelTTL)
+		index.relTTL = data[o]&batchRelTTL != 0
+		if index.keyType > keyTypeRangeDel || index.relTTL && index.keyType != keyTypeValTTL {
+			return newErrBatchCorrupted(fmt.Sprintf("bad record: invalid type %#x", uint(data[o])))
 		}
 		o++
 
@@ -259,7 +401,7 @@ func decodeBatch(data []byte, fn func(i int, index batchIndex) error) error {
 		o += index.keyLen
 
 		// Value.
-		if index.keyType == keyTypeVal {
+		if index.keyType != keyTypeDel {
 			x, n = binary.Uvarint(data[o:])
 			o += n
 			if n <= 0 || o+int(x) > len(data) {
@@ -295,6 +437,9 @@ func decodeBatchToMem(data []byte, expectSeq uint64, mdb *memdb.DB) (seq uint64,
 		if i >= batchLen {
 			return newErrBatchCorrupted("invalid records length")
 		}
+		if index.relTTL {
+			return newErrBatchCorrupted("bad record: unresolved time-to-live")
+		}
 		ik = makeInternalKey(ik, index.k(data), seq+uint64(i), index.keyType)
 		if err := mdb.Put(ik, index.v(data)); err != nil {
 			return err
@@ -321,13 +466,20 @@ func decodeBatchHeader(data []byte) (seq uint64, batchLen int, err error) {
 	}
 
 	seq = binary.LittleEndian.Uint64(data)
-	batchLen = int(binary.LittleEndian.Uint32(data[8:]))
+	batchLen = int(binary.LittleEndian.Uint32(data[8:]) &^ batchCommitted)
 	if batchLen < 0 {
 		return 0, 0, newErrBatchCorrupted("invalid records length")
 	}
 	return
 }
 
+// Reports whether the journaled batch was committed to tables; its records are
+// replayed only, if the manifest lacks the sequence number, see
+// DB.writeJournalCommitted.
+func isCommittedBatch(data []byte) bool {
+	return len(data) >= batchHeaderLen && binary.LittleEndian.Uint32(data[8:])&batchCommitted != 0
+}
+
 func batchesLen(batches []*Batch) int {
 	batchLen := 0
 	for _, batch := range batches {
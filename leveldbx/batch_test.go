@@ -16,10 +16,16 @@ import (
 )
 
 func TestBatchHeader(t *testing.T) {
-	f := func(seq uint64, length uint32) bool {
-		encoded := encodeBatchHeader(nil, seq, int(length))
+	f := func(seq uint64, length uint32, committed bool) bool {
+		length &^= batchCommitted
+		flag := int(length)
+		if committed {
+			flag |= batchCommitted
+		}
+		encoded := encodeBatchHeader(nil, seq, flag)
 		decSeq, decLength, err := decodeBatchHeader(encoded)
-		return err == nil && decSeq == seq && decLength == int(length)
+		return err == nil && decSeq == seq && decLength == int(length) &&
+			isCommittedBatch(encoded) == committed
 	}
 	config := &quick.Config{
 		Rand: testutil.NewRand(),
@@ -16,9 +16,11 @@ import (
 	"sync/atomic"
 	"testing"
 
+	"github.com/syndtr/goleveldb/leveldb/filter"
 	"github.com/syndtr/goleveldb/leveldb/iterator"
 	"github.com/syndtr/goleveldb/leveldb/opt"
 	"github.com/syndtr/goleveldb/leveldb/storage"
+	"github.com/syndtr/goleveldb/leveldb/util"
 )
 
 func randomString(r *rand.Rand, n int) []byte {
@@ -69,9 +71,10 @@ type dbBench struct {
 	stor storage.Storage
 	db   *DB
 
-	o  *opt.Options
-	ro *opt.ReadOptions
-	wo *opt.WriteOptions
+	o    *opt.Options
+	ro   *opt.ReadOptions
+	wo   *opt.WriteOptions
+	conf *Config
 
 	keys, values [][]byte
 }
@@ -99,7 +102,7 @@ func openDBBench(b *testing.B, noCompress bool) *dbBench {
 		p.o.Compression = opt.NoCompression
 	}
 
-	p.db, err = Open(p.stor, p.o)
+	p.db, err = Open(p.stor, p.o, nil)
 	if err != nil {
 		b.Fatal("cannot open db: ", err)
 	}
@@ -110,7 +113,7 @@ func openDBBench(b *testing.B, noCompress bool) *dbBench {
 func (p *dbBench) reopen() {
 	p.db.Close()
 	var err error
-	p.db, err = Open(p.stor, p.o)
+	p.db, err = OpenWithConfig(p.stor, p.o, p.conf)
 	if err != nil {
 		p.b.Fatal("Reopen: got error: ", err)
 	}
@@ -124,6 +127,19 @@ func (p *dbBench) populate(n int) {
 	}
 }
 
+// Keys of 16 entries share a hashed prefix of 8 bytes.
+func benchPrefix(group int) []byte {
+	return []byte(fmt.Sprintf("%08x", uint32(group)*2654435761))
+}
+
+func (p *dbBench) populatePrefix(n int) {
+	p.keys, p.values = make([][]byte, n), make([][]byte, n)
+	v := newValueGen(0.5)
+	for i := range p.keys {
+		p.keys[i], p.values[i] = []byte(fmt.Sprintf("%s%08d", benchPrefix(i/16), i)), v.get(100)
+	}
+}
+
 func (p *dbBench) randomize() {
 	m := len(p.keys)
 	times := m * 2
@@ -239,6 +255,33 @@ func (p *dbBench) seeks() {
 	b.StopTimer()
 }
 
+func (p *dbBench) prefixSeeks(missing bool) {
+	b := p.b
+
+	groups := len(p.keys) / 16
+	r := rand.New(rand.NewSource(0xdeadbeef))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		group := r.Intn(groups)
+		if missing {
+			group += groups
+		}
+		iter := p.db.NewIterator(util.BytesPrefix(benchPrefix(group)), p.ro)
+		n := 0
+		for iter.Next() {
+			n++
+		}
+		if err := iter.Error(); err != nil {
+			b.Fatal("iterator: got error: ", err)
+		}
+		iter.Release()
+		if missing != (n == 0) {
+			b.Fatalf("prefix of group %d: got %d entries", group, n)
+		}
+	}
+	b.StopTimer()
+}
+
 func (p *dbBench) newIter() iterator.Iterator {
 	iter := p.db.NewIterator(nil, p.ro)
 	err := iter.Error()
@@ -505,3 +548,30 @@ func BenchmarkDBReadConcurrent2(b *testing.B) {
 		}
 	})
 }
+
+func benchmarkDBPrefixSeek(b *testing.B, ext PrefixExtractor, missing bool) {
+	p := openDBBench(b, false)
+	p.o.Filter = filter.NewBloomFilter(10)
+	p.conf = &Config{PrefixExtractor: ext}
+	p.reopen()
+	p.populatePrefix(100000)
+	p.fill()
+	p.prefixSeeks(missing)
+	p.close()
+}
+
+func BenchmarkDBPrefixSeek(b *testing.B) {
+	benchmarkDBPrefixSeek(b, nil, false)
+}
+
+func BenchmarkDBPrefixSeekFilter(b *testing.B) {
+	benchmarkDBPrefixSeek(b, FixedPrefix(8), false)
+}
+
+func BenchmarkDBPrefixSeekMissing(b *testing.B) {
+	benchmarkDBPrefixSeek(b, nil, true)
+}
+
+func BenchmarkDBPrefixSeekMissingFilter(b *testing.B) {
+	benchmarkDBPrefixSeek(b, FixedPrefix(8), true)
+}
@@ -0,0 +1,235 @@
+/*
+This is free and unencumbered software released into the public domain.
+
+Anyone is free to copy, modify, publish, use, compile, sell, or
+distribute this software, either in source code form or as a compiled
+binary, for any purpose, commercial or non-commercial, and by any
+means.
+
+In jurisdictions that recognize copyright laws, the author or authors
+of this software dedicate any and all copyright interest in the
+software to the public domain. We make this dedication for the benefit
+of the public at large and to the detriment of our heirs and
+successors. We intend this dedication to be an overt act of
+relinquishment in perpetuity of all present and future rights to this
+software under copyright law.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+IN NO EVENT SHALL THE AUTHORS BE LIABLE FOR ANY CLAIM, DAMAGES OR
+OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE,
+ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR
+OTHER DEALINGS IN THE SOFTWARE.
+
+For more information, please refer to <http://unlicense.org/>
+*/
+
+package leveldb
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+/*
+MemoryBudget is a memory budget shared by several databases, see Config.MemoryBudget. It
+arbitrates the memdbs and the block caches of its members:
+
+The memdbs of all members may use up to half of the budget; beyond that, the largest memdb
+is flushed, as if it had reached its write buffer size. The block caches share the rest of
+the budget, and whatever the memdbs leave unused, in equal parts; their capacities replace
+the BlockCacheCapacity of the options. The open tables keep their index and filter blocks in
+the block cache, so they are covered as well, unless the block cache is disabled.
+*/
+type MemoryBudget struct {
+	limit int64 // atomic
+	mem   int64 // The bytes used by the memdbs of the members, atomic
+
+	mu      sync.Mutex
+	members []*budgetMember
+	shared  int64 // mem at the last rebalance
+}
+
+// The share of a DB.
+type budgetMember struct {
+	mem      int64 // The bytes used by its memdbs, or -1 once left, atomic
+	flushing int32 // atomic
+	b        *MemoryBudget
+	db       *DB
+	readOnly bool
+}
+
+/*
+NewMemoryBudget returns a MemoryBudget of the given bytes.
+*/
+func NewMemoryBudget(limit int64) *MemoryBudget {
+	return &MemoryBudget{limit: limit}
+}
+
+/*
+SetLimit changes the bytes of the budget. The block caches are resized at once, the memdbs
+are flushed as they are written to.
+*/
+func (b *MemoryBudget) SetLimit(limit int64) {
+	atomic.StoreInt64(&b.limit, limit)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.rebalance()
+}
+
+// Limit returns the bytes of the budget.
+func (b *MemoryBudget) Limit() int64 { return atomic.LoadInt64(&b.limit) }
+
+/*
+Usage returns the bytes used by the memdbs and the block caches of the members.
+*/
+func (b *MemoryBudget) Usage() (memdbs, caches int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, m := range b.members {
+		if bc := m.db.s.tops.bcache; bc != nil {
+			caches += int64(bc.Size())
+		}
+	}
+	return atomic.LoadInt64(&b.mem), caches
+}
+
+// Resizes the block caches of the members; b.mu must be held.
+func (b *MemoryBudget) rebalance() {
+	mem := atomic.LoadInt64(&b.mem)
+	b.shared = mem
+	if len(b.members) == 0 {
+		return
+	}
+	share := (b.Limit() - mem) / int64(len(b.members))
+	if share < 0 {
+		share = 0
+	}
+	for _, m := range b.members {
+		if bc := m.db.s.tops.bcache; bc != nil {
+			bc.SetCapacity(int(share))
+		}
+	}
+}
+
+func (b *MemoryBudget) join(db *DB, readOnly bool) {
+	m := &budgetMember{b: b, db: db, readOnly: readOnly}
+	db.budget = m
+	b.mu.Lock()
+	b.members = append(b.members, m)
+	b.rebalance()
+	b.mu.Unlock()
+	m.report()
+}
+
+func (b *MemoryBudget) leave(m *budgetMember) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for i, x := range b.members {
+		if x == m {
+			b.members = append(b.members[:i], b.members[i+1:]...)
+			break
+		}
+	}
+	if n := atomic.SwapInt64(&m.mem, -1); n > 0 {
+		atomic.AddInt64(&b.mem, -n)
+	}
+	b.rebalance()
+}
+
+// Updates the bytes used by the memdbs of the DB, and flushes or resizes as needed.
+func (m *budgetMember) report() {
+	var n int64
+	db := m.db
+	db.memMu.RLock()
+	if db.mem != nil {
+		n += int64(db.mem.Size())
+	}
+	if db.frozenMem != nil {
+		n += int64(db.frozenMem.Size())
+	}
+	db.memMu.RUnlock()
+
+	b := m.b
+	var mem int64
+	for {
+		old := atomic.LoadInt64(&m.mem)
+		if old < 0 {
+			return
+		}
+		if atomic.CompareAndSwapInt64(&m.mem, old, n) {
+			mem = atomic.AddInt64(&b.mem, n-old)
+			break
+		}
+	}
+	limit := b.Limit()
+	if mem > limit/2 {
+		b.reclaim()
+	}
+	b.mu.Lock()
+	if d := mem - b.shared; d > limit/32 || d < -limit/32 {
+		b.rebalance()
+	}
+	b.mu.Unlock()
+}
+
+// Returns the bytes of the effective memdb.
+func (m *budgetMember) effective() int {
+	db := m.db
+	db.memMu.RLock()
+	defer db.memMu.RUnlock()
+	if m.readOnly || db.mem == nil || db.frozenMem != nil {
+		return 0
+	}
+	return db.mem.Size()
+}
+
+// Flushes the largest effective memdb, unless a flush is pending.
+func (b *MemoryBudget) reclaim() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var (
+		victim *budgetMember
+		max    int
+	)
+	for _, m := range b.members {
+		if atomic.LoadInt32(&m.flushing) != 0 {
+			return
+		}
+		if n := m.effective(); n > max {
+			victim, max = m, n
+		}
+	}
+	if victim == nil {
+		return
+	}
+	atomic.StoreInt32(&victim.flushing, 1)
+	// The member is still open, so Close waits for the flush.
+	victim.db.closeW.Add(1)
+	go victim.flush()
+}
+
+func (m *budgetMember) flush() {
+	db := m.db
+	defer db.closeW.Done()
+	defer atomic.StoreInt32(&m.flushing, 0)
+	select {
+	case db.writeLockC <- struct{}{}:
+	case <-db.closeC:
+		return
+	}
+	if m.effective() == 0 {
+		<-db.writeLockC
+		return
+	}
+	_, err := db.rotateMem(0, false)
+	<-db.writeLockC
+	// The trigger of rotateMem may be missed, if no write follows.
+	if err == nil {
+		err = db.compTriggerWait(db.mcompCmdC)
+	}
+	if err != nil {
+		db.logf("budget@flush error %q", err)
+	}
+}
@@ -0,0 +1,97 @@
+package leveldb
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/syndtr/goleveldb/leveldb/opt"
+)
+
+func newBudgetHarness(t *testing.T, b *MemoryBudget) *dbHarness {
+	h := new(dbHarness)
+	h.conf = &Config{MemoryBudget: b}
+	h.init(t, &opt.Options{DisableLargeBatchTransaction: true, WriteBuffer: 1 << 20})
+	return h
+}
+
+func (h *dbHarness) cacheCapacity() int {
+	return h.db.s.tops.bcache.Capacity()
+}
+
+func TestMemoryBudget_Caches(t *testing.T) {
+	b := NewMemoryBudget(1 << 20)
+	h1 := newBudgetHarness(t, b)
+	defer h1.close()
+	if n := h1.cacheCapacity(); n != 1<<20 {
+		t.Errorf("one member: want capacity %d, got %d", 1<<20, n)
+	}
+	h2 := newBudgetHarness(t, b)
+	if n1, n2 := h1.cacheCapacity(), h2.cacheCapacity(); n1 != 1<<19 || n2 != 1<<19 {
+		t.Errorf("two members: want capacity %d, got %d and %d", 1<<19, n1, n2)
+	}
+
+	// The memdbs take their share from the caches; the caches are resized,
+	// once the memdbs have changed by 1/32 of the budget.
+	for i := 0; i < 100; i++ {
+		h2.put(fmt.Sprintf("k%03d", i), string(bytes.Repeat([]byte("v"), 1000)))
+	}
+	mem, _ := b.Usage()
+	if mem < 100*1000 {
+		t.Errorf("want the memdbs accounted, got %d", mem)
+	}
+	if n, want := h1.cacheCapacity(), int((1<<20-mem)/2); n < want || n > want+(1<<20)/64 {
+		t.Errorf("want capacity %d, got %d", want, n)
+	}
+
+	h2.close()
+	if mem, _ := b.Usage(); mem != 0 {
+		t.Errorf("closed member: want no memdbs, got %d", mem)
+	}
+	if n := h1.cacheCapacity(); n != 1<<20 {
+		t.Errorf("closed member: want capacity %d, got %d", 1<<20, n)
+	}
+	b.SetLimit(1 << 10)
+	if n := h1.cacheCapacity(); n != 1<<10 {
+		t.Errorf("SetLimit: want capacity %d, got %d", 1<<10, n)
+	}
+}
+
+func TestMemoryBudget_Flush(t *testing.T) {
+	b := NewMemoryBudget(64 << 10)
+	h1 := newBudgetHarness(t, b)
+	defer h1.close()
+	h2 := newBudgetHarness(t, b)
+	defer h2.close()
+
+	value := string(bytes.Repeat([]byte("v"), 1000))
+	for i := 0; i < 20; i++ {
+		h1.put(fmt.Sprintf("k%03d", i), value)
+	}
+	for i := 0; i < 10; i++ {
+		h2.put(fmt.Sprintf("k%03d", i), value)
+	}
+	if n := h1.totalTables() + h2.totalTables(); n != 0 {
+		t.Fatalf("within the budget: want no flush, got %d tables", n)
+	}
+
+	// Exceeding half of the budget flushes the largest memdb.
+	for i := 10; i < 20; i++ {
+		h2.put(fmt.Sprintf("k%03d", i), value)
+	}
+	for i := 0; h1.totalTables() == 0 && i < 100; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := h1.totalTables(); n != 1 {
+		t.Fatalf("want the largest memdb flushed, got %d tables", n)
+	}
+	if n := h2.totalTables(); n != 0 {
+		t.Errorf("want the other memdb kept, got %d tables", n)
+	}
+	if mem, _ := b.Usage(); mem > 32<<10 {
+		t.Errorf("want the memdbs within the budget, got %d", mem)
+	}
+	h1.getVal("k000", value)
+	h2.getVal("k019", value)
+}
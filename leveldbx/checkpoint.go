@@ -0,0 +1,250 @@
+/*
+This is free and unencumbered software released into the public domain.
+
+Anyone is free to copy, modify, publish, use, compile, sell, or
+distribute this software, either in source code form or as a compiled
+binary, for any purpose, commercial or non-commercial, and by any
+means.
+
+In jurisdictions that recognize copyright laws, the author or authors
+of this software dedicate any and all copyright interest in the
+software to the public domain. We make this dedication for the benefit
+of the public at large and to the detriment of our heirs and
+successors. We intend this dedication to be an overt act of
+relinquishment in perpetuity of all present and future rights to this
+software under copyright law.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+IN NO EVENT SHALL THE AUTHORS BE LIABLE FOR ANY CLAIM, DAMAGES OR
+OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE,
+ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR
+OTHER DEALINGS IN THE SOFTWARE.
+
+For more information, please refer to <http://unlicense.org/>
+*/
+
+package leveldb
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/syndtr/goleveldb/leveldb/journal"
+	"github.com/syndtr/goleveldb/leveldb/storage"
+)
+
+type checkpointEntry struct {
+	seq   uint64
+	kt    keyType
+	key   []byte
+	value []byte
+}
+
+type checkpointEntries []checkpointEntry
+
+func (p checkpointEntries) Len() int           { return len(p) }
+func (p checkpointEntries) Less(i, j int) bool { return p[i].seq < p[j].seq }
+func (p checkpointEntries) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
+
+// Appends the entries of the memdb, that are not newer than seq.
+func (p checkpointEntries) appendMem(mdb *memDB, seq uint64) (checkpointEntries, error) {
+	iter := mdb.NewIterator(nil)
+	defer iter.Release()
+	for iter.Next() {
+		ukey, eseq, kt, err := parseInternalKey(iter.Key())
+		if err != nil {
+			return p, err
+		}
+		if eseq > seq {
+			continue
+		}
+		p = append(p, checkpointEntry{eseq, kt, append([]byte{}, ukey...), append([]byte{}, iter.Value()...)})
+	}
+	return p, iter.Error()
+}
+
+// Hard-links the table into dir, if both reside in the same file system, and
+// copies it otherwise. An encrypted table is copied as is.
+func (db *DB) checkpointTable(dst storage.Storage, dir string, fd storage.FileDesc) error {
+	r, err := db.s.rawStorage().Open(fd)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	if f, ok := r.(interface{ Name() string }); ok {
+		if os.Link(f.Name(), filepath.Join(dir, filepath.Base(f.Name()))) == nil {
+			return nil
+		}
+	}
+	w, err := dst.Create(fd)
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+	if _, err = io.Copy(w, r); err != nil {
+		return err
+	}
+	return w.Sync()
+}
+
+// Writes the entries into a fresh journal, a batch per run of consecutive
+// sequence numbers.
+func writeCheckpointJournal(w io.Writer, entries checkpointEntries, seq uint64) error {
+	jw := journal.NewWriter(w)
+	write := func(b *Batch, bseq uint64) error {
+		jr, err := jw.Next()
+		if err != nil {
+			return err
+		}
+		return writeBatchesWithHeader(jr, []*Batch{b}, bseq)
+	}
+
+	b := new(Batch)
+	for i, e := range entries {
+		if i > 0 && e.seq != entries[i-1].seq+1 {
+			if err := write(b, entries[i-1].seq+1-uint64(b.Len())); err != nil {
+				return err
+			}
+			b.Reset()
+		}
+		b.appendRec(e.kt, e.key, e.value)
+	}
+	if n := len(entries); n > 0 {
+		if err := write(b, entries[n-1].seq+1-uint64(b.Len())); err != nil {
+			return err
+		}
+		// An empty batch advances the sequence number past the writes,
+		// that are only held by tables.
+		if entries[n-1].seq < seq {
+			b.Reset()
+			if err := write(b, seq); err != nil {
+				return err
+			}
+		}
+	}
+	return jw.Close()
+}
+
+// Checkpoint writes a consistent point-in-time copy of the DB into the given
+// directory, which must not contain any files. The live tables are
+// hard-linked, if possible, and copied otherwise; the content of the memdb
+// is written into a fresh journal. The copy can be opened by OpenFile; the
+// copy of an encrypted DB needs the same Encryption.
+//
+// Writes are blocked only while the state of the DB is captured.
+func (db *DB) Checkpoint(dir string) error {
+	if err := db.ok(); err != nil {
+		return err
+	}
+
+	// Lock writer.
+	select {
+	case db.writeLockC <- struct{}{}:
+	case err := <-db.compPerErrC:
+		return err
+	case <-db.closeC:
+		return ErrClosed
+	}
+	seq := db.getSeq()
+	mem, frozen := db.getMems()
+	// The memdbs are acquired first; if the frozen memdb gets flushed in
+	// between, its entries end up twice in the checkpoint, which is harmless.
+	v := db.s.version()
+	<-db.writeLockC
+	defer v.release()
+	if mem == nil {
+		return ErrClosed
+	}
+	defer mem.decref()
+	if frozen != nil {
+		defer frozen.decref()
+	}
+
+	var entries checkpointEntries
+	var err error
+	if frozen != nil {
+		if entries, err = entries.appendMem(frozen, seq); err != nil {
+			return err
+		}
+	}
+	if entries, err = entries.appendMem(mem, seq); err != nil {
+		return err
+	}
+	sort.Sort(entries)
+
+	dst, err := storage.OpenFile(dir, false)
+	if err != nil {
+		return err
+	}
+	defer dst.Close()
+	if fds, err := dst.List(storage.TypeAll); err != nil {
+		return err
+	} else if len(fds) > 0 {
+		return ErrCheckpointExist
+	}
+
+	var num int64
+	for _, tables := range v.levels {
+		for _, t := range tables {
+			if err := db.checkpointTable(dst, dir, t.fd); err != nil {
+				return err
+			}
+			if t.fd.Num > num {
+				num = t.fd.Num
+			}
+		}
+	}
+
+	// The journal and manifest are encrypted like those of the DB.
+	edst := db.s.encrypt(dst)
+	jfd := storage.FileDesc{Type: storage.TypeJournal, Num: num + 1}
+	w, err := edst.Create(jfd)
+	if err != nil {
+		return err
+	}
+	err = writeCheckpointJournal(w, entries, seq)
+	if err == nil {
+		err = w.Sync()
+	}
+	w.Close()
+	if err != nil {
+		return err
+	}
+
+	rec := &sessionRecord{}
+	rec.setComparer(db.s.icmp.uName())
+	rec.setJournalNum(jfd.Num)
+	rec.setNextFileNum(num + 3)
+	if len(entries) > 0 {
+		rec.setSeqNum(entries[0].seq)
+	} else {
+		rec.setSeqNum(seq)
+	}
+	v.fillRecord(rec)
+
+	mfd := storage.FileDesc{Type: storage.TypeManifest, Num: num + 2}
+	w, err = edst.Create(mfd)
+	if err != nil {
+		return err
+	}
+	jw := journal.NewWriter(w)
+	mw, err := jw.Next()
+	if err == nil {
+		err = rec.encode(mw)
+	}
+	if err == nil {
+		err = jw.Close()
+	}
+	if err == nil {
+		err = w.Sync()
+	}
+	w.Close()
+	if err != nil {
+		return err
+	}
+	return dst.SetMeta(mfd)
+}
@@ -0,0 +1,142 @@
+package leveldb
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/syndtr/goleveldb/leveldb/opt"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+func checkpointTestDir(t *testing.T, name string) string {
+	dir := filepath.Join(os.TempDir(), fmt.Sprintf("goleveldbtest%s-%d", name, os.Getuid()))
+	if err := os.RemoveAll(dir); err != nil {
+		t.Fatal("RemoveAll: got error: ", err)
+	}
+	return dir
+}
+
+func dumpDB(t *testing.T, db *DB) string {
+	iter := db.NewIterator(nil, nil)
+	defer iter.Release()
+	res := ""
+	for iter.Next() {
+		res += "(" + string(iter.Key()) + "->" + string(iter.Value()) + ")"
+	}
+	if err := iter.Error(); err != nil {
+		t.Error("iterator: got error: ", err)
+	}
+	return res
+}
+
+func TestDB_Checkpoint(t *testing.T) {
+	src := checkpointTestDir(t, "CheckpointSrc")
+	defer os.RemoveAll(src)
+	dst := checkpointTestDir(t, "CheckpointDst")
+	defer os.RemoveAll(dst)
+
+	db, err := OpenFile(src, &opt.Options{DisableLargeBatchTransaction: true}, nil)
+	if err != nil {
+		t.Fatal("OpenFile: got error: ", err)
+	}
+	defer db.Close()
+
+	for _, k := range []string{"a", "b", "c", "d"} {
+		if err := db.Put([]byte(k), []byte("t-"+k), nil); err != nil {
+			t.Fatal("Put: got error: ", err)
+		}
+	}
+	if err := db.CompactRange(util.Range{}); err != nil {
+		t.Fatal("CompactRange: got error: ", err)
+	}
+	db.Put([]byte("b"), []byte("m-b"), nil)
+	db.Delete([]byte("c"), nil)
+	db.PutWithTTL([]byte("e"), []byte("m-e"), time.Hour, nil)
+	want := dumpDB(t, db)
+
+	if err := db.Checkpoint(dst); err != nil {
+		t.Fatal("Checkpoint: got error: ", err)
+	}
+	db.Put([]byte("a"), []byte("after"), nil)
+	db.Put([]byte("f"), []byte("after"), nil)
+
+	// The tables are shared.
+	fis, err := filepath.Glob(filepath.Join(src, "*.ldb"))
+	if err != nil || len(fis) == 0 {
+		t.Fatal("no tables in source: ", err)
+	}
+	for _, fi := range fis {
+		a, err := os.Stat(fi)
+		if err != nil {
+			t.Fatal("Stat: got error: ", err)
+		}
+		b, err := os.Stat(filepath.Join(dst, filepath.Base(fi)))
+		if err != nil {
+			t.Fatal("Stat: got error: ", err)
+		}
+		if !os.SameFile(a, b) {
+			t.Errorf("table %s was not hard-linked", filepath.Base(fi))
+		}
+	}
+
+	cp, err := OpenFile(dst, nil, nil)
+	if err != nil {
+		t.Fatal("OpenFile checkpoint: got error: ", err)
+	}
+	if got := dumpDB(t, cp); got != want {
+		t.Errorf("checkpoint content: want=%q got=%q", want, got)
+	}
+	if _, ttl, err := cp.GetWithExpiry([]byte("e"), nil); err != nil || ttl <= 0 {
+		t.Errorf("GetWithExpiry: ttl=%v err=%v", ttl, err)
+	}
+
+	// The checkpoint continues independently.
+	if err := cp.Put([]byte("g"), []byte("cp"), nil); err != nil {
+		t.Fatal("Put: got error: ", err)
+	}
+	cp.Close()
+	cp, err = OpenFile(dst, nil, nil)
+	if err != nil {
+		t.Fatal("OpenFile checkpoint: got error: ", err)
+	}
+	if got := dumpDB(t, cp); got != want+"(g->cp)" {
+		t.Errorf("checkpoint content: want=%q got=%q", want+"(g->cp)", got)
+	}
+	cp.Close()
+
+	if err := db.Checkpoint(dst); err != ErrCheckpointExist {
+		t.Errorf("Checkpoint: want=%v got=%v", ErrCheckpointExist, err)
+	}
+}
+
+func TestDB_CheckpointCopy(t *testing.T) {
+	dst := checkpointTestDir(t, "CheckpointCopy")
+	defer os.RemoveAll(dst)
+
+	h := newDbHarness(t)
+	defer h.close()
+
+	h.put("a", "v1")
+	h.put("b", "v1")
+	h.compactMem()
+	h.put("a", "v2")
+	h.delete("b")
+	h.put("c", "v1")
+
+	if err := h.db.Checkpoint(dst); err != nil {
+		t.Fatal("Checkpoint: got error: ", err)
+	}
+	h.put("d", "v1")
+
+	cp, err := OpenFile(dst, nil, nil)
+	if err != nil {
+		t.Fatal("OpenFile checkpoint: got error: ", err)
+	}
+	defer cp.Close()
+	if got, want := dumpDB(t, cp), "(a->v2)(c->v1)"; got != want {
+		t.Errorf("checkpoint content: want=%q got=%q", want, got)
+	}
+}
@@ -0,0 +1,278 @@
+/*
+This is free and unencumbered software released into the public domain.
+
+Anyone is free to copy, modify, publish, use, compile, sell, or
+distribute this software, either in source code form or as a compiled
+binary, for any purpose, commercial or non-commercial, and by any
+means.
+
+In jurisdictions that recognize copyright laws, the author or authors
+of this software dedicate any and all copyright interest in the
+software to the public domain. We make this dedication for the benefit
+of the public at large and to the detriment of our heirs and
+successors. We intend this dedication to be an overt act of
+relinquishment in perpetuity of all present and future rights to this
+software under copyright law.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+IN NO EVENT SHALL THE AUTHORS BE LIABLE FOR ANY CLAIM, DAMAGES OR
+OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE,
+ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR
+OTHER DEALINGS IN THE SOFTWARE.
+
+For more information, please refer to <http://unlicense.org/>
+*/
+
+/*
+Package lz4 implements the LZ4 block format, in pure Go.
+
+Encode and Decode prefix the block with its decoded length, like the snappy package;
+EncodeBlock and DecodeBlock work on bare blocks.
+*/
+package lz4
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+var (
+	ErrCorrupt  = errors.New("lz4: corrupt input")
+	ErrTooLarge = errors.New("lz4: decoded block is too large")
+)
+
+const (
+	minMatch    = 4
+	lastLits    = 5  // The last literals of a block
+	mfLimit     = 12 // A match must start this far before the end of a block
+	maxOffset   = 65535
+	hashLog     = 14
+	skipTrigger = 6
+
+	// The maximum decoded length accepted by Decode.
+	maxDecodedLen = 1<<31 - 1
+)
+
+// MaxEncodedLen returns the maximum length of an encoded block of n bytes.
+func MaxEncodedLen(n int) int {
+	return binary.MaxVarintLen64 + n + n/255 + 16
+}
+
+func hash(u uint32) uint32 { return (u * 2654435761) >> (32 - hashLog) }
+
+/*
+EncodeBlock appends the LZ4 block of src to dst.
+*/
+func EncodeBlock(dst, src []byte) []byte {
+	n := len(src)
+	if n < mfLimit+1 {
+		return emitLast(dst, src)
+	}
+	var table [1 << hashLog]int32
+	anchor, s := 0, 1
+	table[hash(binary.LittleEndian.Uint32(src))] = 0
+	limit := n - mfLimit
+	for s < limit {
+		// Find a match, skipping faster through incompressible data.
+		var ref int
+		step, attempts := 1, 1<<skipTrigger
+		for {
+			h := hash(binary.LittleEndian.Uint32(src[s:]))
+			ref = int(table[h])
+			table[h] = int32(s)
+			if s-ref <= maxOffset && binary.LittleEndian.Uint32(src[ref:]) == binary.LittleEndian.Uint32(src[s:]) {
+				break
+			}
+			s += step
+			attempts++
+			step = attempts >> skipTrigger
+			if s >= limit {
+				return emitLast(dst, src[anchor:])
+			}
+		}
+
+		// Extend backwards and forwards.
+		for s > anchor && ref > 0 && src[s-1] == src[ref-1] {
+			s--
+			ref--
+		}
+		m := s + minMatch
+		for r := ref + minMatch; m < n-lastLits && src[m] == src[r]; m, r = m+1, r+1 {
+		}
+
+		dst = emitSeq(dst, src[anchor:s], s-ref, m-s)
+		anchor, s = m, m
+		if s >= limit {
+			break
+		}
+		table[hash(binary.LittleEndian.Uint32(src[s-2:]))] = int32(s - 2)
+	}
+	return emitLast(dst, src[anchor:])
+}
+
+func appendLen(dst []byte, n int) []byte {
+	for ; n >= 255; n -= 255 {
+		dst = append(dst, 255)
+	}
+	return append(dst, byte(n))
+}
+
+func emitSeq(dst, lits []byte, offset, mlen int) []byte {
+	ll, ml := len(lits), mlen-minMatch
+	token := byte(0)
+	if ll >= 15 {
+		token = 15 << 4
+	} else {
+		token = byte(ll) << 4
+	}
+	if ml >= 15 {
+		token |= 15
+	} else {
+		token |= byte(ml)
+	}
+	dst = append(dst, token)
+	if ll >= 15 {
+		dst = appendLen(dst, ll-15)
+	}
+	dst = append(dst, lits...)
+	dst = append(dst, byte(offset), byte(offset>>8))
+	if ml >= 15 {
+		dst = appendLen(dst, ml-15)
+	}
+	return dst
+}
+
+func emitLast(dst, lits []byte) []byte {
+	ll := len(lits)
+	if ll >= 15 {
+		dst = append(dst, 15<<4)
+		dst = appendLen(dst, ll-15)
+	} else {
+		dst = append(dst, byte(ll)<<4)
+	}
+	return append(dst, lits...)
+}
+
+/*
+DecodeBlock appends the decoded LZ4 block src to dst. Matches may refer to the data
+preceding in dst, up to the offset limit of the format.
+*/
+func DecodeBlock(dst, src []byte) ([]byte, error) {
+	for i := 0; i < len(src); {
+		token := src[i]
+		i++
+		ll := int(token >> 4)
+		if ll == 15 {
+			for {
+				if i >= len(src) {
+					return nil, ErrCorrupt
+				}
+				b := src[i]
+				i++
+				ll += int(b)
+				if ll > maxDecodedLen {
+					return nil, ErrCorrupt
+				}
+				if b != 255 {
+					break
+				}
+			}
+		}
+		if ll > len(src)-i {
+			return nil, ErrCorrupt
+		}
+		dst = append(dst, src[i:i+ll]...)
+		i += ll
+		if i == len(src) {
+			return dst, nil
+		} // The last sequence.
+		if i+2 > len(src) {
+			return nil, ErrCorrupt
+		}
+		offset := int(src[i]) | int(src[i+1])<<8
+		i += 2
+		ml := int(token & 15)
+		if ml == 15 {
+			for {
+				if i >= len(src) {
+					return nil, ErrCorrupt
+				}
+				b := src[i]
+				i++
+				ml += int(b)
+				if ml > maxDecodedLen {
+					return nil, ErrCorrupt
+				}
+				if b != 255 {
+					break
+				}
+			}
+		}
+		ml += minMatch
+		if offset == 0 || offset > len(dst) {
+			return nil, ErrCorrupt
+		}
+		if len(dst)+ml > maxDecodedLen {
+			return nil, ErrTooLarge
+		}
+		p := len(dst) - offset
+		if offset >= ml {
+			dst = append(dst, dst[p:p+ml]...)
+		} else {
+			// Overlapping copy.
+			for j := 0; j < ml; j++ {
+				dst = append(dst, dst[p+j])
+			}
+		}
+	}
+	return nil, ErrCorrupt
+}
+
+/*
+Encode returns the encoded form of src, prefixed with its length. The returned slice may
+be a sub-slice of dst, if dst was large enough.
+*/
+func Encode(dst, src []byte) []byte {
+	if n := MaxEncodedLen(len(src)); cap(dst) < n {
+		dst = make([]byte, 0, n)
+	}
+	dst = dst[:binary.PutUvarint(dst[:binary.MaxVarintLen64], uint64(len(src)))]
+	return EncodeBlock(dst, src)
+}
+
+// DecodedLen returns the length of the decoded form of the encoded src.
+func DecodedLen(src []byte) (int, error) {
+	v, n := binary.Uvarint(src)
+	if n <= 0 {
+		return 0, ErrCorrupt
+	}
+	if v > maxDecodedLen {
+		return 0, ErrTooLarge
+	}
+	return int(v), nil
+}
+
+/*
+Decode returns the decoded form of the encoded src. The returned slice may be a sub-slice
+of dst, if dst was large enough.
+*/
+func Decode(dst, src []byte) ([]byte, error) {
+	dlen, err := DecodedLen(src)
+	if err != nil {
+		return nil, err
+	}
+	_, n := binary.Uvarint(src)
+	if cap(dst) < dlen {
+		dst = make([]byte, 0, dlen)
+	}
+	out, err := DecodeBlock(dst[:0], src[n:])
+	if err != nil {
+		return nil, err
+	}
+	if len(out) != dlen {
+		return nil, ErrCorrupt
+	}
+	return out, nil
+}
@@ -0,0 +1,61 @@
+package lz4
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"math/rand"
+	"testing"
+)
+
+func testRecords(n int) []byte {
+	var b bytes.Buffer
+	for i := 0; i < n; i++ {
+		fmt.Fprintf(&b, "key%04d=value of record %d, the quick brown fox\n", i, i*i%97)
+	}
+	return b.Bytes()
+}
+
+func TestRoundTrip(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	in := map[string][]byte{"empty": nil, "records": testRecords(5000)}
+	for _, n := range []int{1, 12, 13, 100, 65536, 300000} {
+		rnd := make([]byte, n)
+		r.Read(rnd)
+		in[fmt.Sprint("random", n)] = rnd
+		in[fmt.Sprint("zero", n)] = make([]byte, n)
+	}
+	for name, src := range in {
+		enc := Encode(nil, src)
+		if len(enc) > MaxEncodedLen(len(src)) {
+			t.Errorf("%s: encoded length %d exceeds %d", name, len(enc), MaxEncodedLen(len(src)))
+		}
+		dec, err := Decode(nil, enc)
+		if err != nil {
+			t.Errorf("%s: decode: %v", name, err)
+			continue
+		}
+		if !bytes.Equal(dec, src) {
+			t.Errorf("%s: mismatch", name)
+		}
+	}
+}
+
+// A block written by the reference implementation (lz4 -9).
+const testReference = "f2206b6579303030303d76616c7565206f66207265636f726420302c2074686520717569636b2062726f776e20666f780a2f001d312f001f312f00091d322f001f342f00091d332f001f392f00091e348d001f363000091d3530002f32353000091d3630001f3360000a1e37ee000fc0000a1d3830001f361f010a1d3930001f387e01091e31dc011f332f00090edc011f328f00091f31dd01001f373000090ede012f37323000090edf010f2f000a0ede011f331e010a0ede011f3660000a0ede011f393e02091e31de011f337f010a0ede011f378b03091e32de011f31c000091e32df011f359000091e32df011f39fe02091e32df011f343f02091e32df011f39b001091e32e0011f34c0000a0ee0011f3990000a0ee0011f3580010a0ee0011f382f00090edf011f363f02091e33df011f325e03091e33df011f389000091e33df011f351f01091e33df011f326f02091e33df011f380d05091e33df011f360f02091f339d05000f50010a0edf011f3160000a0fdf01000fff02091f33e001000e30005020666f780a"
+
+func TestDecodeReference(t *testing.T) {
+	block, _ := hex.DecodeString(testReference)
+	got, err := DecodeBlock(nil, block)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, testRecords(40)) {
+		t.Error("mismatch")
+	}
+	for n := 1; n < len(block); n++ {
+		if out, err := DecodeBlock(nil, block[:n]); err == nil && bytes.Equal(out, testRecords(40)) {
+			t.Fatalf("truncated at %d: no error", n)
+		}
+	}
+}
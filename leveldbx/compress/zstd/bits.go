@@ -0,0 +1,130 @@
+/*
+This is free and unencumbered software released into the public domain.
+
+Anyone is free to copy, modify, publish, use, compile, sell, or
+distribute this software, either in source code form or as a compiled
+binary, for any purpose, commercial or non-commercial, and by any
+means.
+
+In jurisdictions that recognize copyright laws, the author or authors
+of this software dedicate any and all copyright interest in the
+software to the public domain. We make this dedication for the benefit
+of the public at large and to the detriment of our heirs and
+successors. We intend this dedication to be an overt act of
+relinquishment in perpetuity of all present and future rights to this
+software under copyright law.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+IN NO EVENT SHALL THE AUTHORS BE LIABLE FOR ANY CLAIM, DAMAGES OR
+OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE,
+ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR
+OTHER DEALINGS IN THE SOFTWARE.
+
+For more information, please refer to <http://unlicense.org/>
+*/
+
+package zstd
+
+import (
+	"encoding/binary"
+	"math/bits"
+)
+
+func highbit(v uint32) uint { return uint(bits.Len32(v)) - 1 }
+
+/*
+bitWriter writes a bit stream, which is read backwards by bitReader. Bits are appended
+at the least significant end.
+*/
+type bitWriter struct {
+	out []byte
+	acc uint64
+	n   uint
+}
+
+// Appends the nb low bits of v; nb must not exceed 32.
+func (w *bitWriter) add(v uint64, nb uint) {
+	w.acc |= (v & (1<<nb - 1)) << w.n
+	w.n += nb
+	for w.n >= 8 {
+		w.out = append(w.out, byte(w.acc))
+		w.acc >>= 8
+		w.n -= 8
+	}
+}
+
+// Closes the stream with the end mark.
+func (w *bitWriter) close() []byte {
+	w.add(1, 1)
+	if w.n > 0 {
+		w.out = append(w.out, byte(w.acc))
+	}
+	w.acc, w.n = 0, 0
+	return w.out
+}
+
+/*
+bitReader reads a bit stream backwards, starting below its end mark. Bits read beyond
+the start of the stream are zero, and leave pos negative.
+*/
+type bitReader struct {
+	in  []byte
+	pos int // The number of unread bits
+}
+
+func (r *bitReader) init(in []byte) error {
+	if len(in) == 0 || in[len(in)-1] == 0 {
+		return ErrCorrupt
+	}
+	r.in = in
+	r.pos = (len(in)-1)*8 + int(highbit(uint32(in[len(in)-1])))
+	return nil
+}
+
+// Returns nb bits, starting at bit start; nb must not exceed 56.
+func (r *bitReader) get(start int, nb uint) uint64 {
+	var b [8]byte
+	copy(b[:], r.in[start>>3:])
+	return (binary.LittleEndian.Uint64(b[:]) >> uint(start&7)) & (1<<nb - 1)
+}
+
+func (r *bitReader) peek(nb uint) uint64 {
+	start := r.pos - int(nb)
+	if start >= 0 {
+		return r.get(start, nb)
+	}
+	if r.pos <= 0 {
+		return 0
+	}
+	return r.get(0, uint(r.pos)) << uint(-start)
+}
+
+func (r *bitReader) read(nb uint) uint64 {
+	if nb == 0 {
+		return 0
+	}
+	v := r.peek(nb)
+	r.pos -= int(nb)
+	return v
+}
+
+func (r *bitReader) overflow() bool { return r.pos < 0 }
+func (r *bitReader) finished() bool { return r.pos == 0 }
+
+/*
+fwdReader reads a bit stream forwards, starting at the least significant bit.
+*/
+type fwdReader struct {
+	in  []byte
+	pos int // The number of bits read
+}
+
+func (r *fwdReader) peek(nb uint) uint64 {
+	var b [8]byte
+	if i := r.pos >> 3; i < len(r.in) {
+		copy(b[:], r.in[i:])
+	}
+	return (binary.LittleEndian.Uint64(b[:]) >> uint(r.pos&7)) & (1<<nb - 1)
+}
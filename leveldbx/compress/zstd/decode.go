@@ -0,0 +1,318 @@
+/*
+This is free and unencumbered software released into the public domain.
+
+Anyone is free to copy, modify, publish, use, compile, sell, or
+distribute this software, either in source code form or as a compiled
+binary, for any purpose, commercial or non-commercial, and by any
+means.
+
+In jurisdictions that recognize copyright laws, the author or authors
+of this software dedicate any and all copyright interest in the
+software to the public domain. We make this dedication for the benefit
+of the public at large and to the detriment of our heirs and
+successors. We intend this dedication to be an overt act of
+relinquishment in perpetuity of all present and future rights to this
+software under copyright law.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+IN NO EVENT SHALL THE AUTHORS BE LIABLE FOR ANY CLAIM, DAMAGES OR
+OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE,
+ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR
+OTHER DEALINGS IN THE SOFTWARE.
+
+For more information, please refer to <http://unlicense.org/>
+*/
+
+package zstd
+
+/*
+decoder holds the state carried between the blocks of a frame.
+*/
+type decoder struct {
+	start int // The start of the frame in the output
+	rep   [3]int
+	huff  huffDec
+
+	// The tables of the last block, for the repeat modes.
+	hasHuff       bool
+	ll, of, ml    *fseDecTable
+	llT, ofT, mlT fseDecTable
+	lits          []byte
+}
+
+func (d *decoder) reset(start int) {
+	d.start = start
+	d.rep = [3]int{1, 4, 8}
+	d.hasHuff = false
+	d.ll, d.of, d.ml = nil, nil, nil
+}
+
+// Reads the literals section; returns the literals and the length of the section.
+func (d *decoder) literals(src []byte) ([]byte, int, error) {
+	if len(src) == 0 {
+		return nil, 0, ErrCorrupt
+	}
+	b0 := int(src[0])
+	typ, sf := b0&3, b0>>2&3
+	if typ < 2 {
+		var size, hl int
+		switch sf {
+		case 0, 2:
+			size, hl = b0>>3, 1
+		case 1:
+			if len(src) < 2 {
+				return nil, 0, ErrCorrupt
+			}
+			size, hl = b0>>4|int(src[1])<<4, 2
+		case 3:
+			if len(src) < 3 {
+				return nil, 0, ErrCorrupt
+			}
+			size, hl = b0>>4|int(src[1])<<4|int(src[2])<<12, 3
+		}
+		if size > maxBlockSize {
+			return nil, 0, ErrCorrupt
+		}
+		if typ == 0 {
+			if size > len(src)-hl {
+				return nil, 0, ErrCorrupt
+			}
+			return src[hl : hl+size], hl + size, nil
+		}
+		if hl >= len(src) {
+			return nil, 0, ErrCorrupt
+		}
+		d.lits = d.lits[:0]
+		for i := 0; i < size; i++ {
+			d.lits = append(d.lits, src[hl])
+		}
+		return d.lits, hl + 1, nil
+	}
+
+	// Huffman coded literals, in one or four streams.
+	hl, nb := [4]int{3, 3, 4, 5}[sf], [4]uint{10, 10, 14, 18}[sf]
+	if len(src) < hl {
+		return nil, 0, ErrCorrupt
+	}
+	var v uint64
+	for i := hl - 1; i >= 0; i-- {
+		v = v<<8 | uint64(src[i])
+	}
+	size := int(v >> 4 & (1<<nb - 1))
+	csize := int(v >> (4 + nb) & (1<<nb - 1))
+	if size > maxBlockSize || csize > len(src)-hl {
+		return nil, 0, ErrCorrupt
+	}
+	data := src[hl : hl+csize]
+	if typ == 2 {
+		n, err := d.huff.readTree(data)
+		if err != nil {
+			return nil, 0, err
+		}
+		data = data[n:]
+		d.hasHuff = true
+	} else if !d.hasHuff {
+		return nil, 0, ErrCorrupt
+	}
+	var err error
+	lits := d.lits[:0]
+	if sf == 0 {
+		lits, err = d.huff.decode(lits, data, size)
+	} else {
+		if len(data) < 6 {
+			return nil, 0, ErrCorrupt
+		}
+		seg := (size + 3) / 4
+		if size < 3*seg {
+			return nil, 0, ErrCorrupt
+		}
+		s1 := int(data[0]) | int(data[1])<<8
+		s2 := int(data[2]) | int(data[3])<<8
+		s3 := int(data[4]) | int(data[5])<<8
+		data = data[6:]
+		if s1+s2+s3 > len(data) {
+			return nil, 0, ErrCorrupt
+		}
+		streams := [4][]byte{data[:s1], data[s1 : s1+s2], data[s1+s2 : s1+s2+s3], data[s1+s2+s3:]}
+		for i, st := range streams {
+			n := seg
+			if i == 3 {
+				n = size - 3*seg
+			}
+			if lits, err = d.huff.decode(lits, st, n); err != nil {
+				break
+			}
+		}
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	d.lits = lits
+	return lits, hl + csize, nil
+}
+
+// Sets up the table of one symbol type; returns the number of bytes read.
+func (d *decoder) table(mode int, src []byte, cur **fseDecTable, own *fseDecTable, def *fseDecTable, maxSym int, maxLog uint) (int, error) {
+	switch mode {
+	case 0:
+		*cur = def
+		return 0, nil
+	case 1:
+		if len(src) == 0 || int(src[0]) > maxSym {
+			return 0, ErrCorrupt
+		}
+		own.rle(src[0])
+		*cur = own
+		return 1, nil
+	case 2:
+		norm, log, n, err := readNCount(src, maxSym, maxLog)
+		if err != nil {
+			return 0, err
+		}
+		if err = own.build(norm, log); err != nil {
+			return 0, err
+		}
+		*cur = own
+		return n, nil
+	}
+	if *cur == nil {
+		return 0, ErrCorrupt
+	}
+	return 0, nil
+}
+
+// Appends the decoded compressed block src to dst.
+func (d *decoder) block(dst, src []byte) ([]byte, error) {
+	mark := len(dst)
+	lits, pos, err := d.literals(src)
+	if err != nil {
+		return nil, err
+	}
+	if pos >= len(src) {
+		return nil, ErrCorrupt
+	}
+	nseq := int(src[pos])
+	switch {
+	case nseq < 128:
+		pos++
+	case nseq < 255:
+		if pos+2 > len(src) {
+			return nil, ErrCorrupt
+		}
+		nseq = (nseq-128)<<8 | int(src[pos+1])
+		pos += 2
+	default:
+		if pos+3 > len(src) {
+			return nil, ErrCorrupt
+		}
+		nseq = int(src[pos+1]) | int(src[pos+2])<<8 + 0x7F00
+		pos += 3
+	}
+	if nseq == 0 {
+		if pos != len(src) {
+			return nil, ErrCorrupt
+		}
+		return append(dst, lits...), nil
+	}
+	if pos >= len(src) {
+		return nil, ErrCorrupt
+	}
+	modes := int(src[pos])
+	pos++
+	if modes&3 != 0 {
+		return nil, ErrCorrupt
+	}
+	n, err := d.table(modes>>6, src[pos:], &d.ll, &d.llT, &llDefaultDec, llMaxSym, llMaxLog)
+	if err != nil {
+		return nil, err
+	}
+	pos += n
+	if n, err = d.table(modes>>4&3, src[pos:], &d.of, &d.ofT, &ofDefaultDec, ofMaxSym, ofMaxLog); err != nil {
+		return nil, err
+	}
+	pos += n
+	if n, err = d.table(modes>>2&3, src[pos:], &d.ml, &d.mlT, &mlDefaultDec, mlMaxSym, mlMaxLog); err != nil {
+		return nil, err
+	}
+	pos += n
+
+	var r bitReader
+	if err = r.init(src[pos:]); err != nil {
+		return nil, err
+	}
+	var ll, of, ml fseDecState
+	ll.init(d.ll, &r)
+	of.init(d.of, &r)
+	ml.init(d.ml, &r)
+	for i := 0; i < nseq; i++ {
+		llc, ofc, mlc := ll.symbol(), of.symbol(), ml.symbol()
+		if int(llc) > llMaxSym || int(mlc) > mlMaxSym || int(ofc) > ofMaxSym {
+			return nil, ErrCorrupt
+		}
+		ov := int(1<<ofc | r.read(uint(ofc)))
+		mlen := int(mlBase[mlc]) + int(r.read(uint(mlBits[mlc])))
+		llen := int(llBase[llc]) + int(r.read(uint(llBits[llc])))
+
+		// Resolve the repeat offsets.
+		var offset int
+		if ov > 3 {
+			offset = ov - 3
+			d.rep = [3]int{offset, d.rep[0], d.rep[1]}
+		} else {
+			if llen == 0 {
+				ov++
+			}
+			switch ov {
+			case 1:
+				offset = d.rep[0]
+			case 2:
+				offset = d.rep[1]
+				d.rep[0], d.rep[1] = offset, d.rep[0]
+			case 3:
+				offset = d.rep[2]
+				d.rep = [3]int{offset, d.rep[0], d.rep[1]}
+			default:
+				offset = d.rep[0] - 1
+				if offset == 0 {
+					return nil, ErrCorrupt
+				}
+				d.rep = [3]int{offset, d.rep[0], d.rep[1]}
+			}
+		}
+		if i < nseq-1 {
+			ll.update(&r)
+			ml.update(&r)
+			of.update(&r)
+		}
+		if r.overflow() {
+			return nil, ErrCorrupt
+		}
+
+		// Execute the sequence.
+		if llen > len(lits) {
+			return nil, ErrCorrupt
+		}
+		dst = append(dst, lits[:llen]...)
+		lits = lits[llen:]
+		if offset <= 0 || offset > len(dst)-d.start || mlen > maxBlockSize {
+			return nil, ErrCorrupt
+		}
+		p := len(dst) - offset
+		if offset >= mlen {
+			dst = append(dst, dst[p:p+mlen]...)
+		} else {
+			for j := 0; j < mlen; j++ {
+				dst = append(dst, dst[p+j])
+			}
+		}
+		if len(dst)-mark > maxBlockSize {
+			return nil, ErrCorrupt
+		}
+	}
+	if !r.finished() {
+		return nil, ErrCorrupt
+	}
+	return append(dst, lits...), nil
+}
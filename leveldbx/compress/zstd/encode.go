@@ -0,0 +1,351 @@
+/*
+This is free and unencumbered software released into the public domain.
+
+Anyone is free to copy, modify, publish, use, compile, sell, or
+distribute this software, either in source code form or as a compiled
+binary, for any purpose, commercial or non-commercial, and by any
+means.
+
+In jurisdictions that recognize copyright laws, the author or authors
+of this software dedicate any and all copyright interest in the
+software to the public domain. We make this dedication for the benefit
+of the public at large and to the detriment of our heirs and
+successors. We intend this dedication to be an overt act of
+relinquishment in perpetuity of all present and future rights to this
+software under copyright law.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+IN NO EVENT SHALL THE AUTHORS BE LIABLE FOR ANY CLAIM, DAMAGES OR
+OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE,
+ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR
+OTHER DEALINGS IN THE SOFTWARE.
+
+For more information, please refer to <http://unlicense.org/>
+*/
+
+package zstd
+
+import (
+	"encoding/binary"
+	"math/bits"
+)
+
+const (
+	minMatch    = 4
+	skipTrigger = 6
+)
+
+// Maps literal lengths and match lengths (minus 3) to their codes.
+var llCodes [64]uint8
+var mlCodes [128]uint8
+
+func init() {
+	for c := range llBase {
+		for v := llBase[c]; v < llBase[c]+1<<llBits[c] && v < 64; v++ {
+			llCodes[v] = uint8(c)
+		}
+	}
+	for c := range mlBase {
+		for v := mlBase[c] - 3; v < mlBase[c]-3+1<<mlBits[c] && v < 128; v++ {
+			mlCodes[v] = uint8(c)
+		}
+	}
+}
+
+func llCode(ll uint32) uint8 {
+	if ll > 63 {
+		return uint8(highbit(ll) + 19)
+	}
+	return llCodes[ll]
+}
+
+func mlCode(mb uint32) uint8 {
+	if mb > 127 {
+		return uint8(highbit(mb) + 36)
+	}
+	return mlCodes[mb]
+}
+
+type sequence struct {
+	ll, ml, off uint32
+}
+
+/*
+encoder holds the state carried between the blocks of a frame.
+*/
+type encoder struct {
+	hashLog uint
+	table   []int32 // Positions plus one
+	lits    []byte
+	seqs    []sequence
+	huff    huffEnc
+
+	llc, mlc, ofc []uint8
+}
+
+func newEncoder(n int) *encoder {
+	log := uint(bits.Len(uint(n)))
+	if log < 8 {
+		log = 8
+	}
+	if log > 16 {
+		log = 16
+	}
+	return &encoder{hashLog: log, table: make([]int32, 1<<log)}
+}
+
+func (e *encoder) hash(u uint32) uint32 { return (u * 2654435761) >> (32 - e.hashLog) }
+
+// Appends the block of src[start:end]; earlier data in src serves as history.
+func (e *encoder) block(dst, src []byte, start, end int, last bool) []byte {
+	e.parse(src, start, end)
+	hdr := len(dst)
+	dst = appendBlockHeader(dst, last, blockCompressed, 0)
+	body := len(dst)
+	dst = e.encodeLiterals(dst)
+	dst = e.encodeSequences(dst)
+	size := len(dst) - body
+	if size >= end-start {
+		dst = appendBlockHeader(dst[:hdr], last, blockRaw, end-start)
+		return append(dst, src[start:end]...)
+	}
+	appendBlockHeader(dst[hdr:hdr], last, blockCompressed, size)
+	return dst
+}
+
+// Splits src[start:end] into literals and sequences, with a greedy match finder.
+func (e *encoder) parse(src []byte, start, end int) {
+	e.lits, e.seqs = e.lits[:0], e.seqs[:0]
+	anchor, s := start, start
+	for limit := end - minMatch; s <= limit; {
+		cur := binary.LittleEndian.Uint32(src[s:])
+		h := e.hash(cur)
+		ref := int(e.table[h]) - 1
+		e.table[h] = int32(s + 1)
+		if ref < 0 || binary.LittleEndian.Uint32(src[ref:]) != cur {
+			// Skip faster through incompressible data.
+			s += 1 + (s-anchor)>>skipTrigger
+			continue
+		}
+
+		// Extend backwards and forwards.
+		for s > anchor && ref > 0 && src[s-1] == src[ref-1] {
+			s--
+			ref--
+		}
+		m := s + minMatch
+		for r := ref + minMatch; m < end && src[m] == src[r]; m, r = m+1, r+1 {
+		}
+
+		e.lits = append(e.lits, src[anchor:s]...)
+		e.seqs = append(e.seqs, sequence{ll: uint32(s - anchor), ml: uint32(m - s), off: uint32(s - ref)})
+		if m+2 <= end {
+			e.table[e.hash(binary.LittleEndian.Uint32(src[m-2:]))] = int32(m - 1)
+		}
+		anchor, s = m, m
+	}
+	e.lits = append(e.lits, src[anchor:end]...)
+}
+
+func appendLitHeader(dst []byte, typ, n int) []byte {
+	switch {
+	case n < 32:
+		return append(dst, byte(typ|n<<3))
+	case n < 4096:
+		return append(dst, byte(typ|1<<2|(n&15)<<4), byte(n>>4))
+	}
+	return append(dst, byte(typ|3<<2|(n&15)<<4), byte(n>>4), byte(n>>12))
+}
+
+func (e *encoder) encodeLiterals(dst []byte) []byte {
+	lits := e.lits
+	n := len(lits)
+	rle := n > 1
+	for _, c := range lits {
+		if c != lits[0] {
+			rle = false
+			break
+		}
+	}
+	if rle {
+		return append(appendLitHeader(dst, 1, n), lits[0])
+	}
+	if n >= 32 {
+		if out, ok := e.huffLiterals(dst, lits); ok {
+			return out
+		}
+	}
+	return append(appendLitHeader(dst, 0, n), lits...)
+}
+
+// Appends the Huffman coded literals; returns false if they don't compress.
+func (e *encoder) huffLiterals(dst, lits []byte) ([]byte, bool) {
+	n := len(lits)
+	var hist [256]int
+	for _, c := range lits {
+		hist[c]++
+	}
+	e.huff.init(huffLengths(hist[:], huffMaxBits))
+	mark := len(dst)
+	dst = append(dst, 0, 0, 0, 0, 0) // The largest header
+	body, ok := e.huff.writeTree(dst)
+	if !ok {
+		return dst[:mark], false
+	}
+	single := n < 256
+	if single {
+		body = e.huff.encode(body, lits)
+	} else {
+		jt := len(body)
+		body = append(body, 0, 0, 0, 0, 0, 0)
+		seg := (n + 3) / 4
+		for i := 0; i < 4; i++ {
+			lo, hi := i*seg, (i+1)*seg
+			if hi > n {
+				hi = n
+			}
+			start := len(body)
+			body = e.huff.encode(body, lits[lo:hi])
+			if i < 3 {
+				sz := len(body) - start
+				if sz > 65535 {
+					return body[:mark], false
+				}
+				body[jt+2*i], body[jt+2*i+1] = byte(sz), byte(sz>>8)
+			}
+		}
+	}
+	csize := len(body) - mark - 5
+	var sf, hl int
+	var nb uint
+	switch {
+	case single && csize < 1024:
+		sf, hl, nb = 0, 3, 10
+	case single:
+		return body[:mark], false
+	case n < 1024 && csize < 1024:
+		sf, hl, nb = 1, 3, 10
+	case n < 16384 && csize < 16384:
+		sf, hl, nb = 2, 4, 14
+	default:
+		sf, hl, nb = 3, 5, 18
+	}
+	if hl+csize >= n {
+		return body[:mark], false
+	}
+	v := uint64(2|sf<<2) | uint64(n)<<4 | uint64(csize)<<(4+nb)
+	appendUint(body[:mark], v, hl)
+	copy(body[mark+hl:], body[mark+5:])
+	return body[:mark+hl+csize], true
+}
+
+/*
+seqTable is the encoding table of one symbol type within a block.
+*/
+type seqTable struct {
+	rle bool
+	t   *fseEncTable
+	own fseEncTable
+}
+
+// Chooses the table mode for codes; appends the table description to desc.
+func (t *seqTable) choose(desc []byte, codes []uint8, def *fseEncTable, defMax int, maxLog uint) (int, []byte) {
+	var hist [64]int
+	max, distinct := 0, 0
+	for _, c := range codes {
+		if hist[c] == 0 {
+			distinct++
+		}
+		hist[c]++
+		if int(c) > max {
+			max = int(c)
+		}
+	}
+	t.rle = distinct == 1
+	if t.rle {
+		return 1, append(desc, codes[0])
+	}
+	if len(codes) < 32 && max <= defMax {
+		t.t = def
+		return 0, desc
+	}
+	log := tableLog(len(codes), distinct, maxLog)
+	norm := normalize(hist[:max+1], len(codes), log)
+	t.own.build(norm, log)
+	t.t = &t.own
+	return 2, writeNCount(desc, norm, log)
+}
+
+func (t *seqTable) init(s *fseEncState, sym uint8) {
+	if !t.rle {
+		s.init(t.t, sym)
+	}
+}
+
+func (t *seqTable) encode(w *bitWriter, s *fseEncState, sym uint8) {
+	if !t.rle {
+		s.encode(w, sym)
+	}
+}
+
+func (t *seqTable) flush(w *bitWriter, s *fseEncState) {
+	if !t.rle {
+		s.flush(w)
+	}
+}
+
+func (e *encoder) encodeSequences(dst []byte) []byte {
+	n := len(e.seqs)
+	switch {
+	case n < 128:
+		dst = append(dst, byte(n))
+	case n < 0x7F00:
+		dst = append(dst, byte(n>>8+128), byte(n))
+	default:
+		dst = append(dst, 0xFF, byte(n-0x7F00), byte((n-0x7F00)>>8))
+	}
+	if n == 0 {
+		return dst
+	}
+	e.llc, e.mlc, e.ofc = e.llc[:0], e.mlc[:0], e.ofc[:0]
+	for _, s := range e.seqs {
+		e.llc = append(e.llc, llCode(s.ll))
+		e.mlc = append(e.mlc, mlCode(s.ml-3))
+		e.ofc = append(e.ofc, uint8(highbit(s.off+3)))
+	}
+	var ll, of, ml seqTable
+	mark := len(dst)
+	dst = append(dst, 0)
+	llm, dst := ll.choose(dst, e.llc, &llDefaultEnc, llMaxSym, llMaxLog)
+	ofm, dst := of.choose(dst, e.ofc, &ofDefaultEnc, len(ofDefault)-1, ofMaxLog)
+	mlm, dst := ml.choose(dst, e.mlc, &mlDefaultEnc, mlMaxSym, mlMaxLog)
+	dst[mark] = byte(llm<<6 | ofm<<4 | mlm<<2)
+
+	// The sequences are written backwards, so that they are read forwards.
+	w := bitWriter{out: dst}
+	var sll, sof, sml fseEncState
+	extras := func(i int) {
+		s := e.seqs[i]
+		llc, mlc, ofc := e.llc[i], e.mlc[i], e.ofc[i]
+		w.add(uint64(s.ll-llBase[llc]), uint(llBits[llc]))
+		w.add(uint64(s.ml-mlBase[mlc]), uint(mlBits[mlc]))
+		w.add(uint64(s.off+3-1<<ofc), uint(ofc))
+	}
+	last := n - 1
+	ml.init(&sml, e.mlc[last])
+	of.init(&sof, e.ofc[last])
+	ll.init(&sll, e.llc[last])
+	extras(last)
+	for i := n - 2; i >= 0; i-- {
+		of.encode(&w, &sof, e.ofc[i])
+		ml.encode(&w, &sml, e.mlc[i])
+		ll.encode(&w, &sll, e.llc[i])
+		extras(i)
+	}
+	ml.flush(&w, &sml)
+	of.flush(&w, &sof)
+	ll.flush(&w, &sll)
+	return w.close()
+}
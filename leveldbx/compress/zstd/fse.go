@@ -0,0 +1,410 @@
+/*
+This is free and unencumbered software released into the public domain.
+
+Anyone is free to copy, modify, publish, use, compile, sell, or
+distribute this software, either in source code form or as a compiled
+binary, for any purpose, commercial or non-commercial, and by any
+means.
+
+In jurisdictions that recognize copyright laws, the author or authors
+of this software dedicate any and all copyright interest in the
+software to the public domain. We make this dedication for the benefit
+of the public at large and to the detriment of our heirs and
+successors. We intend this dedication to be an overt act of
+relinquishment in perpetuity of all present and future rights to this
+software under copyright law.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+IN NO EVENT SHALL THE AUTHORS BE LIABLE FOR ANY CLAIM, DAMAGES OR
+OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE,
+ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR
+OTHER DEALINGS IN THE SOFTWARE.
+
+For more information, please refer to <http://unlicense.org/>
+*/
+
+package zstd
+
+/*
+Finite State Entropy tables, as described in RFC 8878, section 4.1.
+*/
+
+const minTableLog = 5
+
+type fseDecEntry struct {
+	sym  uint8
+	nb   uint8
+	base uint16
+}
+
+type fseDecTable struct {
+	log uint
+	t   []fseDecEntry
+}
+
+// Spreads the symbols over the table; returns false if the counts do not fit.
+func fseSpread(norm []int16, log uint, sym []uint8) bool {
+	size := 1 << log
+	high := size - 1
+	for s, c := range norm {
+		if c == -1 {
+			sym[high] = uint8(s)
+			high--
+		}
+	}
+	pos, step, mask := 0, size>>1+size>>3+3, size-1
+	for s, c := range norm {
+		for i := 0; i < int(c); i++ {
+			sym[pos] = uint8(s)
+			pos = (pos + step) & mask
+			for pos > high {
+				pos = (pos + step) & mask
+			}
+		}
+	}
+	return pos == 0
+}
+
+func checkNorm(norm []int16, log uint) bool {
+	if len(norm) > 256 {
+		return false
+	}
+	sum := 0
+	for _, c := range norm {
+		if c < -1 {
+			return false
+		}
+		if c == -1 {
+			sum++
+		} else {
+			sum += int(c)
+		}
+	}
+	return sum == 1<<log
+}
+
+func (d *fseDecTable) build(norm []int16, log uint) error {
+	if !checkNorm(norm, log) {
+		return ErrCorrupt
+	}
+	size := 1 << log
+	if cap(d.t) < size {
+		d.t = make([]fseDecEntry, size)
+	}
+	d.t = d.t[:size]
+	d.log = log
+	sym := make([]uint8, size)
+	if !fseSpread(norm, log, sym) {
+		return ErrCorrupt
+	}
+	var next [256]uint16
+	for s, c := range norm {
+		if c == -1 {
+			next[s] = 1
+		} else {
+			next[s] = uint16(c)
+		}
+	}
+	for u := range d.t {
+		s := sym[u]
+		ns := uint32(next[s])
+		next[s]++
+		nb := log - highbit(ns)
+		d.t[u] = fseDecEntry{sym: s, nb: uint8(nb), base: uint16((ns << nb) - uint32(size))}
+	}
+	return nil
+}
+
+// A table decoding one symbol only.
+func (d *fseDecTable) rle(s uint8) {
+	d.log = 0
+	d.t = append(d.t[:0], fseDecEntry{sym: s})
+}
+
+type fseDecState struct {
+	t     *fseDecTable
+	state uint
+}
+
+func (s *fseDecState) init(t *fseDecTable, r *bitReader) {
+	s.t = t
+	s.state = uint(r.read(t.log))
+}
+
+func (s *fseDecState) symbol() uint8 { return s.t.t[s.state].sym }
+
+func (s *fseDecState) update(r *bitReader) {
+	e := s.t.t[s.state]
+	s.state = uint(e.base) + uint(r.read(uint(e.nb)))
+}
+
+type fseSymbolTT struct {
+	deltaNb   uint32
+	deltaFind int32
+}
+
+type fseEncTable struct {
+	log   uint
+	state []uint16
+	tt    []fseSymbolTT
+}
+
+func (e *fseEncTable) build(norm []int16, log uint) {
+	size := 1 << log
+	e.log = log
+	e.state = make([]uint16, size)
+	e.tt = make([]fseSymbolTT, len(norm))
+	sym := make([]uint8, size)
+	fseSpread(norm, log, sym)
+	cumul := make([]int, len(norm)+1)
+	for s, c := range norm {
+		if c == -1 {
+			cumul[s+1] = cumul[s] + 1
+		} else {
+			cumul[s+1] = cumul[s] + int(c)
+		}
+	}
+	for u := 0; u < size; u++ {
+		s := sym[u]
+		e.state[cumul[s]] = uint16(size + u)
+		cumul[s]++
+	}
+	total := 0
+	for s, c := range norm {
+		switch {
+		case c == 0:
+		case c == -1 || c == 1:
+			e.tt[s] = fseSymbolTT{uint32(log<<16) - uint32(size), int32(total - 1)}
+			total++
+		default:
+			maxOut := log - highbit(uint32(c-1))
+			e.tt[s] = fseSymbolTT{uint32(maxOut<<16) - uint32(int(c)<<maxOut), int32(total - int(c))}
+			total += int(c)
+		}
+	}
+}
+
+type fseEncState struct {
+	t     *fseEncTable
+	state uint32
+}
+
+func (s *fseEncState) init(t *fseEncTable, sym uint8) {
+	s.t = t
+	tt := t.tt[sym]
+	nb := (tt.deltaNb + 1<<15) >> 16
+	v := (nb << 16) - tt.deltaNb
+	s.state = uint32(t.state[int32(v>>nb)+tt.deltaFind])
+}
+
+func (s *fseEncState) encode(w *bitWriter, sym uint8) {
+	tt := s.t.tt[sym]
+	nb := (s.state + tt.deltaNb) >> 16
+	w.add(uint64(s.state), uint(nb))
+	s.state = uint32(s.t.state[int32(s.state>>nb)+tt.deltaFind])
+}
+
+func (s *fseEncState) flush(w *bitWriter) { w.add(uint64(s.state), s.t.log) }
+
+/*
+Normalizes the histogram to a sum of 1<<log; the histogram must hold two symbols at least,
+and no more than 1<<log.
+*/
+func normalize(hist []int, total int, log uint) []int16 {
+	size := 1 << log
+	last := len(hist) - 1
+	for last > 0 && hist[last] == 0 {
+		last--
+	}
+	norm := make([]int16, last+1)
+	sum, largest := 0, 0
+	for s := range norm {
+		if hist[s] == 0 {
+			continue
+		}
+		v := hist[s] * size / total
+		if v == 0 {
+			v = 1
+		}
+		norm[s] = int16(v)
+		sum += v
+		if norm[s] > norm[largest] {
+			largest = s
+		}
+	}
+	// Move the rounding error to the largest symbols.
+	for sum > size {
+		big := largest
+		for s := range norm {
+			if norm[s] > norm[big] {
+				big = s
+			}
+		}
+		d := sum - size
+		if m := int(norm[big]) / 2; d > m {
+			d = m
+		}
+		norm[big] -= int16(d)
+		sum -= d
+	}
+	norm[largest] += int16(size - sum)
+	return norm
+}
+
+// Chooses the accuracy of a table for n symbols out of total, up to max.
+func tableLog(total, n int, max uint) uint {
+	log := highbit(uint32(total)) + 1
+	if log > max {
+		log = max
+	}
+	for 1<<log < n*2 && log < max {
+		log++
+	}
+	if log < minTableLog {
+		log = minTableLog
+	}
+	return log
+}
+
+// Appends the description of the normalized counts (RFC 8878, section 4.1.1).
+func writeNCount(dst []byte, norm []int16, log uint) []byte {
+	var acc uint64
+	var n uint
+	flush := func() {
+		for n >= 8 {
+			dst = append(dst, byte(acc))
+			acc >>= 8
+			n -= 8
+		}
+	}
+	acc, n = uint64(log-minTableLog), 4
+	size := 1 << log
+	remaining, threshold, nbBits := size+1, size, log+1
+	prev0 := false
+	for s := 0; remaining > 1 && s < len(norm); {
+		if prev0 {
+			start := s
+			for s < len(norm) && norm[s] == 0 {
+				s++
+			}
+			for s >= start+24 {
+				start += 24
+				acc |= 0xFFFF << n
+				n += 16
+				flush()
+			}
+			for s >= start+3 {
+				start += 3
+				acc |= 3 << n
+				n += 2
+			}
+			acc |= uint64(s-start) << n
+			n += 2
+			flush()
+		}
+		count := int(norm[s])
+		s++
+		max := 2*threshold - 1 - remaining
+		if count < 0 {
+			remaining += count
+		} else {
+			remaining -= count
+		}
+		count++
+		if count >= threshold {
+			count += max
+		}
+		acc |= uint64(count) << n
+		n += nbBits
+		if count < max {
+			n--
+		}
+		prev0 = count == 1
+		for remaining < threshold {
+			nbBits--
+			threshold >>= 1
+		}
+		flush()
+	}
+	if n > 0 {
+		dst = append(dst, byte(acc))
+	}
+	return dst
+}
+
+// Reads the description of normalized counts; returns the counts, the accuracy and the
+// number of bytes read.
+func readNCount(src []byte, maxSym int, maxLog uint) (norm []int16, log uint, n int, err error) {
+	r := fwdReader{in: src}
+	log = uint(r.peek(4)) + minTableLog
+	if log > maxLog || len(src) == 0 {
+		return nil, 0, 0, ErrCorrupt
+	}
+	r.pos = 4
+	remaining, threshold, nbBits := 1<<log+1, 1<<log, log+1
+	norm = make([]int16, 0, maxSym+1)
+	prev0 := false
+	for remaining > 1 {
+		if prev0 {
+			n0 := len(norm)
+			for r.peek(16) == 0xFFFF {
+				n0 += 24
+				r.pos += 16
+				if r.pos > len(src)*8 {
+					return nil, 0, 0, ErrCorrupt
+				}
+			}
+			for r.peek(2) == 3 {
+				n0 += 3
+				r.pos += 2
+			}
+			n0 += int(r.peek(2))
+			r.pos += 2
+			if n0 > maxSym {
+				return nil, 0, 0, ErrCorrupt
+			}
+			for len(norm) < n0 {
+				norm = append(norm, 0)
+			}
+		}
+		if len(norm) > maxSym {
+			return nil, 0, 0, ErrCorrupt
+		}
+		max := 2*threshold - 1 - remaining
+		var count int
+		if v := int(r.peek(nbBits - 1)); v < max {
+			count = v
+			r.pos += int(nbBits - 1)
+		} else {
+			count = int(r.peek(nbBits))
+			if count >= threshold {
+				count -= max
+			}
+			r.pos += int(nbBits)
+		}
+		count--
+		if count < 0 {
+			remaining += count
+		} else {
+			remaining -= count
+		}
+		if remaining < 1 {
+			return nil, 0, 0, ErrCorrupt
+		}
+		norm = append(norm, int16(count))
+		prev0 = count == 0
+		for remaining < threshold {
+			nbBits--
+			threshold >>= 1
+		}
+		if r.pos > len(src)*8 {
+			return nil, 0, 0, ErrCorrupt
+		}
+	}
+	if remaining != 1 {
+		return nil, 0, 0, ErrCorrupt
+	}
+	return norm, log, (r.pos + 7) >> 3, nil
+}
@@ -0,0 +1,355 @@
+/*
+This is free and unencumbered software released into the public domain.
+
+Anyone is free to copy, modify, publish, use, compile, sell, or
+distribute this software, either in source code form or as a compiled
+binary, for any purpose, commercial or non-commercial, and by any
+means.
+
+In jurisdictions that recognize copyright laws, the author or authors
+of this software dedicate any and all copyright interest in the
+software to the public domain. We make this dedication for the benefit
+of the public at large and to the detriment of our heirs and
+successors. We intend this dedication to be an overt act of
+relinquishment in perpetuity of all present and future rights to this
+software under copyright law.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+IN NO EVENT SHALL THE AUTHORS BE LIABLE FOR ANY CLAIM, DAMAGES OR
+OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE,
+ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR
+OTHER DEALINGS IN THE SOFTWARE.
+
+For more information, please refer to <http://unlicense.org/>
+*/
+
+package zstd
+
+import "sort"
+
+/*
+Huffman coding of literals, as described in RFC 8878, section 4.2.
+*/
+
+const huffMaxBits = 11
+
+// Computes the code lengths of the histogram, limited to limit bits.
+func huffLengths(hist []int, limit uint) []uint8 {
+	for {
+		lens, max := huffBuild(hist)
+		if max <= limit {
+			return lens
+		}
+		// Flatten the histogram, until the tree is shallow enough.
+		flat := make([]int, len(hist))
+		for s, c := range hist {
+			flat[s] = (c + 1) / 2
+		}
+		hist = flat
+	}
+}
+
+func huffBuild(hist []int) ([]uint8, uint) {
+	type node struct{ w, parent int }
+	syms := make([]int, 0, len(hist))
+	for s, c := range hist {
+		if c > 0 {
+			syms = append(syms, s)
+		}
+	}
+	sort.SliceStable(syms, func(i, j int) bool { return hist[syms[i]] < hist[syms[j]] })
+	n := len(syms)
+	nodes := make([]node, 2*n-1)
+	for i, s := range syms {
+		nodes[i].w = hist[s]
+	}
+	leaf, inner, next := 0, n, n
+	pick := func() int {
+		if leaf < n && (inner >= next || nodes[leaf].w <= nodes[inner].w) {
+			leaf++
+			return leaf - 1
+		}
+		inner++
+		return inner - 1
+	}
+	for ; next < len(nodes); next++ {
+		a, b := pick(), pick()
+		nodes[next].w = nodes[a].w + nodes[b].w
+		nodes[a].parent, nodes[b].parent = next, next
+	}
+	depth := make([]uint8, len(nodes))
+	for i := len(nodes) - 2; i >= 0; i-- {
+		depth[i] = depth[nodes[i].parent] + 1
+	}
+	lens := make([]uint8, len(hist))
+	max := uint(0)
+	for i, s := range syms {
+		lens[s] = depth[i]
+		if uint(depth[i]) > max {
+			max = uint(depth[i])
+		}
+	}
+	return lens, max
+}
+
+type huffEnc struct {
+	maxBits uint
+	last    int // The last symbol with a code
+	code    [256]uint16
+	nb      [256]uint8
+}
+
+// Assigns the codes of a complete set of code lengths.
+func (h *huffEnc) init(lens []uint8) {
+	h.maxBits, h.last = 0, 0
+	for s, l := range lens {
+		if l == 0 {
+			continue
+		}
+		h.last = s
+		if uint(l) > h.maxBits {
+			h.maxBits = uint(l)
+		}
+	}
+	// Codes are handed out by increasing weight, then by symbol.
+	var start [huffMaxBits + 2]uint32
+	pos := uint32(0)
+	for w := uint(1); w <= h.maxBits; w++ {
+		start[w] = pos
+		for _, l := range lens {
+			if l != 0 && h.maxBits+1-uint(l) == w {
+				pos += 1 << (w - 1)
+			}
+		}
+	}
+	for s, l := range lens {
+		h.nb[s] = l
+		if l == 0 {
+			continue
+		}
+		w := h.maxBits + 1 - uint(l)
+		h.code[s] = uint16(start[w] >> (w - 1))
+		start[w] += 1 << (w - 1)
+	}
+}
+
+func (h *huffEnc) weight(s int) uint8 {
+	if h.nb[s] == 0 {
+		return 0
+	}
+	return uint8(h.maxBits + 1 - uint(h.nb[s]))
+}
+
+// Appends the tree description; returns false if it cannot be described.
+func (h *huffEnc) writeTree(dst []byte) ([]byte, bool) {
+	n := h.last // The weight of the last symbol is implied.
+	weights := make([]uint8, n)
+	var hist [huffMaxBits + 1]int
+	distinct := 0
+	for s := range weights {
+		weights[s] = h.weight(s)
+		if hist[weights[s]] == 0 {
+			distinct++
+		}
+		hist[weights[s]]++
+	}
+	if distinct > 1 {
+		log := tableLog(n, distinct, 6)
+		norm := normalize(hist[:], n, log)
+		var t fseEncTable
+		t.build(norm, log)
+		desc := writeNCount(nil, norm, log)
+		desc = append(desc, fseEncode2(&t, weights)...)
+		if len(desc) < 128 && (n > 128 || len(desc) < (n+1)/2) {
+			dst = append(dst, byte(len(desc)))
+			return append(dst, desc...), true
+		}
+	}
+	if n > 128 {
+		return dst, false
+	}
+	dst = append(dst, byte(127+n))
+	for i := 0; i < n; i += 2 {
+		b := weights[i] << 4
+		if i+1 < n {
+			b |= weights[i+1]
+		}
+		dst = append(dst, b)
+	}
+	return dst, true
+}
+
+// Encodes src with two interleaved states, as used for Huffman weights.
+func fseEncode2(t *fseEncTable, src []uint8) []byte {
+	var w bitWriter
+	var s1, s2 fseEncState
+	i := len(src)
+	if i&1 != 0 {
+		s1.init(t, src[i-1])
+		s2.init(t, src[i-2])
+		s1.encode(&w, src[i-3])
+		i -= 3
+	} else {
+		s2.init(t, src[i-1])
+		s1.init(t, src[i-2])
+		i -= 2
+	}
+	for i > 0 {
+		s2.encode(&w, src[i-1])
+		s1.encode(&w, src[i-2])
+		i -= 2
+	}
+	s2.flush(&w)
+	s1.flush(&w)
+	return w.close()
+}
+
+// Appends the Huffman stream of src.
+func (h *huffEnc) encode(dst, src []byte) []byte {
+	w := bitWriter{out: dst}
+	for i := len(src) - 1; i >= 0; i-- {
+		w.add(uint64(h.code[src[i]]), uint(h.nb[src[i]]))
+	}
+	return w.close()
+}
+
+// The size of the Huffman stream of src, in bytes.
+func (h *huffEnc) size(hist []int) int {
+	n := 0
+	for s, c := range hist {
+		n += c * int(h.nb[s])
+	}
+	return n/8 + 1
+}
+
+type huffEntry struct {
+	sym uint8
+	nb  uint8
+}
+
+type huffDec struct {
+	maxBits uint
+	t       []huffEntry
+}
+
+// Reads a tree description; returns the number of bytes read.
+func (h *huffDec) readTree(src []byte) (int, error) {
+	if len(src) == 0 {
+		return 0, ErrCorrupt
+	}
+	var weights [256]uint8
+	nw, n := 0, 0
+	if hb := int(src[0]); hb >= 128 {
+		nw = hb - 127
+		n = 1 + (nw+1)/2
+		if n > len(src) {
+			return 0, ErrCorrupt
+		}
+		for i := 0; i < nw; i++ {
+			b := src[1+i/2]
+			if i&1 == 0 {
+				b >>= 4
+			}
+			weights[i] = b & 15
+		}
+	} else {
+		n = 1 + hb
+		if n > len(src) {
+			return 0, ErrCorrupt
+		}
+		norm, log, k, err := readNCount(src[1:n], huffMaxBits+1, 6)
+		if err != nil {
+			return 0, err
+		}
+		var t fseDecTable
+		if err = t.build(norm, log); err != nil {
+			return 0, err
+		}
+		var r bitReader
+		if err = r.init(src[1+k : n]); err != nil {
+			return 0, err
+		}
+		var s1, s2 fseDecState
+		s1.init(&t, &r)
+		s2.init(&t, &r)
+		for {
+			if nw > 253 {
+				return 0, ErrCorrupt
+			}
+			weights[nw] = s1.symbol()
+			nw++
+			s1.update(&r)
+			if r.overflow() {
+				weights[nw] = s2.symbol()
+				nw++
+				break
+			}
+			weights[nw] = s2.symbol()
+			nw++
+			s2.update(&r)
+			if r.overflow() {
+				weights[nw] = s1.symbol()
+				nw++
+				break
+			}
+		}
+	}
+
+	// Derive the largest code length and the last weight.
+	total := uint32(0)
+	for _, w := range weights[:nw] {
+		if w > huffMaxBits {
+			return 0, ErrCorrupt
+		}
+		if w > 0 {
+			total += 1 << (w - 1)
+		}
+	}
+	if total == 0 {
+		return 0, ErrCorrupt
+	}
+	maxBits := highbit(total) + 1
+	if maxBits > huffMaxBits {
+		return 0, ErrCorrupt
+	}
+	rest := uint32(1)<<maxBits - total
+	if rest&(rest-1) != 0 {
+		return 0, ErrCorrupt
+	}
+	weights[nw] = uint8(highbit(rest) + 1)
+	nw++
+
+	h.maxBits = maxBits
+	h.t = h.t[:0]
+	for w := uint8(1); uint(w) <= maxBits; w++ {
+		for s, sw := range weights[:nw] {
+			if sw != w {
+				continue
+			}
+			e := huffEntry{sym: uint8(s), nb: uint8(maxBits + 1 - uint(w))}
+			for i := 0; i < 1<<(w-1); i++ {
+				h.t = append(h.t, e)
+			}
+		}
+	}
+	return n, nil
+}
+
+// Appends n symbols decoded from the Huffman stream src.
+func (h *huffDec) decode(dst, src []byte, n int) ([]byte, error) {
+	var r bitReader
+	if err := r.init(src); err != nil {
+		return nil, err
+	}
+	for ; n > 0; n-- {
+		e := h.t[r.peek(h.maxBits)]
+		dst = append(dst, e.sym)
+		r.pos -= int(e.nb)
+	}
+	if !r.finished() {
+		return nil, ErrCorrupt
+	}
+	return dst, nil
+}
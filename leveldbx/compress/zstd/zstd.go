@@ -0,0 +1,347 @@
+/*
+This is free and unencumbered software released into the public domain.
+
+Anyone is free to copy, modify, publish, use, compile, sell, or
+distribute this software, either in source code form or as a compiled
+binary, for any purpose, commercial or non-commercial, and by any
+means.
+
+In jurisdictions that recognize copyright laws, the author or authors
+of this software dedicate any and all copyright interest in the
+software to the public domain. We make this dedication for the benefit
+of the public at large and to the detriment of our heirs and
+successors. We intend this dedication to be an overt act of
+relinquishment in perpetuity of all present and future rights to this
+software under copyright law.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+IN NO EVENT SHALL THE AUTHORS BE LIABLE FOR ANY CLAIM, DAMAGES OR
+OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE,
+ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR
+OTHER DEALINGS IN THE SOFTWARE.
+
+For more information, please refer to <http://unlicense.org/>
+*/
+
+/*
+Package zstd implements the Zstandard format (RFC 8878), in pure Go.
+
+Encode writes single frames with a fast, greedy match finder. Decode reads any frame
+without a dictionary, including those written by the reference implementation.
+*/
+package zstd
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+var (
+	ErrCorrupt    = errors.New("zstd: corrupt input")
+	ErrTooLarge   = errors.New("zstd: decoded frame is too large")
+	ErrDictionary = errors.New("zstd: dictionaries are not supported")
+	ErrChecksum   = errors.New("zstd: checksum mismatch")
+)
+
+const (
+	frameMagic     = 0xFD2FB528
+	skippableMagic = 0x184D2A50 // Up to 0x184D2A5F
+	maxBlockSize   = 128 << 10
+
+	// The maximum decoded length accepted by Decode.
+	maxDecodedLen = 1<<31 - 1
+)
+
+// Literal length and match length codes: baselines and extra bits.
+var (
+	llBase = [36]uint32{
+		0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15,
+		16, 18, 20, 22, 24, 28, 32, 40, 48, 64, 128, 256, 512, 1024, 2048, 4096,
+		8192, 16384, 32768, 65536}
+	llBits = [36]uint8{
+		0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+		1, 1, 1, 1, 2, 2, 3, 3, 4, 6, 7, 8, 9, 10, 11, 12,
+		13, 14, 15, 16}
+	mlBase = [53]uint32{
+		3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 18,
+		19, 20, 21, 22, 23, 24, 25, 26, 27, 28, 29, 30, 31, 32, 33, 34,
+		35, 37, 39, 41, 43, 47, 51, 59, 67, 83, 99, 131, 259, 515, 1027, 2051,
+		4099, 8195, 16387, 32771, 65539}
+	mlBits = [53]uint8{
+		0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+		0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+		1, 1, 1, 1, 2, 2, 3, 3, 4, 4, 5, 7, 8, 9, 10, 11,
+		12, 13, 14, 15, 16}
+)
+
+const (
+	llMaxSym, llMaxLog = 35, 9
+	mlMaxSym, mlMaxLog = 52, 9
+	ofMaxSym, ofMaxLog = 31, 8
+)
+
+// The predefined distributions.
+var (
+	llDefault = []int16{
+		4, 3, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 1, 1, 1,
+		2, 2, 2, 2, 2, 2, 2, 2, 2, 3, 2, 1, 1, 1, 1, 1,
+		-1, -1, -1, -1}
+	mlDefault = []int16{
+		1, 4, 3, 2, 2, 2, 2, 2, 2, 1, 1, 1, 1, 1, 1, 1,
+		1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1,
+		1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, -1, -1,
+		-1, -1, -1, -1, -1}
+	ofDefault = []int16{
+		1, 1, 1, 1, 1, 1, 2, 2, 2, 1, 1, 1, 1, 1, 1, 1,
+		1, 1, 1, 1, 1, 1, 1, 1, -1, -1, -1, -1, -1}
+)
+
+const llDefaultLog, mlDefaultLog, ofDefaultLog = 6, 6, 5
+
+var (
+	llDefaultDec, mlDefaultDec, ofDefaultDec fseDecTable
+	llDefaultEnc, mlDefaultEnc, ofDefaultEnc fseEncTable
+)
+
+func init() {
+	llDefaultDec.build(llDefault, llDefaultLog)
+	mlDefaultDec.build(mlDefault, mlDefaultLog)
+	ofDefaultDec.build(ofDefault, ofDefaultLog)
+	llDefaultEnc.build(llDefault, llDefaultLog)
+	mlDefaultEnc.build(mlDefault, mlDefaultLog)
+	ofDefaultEnc.build(ofDefault, ofDefaultLog)
+}
+
+/*
+Encode returns src as a Zstandard frame. The returned slice may be a sub-slice of dst, if
+dst was large enough.
+*/
+func Encode(dst, src []byte) []byte {
+	dst = dst[:0]
+	n := len(src)
+	dst = appendUint(dst, frameMagic, 4)
+	// A single segment frame, with its content size.
+	switch {
+	case n < 256:
+		dst = append(dst, 0<<6|1<<5, byte(n))
+	case n < 65536+256:
+		dst = append(dst, 1<<6|1<<5)
+		dst = appendUint(dst, uint64(n-256), 2)
+	case uint64(n) < 1<<32:
+		dst = append(dst, 2<<6|1<<5)
+		dst = appendUint(dst, uint64(n), 4)
+	default:
+		dst = append(dst, 3<<6|1<<5)
+		dst = appendUint(dst, uint64(n), 8)
+	}
+	if n == 0 {
+		return appendBlockHeader(dst, true, blockRaw, 0)
+	}
+	e := newEncoder(n)
+	for start := 0; start < n; start += maxBlockSize {
+		end := start + maxBlockSize
+		if end > n {
+			end = n
+		}
+		dst = e.block(dst, src, start, end, end == n)
+	}
+	return dst
+}
+
+/*
+Decode returns the decoded content of the frames in src. The returned slice may be a
+sub-slice of dst, if dst was large enough.
+*/
+func Decode(dst, src []byte) ([]byte, error) {
+	dst = dst[:0]
+	var d decoder
+	for len(src) > 0 {
+		n, err := d.frame(&dst, src)
+		if err != nil {
+			return nil, err
+		}
+		src = src[n:]
+	}
+	return dst, nil
+}
+
+// DecodedLen returns the content size declared by the first frame in src, or -1 if the
+// frame does not declare it.
+func DecodedLen(src []byte) (int, error) {
+	h, err := readFrameHeader(src)
+	if err != nil {
+		return 0, err
+	}
+	if h.size < 0 {
+		return -1, nil
+	}
+	if h.size > maxDecodedLen {
+		return 0, ErrTooLarge
+	}
+	return int(h.size), nil
+}
+
+type frameHeader struct {
+	n        int   // The length of the header
+	size     int64 // The content size, or -1
+	checksum bool
+}
+
+func readFrameHeader(src []byte) (h frameHeader, err error) {
+	if len(src) < 5 || binary.LittleEndian.Uint32(src) != frameMagic {
+		return h, ErrCorrupt
+	}
+	fhd := src[4]
+	if fhd&8 != 0 {
+		return h, ErrCorrupt
+	}
+	single := fhd&0x20 != 0
+	h.checksum = fhd&4 != 0
+	h.n = 5
+	if !single {
+		h.n++
+	} // The window descriptor
+	did := [4]int{0, 1, 2, 4}[fhd&3]
+	fcs := [4]int{0, 2, 4, 8}[fhd>>6]
+	if fcs == 0 && single {
+		fcs = 1
+	}
+	if h.n+did+fcs > len(src) {
+		return h, ErrCorrupt
+	}
+	var id uint64
+	for i := did - 1; i >= 0; i-- {
+		id = id<<8 | uint64(src[h.n+i])
+	}
+	if id != 0 {
+		return h, ErrDictionary
+	}
+	h.n += did
+	h.size = -1
+	if fcs > 0 {
+		var v uint64
+		for i := fcs - 1; i >= 0; i-- {
+			v = v<<8 | uint64(src[h.n+i])
+		}
+		if fcs == 2 {
+			v += 256
+		}
+		if v > 1<<62 {
+			return h, ErrTooLarge
+		}
+		h.size = int64(v)
+		h.n += fcs
+	}
+	return h, nil
+}
+
+const (
+	blockRaw = iota
+	blockRLE
+	blockCompressed
+)
+
+// Appends the n low bytes of v, in little endian order.
+func appendUint(dst []byte, v uint64, n int) []byte {
+	for ; n > 0; n-- {
+		dst = append(dst, byte(v))
+		v >>= 8
+	}
+	return dst
+}
+
+func appendBlockHeader(dst []byte, last bool, typ int, size int) []byte {
+	v := uint64(typ<<1 | size<<3)
+	if last {
+		v |= 1
+	}
+	return appendUint(dst, v, 3)
+}
+
+// Decodes the frame at the start of src into *dst; returns the length of the frame.
+func (d *decoder) frame(dst *[]byte, src []byte) (int, error) {
+	if len(src) >= 8 && binary.LittleEndian.Uint32(src)&^15 == skippableMagic {
+		n := 8 + int64(binary.LittleEndian.Uint32(src[4:]))
+		if n > int64(len(src)) {
+			return 0, ErrCorrupt
+		}
+		return int(n), nil
+	}
+	h, err := readFrameHeader(src)
+	if err != nil {
+		return 0, err
+	}
+	start := len(*dst)
+	if h.size > maxDecodedLen-int64(start) {
+		return 0, ErrTooLarge
+	}
+	if h.size > 0 && cap(*dst)-start < int(h.size) {
+		// Don't trust large sizes before the content has been seen.
+		c := h.size
+		if c > 16<<20 {
+			c = 16 << 20
+		}
+		grown := make([]byte, start, start+int(c))
+		copy(grown, *dst)
+		*dst = grown
+	}
+	d.reset(start)
+	pos := h.n
+	for last := false; !last; {
+		if pos+3 > len(src) {
+			return 0, ErrCorrupt
+		}
+		bh := uint32(src[pos]) | uint32(src[pos+1])<<8 | uint32(src[pos+2])<<16
+		pos += 3
+		last = bh&1 != 0
+		size := int(bh >> 3)
+		mark := len(*dst)
+		switch bh >> 1 & 3 {
+		case blockRaw:
+			if size > len(src)-pos {
+				return 0, ErrCorrupt
+			}
+			*dst = append(*dst, src[pos:pos+size]...)
+			pos += size
+		case blockRLE:
+			if pos >= len(src) || size > maxBlockSize {
+				return 0, ErrCorrupt
+			}
+			for i := 0; i < size; i++ {
+				*dst = append(*dst, src[pos])
+			}
+			pos++
+		case blockCompressed:
+			if size > len(src)-pos || size > maxBlockSize {
+				return 0, ErrCorrupt
+			}
+			if *dst, err = d.block(*dst, src[pos:pos+size]); err != nil {
+				return 0, err
+			}
+			pos += size
+		default:
+			return 0, ErrCorrupt
+		}
+		if len(*dst)-mark > maxBlockSize {
+			return 0, ErrCorrupt
+		}
+		if len(*dst) > maxDecodedLen {
+			return 0, ErrTooLarge
+		}
+	}
+	if h.size >= 0 && int64(len(*dst)-start) != h.size {
+		return 0, ErrCorrupt
+	}
+	if h.checksum {
+		if pos+4 > len(src) {
+			return 0, ErrCorrupt
+		}
+		if uint32(xxhash64((*dst)[start:])) != binary.LittleEndian.Uint32(src[pos:]) {
+			return 0, ErrChecksum
+		}
+		pos += 4
+	}
+	return pos, nil
+}
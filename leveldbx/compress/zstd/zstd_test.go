@@ -0,0 +1,105 @@
+package zstd
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"math/rand"
+	"testing"
+)
+
+func testRecords(n int) []byte {
+	var b bytes.Buffer
+	for i := 0; i < n; i++ {
+		fmt.Fprintf(&b, "key%04d=value of record %d, the quick brown fox\n", i, i*i%97)
+	}
+	return b.Bytes()
+}
+
+func testInputs() map[string][]byte {
+	r := rand.New(rand.NewSource(1))
+	m := map[string][]byte{"empty": nil, "records": testRecords(5000)}
+	for _, n := range []int{1, 31, 255, 256, 1024, 70000, 300000} {
+		rnd := make([]byte, n)
+		r.Read(rnd)
+		m[fmt.Sprint("random", n)] = rnd
+		skew := make([]byte, n)
+		for i := range skew {
+			skew[i] = byte(r.ExpFloat64() * 10)
+		}
+		m[fmt.Sprint("skewed", n)] = skew
+		m[fmt.Sprint("zero", n)] = make([]byte, n)
+	}
+	return m
+}
+
+func TestRoundTrip(t *testing.T) {
+	for name, in := range testInputs() {
+		enc := Encode(nil, in)
+		dec, err := Decode(nil, enc)
+		if err != nil {
+			t.Errorf("%s: decode: %v", name, err)
+			continue
+		}
+		if !bytes.Equal(dec, in) {
+			t.Errorf("%s: mismatch", name)
+		}
+		if n, err := DecodedLen(enc); err != nil || n != len(in) {
+			t.Errorf("%s: decoded length: want %d, got %d (%v)", name, len(in), n, err)
+		}
+	}
+	if in := testRecords(5000); len(Encode(nil, in)) > len(in)/4 {
+		t.Errorf("records: poor compression: %d -> %d", len(in), len(Encode(nil, in)))
+	}
+}
+
+// Frames written by the reference implementation.
+var testReference = []string{
+	// zstd -19
+	"28b52ffd04686d0500d2091c1690cd01f015088150ecc5dd613c333333d346f05d0a5ccccfee77bdc9c7fbae88a98beed7c872f9ddd9b63ea7eebdaa263fe61e1e43d69fe9fb8dcc97abd8d98a8a97ecce5fd95f9b9317974f3f15ef00aa391633350fb19422899cd51068056c194f53b4671dcd6ad1324802ab9a1250a821883dec7f07e02d07210409c10f184684197ee541c8f460e2896f0181896468fb2fb465d1c619be389c72a905d1130673bc1572ad80a606d9695de5",
+	// zstd -1 --no-check
+	"28b52ffd0048050600a2ca1e1c7037c903119536d106681c316b6fc3ffff198a9048d80cd87fdd8003aa7d65bed93eaf37cd37e3ef7ecc3bb37dafaecd5cb9a84f43c46bdfd3eeacaeb67fce5ed527de7ef1ecec65c68dca8dabd7bc54c33e9e59552d0619149c8300084e7220c450280164120244284224c730091d878cb2300c14aaaa1683044ea821787effefa033940e11fc178ea008a3fac4d85fcffe7af64716024dd2e727010920d0930ed2c739ee9410431d52d2f4df4ade4b8a2dbbbb3d9f1dfbcfe4d1aa",
+}
+
+func TestDecodeReference(t *testing.T) {
+	want := testRecords(40)
+	var all []byte
+	for i, s := range testReference {
+		frame, _ := hex.DecodeString(s)
+		all = append(all, frame...)
+		got, err := Decode(nil, frame)
+		if err != nil {
+			t.Fatalf("frame %d: %v", i, err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("frame %d: mismatch", i)
+		}
+	}
+	// Concatenated frames, and a skippable one.
+	all = append(all, 0x50, 0x2a, 0x4d, 0x18, 2, 0, 0, 0, 'h', 'i')
+	got, err := Decode(nil, all)
+	if err != nil || !bytes.Equal(got, append(want[:len(want):len(want)], want...)) {
+		t.Errorf("concatenated frames: %v", err)
+	}
+}
+
+func TestDecodeCorrupt(t *testing.T) {
+	frame, _ := hex.DecodeString(testReference[0])
+	bad := append([]byte(nil), frame...)
+	bad[len(bad)-1] ^= 1
+	if _, err := Decode(nil, bad); err != ErrChecksum {
+		t.Errorf("checksum: want %v, got %v", ErrChecksum, err)
+	}
+	for n := 1; n < len(frame); n++ {
+		if _, err := Decode(nil, frame[:n]); err == nil {
+			t.Fatalf("truncated at %d: no error", n)
+		}
+	}
+	r := rand.New(rand.NewSource(2))
+	enc := Encode(nil, testRecords(1000))
+	for i := 0; i < 2000; i++ {
+		bad := append([]byte(nil), enc...)
+		bad[r.Intn(len(bad))] ^= byte(1 << uint(r.Intn(8)))
+		Decode(nil, bad) // Must not panic.
+	}
+}
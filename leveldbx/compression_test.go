@@ -0,0 +1,125 @@
+package leveldb
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/maxymania/storage-engines/leveldbx/table"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+	"github.com/syndtr/goleveldb/leveldb/storage"
+)
+
+// Returns the compression ratio of each level, from the leveldb.compression property.
+func (h *dbHarness) compressionRatios() map[int]float64 {
+	value, err := h.db.GetProperty("leveldb.compression")
+	if err != nil {
+		h.t.Fatal("GetProperty: got error: ", err)
+	}
+	ratios := make(map[int]float64)
+	for _, line := range strings.Split(value, "\n")[3:] {
+		var level, tables int
+		var size, raw, ratio float64
+		if n, _ := fmt.Sscanf(line, " %d | %d | %f | %f | %f", &level, &tables, &size, &raw, &ratio); n == 5 {
+			ratios[level] = ratio
+		}
+	}
+	return ratios
+}
+
+func (h *dbHarness) putBatchOf(n int, value string) {
+	for i := 0; i < n; i++ {
+		h.put(fmt.Sprintf("k%04d", i), value)
+	}
+}
+
+func TestDB_CompressionLevels(t *testing.T) {
+	h := new(dbHarness)
+	h.conf = &Config{Compression: []table.Codec{table.NoCodec, table.LZ4Codec, table.ZstdCodec}}
+	h.init(t, &opt.Options{DisableLargeBatchTransaction: true})
+	defer h.close()
+	h.db.memdbMaxLevel = 2
+
+	// Each flush overlaps the previous one, and lands a level higher.
+	for b := 0; b < 3; b++ {
+		h.putBatchOf(200, strings.Repeat(fmt.Sprintf("batch %d ", b), 40))
+		h.compactMem()
+	}
+	h.tablesPerLevel("1,1,1")
+	ratios := h.compressionRatios()
+	if ratios[0] != 1 {
+		t.Errorf("level 0: want no compression, got ratio %.3f", ratios[0])
+	}
+	for level := 1; level <= 2; level++ {
+		if ratios[level] < 4 {
+			t.Errorf("level %d: want a ratio above 4, got %.3f", level, ratios[level])
+		}
+	}
+
+	// Tables keep their codecs, and stay readable with other options.
+	h.conf = nil
+	h.reopenDB()
+	h.getVal("k0042", strings.Repeat("batch 2 ", 40))
+	if got := h.compressionRatios(); got[1] != ratios[1] || got[2] != ratios[2] {
+		t.Errorf("ratios changed on reopen: want %v, got %v", ratios, got)
+	}
+	h.compactRange("", "")
+	h.getVal("k0199", strings.Repeat("batch 2 ", 40))
+	if got := h.compressionRatios(); len(got) != 1 {
+		t.Errorf("want a single level after compaction, got %v", got)
+	}
+}
+
+func TestDB_CompressionDeepestLevel(t *testing.T) {
+	h := new(dbHarness)
+	h.conf = &Config{Compression: []table.Codec{nil, table.ZstdCodec}}
+	h.init(t, &opt.Options{DisableLargeBatchTransaction: true, Compression: opt.SnappyCompression})
+	defer h.close()
+	h.db.memdbMaxLevel = 2
+
+	h.putBatchOf(200, strings.Repeat("deep ", 80))
+	h.compactMem()
+	h.tablesPerLevel("0,0,1")
+	if ratio := h.compressionRatios()[2]; ratio < 4 {
+		t.Errorf("level 2: want the last codec, got ratio %.3f", ratio)
+	}
+	h.putBatchOf(200, "v")
+	h.compactMem()
+	h.put("k0001", "w")
+	h.compactMem()
+	h.tablesPerLevel("1,1,1")
+	if ratio := h.compressionRatios()[0]; ratio != 1 {
+		t.Errorf("level 0: want no compression, got ratio %.3f", ratio)
+	}
+	h.getVal("k0001", "w")
+	h.getVal("k0002", "v")
+}
+
+func TestSessionRecord_TableRawSize(t *testing.T) {
+	v := &sessionRecord{}
+	v.addTableFile(1, &tFile{fd: storage.FileDesc{Type: storage.TypeTable, Num: 7}, size: 10, raw: 40,
+		imin: makeInternalKey(nil, []byte("a"), 1, keyTypeVal),
+		imax: makeInternalKey(nil, []byte("b"), 2, keyTypeVal)})
+	v.addTable(1, 8, 10,
+		makeInternalKey(nil, []byte("c"), 3, keyTypeVal),
+		makeInternalKey(nil, []byte("d"), 4, keyTypeVal))
+
+	b := new(bytes.Buffer)
+	if err := v.encode(b); err != nil {
+		t.Fatal("encode: got error: ", err)
+	}
+	v2 := &sessionRecord{}
+	if err := v2.decode(b); err != nil {
+		t.Fatal("decode: got error: ", err)
+	}
+	if len(v2.addedTables) != 2 {
+		t.Fatalf("decode: want 2 tables, got %d", len(v2.addedTables))
+	}
+	if got := v2.addedTables[0].raw; got != 40 {
+		t.Errorf("decode: want raw size 40, got %d", got)
+	}
+	if got := tableFileFromRecord(v2.addedTables[1]); got.raw != 0 || (tFiles{got}).rawSize() != 10 {
+		t.Errorf("decode: want unknown raw size, got %d", got.raw)
+	}
+}
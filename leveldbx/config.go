@@ -0,0 +1,115 @@
+/*
+This is free and unencumbered software released into the public domain.
+
+Anyone is free to copy, modify, publish, use, compile, sell, or
+distribute this software, either in source code form or as a compiled
+binary, for any purpose, commercial or non-commercial, and by any
+means.
+
+In jurisdictions that recognize copyright laws, the author or authors
+of this software dedicate any and all copyright interest in the
+software to the public domain. We make this dedication for the benefit
+of the public at large and to the detriment of our heirs and
+successors. We intend this dedication to be an overt act of
+relinquishment in perpetuity of all present and future rights to this
+software under copyright law.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+IN NO EVENT SHALL THE AUTHORS BE LIABLE FOR ANY CLAIM, DAMAGES OR
+OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE,
+ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR
+OTHER DEALINGS IN THE SOFTWARE.
+
+For more information, please refer to <http://unlicense.org/>
+*/
+
+package leveldb
+
+import "github.com/maxymania/storage-engines/leveldbx/table"
+
+/*
+Config bundles the extensions of this fork, that are set up when the database is opened
+by OpenWithConfig, OpenFileWithConfig, RecoverWithConfig or RecoverFileWithConfig.
+*/
+type Config struct {
+	Expiration
+
+	// The MergeOperator resolving merge operands, or nil. It must not change
+	// between two opens of the same database.
+	Merge MergeOperator
+
+	// The options of the named keyspaces, see DB.Keyspace. An empty map
+	// enables the keyspaces with the default options; nil disables them.
+	Keyspaces map[string]KeyspaceOptions
+
+	// Holds obsolete journal files until all subscribers have acknowledged their
+	// batches, also across a reopen, see DB.Subscribe.
+	RetainJournals bool
+
+	// Adds the prefixes of the keys to the table filters, see PrefixExtractor.
+	PrefixExtractor PrefixExtractor
+
+	// The block codec of each level, for example {table.NoCodec, table.LZ4Codec,
+	// table.LZ4Codec, table.ZstdCodec}; the last codec applies to all deeper levels.
+	// Empty keeps the Compression of the options. Tables keep their codec until they
+	// are rewritten, so the list may change between two opens.
+	Compression []table.Codec
+
+	// Limits the bytes per second written by flushes and compactions, or nil.
+	RateLimiter *RateLimiter
+
+	// Receives the write stalls and compactions, or nil.
+	Events *EventListener
+
+	// Encrypts the files at rest, or nil.
+	Encryption *Encryption
+
+	// Maintains derived entries, such as secondary indexes, along with the writes, or nil.
+	WriteHook WriteHook
+
+	// Arbitrates the memdbs and block caches of several databases, or nil.
+	MemoryBudget *MemoryBudget
+}
+
+// Returns the configuration, that applies the AutoExpire during compaction
+// only, as Open does.
+func autoExpireConfig(aexp AutoExpire) *Config {
+	return &Config{Expiration: Expiration{AutoExpire: aexp}}
+}
+
+func (s *session) setConfig(cp *Config) {
+	var c Config
+	if cp != nil {
+		c = *cp
+	}
+	s.setExpiration(&c.Expiration)
+	s.merge = c.Merge
+	s.setKeyspaces(c.Keyspaces)
+	s.retainJournals = c.RetainJournals
+	s.prefix = c.PrefixExtractor
+	s.codecs = c.Compression
+	s.limiter = c.RateLimiter
+	s.events = c.Events
+	s.hook = c.WriteHook
+	s.budget = c.MemoryBudget
+	if c.Encryption != nil {
+		s.enc = newEncStorage(s.stor.Storage, c.Encryption)
+		s.stor.Storage = s.enc
+	}
+}
+
+// Returns the block codec of tables written to the given level, or nil for the default.
+func (s *session) levelCodec(level int) table.Codec {
+	if len(s.codecs) == 0 {
+		return nil
+	}
+	if level >= len(s.codecs) {
+		level = len(s.codecs) - 1
+	}
+	if s.codecs[level] == nil {
+		return table.NoCodec
+	}
+	return s.codecs[level]
+}
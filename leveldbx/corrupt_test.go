@@ -42,7 +42,7 @@ func (h *dbCorruptHarness) recover() {
 	t := p.t
 
 	var err error
-	p.db, err = Recover(h.stor, h.o)
+	p.db, err = RecoverWithConfig(h.stor, h.o, h.conf)
 	if err != nil {
 		t.Fatal("Repair: got error: ", err)
 	}
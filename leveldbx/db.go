@@ -10,6 +10,7 @@ import (
 	"container/list"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
 	"runtime"
 	"strings"
@@ -17,13 +18,13 @@ import (
 	"sync/atomic"
 	"time"
 
+	"github.com/maxymania/storage-engines/leveldbx/table"
 	"github.com/syndtr/goleveldb/leveldb/errors"
 	"github.com/syndtr/goleveldb/leveldb/iterator"
 	"github.com/syndtr/goleveldb/leveldb/journal"
 	"github.com/syndtr/goleveldb/leveldb/memdb"
 	"github.com/syndtr/goleveldb/leveldb/opt"
 	"github.com/syndtr/goleveldb/leveldb/storage"
-	"github.com/syndtr/goleveldb/leveldb/table"
 	"github.com/syndtr/goleveldb/leveldb/util"
 )
 
@@ -63,6 +64,7 @@ type DB struct {
 	writeDelay   time.Duration
 	writeDelayN  int
 	tr           *Transaction
+	subs         subscriptions
 
 	// Compaction.
 	compCommitLk     sync.Mutex
@@ -76,6 +78,9 @@ type DB struct {
 	compStats        cStats
 	memdbMaxLevel    int // For testing.
 
+	// The share of the memory budget, or nil.
+	budget *budgetMember
+
 	// Close.
 	closeW sync.WaitGroup
 	closeC chan struct{}
@@ -110,6 +115,7 @@ func openDB(s *session) (*DB, error) {
 		// Close
 		closeC: make(chan struct{}),
 	}
+	s.tops.closeC = db.closeC
 
 	// Read-only mode.
 	readOnly := s.o.GetReadOnly()
@@ -137,6 +143,11 @@ func openDB(s *session) (*DB, error) {
 
 	}
 
+	db.subs.init(db.seq)
+	if s.budget != nil {
+		s.budget.join(db, readOnly)
+	}
+
 	// Doesn't need to be included in the wait group.
 	go db.compactionError()
 	go db.mpoolDrain()
@@ -148,6 +159,10 @@ func openDB(s *session) (*DB, error) {
 		go db.tCompaction()
 		go db.mCompaction()
 		// go db.jWriter()
+		if s.sweepInterval > 0 {
+			db.closeW.Add(1)
+			go db.tSweep()
+		}
 	}
 
 	s.logf("db@open done T·%v", time.Since(start))
@@ -165,10 +180,19 @@ func openDB(s *session) (*DB, error) {
 // detected in the DB. Use errors.IsCorrupted to test whether an error is
 // due to corruption. Corrupted DB can be recovered with Recover function.
 //
+// The AutoExpire, if not nil, decides during compaction, which values are
+// dropped. The extensions of this fork are configured by OpenWithConfig.
+//
 // The returned DB instance is safe for concurrent use.
 // The DB must be closed after use, by calling Close method.
 func Open(stor storage.Storage, o *opt.Options, aexp AutoExpire) (db *DB, err error) {
-	s, err := newSession(stor, o, aexp)
+	return OpenWithConfig(stor, o, autoExpireConfig(aexp))
+}
+
+// OpenWithConfig is like Open, but sets up the extensions of this fork as
+// configured by c, which may be nil.
+func OpenWithConfig(stor storage.Storage, o *opt.Options, c *Config) (db *DB, err error) {
+	s, err := newSession(stor, o, c)
 	if err != nil {
 		return
 	}
@@ -211,11 +235,17 @@ func Open(stor storage.Storage, o *opt.Options, aexp AutoExpire) (db *DB, err er
 // The returned DB instance is safe for concurrent use.
 // The DB must be closed after use, by calling Close method.
 func OpenFile(path string, o *opt.Options, aexp AutoExpire) (db *DB, err error) {
+	return OpenFileWithConfig(path, o, autoExpireConfig(aexp))
+}
+
+// OpenFileWithConfig is like OpenFile, but sets up the extensions of this fork
+// as configured by c, which may be nil.
+func OpenFileWithConfig(path string, o *opt.Options, c *Config) (db *DB, err error) {
 	stor, err := storage.OpenFile(path, o.GetReadOnly())
 	if err != nil {
 		return
 	}
-	db, err = Open(stor, o, aexp)
+	db, err = OpenWithConfig(stor, o, c)
 	if err != nil {
 		stor.Close()
 	} else {
@@ -232,7 +262,13 @@ func OpenFile(path string, o *opt.Options, aexp AutoExpire) (db *DB, err error)
 // The returned DB instance is safe for concurrent use.
 // The DB must be closed after use, by calling Close method.
 func Recover(stor storage.Storage, o *opt.Options, aexp AutoExpire) (db *DB, err error) {
-	s, err := newSession(stor, o, aexp)
+	return RecoverWithConfig(stor, o, autoExpireConfig(aexp))
+}
+
+// RecoverWithConfig is like Recover, but sets up the extensions of this fork
+// as configured by c, which may be nil.
+func RecoverWithConfig(stor storage.Storage, o *opt.Options, c *Config) (db *DB, err error) {
+	s, err := newSession(stor, o, c)
 	if err != nil {
 		return
 	}
@@ -261,11 +297,17 @@ func Recover(stor storage.Storage, o *opt.Options, aexp AutoExpire) (db *DB, err
 // The returned DB instance is safe for concurrent use.
 // The DB must be closed after use, by calling Close method.
 func RecoverFile(path string, o *opt.Options, aexp AutoExpire) (db *DB, err error) {
+	return RecoverFileWithConfig(path, o, autoExpireConfig(aexp))
+}
+
+// RecoverFileWithConfig is like RecoverFile, but sets up the extensions of
+// this fork as configured by c, which may be nil.
+func RecoverFileWithConfig(path string, o *opt.Options, c *Config) (db *DB, err error) {
 	stor, err := storage.OpenFile(path, false)
 	if err != nil {
 		return
 	}
-	db, err = Recover(stor, o, aexp)
+	db, err = RecoverWithConfig(stor, o, c)
 	if err != nil {
 		stor.Close()
 	} else {
@@ -286,6 +328,17 @@ func recoverTable(s *session, o *opt.Options) error {
 	}
 	sortFds(fds)
 
+	// The temp files may hold tables quarantined by Repair; keep them.
+	tmps, err := s.stor.List(storage.TypeTemp)
+	if err != nil {
+		return err
+	}
+	for _, fd := range tmps {
+		if fd.Num >= s.stTempFileNum {
+			s.stTempFileNum = fd.Num + 1
+		}
+	}
+
 	var (
 		maxSeq                                                            uint64
 		recoveredKey, goodKey, corruptedKey, corruptedBlock, droppedTable int
@@ -362,7 +415,13 @@ func recoverTable(s *session, o *opt.Options) error {
 			tSeq                                     uint64
 			tgoodKey, tcorruptedKey, tcorruptedBlock int
 			imin, imax                               []byte
+			trdels                                   rangeTombstones
+			texp                                     tExpiry
 		)
+		// The size of the table, as if no block was compressed, is taken
+		// from a copy of the entries, that is discarded.
+		rw := table.NewWriter(ioutil.Discard, s.o.Options)
+		rw.SetCodec(table.NoCodec)
 		tr, err := table.NewReader(reader, size, fd, nil, bpool, o)
 		if err != nil {
 			return err
@@ -380,7 +439,7 @@ func recoverTable(s *session, o *opt.Options) error {
 		// Scan the table.
 		for iter.Next() {
 			key := iter.Key()
-			_, seq, _, kerr := parseInternalKey(key)
+			ukey, seq, kt, kerr := parseInternalKey(key)
 			if kerr != nil {
 				tcorruptedKey++
 				continue
@@ -389,6 +448,14 @@ func recoverTable(s *session, o *opt.Options) error {
 			if seq > tSeq {
 				tSeq = seq
 			}
+			if kt == keyTypeRangeDel {
+				trdels = append(trdels, rangeTombstone{append([]byte{}, ukey...), append([]byte{}, iter.Value()...), seq})
+			}
+			texp.add(s.deadline(kt, ukey, iter.Value()))
+			if err := rw.Append(key, iter.Value()); err != nil {
+				iter.Release()
+				return err
+			}
 			if imin == nil {
 				imin = append([]byte{}, key...)
 			}
@@ -399,6 +466,9 @@ func recoverTable(s *session, o *opt.Options) error {
 			return err
 		}
 		iter.Release()
+		if err := rw.Close(); err != nil {
+			return err
+		}
 
 		goodKey += tgoodKey
 		corruptedKey += tcorruptedKey
@@ -433,6 +503,12 @@ func recoverTable(s *session, o *opt.Options) error {
 			recoveredKey += tgoodKey
 			// Add table to level 0.
 			rec.addTable(0, fd.Num, size, imin, imax)
+			rec.setTableMaxSeq(fd.Num, tSeq)
+			rec.setTableExpiry(fd.Num, texp)
+			rec.setTableRawSize(fd.Num, int64(rw.RawBytesLen()))
+			for _, t := range trdels {
+				rec.addTableRangeDel(fd.Num, t)
+			}
 			s.logf("table@recovery recovered @%d Gk·%d Ck·%d Cb·%d S·%d Q·%d", fd.Num, tgoodKey, tcorruptedKey, tcorruptedBlock, size, tSeq)
 		} else {
 			droppedTable++
@@ -483,6 +559,9 @@ func (db *DB) recoverJournal() error {
 	for _, fd := range rawFds {
 		if fd.Num >= db.s.stJournalNum || fd.Num == db.s.stPrevJournalNum {
 			fds = append(fds, fd)
+		} else if db.s.retainJournals {
+			// Retained for the subscribers by the previous session.
+			db.holdJournal(fd)
 		}
 	}
 
@@ -503,6 +582,7 @@ func (db *DB) recoverJournal() error {
 			strict      = db.s.o.GetStrict(opt.StrictJournal)
 			checksum    = db.s.o.GetStrict(opt.StrictJournalChecksum)
 			writeBuffer = db.s.o.GetWriteBuffer()
+			committed   = db.s.stSeqNum
 
 			jr       *journal.Reader
 			mdb      = memdb.New(db.s.icmp, writeBuffer)
@@ -543,7 +623,7 @@ func (db *DB) recoverJournal() error {
 				}
 				rec.resetAddedTables()
 
-				db.s.stor.Remove(ofd)
+				db.holdJournal(ofd)
 				ofd = storage.FileDesc{}
 			}
 
@@ -570,6 +650,9 @@ func (db *DB) recoverJournal() error {
 					fr.Close()
 					return errors.SetFd(err, fd)
 				}
+				if db.skipCommittedBatch(buf.Bytes(), committed) {
+					continue
+				}
 				batchSeq, batchLen, err = decodeBatchToMem(buf.Bytes(), db.seq, mdb)
 				if err != nil {
 					if !strict && errors.IsCorrupted(err) {
@@ -627,7 +710,7 @@ func (db *DB) recoverJournal() error {
 
 	// Remove the last obsolete journal file.
 	if !ofd.Zero() {
-		db.s.stor.Remove(ofd)
+		db.holdJournal(ofd)
 	}
 
 	return nil
@@ -654,6 +737,7 @@ func (db *DB) recoverJournalRO() error {
 		strict      = db.s.o.GetStrict(opt.StrictJournal)
 		checksum    = db.s.o.GetStrict(opt.StrictJournalChecksum)
 		writeBuffer = db.s.o.GetWriteBuffer()
+		committed   = db.s.stSeqNum
 
 		mdb = memdb.New(db.s.icmp, writeBuffer)
 	)
@@ -706,6 +790,9 @@ func (db *DB) recoverJournalRO() error {
 					fr.Close()
 					return errors.SetFd(err, fd)
 				}
+				if db.skipCommittedBatch(buf.Bytes(), committed) {
+					continue
+				}
 				batchSeq, batchLen, err = decodeBatchToMem(buf.Bytes(), db.seq, mdb)
 				if err != nil {
 					if !strict && errors.IsCorrupted(err) {
@@ -727,38 +814,52 @@ func (db *DB) recoverJournalRO() error {
 	}
 
 	// Set memDB.
-	db.mem = &memDB{db: db, DB: mdb, ref: 1}
+	db.mem = &memDB{db: db, DB: mdb, ref: 1, rdels: memRangeDels(mdb)}
 
 	return nil
 }
 
-func memGet(mdb *memdb.DB, ikey internalKey, icmp *iComparer) (ok bool, mv []byte, err error) {
+// Entries older than cover are deleted by a range tombstone.
+func memGet(mdb *memdb.DB, ikey internalKey, cover uint64, icmp *iComparer) (ok bool, kt keyType, mv []byte, err error) {
 	mk, mv, err := mdb.Find(ikey)
 	if err == nil {
-		ukey, _, kt, kerr := parseInternalKey(mk)
+		ukey, seq, kt, kerr := parseInternalKey(mk)
 		if kerr != nil {
 			// Shouldn't have had happen.
 			panic(kerr)
 		}
 		if icmp.uCompare(ukey, ikey.ukey()) == 0 {
-			if kt == keyTypeDel {
-				return true, nil, ErrNotFound
+			if kt == keyTypeDel || kt == keyTypeRangeDel || seq < cover {
+				return true, keyTypeDel, nil, ErrNotFound
 			}
-			return true, mv, nil
+			return true, kt, mv, nil
 
 		}
 	} else if err != ErrNotFound {
-		return true, nil, err
+		return true, 0, nil, err
 	}
 	return
 }
 
-func (db *DB) get(auxm *memdb.DB, auxt tFiles, key []byte, seq uint64, ro *opt.ReadOptions) (value []byte, err error) {
+func (db *DB) get(auxm *memDB, auxt tFiles, key []byte, seq uint64, ro *opt.ReadOptions) (value []byte, err error) {
+	value, _, err = db.getEx(auxm, auxt, key, seq, ro)
+	return
+}
+
+// Like get, but returns the expiration deadline of the value as well.
+// Expired values hide older versions, just like deletions.
+func (db *DB) getEx(auxm *memDB, auxt tFiles, key []byte, seq uint64, ro *opt.ReadOptions) (value []byte, deadline int64, err error) {
 	ikey := makeInternalKey(nil, key, seq, keyTypeSeek)
+	aexp := db.readExpire(ro)
+	cover := db.rangeDels(auxm, auxt).cover(db.s.icmp, key, seq)
 
 	if auxm != nil {
-		if ok, mv, me := memGet(auxm, ikey, db.s.icmp); ok {
-			return append([]byte{}, mv...), me
+		if ok, kt, mv, me := memGet(auxm.DB, ikey, cover, db.s.icmp); ok {
+			if kt == keyTypeMerge && me == nil {
+				return db.mergeGet(auxm, auxt, key, seq, cover, ro, aexp)
+			}
+			value, deadline, err = db.s.lookupResult(kt, key, mv, me, aexp)
+			return append([]byte{}, value...), deadline, err
 		}
 	}
 
@@ -769,19 +870,26 @@ func (db *DB) get(auxm *memdb.DB, auxt tFiles, key []byte, seq uint64, ro *opt.R
 		}
 		defer m.decref()
 
-		if ok, mv, me := memGet(m.DB, ikey, db.s.icmp); ok {
-			return append([]byte{}, mv...), me
+		if ok, kt, mv, me := memGet(m.DB, ikey, cover, db.s.icmp); ok {
+			if kt == keyTypeMerge && me == nil {
+				return db.mergeGet(auxm, auxt, key, seq, cover, ro, aexp)
+			}
+			value, deadline, err = db.s.lookupResult(kt, key, mv, me, aexp)
+			return append([]byte{}, value...), deadline, err
 		}
 	}
 
 	v := db.s.version()
-	value, cSched, err := v.get(auxt, ikey, ro, false)
+	value, kt, cSched, err := v.get(auxt, ikey, cover, ro, false)
 	v.release()
 	if cSched {
 		// Trigger table compaction.
 		db.compTrigger(db.tcompCmdC)
 	}
-	return
+	if err == nil && kt == keyTypeMerge {
+		return db.mergeGet(auxm, auxt, key, seq, cover, ro, aexp)
+	}
+	return db.s.lookupResult(kt, key, value, err, aexp)
 }
 
 func nilIfNotFound(err error) error {
@@ -791,11 +899,14 @@ func nilIfNotFound(err error) error {
 	return err
 }
 
-func (db *DB) has(auxm *memdb.DB, auxt tFiles, key []byte, seq uint64, ro *opt.ReadOptions) (ret bool, err error) {
+func (db *DB) has(auxm *memDB, auxt tFiles, key []byte, seq uint64, ro *opt.ReadOptions) (ret bool, err error) {
 	ikey := makeInternalKey(nil, key, seq, keyTypeSeek)
+	aexp := db.readExpire(ro)
+	cover := db.rangeDels(auxm, auxt).cover(db.s.icmp, key, seq)
 
 	if auxm != nil {
-		if ok, _, me := memGet(auxm, ikey, db.s.icmp); ok {
+		if ok, kt, mv, me := memGet(auxm.DB, ikey, cover, db.s.icmp); ok {
+			_, _, me = db.s.lookupResult(kt, key, mv, me, aexp)
 			return me == nil, nilIfNotFound(me)
 		}
 	}
@@ -807,18 +918,24 @@ func (db *DB) has(auxm *memdb.DB, auxt tFiles, key []byte, seq uint64, ro *opt.R
 		}
 		defer m.decref()
 
-		if ok, _, me := memGet(m.DB, ikey, db.s.icmp); ok {
+		if ok, kt, mv, me := memGet(m.DB, ikey, cover, db.s.icmp); ok {
+			_, _, me = db.s.lookupResult(kt, key, mv, me, aexp)
 			return me == nil, nilIfNotFound(me)
 		}
 	}
 
 	v := db.s.version()
-	_, cSched, err := v.get(auxt, ikey, ro, true)
+	// The value is needed, if it has to be checked for expiration.
+	value, kt, cSched, err := v.get(auxt, ikey, cover, ro, aexp == nil)
+	if err == nil && kt == keyTypeValTTL && value == nil {
+		value, kt, _, err = v.get(auxt, ikey, cover, ro, false)
+	}
 	v.release()
 	if cSched {
 		// Trigger table compaction.
 		db.compTrigger(db.tcompCmdC)
 	}
+	_, _, err = db.s.lookupResult(kt, key, value, err, aexp)
 	if err == nil {
 		ret = true
 	} else if err == ErrNotFound {
@@ -830,6 +947,12 @@ func (db *DB) has(auxm *memdb.DB, auxt tFiles, key []byte, seq uint64, ro *opt.R
 // Get gets the value for the given key. It returns ErrNotFound if the
 // DB does not contains the key.
 //
+// Values written with a time-to-live are not returned, once it elapsed.
+// If the AutoExpire is applied on reads (see Expiration.OnRead and Expiration.OnReadFunc),
+// Get returns ErrNotFound for expired values as well.
+//
+// Pending merge operands are combined with the value by the MergeOperator.
+//
 // The returned slice is its own copy, it is safe to modify the contents
 // of the returned slice.
 // It is safe to modify the contents of the argument after Get returns.
@@ -883,7 +1006,7 @@ func (db *DB) NewIterator(slice *util.Range, ro *opt.ReadOptions) iterator.Itera
 	defer db.releaseSnapshot(se)
 	// Iterator holds 'version' lock, 'version' is immutable so snapshot
 	// can be released after iterator created.
-	return db.newIterator(nil, nil, se.seq, slice, ro)
+	return db.newIterator(nil, nil, se.seq, slice, ro, nil)
 }
 
 // GetSnapshot returns a latest snapshot of the underlying DB. A snapshot
@@ -902,6 +1025,7 @@ func (db *DB) GetSnapshot() (*Snapshot, error) {
 // GetProperty returns value of the given property name.
 //
 // Property names:
+//
 //	leveldb.num-files-at-level{n}
 //		Returns the number of files at level 'n'.
 //	leveldb.stats
@@ -922,6 +1046,12 @@ func (db *DB) GetSnapshot() (*Snapshot, error) {
 //		Returns number of alive snapshots.
 //	leveldb.aliveiters
 //		Returns number of alive iterators.
+//	leveldb.expired
+//		Returns number of entries dropped by expiration for each level.
+//	leveldb.reclaimable
+//		Returns estimated size of expired entries still held by tables.
+//	leveldb.compression
+//		Returns size with and without compression for each level.
 func (db *DB) GetProperty(name string) (value string, err error) {
 	err = db.ok()
 	if err != nil {
@@ -989,6 +1119,28 @@ func (db *DB) GetProperty(name string) (value string, err error) {
 		value = fmt.Sprintf("%d", atomic.LoadInt32(&db.aliveSnaps))
 	case p == "aliveiters":
 		value = fmt.Sprintf("%d", atomic.LoadInt32(&db.aliveIters))
+	case p == "expired":
+		value = "Expired\n" +
+			" Level |   Entries\n" +
+			"-------+------------\n"
+		for level := range v.levels {
+			value += fmt.Sprintf(" %3d   | %10d\n", level, db.compStats.getExpired(level))
+		}
+	case p == "reclaimable":
+		value = fmt.Sprintf("%d", v.reclaimable(db.s.now().UnixNano()))
+	case p == "compression":
+		value = "Compression\n" +
+			" Level |   Tables   |    Size(MB)   |  RawSize(MB)  |  Ratio\n" +
+			"-------+------------+---------------+---------------+---------\n"
+		for level, tables := range v.levels {
+			if len(tables) == 0 {
+				continue
+			}
+			size, raw := tables.size(), tables.rawSize()
+			value += fmt.Sprintf(" %3d   | %10d | %13.5f | %13.5f | %7.3f\n",
+				level, len(tables), float64(size)/1048576.0, float64(raw)/1048576.0,
+				float64(raw)/float64(size))
+		}
 	default:
 		err = ErrNotFound
 	}
@@ -1046,6 +1198,11 @@ func (db *DB) Close() error {
 	start := time.Now()
 	db.log("db@close closing")
 
+	// Leave the memory budget.
+	if db.budget != nil {
+		db.budget.b.leave(db.budget)
+	}
+
 	// Clear the finalizer.
 	runtime.SetFinalizer(db, nil)
 
@@ -23,12 +23,14 @@ type cStat struct {
 	duration time.Duration
 	read     int64
 	write    int64
+	expired  int64
 }
 
 func (p *cStat) add(n *cStatStaging) {
 	p.duration += n.duration
 	p.read += n.read
 	p.write += n.write
+	p.expired += n.expired
 }
 
 func (p *cStat) get() (duration time.Duration, read, write int64) {
@@ -41,6 +43,7 @@ type cStatStaging struct {
 	on       bool
 	read     int64
 	write    int64
+	expired  int64
 }
 
 func (p *cStatStaging) startTimer() {
@@ -82,6 +85,15 @@ func (p *cStats) getStat(level int) (duration time.Duration, read, write int64)
 	return
 }
 
+func (p *cStats) getExpired(level int) int64 {
+	p.lk.Lock()
+	defer p.lk.Unlock()
+	if level < len(p.stats) {
+		return p.stats[level].expired
+	}
+	return 0
+}
+
 func (db *DB) compactionError() {
 	var err error
 noerr:
@@ -296,7 +308,10 @@ func (db *DB) memCompaction() {
 		rec        = &sessionRecord{}
 		stats      = &cStatStaging{}
 		flushLevel int
+		start      = time.Now()
+		info       = CompactionInfo{Kind: CompactionFlush, SourceLevel: -1, TargetLevel: -1}
 	)
+	db.s.events.compactionBegin(info)
 
 	// Generate tables.
 	db.compactionTransactFunc("memdb@flush", func(cnt *compactionTransactCounter) (err error) {
@@ -329,6 +344,11 @@ func (db *DB) memCompaction() {
 	}
 	db.compStats.addStat(flushLevel, stats)
 
+	info.TargetLevel, info.OutputTables = flushLevel, len(rec.addedTables)
+	info.ReadBytes, info.WriteBytes = int64(mdb.Size()), stats.write
+	info.Duration = time.Since(start)
+	db.s.events.compactionEnd(info)
+
 	// Drop frozen memdb.
 	db.dropFrozenMem()
 
@@ -359,9 +379,15 @@ type tableCompactionBuilder struct {
 	snapIter        int
 	snapKerrCnt     int
 	snapDropCnt     int
+	snapExpCnt      int
 
 	kerrCnt int
 	dropCnt int
+	expCnt  int
+
+	mergeOps []mergeOperand // pending merge operands of lastUkey, newest first
+
+	rdels rangeFragments // range tombstones of the version
 
 	minSeq    uint64
 	strict    bool
@@ -386,7 +412,7 @@ func (b *tableCompactionBuilder) appendKV(key, value []byte) error {
 
 		// Create new table.
 		var err error
-		b.tw, err = b.s.tops.create()
+		b.tw, err = b.s.tops.create(b.c.targetLevel)
 		if err != nil {
 			return err
 		}
@@ -397,7 +423,7 @@ func (b *tableCompactionBuilder) appendKV(key, value []byte) error {
 }
 
 func (b *tableCompactionBuilder) needFlush() bool {
-	return b.tw.tw.BytesLen() >= b.tableSize
+	return b.tw.tw.BytesLen() >= b.s.keyspaceTableSize(internalKey(b.tw.first).ukey(), b.tableSize)
 }
 
 func (b *tableCompactionBuilder) flush() error {
@@ -405,9 +431,9 @@ func (b *tableCompactionBuilder) flush() error {
 	if err != nil {
 		return err
 	}
-	b.rec.addTableFile(b.c.sourceLevel+1, t)
+	b.rec.addTableFile(b.c.targetLevel, t)
 	b.stat1.write += t.size
-	b.s.logf("table@build created L%d@%d N·%d S·%s %q:%q", b.c.sourceLevel+1, t.fd.Num, b.tw.tw.EntriesLen(), shortenb(int(t.size)), t.imin, t.imax)
+	b.s.logf("table@build created L%d@%d N·%d S·%s %q:%q", b.c.targetLevel, t.fd.Num, b.tw.tw.EntriesLen(), shortenb(int(t.size)), t.imin, t.imax)
 	b.tw = nil
 	return nil
 }
@@ -424,8 +450,11 @@ func (b *tableCompactionBuilder) run(cnt *compactionTransactCounter) error {
 	hasLastUkey := b.snapHasLastUkey // The key might has zero length, so this is necessary.
 	lastUkey := append([]byte{}, b.snapLastUkey...)
 	lastSeq := b.snapLastSeq
+	var cover uint64 // cover of lastUkey by the range tombstones
 	b.kerrCnt = b.snapKerrCnt
 	b.dropCnt = b.snapDropCnt
+	b.expCnt = b.snapExpCnt
+	b.mergeOps = b.mergeOps[:0]
 	// Restore compaction state.
 	b.c.restore()
 
@@ -460,8 +489,12 @@ func (b *tableCompactionBuilder) run(cnt *compactionTransactCounter) error {
 			if !hasLastUkey || b.s.icmp.uCompare(lastUkey, ukey) != 0 {
 				// First occurrence of this user key.
 
+				if err := b.mergeFlush(lastUkey); err != nil {
+					return err
+				}
+
 				// Only rotate tables if ukey doesn't hop across.
-				if b.tw != nil && (shouldStop || b.needFlush()) {
+				if b.tw != nil && (shouldStop || b.needFlush() || b.s.keyspaceBoundary(lastUkey, ukey)) {
 					if err := b.flush(); err != nil {
 						return err
 					}
@@ -474,18 +507,86 @@ func (b *tableCompactionBuilder) run(cnt *compactionTransactCounter) error {
 					b.snapIter = i
 					b.snapKerrCnt = b.kerrCnt
 					b.snapDropCnt = b.dropCnt
+					b.snapExpCnt = b.expCnt
 				}
 
 				hasLastUkey = true
 				lastUkey = append(lastUkey[:0], ukey...)
 				lastSeq = keyMaxSeq
+				cover = b.rdels.cover(b.s.icmp, ukey, b.minSeq)
 			}
 
-			switch {
-			case kt != keyTypeDel && !b.s.aexp.Retain(iter.Value()):
+			if kt == keyTypeRangeDel || seq < cover {
+				// Extension:
+				//   Range tombstones and the entries they delete end a
+				//   chain of merge operands, like a deletion marker.
+				if len(b.mergeOps) > 0 {
+					if _, err := b.mergeFull(ukey, nil); err != nil {
+						return err
+					}
+				}
+				lastSeq = seq
+				if kt != keyTypeRangeDel {
+					// Dropped because a range tombstone, that is visible to
+					// every snapshot, deletes this entry.
+					b.dropCnt++
+					continue
+				}
+				// A range tombstone is never hidden by newer entries of its
+				// start key. It is obsolete, once it is visible to every
+				// snapshot and no table outside of this compaction may hold
+				// an entry of its range.
+				if seq <= b.minSeq && b.c.rangeDelObsolete(b.s.icmp, ukey, iter.Value()) {
+					b.dropCnt++
+					continue
+				}
+				if err := b.appendKV(ikey, iter.Value()); err != nil {
+					return err
+				}
+				continue
+			}
+
+			if len(b.mergeOps) > 0 {
 				// Extension:
-				//   Expired key value pairs should be dropped.
-				fallthrough
+				//   Collecting merge operands, that are not visible to any
+				//   snapshot separately. A value or deletion marker ends
+				//   the chain; the older entries are dropped by rule (A).
+				lastSeq = seq
+				if kt == keyTypeMerge {
+					b.mergeOps = append(b.mergeOps, mergeOperand{seq, append([]byte{}, iter.Value()...)})
+					continue
+				}
+				var base []byte
+				if kt != keyTypeDel {
+					if !b.s.retain(kt, ukey, iter.Value(), b.s.aexp) {
+						b.expCnt++
+					} else if kt == keyTypeValTTL {
+						// The operands outlive the value.
+						if err := b.mergePartial(ukey); err != nil {
+							return err
+						}
+						if err := b.appendKV(ikey, iter.Value()); err != nil {
+							return err
+						}
+						continue
+					} else {
+						base = iter.Value()
+					}
+				}
+				if ok, err := b.mergeFull(ukey, base); err != nil {
+					return err
+				} else if ok {
+					b.dropCnt++
+					continue
+				}
+				// The operands were kept, so is this entry.
+				if err := b.appendKV(ikey, iter.Value()); err != nil {
+					return err
+				}
+				continue
+			}
+
+			switch {
 			case lastSeq <= b.minSeq:
 				// Dropped because newer entry for same user key exist
 				fallthrough // (A)
@@ -500,6 +601,28 @@ func (b *tableCompactionBuilder) run(cnt *compactionTransactCounter) error {
 				lastSeq = seq
 				b.dropCnt++
 				continue
+			case kt != keyTypeDel && !b.s.retain(kt, ukey, iter.Value(), b.s.aexp):
+				// Extension:
+				//   Expired key value pairs act like deletion markers. They
+				//   can be dropped under the same conditions, otherwise they
+				//   are replaced by a deletion marker, so that older entries
+				//   for the same user key in higher levels won't reappear.
+				lastSeq = seq
+				b.expCnt++
+				if seq <= b.minSeq && b.c.baseLevelForKey(lastUkey) {
+					b.dropCnt++
+					continue
+				}
+				if err := b.appendKV(makeInternalKey(nil, ukey, seq, keyTypeDel), nil); err != nil {
+					return err
+				}
+				continue
+			case kt == keyTypeMerge && seq <= b.minSeq && b.s.merge != nil:
+				// Extension:
+				//   Start collecting the merge operands of this user key.
+				lastSeq = seq
+				b.mergeOps = append(b.mergeOps, mergeOperand{seq, append([]byte{}, iter.Value()...)})
+				continue
 			default:
 				lastSeq = seq
 			}
@@ -507,6 +630,9 @@ func (b *tableCompactionBuilder) run(cnt *compactionTransactCounter) error {
 			if b.strict {
 				return kerr
 			}
+			if err := b.mergeFlush(lastUkey); err != nil {
+				return err
+			}
 
 			// Don't drop corrupted keys.
 			hasLastUkey = false
@@ -528,6 +654,9 @@ func (b *tableCompactionBuilder) run(cnt *compactionTransactCounter) error {
 	if err := iter.Error(); err != nil {
 		return err
 	}
+	if err := b.mergeFlush(lastUkey); err != nil {
+		return err
+	}
 
 	// Finish last table.
 	if b.tw != nil && !b.tw.empty() {
@@ -551,15 +680,23 @@ func (db *DB) tableCompaction(c *compaction, noTrivial bool) {
 
 	rec := &sessionRecord{}
 	rec.addCompPtr(c.sourceLevel, c.imax)
+	start := time.Now()
+	info := CompactionInfo{Kind: CompactionTable, SourceLevel: c.sourceLevel, TargetLevel: c.targetLevel,
+		InputTables: len(c.levels[0]) + len(c.levels[1])}
 
 	if !noTrivial && c.trivial() {
 		t := c.levels[0][0]
-		db.logf("table@move L%d@%d -> L%d", c.sourceLevel, t.fd.Num, c.sourceLevel+1)
+		info.Kind, info.OutputTables = CompactionMove, 1
+		db.s.events.compactionBegin(info)
+		db.logf("table@move L%d@%d -> L%d", c.sourceLevel, t.fd.Num, c.targetLevel)
 		rec.delTable(c.sourceLevel, t.fd.Num)
-		rec.addTableFile(c.sourceLevel+1, t)
+		rec.addTableFile(c.targetLevel, t)
 		db.compactionCommit("table-move", rec)
+		info.Duration = time.Since(start)
+		db.s.events.compactionEnd(info)
 		return
 	}
+	db.s.events.compactionBegin(info)
 
 	var stats [2]cStatStaging
 	for i, tables := range c.levels {
@@ -571,7 +708,7 @@ func (db *DB) tableCompaction(c *compaction, noTrivial bool) {
 	}
 	sourceSize := int(stats[0].read + stats[1].read)
 	minSeq := db.minSeq()
-	db.logf("table@compaction L%d·%d -> L%d·%d S·%s Q·%d", c.sourceLevel, len(c.levels[0]), c.sourceLevel+1, len(c.levels[1]), shortenb(sourceSize), minSeq)
+	db.logf("table@compaction L%d·%d -> L%d·%d S·%s Q·%d", c.sourceLevel, len(c.levels[0]), c.targetLevel, len(c.levels[1]), shortenb(sourceSize), minSeq)
 
 	b := &tableCompactionBuilder{
 		db:        db,
@@ -580,10 +717,12 @@ func (db *DB) tableCompaction(c *compaction, noTrivial bool) {
 		rec:       rec,
 		stat1:     &stats[1],
 		minSeq:    minSeq,
+		rdels:     c.v.rfrags,
 		strict:    db.s.o.GetStrict(opt.StrictCompaction),
-		tableSize: db.s.o.GetCompactionTableSize(c.sourceLevel + 1),
+		tableSize: db.s.o.GetCompactionTableSize(c.targetLevel),
 	}
 	db.compactionTransact("table@build", b)
+	stats[1].expired = int64(b.expCnt)
 
 	// Commit.
 	stats[1].startTimer()
@@ -591,12 +730,17 @@ func (db *DB) tableCompaction(c *compaction, noTrivial bool) {
 	stats[1].stopTimer()
 
 	resultSize := int(stats[1].write)
-	db.logf("table@compaction committed F%s S%s Ke·%d D·%d T·%v", sint(len(rec.addedTables)-len(rec.deletedTables)), sshortenb(resultSize-sourceSize), b.kerrCnt, b.dropCnt, stats[1].duration)
+	db.logf("table@compaction committed F%s S%s Ke·%d D·%d E·%d T·%v", sint(len(rec.addedTables)-len(rec.deletedTables)), sshortenb(resultSize-sourceSize), b.kerrCnt, b.dropCnt, b.expCnt, stats[1].duration)
 
 	// Save compaction stats
 	for i := range stats {
-		db.compStats.addStat(c.sourceLevel+1, &stats[i])
+		db.compStats.addStat(c.targetLevel, &stats[i])
 	}
+
+	info.OutputTables = len(rec.addedTables)
+	info.ReadBytes, info.WriteBytes = stats[0].read+stats[1].read, stats[1].write
+	info.Duration = time.Since(start)
+	db.s.events.compactionEnd(info)
 }
 
 func (db *DB) tableRangeCompaction(level int, umin, umax []byte) error {
@@ -632,6 +776,23 @@ func (db *DB) tableRangeCompaction(level int, umin, umax []byte) error {
 				break
 			}
 		}
+
+		// The range tombstones in the bottommost level are only applied by
+		// rewriting their tables.
+		v := db.s.version()
+		m := -1
+		for i, tables := range v.levels {
+			if tables.overlaps(db.s.icmp, umin, umax, false) {
+				m = i
+			}
+		}
+		bottom := m >= 0 && v.levels[m].hasRangeDels(db.s.icmp, umin, umax)
+		v.release()
+		if bottom {
+			if c := db.s.getCompactionRange(m, umin, umax, false); c != nil {
+				db.tableCompaction(c, true)
+			}
+		}
 	}
 
 	return nil
@@ -819,12 +980,21 @@ func (db *DB) tCompaction() {
 				return
 			}
 		}
+		db.tableDropCovered()
 		if x != nil {
 			switch cmd := x.(type) {
 			case cAuto:
 				ackQ = append(ackQ, x)
 			case cRange:
 				x.ack(db.tableRangeCompaction(cmd.level, cmd.min, cmd.max))
+			case cSweep:
+				x.ack(db.tableSweepCompaction(cmd.ctx, cmd.ratio))
+			case cIngest:
+				x.ack(db.tableIngest(cmd.tables, cmd.seq, cmd.journaled))
+			case cRotate:
+				x.ack(db.tableRotateKeys(cmd.ctx))
+			case cRepair:
+				x.ack(db.tableRepair(cmd.ctx, cmd.rep))
 			default:
 				panic("leveldb: unknown command")
 			}
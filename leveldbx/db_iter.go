@@ -7,12 +7,14 @@
 package leveldb
 
 import (
+	"bytes"
 	"errors"
 	"math/rand"
 	"runtime"
 	"sync"
 	"sync/atomic"
 
+	"github.com/maxymania/storage-engines/leveldbx/table"
 	"github.com/syndtr/goleveldb/leveldb/iterator"
 	"github.com/syndtr/goleveldb/leveldb/opt"
 	"github.com/syndtr/goleveldb/leveldb/util"
@@ -33,12 +35,12 @@ func (mr *memdbReleaser) Release() {
 	})
 }
 
-func (db *DB) newRawIterator(auxm *memDB, auxt tFiles, slice *util.Range, ro *opt.ReadOptions) iterator.Iterator {
+func (db *DB) newRawIterator(auxm *memDB, auxt tFiles, slice *util.Range, ro *opt.ReadOptions, to *table.IterOptions) iterator.Iterator {
 	strict := opt.GetStrict(db.s.o.Options, ro, opt.StrictReader)
 	em, fm := db.getMems()
 	v := db.s.version()
 
-	tableIts := v.getIterators(slice, ro)
+	tableIts := v.getIterators(slice, ro, to)
 	n := len(tableIts) + len(auxt) + 3
 	its := make([]iterator.Iterator, 0, n)
 
@@ -48,7 +50,7 @@ func (db *DB) newRawIterator(auxm *memDB, auxt tFiles, slice *util.Range, ro *op
 		its = append(its, ami)
 	}
 	for _, t := range auxt {
-		its = append(its, v.s.tops.newIterator(t, slice, ro))
+		its = append(its, v.s.tops.newIterator(t, slice, ro, to))
 	}
 
 	emi := em.NewIterator(slice)
@@ -65,7 +67,8 @@ func (db *DB) newRawIterator(auxm *memDB, auxt tFiles, slice *util.Range, ro *op
 	return mi
 }
 
-func (db *DB) newIterator(auxm *memDB, auxt tFiles, seq uint64, slice *util.Range, ro *opt.ReadOptions) *dbIter {
+func (db *DB) newIterator(auxm *memDB, auxt tFiles, seq uint64, slice *util.Range, ro *opt.ReadOptions, to *table.IterOptions) *dbIter {
+	slice = db.s.keyspaceSlice(slice)
 	var islice *util.Range
 	if slice != nil {
 		islice = &util.Range{}
@@ -76,13 +79,17 @@ func (db *DB) newIterator(auxm *memDB, auxt tFiles, seq uint64, slice *util.Rang
 			islice.Limit = makeInternalKey(nil, slice.Limit, keyMaxSeq, keyTypeSeek)
 		}
 	}
-	rawIter := db.newRawIterator(auxm, auxt, islice, ro)
+	rdels := db.rangeDels(auxm, auxt)
+	rawIter := db.newRawIterator(auxm, auxt, islice, ro, to)
 	iter := &dbIter{
 		db:     db,
 		icmp:   db.s.icmp,
 		iter:   rawIter,
 		seq:    seq,
+		rdels:  rdels,
 		strict: opt.GetStrict(db.s.o.Options, ro, opt.StrictReader),
+		pinned: to.GetPinned(),
+		aexp:   db.readExpire(ro),
 		key:    make([]byte, 0),
 		value:  make([]byte, 0),
 	}
@@ -112,6 +119,12 @@ type dbIter struct {
 	iter   iterator.Iterator
 	seq    uint64
 	strict bool
+	aexp   AutoExpire // nil, if the AutoExpire is not applied
+
+	// If pinned, the keys are kept in arena, and the values refer to the
+	// pinned blocks and memdbs; see IterOptions.Pinned.
+	pinned bool
+	arena  []byte
 
 	smaplingGap int
 	dir         dir
@@ -119,6 +132,34 @@ type dbIter struct {
 	value       []byte
 	err         error
 	releaser    util.Releaser
+
+	// Merge operands collected by prev, oldest first, and whether value
+	// holds their base.
+	ops  [][]byte
+	base bool
+
+	// The range tombstones, and the cover of the user key ckey.
+	rdels  rangeDelSet
+	ckey   []byte
+	cover  uint64
+	cvalid bool
+}
+
+// Reports whether the entry is deleted by a range tombstone; a tombstone
+// deletes its own start key as well.
+func (i *dbIter) covered(ukey []byte, seq uint64, kt keyType) bool {
+	if kt == keyTypeRangeDel {
+		return true
+	}
+	if len(i.rdels) == 0 {
+		return false
+	}
+	if !i.cvalid || i.icmp.uCompare(ukey, i.ckey) != 0 {
+		i.ckey = append(i.ckey[:0], ukey...)
+		i.cover = i.rdels.cover(i.icmp, ukey, i.seq)
+		i.cvalid = true
+	}
+	return seq < i.cover
 }
 
 func (i *dbIter) sampleSeek() {
@@ -130,6 +171,40 @@ func (i *dbIter) sampleSeek() {
 	}
 }
 
+// Sets the current key; a pinned key is not overwritten.
+func (i *dbIter) setKey(ukey []byte) {
+	if !i.pinned {
+		i.key = append(i.key[:0], ukey...)
+		return
+	}
+	if bytes.Equal(i.key, ukey) {
+		return
+	}
+	if cap(i.arena)-len(i.arena) < len(ukey) {
+		n := 4096
+		if len(ukey) > n {
+			n = len(ukey)
+		}
+		i.arena = make([]byte, 0, n)
+	}
+	off := len(i.arena)
+	i.arena = append(i.arena, ukey...)
+	i.key = i.arena[off:len(i.arena):len(i.arena)]
+}
+
+// Sets the current value; a pinned value is not copied.
+func (i *dbIter) setValue(value []byte) {
+	if i.pinned {
+		i.value = value
+	} else {
+		i.value = append(i.value[:0], value...)
+	}
+}
+
+func (i *dbIter) expired(kt keyType, ukey []byte) bool {
+	return !i.db.s.retain(kt, ukey, i.iter.Value(), i.aexp)
+}
+
 func (i *dbIter) setErr(err error) {
 	i.err = err
 	i.key = nil
@@ -202,16 +277,36 @@ func (i *dbIter) next() bool {
 		if ukey, seq, kt, kerr := parseInternalKey(i.iter.Key()); kerr == nil {
 			i.sampleSeek()
 			if seq <= i.seq {
+				if i.covered(ukey, seq, kt) {
+					kt = keyTypeDel
+				}
 				switch kt {
 				case keyTypeDel:
 					// Skip deleted key.
-					i.key = append(i.key[:0], ukey...)
+					i.setKey(ukey)
 					i.dir = dirForward
-				case keyTypeVal:
+				case keyTypeVal, keyTypeValTTL:
 					if i.dir == dirSOI || i.icmp.uCompare(ukey, i.key) > 0 {
-						i.key = append(i.key[:0], ukey...)
-						i.value = append(i.value[:0], i.iter.Value()...)
+						i.setKey(ukey)
 						i.dir = dirForward
+						if i.expired(kt, ukey) {
+							// Skip expired key.
+							break
+						}
+						_, value := splitValue(kt, i.iter.Value())
+						i.setValue(value)
+						return true
+					}
+				case keyTypeMerge:
+					if i.dir == dirSOI || i.icmp.uCompare(ukey, i.key) > 0 {
+						i.setKey(ukey)
+						i.dir = dirForward
+						value, err := i.db.s.mergeEntries(i.iter, i.key, i.cover, i.aexp)
+						if err != nil {
+							i.setErr(err)
+							return false
+						}
+						i.setValue(value)
 						return true
 					}
 				}
@@ -254,12 +349,32 @@ func (i *dbIter) prev() bool {
 				i.sampleSeek()
 				if seq <= i.seq {
 					if !del && i.icmp.uCompare(ukey, i.key) < 0 {
-						return true
+						return i.merge()
+					}
+					if i.covered(ukey, seq, kt) {
+						kt = keyTypeDel
 					}
-					del = (kt == keyTypeDel)
-					if !del {
-						i.key = append(i.key[:0], ukey...)
-						i.value = append(i.value[:0], i.iter.Value()...)
+					if kt == keyTypeMerge {
+						if del {
+							i.setKey(ukey)
+							i.base = false
+							i.ops = i.ops[:0]
+						}
+						del = false
+						op := i.iter.Value()
+						if !i.pinned {
+							op = append([]byte{}, op...)
+						}
+						i.ops = append(i.ops, op)
+					} else {
+						del = (kt == keyTypeDel) || i.expired(kt, ukey)
+						if !del {
+							_, value := splitValue(kt, i.iter.Value())
+							i.setKey(ukey)
+							i.setValue(value)
+							i.base = true
+							i.ops = i.ops[:0]
+						}
 					}
 				}
 			} else if i.strict {
@@ -276,6 +391,29 @@ func (i *dbIter) prev() bool {
 		i.iterErr()
 		return false
 	}
+	return i.merge()
+}
+
+// Applies the merge operands collected by prev.
+func (i *dbIter) merge() bool {
+	if len(i.ops) == 0 {
+		return true
+	}
+	if i.db.s.merge == nil {
+		i.setErr(ErrNoMergeOperator)
+		return false
+	}
+	var base []byte
+	if i.base {
+		base = i.value
+	}
+	value, err := i.db.s.merge.FullMerge(i.key, base, i.ops)
+	i.ops = i.ops[:0]
+	if err != nil {
+		i.setErr(err)
+		return false
+	}
+	i.setValue(value)
 	return true
 }
 
@@ -338,6 +476,7 @@ func (i *dbIter) Release() {
 		i.dir = dirReleased
 		i.key = nil
 		i.value = nil
+		i.arena = nil
 		i.iter.Release()
 		i.iter = nil
 		atomic.AddInt32(&i.db.aliveIters, -1)
@@ -158,7 +158,7 @@ func (snap *Snapshot) NewIterator(slice *util.Range, ro *opt.ReadOptions) iterat
 	}
 	// Since iterator already hold version ref, it doesn't need to
 	// hold snapshot ref.
-	return snap.db.newIterator(nil, nil, snap.elem.seq, slice, ro)
+	return snap.db.newIterator(nil, nil, snap.elem.seq, slice, ro, nil)
 }
 
 // Release releases the snapshot. This will not release any returned
@@ -8,6 +8,7 @@ package leveldb
 
 import (
 	"errors"
+	"sync"
 	"sync/atomic"
 	"time"
 
@@ -24,6 +25,12 @@ type memDB struct {
 	db *DB
 	*memdb.DB
 	ref int32
+
+	// Extension: the range tombstones written to the memdb, and their
+	// fragments, built on demand.
+	rdMu   sync.Mutex
+	rdels  rangeTombstones
+	rfrags rangeFragments
 }
 
 func (m *memDB) getref() int32 {
@@ -201,15 +208,14 @@ func (db *DB) getFrozenMem() *memDB {
 // Drop frozen memdb; assume that frozen memdb isn't nil.
 func (db *DB) dropFrozenMem() {
 	db.memMu.Lock()
-	if err := db.s.stor.Remove(db.frozenJournalFd); err != nil {
-		db.logf("journal@remove removing @%d %q", db.frozenJournalFd.Num, err)
-	} else {
-		db.logf("journal@remove removed @%d", db.frozenJournalFd.Num)
-	}
+	db.releaseJournal(db.frozenJournalFd)
 	db.frozenJournalFd = storage.FileDesc{}
 	db.frozenMem.decref()
 	db.frozenMem = nil
 	db.memMu.Unlock()
+	if db.budget != nil {
+		db.budget.report()
+	}
 }
 
 // Clear mems ptr; used by DB.Close().
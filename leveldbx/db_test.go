@@ -63,6 +63,7 @@ type dbHarness struct {
 	stor *testutil.Storage
 	db   *DB
 	o    *opt.Options
+	conf *Config
 	ro   *opt.ReadOptions
 	wo   *opt.WriteOptions
 }
@@ -96,7 +97,7 @@ func (h *dbHarness) init(t *testing.T, o *opt.Options) {
 
 func (h *dbHarness) openDB0() (err error) {
 	h.t.Log("opening DB")
-	h.db, err = Open(h.stor, h.o)
+	h.db, err = OpenWithConfig(h.stor, h.o, h.conf)
 	return
 }
 
@@ -139,7 +140,7 @@ func (h *dbHarness) close() {
 }
 
 func (h *dbHarness) openAssert(want bool) {
-	db, err := Open(h.stor, h.o)
+	db, err := OpenWithConfig(h.stor, h.o, h.conf)
 	if err != nil {
 		if want {
 			h.t.Error("Open: assert: got error: ", err)
@@ -276,7 +277,7 @@ func (h *dbHarness) allEntriesFor(key, want string) {
 	s := db.s
 
 	ikey := makeInternalKey(nil, []byte(key), keyMaxSeq, keyTypeVal)
-	iter := db.newRawIterator(nil, nil, nil, nil)
+	iter := db.newRawIterator(nil, nil, nil, nil, nil)
 	if !iter.Seek(ikey) && iter.Error() != nil {
 		t.Error("AllEntries: error during seek, err: ", iter.Error())
 		return
@@ -297,6 +298,10 @@ func (h *dbHarness) allEntriesFor(key, want string) {
 				res += string(iter.Value())
 			case keyTypeDel:
 				res += "DEL"
+			case keyTypeMerge:
+				res += "MERGE:" + string(iter.Value())
+			case keyTypeRangeDel:
+				res += "RANGEDEL"
 			}
 		} else {
 			if !first {
@@ -515,7 +520,7 @@ func truno(t *testing.T, o *opt.Options, f func(h *dbHarness)) {
 				if o == nil {
 					o = &opt.Options{
 						DisableLargeBatchTransaction: true,
-						Filter: testingBloomFilter,
+						Filter:                       testingBloomFilter,
 					}
 				} else {
 					old := o
@@ -1930,7 +1935,7 @@ func TestDB_CreateReopenDbOnFile(t *testing.T) {
 		if err != nil {
 			t.Fatalf("(%d) cannot open storage: %s", i, err)
 		}
-		db, err := Open(stor, nil)
+		db, err := Open(stor, nil, nil)
 		if err != nil {
 			t.Fatalf("(%d) cannot open db: %s", i, err)
 		}
@@ -1954,7 +1959,7 @@ func TestDB_CreateReopenDbOnFile2(t *testing.T) {
 	defer os.RemoveAll(dbpath)
 
 	for i := 0; i < 3; i++ {
-		db, err := OpenFile(dbpath, nil)
+		db, err := OpenFile(dbpath, nil, nil)
 		if err != nil {
 			t.Fatalf("(%d) cannot open db: %s", i, err)
 		}
@@ -2525,7 +2530,7 @@ func TestDB_TableCompactionBuilder(t *testing.T) {
 		CompactionGPOverlapsFactor:   1,
 		DisableBlockCache:            true,
 	}
-	s, err := newSession(stor, o)
+	s, err := newSession(stor, o, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -2539,7 +2544,7 @@ func TestDB_TableCompactionBuilder(t *testing.T) {
 		value      = bytes.Repeat([]byte{'0'}, 100)
 	)
 	for i := 0; i < 2; i++ {
-		tw, err := s.tops.create()
+		tw, err := s.tops.create(0)
 		if err != nil {
 			t.Fatal(err)
 		}
@@ -2667,8 +2672,8 @@ func TestDB_TableCompactionBuilder(t *testing.T) {
 	}
 	for i, f0 := range v.levels[1] {
 		f1 := v.levels[2][i]
-		iter0 := s.tops.newIterator(f0, nil, nil)
-		iter1 := s.tops.newIterator(f1, nil, nil)
+		iter0 := s.tops.newIterator(f0, nil, nil, nil)
+		iter1 := s.tops.newIterator(f1, nil, nil, nil)
 		for j := 0; true; j++ {
 			next0 := iter0.Next()
 			next1 := iter1.Next()
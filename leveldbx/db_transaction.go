@@ -29,6 +29,11 @@ type Transaction struct {
 	rec       sessionRecord
 	stats     cStatStaging
 	closed    bool
+
+	// The records written, if they are journaled on commit, see DB.Subscribe.
+	stream    bool
+	batch     Batch
+	journaled bool
 }
 
 // Get gets the value for the given key. It returns ErrNotFound if the
@@ -43,7 +48,7 @@ func (tr *Transaction) Get(key []byte, ro *opt.ReadOptions) ([]byte, error) {
 	if tr.closed {
 		return nil, errTransactionDone
 	}
-	return tr.db.get(tr.mem.DB, tr.tables, key, tr.seq, ro)
+	return tr.db.get(tr.mem, tr.tables, key, tr.seq, ro)
 }
 
 // Has returns true if the DB does contains the given key.
@@ -55,7 +60,7 @@ func (tr *Transaction) Has(key []byte, ro *opt.ReadOptions) (bool, error) {
 	if tr.closed {
 		return false, errTransactionDone
 	}
-	return tr.db.has(tr.mem.DB, tr.tables, key, tr.seq, ro)
+	return tr.db.has(tr.mem, tr.tables, key, tr.seq, ro)
 }
 
 // NewIterator returns an iterator for the latest snapshot of the transaction.
@@ -79,7 +84,7 @@ func (tr *Transaction) NewIterator(slice *util.Range, ro *opt.ReadOptions) itera
 		return iterator.NewEmptyIterator(errTransactionDone)
 	}
 	tr.mem.incref()
-	return tr.db.newIterator(tr.mem, tr.tables, tr.seq, slice, ro)
+	return tr.db.newIterator(tr.mem, tr.tables, tr.seq, slice, ro, nil)
 }
 
 func (tr *Transaction) flush() error {
@@ -87,7 +92,16 @@ func (tr *Transaction) flush() error {
 	if tr.mem.Len() != 0 {
 		tr.stats.startTimer()
 		iter := tr.mem.NewIterator(nil)
-		t, n, err := tr.db.s.tops.createFrom(iter)
+		var (
+			t   *tFile
+			n   int
+			err error
+		)
+		if tr.db.s.merge != nil {
+			t, n, err = tr.createCollapsed(iter)
+		} else {
+			t, n, err = tr.db.s.tops.createFrom(iter, 0)
+		}
 		iter.Release()
 		tr.stats.stopTimer()
 		if err != nil {
@@ -108,6 +122,73 @@ func (tr *Transaction) flush() error {
 	return nil
 }
 
+// Builds a level-0 table of the memdb, collapsing consecutive merge operands
+// of a key by PartialMerge. No snapshot sees the transaction before it is
+// committed, so the operands are not needed separately; those deleted by a
+// range tombstone are kept apart.
+func (tr *Transaction) createCollapsed(iter iterator.Iterator) (f *tFile, n int, err error) {
+	w, err := tr.db.s.tops.create(0)
+	if err != nil {
+		return
+	}
+	defer func() {
+		if err != nil {
+			w.drop()
+		}
+	}()
+
+	var (
+		icmp  = tr.db.s.icmp
+		rdels = tr.mem.rangeDels()
+		opKey []byte // internal key of the pending operand, the newest one
+		op    []byte
+		cover uint64
+		hasOp bool
+		emit  = func(key, value []byte) error {
+			n++
+			return w.append(key, value)
+		}
+	)
+	for iter.Next() {
+		ukey, seq, kt, kerr := parseInternalKey(iter.Key())
+		if kerr != nil {
+			return nil, 0, kerr
+		}
+		if hasOp && kt == keyTypeMerge && seq > cover && icmp.uCompare(internalKey(opKey).ukey(), ukey) == 0 {
+			if m, ok := tr.db.s.merge.PartialMerge(ukey, iter.Value(), op); ok {
+				op = m
+				continue
+			}
+		}
+		if hasOp {
+			if err = emit(opKey, op); err != nil {
+				return
+			}
+			hasOp = false
+		}
+		if kt == keyTypeMerge {
+			opKey = append(opKey[:0], iter.Key()...)
+			op = append([]byte{}, iter.Value()...)
+			cover = rdels.cover(icmp, ukey, keyMaxSeq)
+			hasOp = true
+			continue
+		}
+		if err = emit(iter.Key(), iter.Value()); err != nil {
+			return
+		}
+	}
+	if hasOp {
+		if err = emit(opKey, op); err != nil {
+			return
+		}
+	}
+	if err = iter.Error(); err != nil {
+		return
+	}
+	f, err = w.finish()
+	return
+}
+
 func (tr *Transaction) put(kt keyType, key, value []byte) error {
 	tr.ikScratch = makeInternalKey(tr.ikScratch, key, tr.seq+1, kt)
 	if tr.mem.Free() < len(tr.ikScratch)+len(value) {
@@ -118,7 +199,13 @@ func (tr *Transaction) put(kt keyType, key, value []byte) error {
 	if err := tr.mem.Put(tr.ikScratch, value); err != nil {
 		return err
 	}
+	if kt == keyTypeRangeDel {
+		tr.mem.addRangeDel(key, value, tr.seq+1)
+	}
 	tr.seq++
+	if tr.stream {
+		tr.batch.appendRec(kt, key, value)
+	}
 	return nil
 }
 
@@ -134,7 +221,7 @@ func (tr *Transaction) Put(key, value []byte, wo *opt.WriteOptions) error {
 	if tr.closed {
 		return errTransactionDone
 	}
-	return tr.put(keyTypeVal, key, value)
+	return tr.write(keyTypeVal, key, value)
 }
 
 // Delete deletes the value for the given key.
@@ -148,7 +235,43 @@ func (tr *Transaction) Delete(key []byte, wo *opt.WriteOptions) error {
 	if tr.closed {
 		return errTransactionDone
 	}
-	return tr.put(keyTypeDel, key, nil)
+	return tr.write(keyTypeDel, key, nil)
+}
+
+// Merge appends the given merge operand for the key. It fails with
+// ErrNoMergeOperator, if the DB has no MergeOperator.
+// The operands accumulate in the transaction, its reads combine them with the
+// value of the key. As the transaction is written to tables, at the latest on
+// commit, consecutive operands of a key are collapsed by PartialMerge; the
+// value is combined with them by compaction, see MergeOperator.
+//
+// It is safe to modify the contents of the arguments after Merge returns.
+func (tr *Transaction) Merge(key, operand []byte, wo *opt.WriteOptions) error {
+	tr.lk.Lock()
+	defer tr.lk.Unlock()
+	if tr.closed {
+		return errTransactionDone
+	}
+	if tr.db.s.merge == nil {
+		return ErrNoMergeOperator
+	}
+	return tr.write(keyTypeMerge, key, operand)
+}
+
+// DeleteRange deletes the keys start <= key < limit, see Batch.DeleteRange.
+// The keys written before, to the DB or earlier in the transaction, are hidden
+// from the reads of the transaction at once; keys written later in the
+// transaction are not affected. The tombstone becomes visible to the DB with
+// the commit, the deleted entries are dropped by compaction afterwards.
+//
+// It is safe to modify the contents of the arguments after DeleteRange returns.
+func (tr *Transaction) DeleteRange(start, limit []byte, wo *opt.WriteOptions) error {
+	tr.lk.Lock()
+	defer tr.lk.Unlock()
+	if tr.closed {
+		return errTransactionDone
+	}
+	return tr.write(keyTypeRangeDel, start, limit)
 }
 
 // Write apply the given batch to the transaction. The batch will be applied
@@ -167,8 +290,8 @@ func (tr *Transaction) Write(b *Batch, wo *opt.WriteOptions) error {
 	if tr.closed {
 		return errTransactionDone
 	}
-	return b.replayInternal(func(i int, kt keyType, k, v []byte) error {
-		return tr.put(kt, k, v)
+	return b.resolveTTL(tr.db.s.now()).replayInternal(func(i int, kt keyType, k, v []byte) error {
+		return tr.write(kt, k, v)
 	})
 }
 
@@ -199,6 +322,15 @@ func (tr *Transaction) Commit() error {
 		return err
 	}
 	if len(tr.tables) != 0 {
+		// Journal the records for the subscribers.
+		seq := tr.seq - uint64(tr.batch.Len()) + 1
+		if tr.stream && !tr.journaled {
+			if err := tr.db.writeJournalCommitted(&tr.batch, seq); err != nil {
+				return err
+			}
+			tr.journaled = true
+		}
+
 		// Committing transaction.
 		tr.rec.setSeqNum(tr.seq)
 		tr.db.compCommitLk.Lock()
@@ -222,6 +354,11 @@ func (tr *Transaction) Commit() error {
 			}
 		}
 		tr.stats.stopTimer()
+		if cerr != nil && tr.journaled {
+			// The journal commits the records; apply them to the memdb instead.
+			tr.db.compCommitLk.Unlock()
+			return tr.commitMem(seq)
+		}
 		if cerr != nil {
 			// Return error, lets user decide either to retry or discard
 			// transaction.
@@ -235,6 +372,11 @@ func (tr *Transaction) Commit() error {
 		tr.db.compTrigger(tr.db.tcompCmdC)
 		tr.db.compCommitLk.Unlock()
 
+		if tr.journaled {
+			tr.db.rotateCommitted()
+			tr.db.publish(seq, []*Batch{&tr.batch})
+		}
+
 		// Additionally, wait compaction when certain threshold reached.
 		// Ignore error, returns error only if transaction can't be committed.
 		tr.db.waitCompaction()
@@ -244,6 +386,25 @@ func (tr *Transaction) Commit() error {
 	return nil
 }
 
+// Commits the journaled records through the memdb, when the tables could not be
+// committed.
+func (tr *Transaction) commitMem(seq uint64) error {
+	db := tr.db
+	mdb := db.getEffectiveMem()
+	if mdb == nil {
+		return ErrClosed
+	}
+	defer mdb.decref()
+	if err := tr.batch.putMem(seq, mdb); err != nil {
+		return err
+	}
+	db.publish(seq, []*Batch{&tr.batch})
+	db.setSeq(tr.seq)
+	tr.discard()
+	tr.setDone()
+	return nil
+}
+
 func (tr *Transaction) discard() {
 	// Discard transaction.
 	for _, t := range tr.tables {
@@ -315,9 +476,10 @@ func (db *DB) OpenTransaction() (*Transaction, error) {
 	}
 
 	tr := &Transaction{
-		db:  db,
-		seq: db.seq,
-		mem: db.mpoolGet(0),
+		db:     db,
+		seq:    db.seq,
+		mem:    db.mpoolGet(0),
+		stream: db.journalCommitted(),
 	}
 	tr.mem.incref()
 	db.tr = tr
@@ -67,6 +67,7 @@ func (db *DB) checkAndCleanFiles() error {
 			} else {
 				keep = fd.Num >= db.journalFd.Num
 			}
+			keep = keep || db.subs.holds(fd)
 		case storage.TypeTable:
 			_, keep = tmap[fd.Num]
 			if keep {
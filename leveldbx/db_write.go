@@ -27,16 +27,79 @@ func (db *DB) writeJournal(batches []*Batch, seq uint64, sync bool) error {
 		return err
 	}
 	if sync {
-		return db.journalWriter.Sync()
+		if err := db.journalWriter.Sync(); err != nil {
+			return err
+		}
 	}
+	db.subs.journaled(db.journalFd.Num, seq, batchesLen(batches))
 	return nil
 }
 
+// Reports whether the batches, that a transaction or an ingestion commits to
+// tables, are journaled for the subscribers.
+func (db *DB) journalCommitted() bool {
+	return db.s.retainJournals || db.subs.active()
+}
+
+// Journals a batch, that is committed to tables by a transaction or an
+// ingestion, for the subscribers; the write lock must be held. The records
+// are replayed by the next open, unless the manifest commits the sequence
+// number seq+b.Len()-1.
+func (db *DB) writeJournalCommitted(b *Batch, seq uint64) error {
+	wr, err := db.journal.Next()
+	if err != nil {
+		return err
+	}
+	if _, err := wr.Write(encodeBatchHeader(nil, seq, b.Len()|batchCommitted)); err != nil {
+		return err
+	}
+	if _, err := wr.Write(b.data); err != nil {
+		return err
+	}
+	if err := db.journal.Flush(); err != nil {
+		return err
+	}
+	if !db.s.o.GetNoSync() {
+		if err := db.journalWriter.Sync(); err != nil {
+			return err
+		}
+	}
+	db.subs.journaled(db.journalFd.Num, seq, b.Len())
+	return nil
+}
+
+// Skips a journaled batch, that was committed to tables, when recovering the
+// journal; the manifest commits the sequence numbers up to committed.
+func (db *DB) skipCommittedBatch(data []byte, committed uint64) bool {
+	if !isCommittedBatch(data) {
+		return false
+	}
+	seq, n, err := decodeBatchHeader(data)
+	if err != nil || n == 0 || seq+uint64(n)-1 > committed {
+		return false
+	}
+	db.seq = seq + uint64(n)
+	return true
+}
+
+// Moves a journal, that holds committed batches, out of the way of the
+// subscribers, which read the current journal from the memdb; the write lock
+// must be held.
+func (db *DB) rotateCommitted() {
+	if _, err := db.rotateMem(0, false); err != nil {
+		db.logf("journal@rotate error %q", err)
+	}
+}
+
 func (db *DB) rotateMem(n int, wait bool) (mem *memDB, err error) {
 	retryLimit := 3
 retry:
 	// Wait for pending memdb compaction.
+	stalled, tLen, start := !wait && db.hasFrozenMem(), db.s.tLen(0), time.Now()
 	err = db.compTriggerWait(db.mcompCmdC)
+	if stalled {
+		db.s.events.writeStall(WriteStallInfo{Kind: StallMemdbFlush, Level0Tables: tLen, Duration: time.Since(start)})
+	}
 	if err != nil {
 		return
 	}
@@ -84,12 +147,16 @@ func (db *DB) flush(n int) (mdb *memDB, mdbFree int, err error) {
 		switch {
 		case tLen >= slowdownTrigger && !delayed:
 			delayed = true
+			start := time.Now()
 			time.Sleep(time.Millisecond)
+			db.s.events.writeStall(WriteStallInfo{Kind: StallL0Slowdown, Level0Tables: tLen, Duration: time.Since(start)})
 		case mdbFree >= n:
 			return false
 		case tLen >= pauseTrigger:
 			delayed = true
+			start := time.Now()
 			err = db.compTriggerWait(db.tcompCmdC)
+			db.s.events.writeStall(WriteStallInfo{Kind: StallL0Pause, Level0Tables: tLen, Duration: time.Since(start)})
 			if err != nil {
 				return false
 			}
@@ -148,6 +215,23 @@ func (db *DB) unlockWrite(overflow bool, merged int, err error) {
 
 // ourBatch is batch that we can modify.
 func (db *DB) writeLocked(batch, ourBatch *Batch, merge, sync bool) error {
+	// The time-to-live of the records is resolved as they are applied.
+	now := db.s.now()
+	batch = batch.resolveTTL(now)
+
+	// Add the records of the write hook.
+	if db.s.hook != nil {
+		hooked, err := db.hookBatch(batch)
+		if err != nil {
+			db.unlockWrite(false, 0, err)
+			return err
+		}
+		if ourBatch != nil {
+			db.batchPool.Put(ourBatch)
+		}
+		batch, ourBatch = hooked, hooked
+	}
+
 	// Try to flush memdb. This method would also trying to throttle writes
 	// if it is too fast and compaction cannot catch-up.
 	mdb, mdbFree, err := db.flush(batch.internalLen)
@@ -186,7 +270,7 @@ func (db *DB) writeLocked(batch, ourBatch *Batch, merge, sync bool) error {
 						overflow = true
 						break merge
 					}
-					batches = append(batches, incoming.batch)
+					batches = append(batches, incoming.batch.resolveTTL(now))
 					mergeLimit -= incoming.batch.internalLen
 				} else {
 					// Merge put.
@@ -231,12 +315,15 @@ func (db *DB) writeLocked(batch, ourBatch *Batch, merge, sync bool) error {
 
 	// Put batches.
 	for _, batch := range batches {
-		if err := batch.putMem(seq, mdb.DB); err != nil {
+		if err := batch.putMem(seq, mdb); err != nil {
 			panic(err)
 		}
 		seq += uint64(batch.Len())
 	}
 
+	// Feed the subscribers.
+	db.publish(db.seq+1, batches)
+
 	// Incr seq number.
 	db.addSeq(uint64(batchesLen(batches)))
 
@@ -246,6 +333,11 @@ func (db *DB) writeLocked(batch, ourBatch *Batch, merge, sync bool) error {
 	}
 
 	db.unlockWrite(overflow, merged, nil)
+
+	// Account the memdb to the memory budget.
+	if db.budget != nil {
+		db.budget.report()
+	}
 	return nil
 }
 
@@ -276,7 +368,8 @@ func (db *DB) Write(batch *Batch, wo *opt.WriteOptions) error {
 		return tr.Commit()
 	}
 
-	merge := !wo.GetNoWriteMerge() && !db.s.o.GetNoWriteMerge()
+	// Writes passing through the write hook are not merged, see WriteHook.
+	merge := !wo.GetNoWriteMerge() && !db.s.o.GetNoWriteMerge() && db.s.hook == nil
 	sync := wo.GetSync() && !db.s.o.GetNoSync()
 
 	// Acquire write lock.
@@ -318,7 +411,8 @@ func (db *DB) putRec(kt keyType, key, value []byte, wo *opt.WriteOptions) error
 		return err
 	}
 
-	merge := !wo.GetNoWriteMerge() && !db.s.o.GetNoWriteMerge()
+	// Writes passing through the write hook are not merged, see WriteHook.
+	merge := !wo.GetNoWriteMerge() && !db.s.o.GetNoWriteMerge() && db.s.hook == nil
 	sync := wo.GetSync() && !db.s.o.GetNoSync()
 
 	// Acquire write lock.
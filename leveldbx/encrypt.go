@@ -0,0 +1,700 @@
+/*
+This is free and unencumbered software released into the public domain.
+
+Anyone is free to copy, modify, publish, use, compile, sell, or
+distribute this software, either in source code form or as a compiled
+binary, for any purpose, commercial or non-commercial, and by any
+means.
+
+In jurisdictions that recognize copyright laws, the author or authors
+of this software dedicate any and all copyright interest in the
+software to the public domain. We make this dedication for the benefit
+of the public at large and to the detriment of our heirs and
+successors. We intend this dedication to be an overt act of
+relinquishment in perpetuity of all present and future rights to this
+software under copyright law.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+IN NO EVENT SHALL THE AUTHORS BE LIABLE FOR ANY CLAIM, DAMAGES OR
+OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE,
+ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR
+OTHER DEALINGS IN THE SOFTWARE.
+
+For more information, please refer to <http://unlicense.org/>
+*/
+
+package leveldb
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"io"
+	"sync"
+
+	"github.com/syndtr/goleveldb/leveldb/errors"
+	"github.com/syndtr/goleveldb/leveldb/storage"
+)
+
+/*
+Encryption encrypts the tables, journals and manifests at rest, see Config.Encryption.
+
+Every file starts with a plain header, holding the id of the key it is encrypted with and
+a random salt; the file key is derived from both. The content follows in blocks, each
+sealed by the AEAD of the file key, with the block number as nonce. Tables are written in
+blocks of equal size, so that they are read at random. Journals and manifests seal every
+write on its own, so a crash loses no more of them than without encryption.
+
+The tables written by compactions, and the journals and manifests, are encrypted with the
+current key of the KeyProvider, when they are created; DB.RotateKeys re-encrypts the rest.
+The info log quotes user keys, so it is dropped unless Log is set. The CURRENT and LOCK
+files carry no data, and stay plain.
+*/
+type Encryption struct {
+	// Provides the keys; required.
+	Keys KeyProvider
+
+	// Returns the AEAD sealing the blocks, for a 32 byte key; nil selects AES-256-GCM.
+	// The New function of golang.org/x/crypto/chacha20poly1305 fits as well. It must not
+	// change between two opens of the same database.
+	NewCipher func(key []byte) (cipher.AEAD, error)
+
+	// The plain bytes per block of a table; 0 means 4KiB. Tables keep their block size,
+	// so it may change between two opens.
+	BlockSize int
+
+	// Reads the files, that were written without encryption, so that DB.RotateKeys
+	// encrypts an existing database.
+	ReadPlaintext bool
+
+	// Keeps the info log, which quotes user keys in plain text.
+	Log bool
+}
+
+/*
+KeyProvider provides the keys of an Encryption. A key is identified by a number, which is
+stored in plain text with each file. A key must remain available, as long as a file is
+encrypted with it, that is until DB.RotateKeys returns after its successor became current.
+*/
+type KeyProvider interface {
+	// Returns the key encrypting new files, and its id.
+	CurrentKey() (id uint32, key []byte, err error)
+
+	// Returns the key of the given id.
+	Key(id uint32) ([]byte, error)
+}
+
+/*
+KeyRing is a KeyProvider holding the keys in memory. It is safe for concurrent use.
+*/
+type KeyRing struct {
+	mu      sync.RWMutex
+	current uint32
+	keys    map[uint32][]byte
+}
+
+// NewKeyRing returns a KeyRing, whose current key is the given one.
+func NewKeyRing(id uint32, key []byte) *KeyRing {
+	r := &KeyRing{keys: make(map[uint32][]byte)}
+	r.Add(id, key)
+	r.current = id
+	return r
+}
+
+// Add adds or replaces the key of the given id.
+func (r *KeyRing) Add(id uint32, key []byte) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.keys[id] = append([]byte{}, key...)
+}
+
+// Remove removes the key of the given id. The current key can't be removed.
+func (r *KeyRing) Remove(id uint32) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if id != r.current {
+		delete(r.keys, id)
+	}
+}
+
+// SetCurrent makes the key of the given id encrypt the new files.
+func (r *KeyRing) SetCurrent(id uint32) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.keys[id]; !ok {
+		return ErrUnknownKey
+	}
+	r.current = id
+	return nil
+}
+
+func (r *KeyRing) CurrentKey() (uint32, []byte, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.current, r.keys[r.current], nil
+}
+
+func (r *KeyRing) Key(id uint32) ([]byte, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if key, ok := r.keys[id]; ok {
+		return key, nil
+	}
+	return nil, ErrUnknownKey
+}
+
+const (
+	encMagic        = "LDBXENC1"
+	encHeaderLen    = 36
+	encLayoutBlocks = 1 // Blocks of equal size, read at random
+	encLayoutStream = 2 // A block per write, read in sequence
+	encBlockSize    = 4 << 10
+	encMaxWrite     = 64 << 20 // Largest block of a stream
+)
+
+var (
+	errEncHeader = errors.New("leveldb: invalid encryption header")
+	errEncBlock  = errors.New("leveldb: encrypted block corrupted")
+	errEncPlain  = errors.New("leveldb: file not encrypted")
+	errEncSeek   = errors.New("leveldb: encrypted journal is not seekable")
+	errEncSynced = errors.New("leveldb: encrypted table written after sync")
+)
+
+// The plain header of an encrypted file.
+type encHeader struct {
+	layout    byte
+	keyID     uint32
+	blockSize uint32
+	salt      [16]byte
+}
+
+func (h *encHeader) encode() []byte {
+	b := make([]byte, encHeaderLen)
+	copy(b, encMagic)
+	b[8] = h.layout
+	binary.LittleEndian.PutUint32(b[12:], h.keyID)
+	binary.LittleEndian.PutUint32(b[16:], h.blockSize)
+	copy(b[20:], h.salt[:])
+	return b
+}
+
+func (h *encHeader) decode(b []byte) error {
+	h.layout = b[8]
+	h.keyID = binary.LittleEndian.Uint32(b[12:])
+	h.blockSize = binary.LittleEndian.Uint32(b[16:])
+	copy(h.salt[:], b[20:])
+	switch {
+	case h.layout == encLayoutBlocks && h.blockSize > 0:
+	case h.layout == encLayoutStream && h.blockSize == 0:
+	default:
+		return errEncHeader
+	}
+	return nil
+}
+
+/*
+encStorage encrypts the files of the wrapped storage; it sits beneath the iStorage.
+*/
+type encStorage struct {
+	storage.Storage
+	e *Encryption
+}
+
+func newEncStorage(stor storage.Storage, e *Encryption) *encStorage {
+	return &encStorage{stor, e}
+}
+
+// Returns the AEAD of the file, whose header is given, derived from the key.
+func (s *encStorage) aead(h *encHeader, key []byte) (aead cipher.AEAD, err error) {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(h.salt[:])
+	fkey := mac.Sum(nil)
+	if s.e.NewCipher != nil {
+		aead, err = s.e.NewCipher(fkey)
+	} else if b, berr := aes.NewCipher(fkey); berr != nil {
+		err = berr
+	} else {
+		aead, err = cipher.NewGCM(b)
+	}
+	if err == nil && aead.NonceSize() < 8 {
+		err = errEncHeader
+	}
+	return
+}
+
+func (s *encStorage) Log(str string) {
+	if s.e.Log {
+		s.Storage.Log(str)
+	}
+}
+
+func (s *encStorage) Create(fd storage.FileDesc) (storage.Writer, error) {
+	id, key, err := s.e.Keys.CurrentKey()
+	if err != nil {
+		return nil, err
+	}
+	h := encHeader{layout: encLayoutStream, keyID: id}
+	if fd.Type == storage.TypeTable || fd.Type == storage.TypeTemp {
+		h.layout, h.blockSize = encLayoutBlocks, encBlockSize
+		if s.e.BlockSize > 0 {
+			h.blockSize = uint32(s.e.BlockSize)
+		}
+	}
+	if _, err = io.ReadFull(rand.Reader, h.salt[:]); err != nil {
+		return nil, err
+	}
+	aead, err := s.aead(&h, key)
+	if err != nil {
+		return nil, err
+	}
+	w, err := s.Storage.Create(fd)
+	if err != nil {
+		return nil, err
+	}
+	hdr := h.encode()
+	if _, err = w.Write(hdr); err != nil {
+		w.Close()
+		return nil, err
+	}
+	return &encWriter{Writer: w, aead: aead, hdr: hdr, bs: int(h.blockSize)}, nil
+}
+
+func (s *encStorage) Open(fd storage.FileDesc) (storage.Reader, error) {
+	r, err := s.Storage.Open(fd)
+	if err != nil {
+		return nil, err
+	}
+	er, err := s.reader(fd, r)
+	if err != nil {
+		r.Close()
+		return nil, err
+	}
+	return er, nil
+}
+
+// Reads the header of the file; a file shorter than the header is empty.
+func (s *encStorage) header(fd storage.FileDesc, r io.ReaderAt) (h encHeader, hdr []byte, ok bool, err error) {
+	hdr = make([]byte, encHeaderLen)
+	n, err := r.ReadAt(hdr, 0)
+	if n == len(hdr) {
+		err = nil
+	}
+	if err != nil && err != io.EOF {
+		return
+	}
+	err = nil
+	m := n
+	if m > len(encMagic) {
+		m = len(encMagic)
+	}
+	if !bytes.Equal(hdr[:m], []byte(encMagic[:m])) {
+		return h, nil, false, nil
+	}
+	if n < len(hdr) {
+		return h, nil, true, nil
+	}
+	if err = h.decode(hdr); err != nil {
+		err = errors.NewErrCorrupted(fd, err)
+	}
+	return h, hdr, true, err
+}
+
+func (s *encStorage) reader(fd storage.FileDesc, r storage.Reader) (storage.Reader, error) {
+	h, hdr, ok, err := s.header(fd, r)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		if s.e.ReadPlaintext {
+			return r, nil
+		}
+		return nil, errors.NewErrCorrupted(fd, errEncPlain)
+	}
+	if hdr == nil {
+		return &encReader{Reader: r, fd: fd, bs: 1}, nil
+	}
+	key, err := s.e.Keys.Key(h.keyID)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := s.aead(&h, key)
+	if err != nil {
+		return nil, err
+	}
+	er := &encReader{Reader: r, fd: fd, aead: aead, hdr: hdr, bs: int(h.blockSize), off: encHeaderLen}
+	if er.bs > 0 {
+		end, err := r.Seek(0, io.SeekEnd)
+		if err != nil {
+			return nil, err
+		}
+		rs := int64(4 + er.bs + aead.Overhead())
+		full, rem := (end-encHeaderLen)/rs, (end-encHeaderLen)%rs
+		er.size = full * int64(er.bs)
+		if rem > 0 {
+			if rem <= int64(4+aead.Overhead()) {
+				return nil, errors.NewErrCorrupted(fd, errEncBlock)
+			}
+			er.size += rem - int64(4+aead.Overhead())
+		}
+	}
+	return er, nil
+}
+
+// Returns the id of the key, the file is encrypted with, and false if it is plain.
+func (s *encStorage) keyID(fd storage.FileDesc) (uint32, bool, error) {
+	r, err := s.Storage.Open(fd)
+	if err != nil {
+		return 0, false, err
+	}
+	defer r.Close()
+	h, hdr, ok, err := s.header(fd, r)
+	return h.keyID, ok && hdr != nil, err
+}
+
+// The nonce and additional data of a block.
+func encBlockParams(aead cipher.AEAD, hdr []byte, num uint64, n int) (nonce, ad []byte) {
+	nonce = make([]byte, aead.NonceSize())
+	binary.BigEndian.PutUint64(nonce[len(nonce)-8:], num)
+	ad = make([]byte, len(hdr)+4)
+	copy(ad, hdr)
+	binary.LittleEndian.PutUint32(ad[len(hdr):], uint32(n))
+	return
+}
+
+type encWriter struct {
+	storage.Writer
+	aead   cipher.AEAD
+	hdr    []byte
+	bs     int    // Plain bytes per block, or 0 to seal every write
+	buf    []byte // Pending plain bytes of a table
+	num    uint64 // Blocks written
+	out    []byte
+	synced bool
+}
+
+func (w *encWriter) seal(p []byte) error {
+	nonce, ad := encBlockParams(w.aead, w.hdr, w.num, len(p))
+	w.out = append(w.out[:0], ad[len(w.hdr):]...)
+	w.out = w.aead.Seal(w.out, nonce, p, ad)
+	if _, err := w.Writer.Write(w.out); err != nil {
+		return err
+	}
+	w.num++
+	return nil
+}
+
+func (w *encWriter) Write(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if w.bs == 0 {
+		for i := 0; i < len(p); i += encMaxWrite {
+			j := i + encMaxWrite
+			if j > len(p) {
+				j = len(p)
+			}
+			if err := w.seal(p[i:j]); err != nil {
+				return i, err
+			}
+		}
+		return len(p), nil
+	}
+	if w.synced {
+		return 0, errEncSynced
+	}
+	n := len(p)
+	for len(p) > 0 {
+		m := w.bs - len(w.buf)
+		if m > len(p) {
+			m = len(p)
+		}
+		w.buf = append(w.buf, p[:m]...)
+		p = p[m:]
+		if len(w.buf) == w.bs {
+			if err := w.seal(w.buf); err != nil {
+				return 0, err
+			}
+			w.buf = w.buf[:0]
+		}
+	}
+	return n, nil
+}
+
+// Seals the last block of a table; the table is complete afterwards.
+func (w *encWriter) flush() error {
+	if w.bs == 0 || w.synced {
+		return nil
+	}
+	w.synced = true
+	if len(w.buf) == 0 {
+		return nil
+	}
+	return w.seal(w.buf)
+}
+
+func (w *encWriter) Sync() error {
+	if err := w.flush(); err != nil {
+		return err
+	}
+	return w.Writer.Sync()
+}
+
+func (w *encWriter) Close() error {
+	err := w.flush()
+	if cerr := w.Writer.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+type encReader struct {
+	storage.Reader
+	fd   storage.FileDesc
+	aead cipher.AEAD
+	hdr  []byte
+	bs   int   // Plain bytes per block, or 0 for a stream
+	size int64 // Plain size of a table
+	pos  int64 // Plain offset of Read in a table
+
+	// The state of a stream.
+	num uint64 // Next block
+	off int64  // Offset of the next block
+	buf []byte // Unread plain bytes
+}
+
+func (r *encReader) open(num uint64, n int, ct []byte) ([]byte, error) {
+	nonce, ad := encBlockParams(r.aead, r.hdr, num, n)
+	p, err := r.aead.Open(nil, nonce, ct, ad)
+	if err != nil || len(p) != n {
+		return nil, errors.NewErrCorrupted(r.fd, errEncBlock)
+	}
+	return p, nil
+}
+
+// Reads the block of a table.
+func (r *encReader) block(num int64) ([]byte, error) {
+	rs := 4 + r.bs + r.aead.Overhead()
+	b := make([]byte, rs)
+	m, err := r.Reader.ReadAt(b, encHeaderLen+num*int64(rs))
+	if m < rs && err != io.EOF {
+		return nil, err
+	}
+	if m < 4+r.aead.Overhead() {
+		return nil, errors.NewErrCorrupted(r.fd, errEncBlock)
+	}
+	n := int(binary.LittleEndian.Uint32(b))
+	if n > r.bs || 4+n+r.aead.Overhead() != m {
+		return nil, errors.NewErrCorrupted(r.fd, errEncBlock)
+	}
+	return r.open(uint64(num), n, b[4:m])
+}
+
+func (r *encReader) ReadAt(p []byte, off int64) (n int, err error) {
+	if r.bs == 0 {
+		return 0, errEncSeek
+	}
+	for n < len(p) && off < r.size {
+		num := off / int64(r.bs)
+		b, err := r.block(num)
+		if err != nil {
+			return n, err
+		}
+		c := copy(p[n:], b[off-num*int64(r.bs):])
+		n += c
+		off += int64(c)
+	}
+	if n < len(p) {
+		err = io.EOF
+	}
+	return
+}
+
+func (r *encReader) Seek(offset int64, whence int) (int64, error) {
+	if r.bs == 0 {
+		return 0, errEncSeek
+	}
+	switch whence {
+	case io.SeekCurrent:
+		offset += r.pos
+	case io.SeekEnd:
+		offset += r.size
+	}
+	if offset < 0 {
+		return 0, errEncSeek
+	}
+	r.pos = offset
+	return offset, nil
+}
+
+func (r *encReader) Read(p []byte) (n int, err error) {
+	if r.bs > 0 {
+		n, err = r.ReadAt(p, r.pos)
+		r.pos += int64(n)
+		if n > 0 && err == io.EOF {
+			err = nil
+		}
+		return
+	}
+	for len(r.buf) == 0 {
+		var lb [4]byte
+		if m, err := r.Reader.ReadAt(lb[:], r.off); m < len(lb) {
+			if err == nil || err == io.EOF {
+				err = io.EOF
+			}
+			return 0, err
+		}
+		bn := int(binary.LittleEndian.Uint32(lb[:]))
+		if bn > encMaxWrite {
+			return 0, errors.NewErrCorrupted(r.fd, errEncBlock)
+		}
+		ct := make([]byte, bn+r.aead.Overhead())
+		// A torn block at the end is left for a later read.
+		if m, err := r.Reader.ReadAt(ct, r.off+4); m < len(ct) {
+			if err == nil || err == io.EOF {
+				err = io.EOF
+			}
+			return 0, err
+		}
+		b, err := r.open(r.num, bn, ct)
+		if err != nil {
+			return 0, err
+		}
+		r.num++
+		r.off += int64(4 + len(ct))
+		r.buf = b
+	}
+	n = copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return
+}
+
+// Returns the storage beneath the encryption.
+func (s *session) rawStorage() storage.Storage {
+	if s.enc != nil {
+		return s.enc.Storage
+	}
+	return s.stor.Storage
+}
+
+// Wraps the storage into the encryption of the DB, if any.
+func (s *session) encrypt(stor storage.Storage) storage.Storage {
+	if s.enc == nil {
+		return stor
+	}
+	return newEncStorage(stor, s.enc.e)
+}
+
+/*
+RotateKeys re-encrypts the database with the current key of the KeyProvider: it flushes
+the memdb into a fresh journal, rewrites the tables encrypted with another key, and writes
+a fresh manifest. Once it returns, the former keys are no longer needed, except by the
+journals retained for the subscribers (see Config.RetainJournals).
+
+The context only cancels the rewrite between two tables.
+*/
+func (db *DB) RotateKeys(ctx context.Context) error {
+	if err := db.ok(); err != nil {
+		return err
+	}
+	if db.s.enc == nil {
+		return ErrNoEncryption
+	}
+
+	// Lock writer.
+	select {
+	case db.writeLockC <- struct{}{}:
+	case err := <-db.compPerErrC:
+		return err
+	case <-db.closeC:
+		return ErrClosed
+	}
+	if _, err := db.rotateMem(0, false); err != nil {
+		<-db.writeLockC
+		return err
+	}
+	<-db.writeLockC
+	if err := db.compTriggerWait(db.mcompCmdC); err != nil {
+		return err
+	}
+	return db.compTriggerRotate(db.tcompCmdC, ctx)
+}
+
+// Rewrites the tables encrypted with another than the current key, and the manifest.
+func (db *DB) tableRotateKeys(ctx context.Context) error {
+	id, _, err := db.s.enc.e.Keys.CurrentKey()
+	if err != nil {
+		return err
+	}
+	var stale []sweepCandidate
+	v := db.s.version()
+	for level, tables := range v.levels {
+		for _, t := range tables {
+			kid, ok, err := db.s.enc.keyID(t.fd)
+			if err != nil {
+				v.release()
+				return err
+			}
+			if !ok || kid != id {
+				stale = append(stale, sweepCandidate{level, t.fd.Num})
+			}
+		}
+	}
+	v.release()
+	for _, sc := range stale {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if c := db.s.getSweepCompaction(sc.level, sc.num); c != nil {
+			db.logf("table@rotate L%d@%d", sc.level, sc.num)
+			db.tableCompaction(c, true)
+		}
+	}
+	db.compCommitLk.Lock()
+	defer db.compCommitLk.Unlock()
+	return db.s.newManifest(nil, nil)
+}
+
+type cRotate struct {
+	ctx  context.Context
+	ackC chan<- error
+}
+
+func (r cRotate) ack(err error) {
+	if r.ackC != nil {
+		defer func() {
+			recover()
+		}()
+		r.ackC <- err
+	}
+}
+
+// Send key rotation request.
+func (db *DB) compTriggerRotate(compC chan<- cCmd, ctx context.Context) (err error) {
+	ch := make(chan error)
+	defer close(ch)
+	// Send cmd.
+	select {
+	case compC <- cRotate{ctx, ch}:
+	case err := <-db.compErrC:
+		return err
+	case <-db.closeC:
+		return ErrClosed
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	// Wait cmd.
+	select {
+	case err = <-ch:
+	case err = <-db.compErrC:
+	case <-db.closeC:
+		return ErrClosed
+	}
+	return err
+}
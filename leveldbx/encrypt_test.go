@@ -0,0 +1,293 @@
+package leveldb
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/syndtr/goleveldb/leveldb/errors"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+	"github.com/syndtr/goleveldb/leveldb/storage"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+var testEncKey1, testEncKey2 = bytes.Repeat([]byte{1}, 32), bytes.Repeat([]byte{2}, 32)
+
+func writeEncFile(t *testing.T, s storage.Storage, fd storage.FileDesc, chunks ...string) {
+	w, err := s.Create(fd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range chunks {
+		if _, err := w.Write([]byte(c)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readRawFile(t *testing.T, s storage.Storage, fd storage.FileDesc) []byte {
+	r, err := s.Open(fd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestEncStorage_Table(t *testing.T) {
+	raw := storage.NewMemStorage()
+	s := newEncStorage(raw, &Encryption{Keys: NewKeyRing(1, testEncKey1), BlockSize: 16})
+	fd := storage.FileDesc{Type: storage.TypeTable, Num: 1}
+	plain := strings.Repeat("0123456789", 10)
+	writeEncFile(t, s, fd, plain[:7], plain[7:40], plain[40:])
+
+	if b := readRawFile(t, raw, fd); bytes.Contains(b, []byte("0123456789")) {
+		t.Fatal("the table is stored in plain text")
+	}
+	r, err := s.Open(fd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size, err := r.Seek(0, io.SeekEnd); err != nil || size != 100 {
+		t.Fatalf("Seek: want size 100, got %d %v", size, err)
+	}
+	for _, c := range []struct{ off, n int }{{0, 100}, {3, 20}, {16, 16}, {90, 10}, {99, 1}} {
+		b := make([]byte, c.n)
+		if n, err := r.ReadAt(b, int64(c.off)); n != c.n || err != nil || string(b) != plain[c.off:c.off+c.n] {
+			t.Errorf("ReadAt %d+%d: got %q %v", c.off, c.n, b[:n], err)
+		}
+	}
+	if n, err := r.ReadAt(make([]byte, 10), 95); n != 5 || err != io.EOF {
+		t.Errorf("ReadAt past the end: got %d %v", n, err)
+	}
+	r.Seek(0, io.SeekStart)
+	if b, err := ioutil.ReadAll(r); err != nil || string(b) != plain {
+		t.Errorf("Read: got %q %v", b, err)
+	}
+	r.Close()
+
+	// A tampered block fails, the others can still be read.
+	b := readRawFile(t, raw, fd)
+	b[encHeaderLen+10] ^= 1
+	writeEncFile(t, raw, fd, string(b))
+	r, err = s.Open(fd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if _, err := r.ReadAt(make([]byte, 4), 0); !errors.IsCorrupted(err) {
+		t.Errorf("tampered block: want corruption, got %v", err)
+	}
+	if n, err := r.ReadAt(make([]byte, 4), 16); n != 4 || err != nil {
+		t.Errorf("intact block: got %d %v", n, err)
+	}
+}
+
+func TestEncStorage_Journal(t *testing.T) {
+	raw := storage.NewMemStorage()
+	s := newEncStorage(raw, &Encryption{Keys: NewKeyRing(1, testEncKey1)})
+	fd := storage.FileDesc{Type: storage.TypeJournal, Num: 1}
+	writeEncFile(t, s, fd, "first", "second", "third")
+
+	r, err := s.Open(fd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b, err := ioutil.ReadAll(r); err != nil || string(b) != "firstsecondthird" {
+		t.Errorf("Read: got %q %v", b, err)
+	}
+	if _, err := r.Seek(0, io.SeekEnd); err != errEncSeek {
+		t.Errorf("Seek: want %v, got %v", errEncSeek, err)
+	}
+	r.Close()
+
+	// A torn write at the end is not read.
+	b := readRawFile(t, raw, fd)
+	writeEncFile(t, raw, fd, string(b[:len(b)-3]))
+	r, err = s.Open(fd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b, err := ioutil.ReadAll(r); err != nil || string(b) != "firstsecond" {
+		t.Errorf("torn journal: got %q %v", b, err)
+	}
+	r.Close()
+
+	// So is a torn header.
+	writeEncFile(t, raw, fd, encMagic[:5])
+	r, err = s.Open(fd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b, err := ioutil.ReadAll(r); err != nil || len(b) != 0 {
+		t.Errorf("torn header: got %q %v", b, err)
+	}
+	r.Close()
+
+	// Plain files are refused, unless ReadPlaintext is set.
+	writeEncFile(t, raw, fd, "plain")
+	if _, err := s.Open(fd); !errors.IsCorrupted(err) {
+		t.Errorf("plain file: want corruption, got %v", err)
+	}
+	s.e.ReadPlaintext = true
+	if r, err := s.Open(fd); err != nil {
+		t.Error(err)
+	} else if b, err := ioutil.ReadAll(r); err != nil || string(b) != "plain" {
+		t.Errorf("plain file: got %q %v", b, err)
+	}
+}
+
+// Reports the files of the DB, whose raw content contains the given text,
+// or which are not encrypted with the given key. The DB is closed meanwhile.
+func (h *dbHarness) checkEncrypted(text string, keyID uint32) {
+	h.closeDB()
+	defer h.openDB()
+	fds, err := h.stor.List(storage.TypeAll)
+	if err != nil {
+		h.t.Fatal(err)
+	}
+	es := newEncStorage(h.stor, &Encryption{})
+	for _, fd := range fds {
+		if bytes.Contains(readRawFile(h.t, h.stor, fd), []byte(text)) {
+			h.t.Errorf("%s holds %q in plain text", fd, text)
+		}
+		if id, ok, err := es.keyID(fd); err != nil || !ok || id != keyID {
+			h.t.Errorf("%s: want key %d, got %d %v %v", fd, keyID, id, ok, err)
+		}
+	}
+}
+
+func TestDB_Encryption(t *testing.T) {
+	keys := NewKeyRing(1, testEncKey1)
+	h := new(dbHarness)
+	h.conf = &Config{Encryption: &Encryption{Keys: keys}}
+	h.init(t, &opt.Options{DisableLargeBatchTransaction: true})
+	defer h.close()
+
+	h.put("customer-1", "secret-value-1")
+	h.compactMem()
+	h.put("customer-2", "secret-value-2")
+	h.checkEncrypted("secret-value", 1)
+	h.reopenDB()
+	h.getVal("customer-1", "secret-value-1")
+	h.getVal("customer-2", "secret-value-2")
+	h.compactRange("", "")
+	h.getKeyVal("(customer-1->secret-value-1)(customer-2->secret-value-2)")
+	h.checkEncrypted("secret-value", 1)
+
+	// A wrong key can't open the DB.
+	h.closeDB()
+	h.conf = &Config{Encryption: &Encryption{Keys: NewKeyRing(1, testEncKey2)}}
+	if err := h.openDB0(); err == nil {
+		t.Fatal("open with a wrong key: want error")
+	}
+	h.conf = &Config{Encryption: &Encryption{Keys: keys}}
+	h.openDB()
+	h.getVal("customer-1", "secret-value-1")
+}
+
+func TestDB_RotateKeys(t *testing.T) {
+	keys := NewKeyRing(1, testEncKey1)
+	h := new(dbHarness)
+	h.conf = &Config{Encryption: &Encryption{Keys: keys}}
+	h.init(t, &opt.Options{DisableLargeBatchTransaction: true})
+	defer h.close()
+
+	h.put("a", "value-a")
+	h.compactMem()
+	h.put("b", "value-b")
+	h.compactMem()
+	h.put("c", "value-c")
+
+	keys.Add(2, testEncKey2)
+	if err := keys.SetCurrent(2); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.db.RotateKeys(context.Background()); err != nil {
+		t.Fatal("RotateKeys: got error: ", err)
+	}
+	h.checkEncrypted("value-", 2)
+	keys.Remove(1)
+	if _, err := keys.Key(1); err != ErrUnknownKey {
+		t.Fatalf("want %v, got %v", ErrUnknownKey, err)
+	}
+	h.reopenDB()
+	h.getKeyVal("(a->value-a)(b->value-b)(c->value-c)")
+}
+
+func TestDB_RotateKeysPlaintext(t *testing.T) {
+	h := newDbHarness(t)
+	defer h.close()
+
+	if err := h.db.RotateKeys(context.Background()); err != ErrNoEncryption {
+		t.Fatalf("want %v, got %v", ErrNoEncryption, err)
+	}
+	h.put("a", "value-a")
+	h.compactMem()
+	h.put("b", "value-b")
+	h.closeDB()
+
+	// An existing database is encrypted in place.
+	keys := NewKeyRing(1, testEncKey1)
+	h.conf = &Config{Encryption: &Encryption{Keys: keys, ReadPlaintext: true}}
+	h.openDB()
+	if err := h.db.RotateKeys(context.Background()); err != nil {
+		t.Fatal("RotateKeys: got error: ", err)
+	}
+	h.checkEncrypted("value-", 1)
+	h.closeDB()
+	h.conf = &Config{Encryption: &Encryption{Keys: keys}}
+	h.openDB()
+	h.getKeyVal("(a->value-a)(b->value-b)")
+}
+
+func TestDB_EncryptedCheckpoint(t *testing.T) {
+	src := checkpointTestDir(t, "EncCheckpointSrc")
+	defer os.RemoveAll(src)
+	dst := checkpointTestDir(t, "EncCheckpointDst")
+	defer os.RemoveAll(dst)
+
+	config := &Config{Encryption: &Encryption{Keys: NewKeyRing(1, testEncKey1)}}
+	db, err := OpenFileWithConfig(src, &opt.Options{DisableLargeBatchTransaction: true}, config)
+	if err != nil {
+		t.Fatal("OpenFile: got error: ", err)
+	}
+	defer db.Close()
+	db.Put([]byte("a"), []byte("table"), nil)
+	db.CompactRange(util.Range{})
+	db.Put([]byte("b"), []byte("memdb"), nil)
+	if err := db.Checkpoint(dst); err != nil {
+		t.Fatal("Checkpoint: got error: ", err)
+	}
+
+	fis, _ := ioutil.ReadDir(dst)
+	for _, fi := range fis {
+		b, _ := ioutil.ReadFile(filepath.Join(dst, fi.Name()))
+		if bytes.Contains(b, []byte("memdb")) || bytes.Contains(b, []byte("table")) {
+			t.Errorf("%s holds plain text", fi.Name())
+		}
+	}
+	cp, err := OpenFileWithConfig(dst, nil, config)
+	if err != nil {
+		t.Fatal("OpenFile: got error: ", err)
+	}
+	defer cp.Close()
+	if got := dumpDB(t, cp); got != "(a->table)(b->memdb)" {
+		t.Errorf("checkpoint: got %q", got)
+	}
+}
@@ -17,4 +17,14 @@ var (
 	ErrSnapshotReleased = errors.New("leveldb: snapshot released")
 	ErrIterReleased     = errors.New("leveldb: iterator released")
 	ErrClosed           = errors.New("leveldb: closed")
+	ErrCheckpointExist  = errors.New("leveldb: checkpoint directory not empty")
+	ErrNoMergeOperator  = errors.New("leveldb: no merge operator")
+	ErrKeyOrder         = errors.New("leveldb: keys not in ascending order")
+	ErrIngestOverlap    = errors.New("leveldb: ingested tables overlap")
+	ErrInvalidKeyspace  = errors.New("leveldb: invalid keyspace name")
+	ErrNotRetained      = errors.New("leveldb: sequence number not retained")
+	ErrConflict         = errors.New("leveldb: transaction conflict")
+	ErrNoDeleteRange    = errors.New("leveldb: batch replay without range deletions")
+	ErrNoEncryption     = errors.New("leveldb: encryption not configured")
+	ErrUnknownKey       = errors.New("leveldb: unknown encryption key")
 )
@@ -0,0 +1,128 @@
+/*
+This is free and unencumbered software released into the public domain.
+
+Anyone is free to copy, modify, publish, use, compile, sell, or
+distribute this software, either in source code form or as a compiled
+binary, for any purpose, commercial or non-commercial, and by any
+means.
+
+In jurisdictions that recognize copyright laws, the author or authors
+of this software dedicate any and all copyright interest in the
+software to the public domain. We make this dedication for the benefit
+of the public at large and to the detriment of our heirs and
+successors. We intend this dedication to be an overt act of
+relinquishment in perpetuity of all present and future rights to this
+software under copyright law.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+IN NO EVENT SHALL THE AUTHORS BE LIABLE FOR ANY CLAIM, DAMAGES OR
+OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE,
+ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR
+OTHER DEALINGS IN THE SOFTWARE.
+
+For more information, please refer to <http://unlicense.org/>
+*/
+
+package leveldb
+
+import "time"
+
+/*
+EventListener receives the events of a database, see Config.Events. Any callback may be
+nil. The callbacks run on the goroutine causing the event; they must return quickly, and
+must not call into the database.
+*/
+type EventListener struct {
+	// Called after a write has been stalled.
+	WriteStall func(WriteStallInfo)
+
+	// Called before and after a flush or compaction. The byte counts and the duration
+	// are set after it only.
+	CompactionBegin func(CompactionInfo)
+	CompactionEnd   func(CompactionInfo)
+}
+
+type WriteStallKind int
+
+const (
+	// Level 0 reached WriteL0SlowdownTrigger; the write was delayed by a millisecond.
+	StallL0Slowdown WriteStallKind = iota
+	// Level 0 reached WriteL0PauseTrigger; the write waited for a table compaction.
+	StallL0Pause
+	// The write waited for the flush of the previous memdb.
+	StallMemdbFlush
+)
+
+func (k WriteStallKind) String() string {
+	switch k {
+	case StallL0Slowdown:
+		return "l0-slowdown"
+	case StallL0Pause:
+		return "l0-pause"
+	case StallMemdbFlush:
+		return "memdb-flush"
+	}
+	return "unknown"
+}
+
+type WriteStallInfo struct {
+	Kind         WriteStallKind
+	Level0Tables int // The number of tables at level 0, when the stall began
+	Duration     time.Duration
+}
+
+type CompactionKind int
+
+const (
+	// A memdb is flushed into a table.
+	CompactionFlush CompactionKind = iota
+	// Tables are merged into the next level.
+	CompactionTable
+	// A table is moved into the next level, without any IO.
+	CompactionMove
+)
+
+func (k CompactionKind) String() string {
+	switch k {
+	case CompactionFlush:
+		return "flush"
+	case CompactionTable:
+		return "table"
+	case CompactionMove:
+		return "move"
+	}
+	return "unknown"
+}
+
+type CompactionInfo struct {
+	Kind CompactionKind
+
+	// SourceLevel is -1 for flushes. The TargetLevel of a flush is picked as it runs,
+	// it is -1 before.
+	SourceLevel, TargetLevel  int
+	InputTables, OutputTables int
+
+	// The bytes read from the inputs (a memdb, or tables) and written to tables.
+	ReadBytes, WriteBytes int64
+	Duration              time.Duration
+}
+
+func (e *EventListener) writeStall(i WriteStallInfo) {
+	if e != nil && e.WriteStall != nil {
+		e.WriteStall(i)
+	}
+}
+
+func (e *EventListener) compactionBegin(i CompactionInfo) {
+	if e != nil && e.CompactionBegin != nil {
+		e.CompactionBegin(i)
+	}
+}
+
+func (e *EventListener) compactionEnd(i CompactionInfo) {
+	if e != nil && e.CompactionEnd != nil {
+		e.CompactionEnd(i)
+	}
+}
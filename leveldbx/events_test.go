@@ -0,0 +1,112 @@
+package leveldb
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/syndtr/goleveldb/leveldb/opt"
+)
+
+type eventRecorder struct {
+	mu     sync.Mutex
+	stalls []WriteStallInfo
+	begins []CompactionInfo
+	ends   []CompactionInfo
+}
+
+func (r *eventRecorder) listener() *EventListener {
+	return &EventListener{
+		WriteStall: func(i WriteStallInfo) {
+			r.mu.Lock()
+			r.stalls = append(r.stalls, i)
+			r.mu.Unlock()
+		},
+		CompactionBegin: func(i CompactionInfo) {
+			r.mu.Lock()
+			r.begins = append(r.begins, i)
+			r.mu.Unlock()
+		},
+		CompactionEnd: func(i CompactionInfo) {
+			r.mu.Lock()
+			r.ends = append(r.ends, i)
+			r.mu.Unlock()
+		},
+	}
+}
+
+func (r *eventRecorder) endsOf(kind CompactionKind) (ends []CompactionInfo) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, i := range r.ends {
+		if i.Kind == kind {
+			ends = append(ends, i)
+		}
+	}
+	return
+}
+
+func TestDB_EventsCompaction(t *testing.T) {
+	rec := new(eventRecorder)
+	h := new(dbHarness)
+	h.conf = &Config{Events: rec.listener()}
+	h.init(t, &opt.Options{DisableLargeBatchTransaction: true})
+	defer h.close()
+
+	for b := 0; b < 2; b++ {
+		for i := 0; i < 100; i++ {
+			h.put(fmt.Sprintf("k%04d", i), strings.Repeat(fmt.Sprint(b), 100))
+		}
+		h.compactMem()
+	}
+	flushes := rec.endsOf(CompactionFlush)
+	if len(flushes) != 2 {
+		t.Fatalf("want 2 flushes, got %v", flushes)
+	}
+	for _, i := range flushes {
+		if i.SourceLevel != -1 || i.TargetLevel != 0 || i.OutputTables != 1 || i.ReadBytes <= 0 || i.WriteBytes <= 0 {
+			t.Errorf("unexpected flush %+v", i)
+		}
+	}
+
+	h.compactRange("", "")
+	tables := rec.endsOf(CompactionTable)
+	if len(tables) == 0 {
+		t.Fatal("want a table compaction")
+	}
+	if i := tables[0]; i.SourceLevel != 0 || i.TargetLevel != 1 || i.InputTables != 2 || i.OutputTables != 1 || i.ReadBytes <= 0 || i.WriteBytes <= 0 || i.Duration <= 0 {
+		t.Errorf("unexpected table compaction %+v", i)
+	}
+
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	if len(rec.begins) != len(rec.ends) {
+		t.Errorf("%d begins, but %d ends", len(rec.begins), len(rec.ends))
+	}
+}
+
+func TestDB_EventsWriteStall(t *testing.T) {
+	rec := new(eventRecorder)
+	h := new(dbHarness)
+	h.conf = &Config{Events: rec.listener()}
+	h.init(t, &opt.Options{
+		DisableLargeBatchTransaction: true,
+		WriteL0SlowdownTrigger:       1,
+		WriteL0PauseTrigger:          100,
+	})
+	defer h.close()
+
+	h.put("a", "v1")
+	h.compactMem()
+	h.put("b", "v2")
+
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	if len(rec.stalls) != 1 {
+		t.Fatalf("want a single stall, got %v", rec.stalls)
+	}
+	if i := rec.stalls[0]; i.Kind != StallL0Slowdown || i.Level0Tables != 1 || i.Duration <= 0 {
+		t.Errorf("unexpected stall %+v", i)
+	}
+}
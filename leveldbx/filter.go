@@ -15,6 +15,10 @@ type iFilter struct {
 }
 
 func (f iFilter) Contains(filter, key []byte) bool {
+	if isPrefixProbe(key) {
+		// The filter has no prefixes.
+		return true
+	}
 	return f.Filter.Contains(filter, internalKey(key).ukey())
 }
 
@@ -0,0 +1,178 @@
+/*
+This is free and unencumbered software released into the public domain.
+
+Anyone is free to copy, modify, publish, use, compile, sell, or
+distribute this software, either in source code form or as a compiled
+binary, for any purpose, commercial or non-commercial, and by any
+means.
+
+In jurisdictions that recognize copyright laws, the author or authors
+of this software dedicate any and all copyright interest in the
+software to the public domain. We make this dedication for the benefit
+of the public at large and to the detriment of our heirs and
+successors. We intend this dedication to be an overt act of
+relinquishment in perpetuity of all present and future rights to this
+software under copyright law.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+IN NO EVENT SHALL THE AUTHORS BE LIABLE FOR ANY CLAIM, DAMAGES OR
+OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE,
+ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR
+OTHER DEALINGS IN THE SOFTWARE.
+
+For more information, please refer to <http://unlicense.org/>
+*/
+
+package leveldb
+
+/*
+WriteHook maintains derived entries along with the writes to the DB, for example a secondary
+index in a separate key prefix. It is set by Config.WriteHook.
+
+The hook is called inside the write path, with the write lock held, once for each put, delete
+and merge of a write, in order. The records it adds to the batch are written atomically along
+with the write, right after the record, that caused them; they do not call the hook again.
+Range deletions are not passed to the hook, nor are ingested tables.
+
+Writes are not merged (see opt.Options.NoWriteMerge), while a hook is set, since every write
+looks up the old values of its keys.
+*/
+type WriteHook interface {
+	// OnWrite receives the key, its current value, or nil if it has none, and the value
+	// after the write, or nil for a delete; for a merge, value is the merged value. The
+	// keys are those of the DB, including the prefix of their keyspace. An error fails
+	// the whole write.
+	//
+	// The slices must not be retained; b is only valid during the call.
+	OnWrite(key, old, value []byte, b *Batch) error
+}
+
+// Returns the value of the key after a record, given the value before.
+func (s *session) hookValue(kt keyType, key, old, value []byte) ([]byte, error) {
+	switch kt {
+	case keyTypeDel:
+		return nil, nil
+	case keyTypeMerge:
+		if s.merge == nil {
+			return nil, ErrNoMergeOperator
+		}
+		return s.merge.FullMerge(key, old, [][]byte{value})
+	}
+	_, value = splitValue(kt, value)
+	return value, nil
+}
+
+/*
+A write passing through the hook. The values written by the preceding records of the write are
+kept, since they are not yet visible in the DB.
+*/
+type hookWrite struct {
+	db    *DB
+	vals  map[string][]byte // nil for a deleted key
+	rdels [][2][]byte
+	hb    Batch
+}
+
+// Returns the current value of the key.
+func (w *hookWrite) get(key []byte) ([]byte, error) {
+	if v, ok := w.vals[string(key)]; ok {
+		return v, nil
+	}
+	for _, r := range w.rdels {
+		if w.db.s.icmp.uCompare(key, r[0]) >= 0 && w.db.s.icmp.uCompare(key, r[1]) < 0 {
+			return nil, nil
+		}
+	}
+	v, err := w.db.get(nil, nil, key, w.db.seq, nil)
+	if err == ErrNotFound {
+		return nil, nil
+	}
+	return v, err
+}
+
+// Applies a record to the kept values, and returns the value before and after it.
+func (w *hookWrite) apply(kt keyType, key, value []byte) (old, nv []byte, err error) {
+	if kt == keyTypeRangeDel {
+		w.rdels = append(w.rdels, [2][]byte{append([]byte{}, key...), append([]byte{}, value...)})
+		for k := range w.vals {
+			if w.db.s.icmp.uCompare([]byte(k), key) >= 0 && w.db.s.icmp.uCompare([]byte(k), value) < 0 {
+				w.vals[k] = nil
+			}
+		}
+		return nil, nil, nil
+	}
+	if old, err = w.get(key); err != nil {
+		return
+	}
+	if nv, err = w.db.s.hookValue(kt, key, old, value); err != nil {
+		return
+	}
+	if nv != nil {
+		nv = append([]byte{}, nv...)
+	}
+	w.vals[string(key)] = nv
+	return
+}
+
+// Returns the batch extended by the records of the hook.
+func (db *DB) hookBatch(batch *Batch) (*Batch, error) {
+	w := &hookWrite{db: db, vals: make(map[string][]byte)}
+	out := db.batchPool.Get().(*Batch)
+	out.Reset()
+	err := batch.replayInternal(func(i int, kt keyType, k, v []byte) error {
+		old, nv, err := w.apply(kt, k, v)
+		if err != nil {
+			return err
+		}
+		out.appendRec(kt, k, v)
+		if kt == keyTypeRangeDel {
+			return nil
+		}
+		w.hb.Reset()
+		if err = db.s.hook.OnWrite(k, old, nv, &w.hb); err != nil {
+			return err
+		}
+		return w.hb.resolveTTL(db.s.now()).replayInternal(func(i int, kt keyType, k, v []byte) error {
+			if _, _, err := w.apply(kt, k, v); err != nil {
+				return err
+			}
+			out.appendRec(kt, k, v)
+			return nil
+		})
+	})
+	if err != nil {
+		db.batchPool.Put(out)
+		return nil, err
+	}
+	return out, nil
+}
+
+// Writes a record of the transaction through the hook.
+func (tr *Transaction) write(kt keyType, key, value []byte) error {
+	hook := tr.db.s.hook
+	if hook == nil || kt == keyTypeRangeDel {
+		return tr.put(kt, key, value)
+	}
+	old, err := tr.db.get(tr.mem, tr.tables, key, tr.seq, nil)
+	if err == ErrNotFound {
+		old, err = nil, nil
+	} else if err != nil {
+		return err
+	}
+	nv, err := tr.db.s.hookValue(kt, key, old, value)
+	if err != nil {
+		return err
+	}
+	var hb Batch
+	if err = hook.OnWrite(key, old, nv, &hb); err != nil {
+		return err
+	}
+	if err = tr.put(kt, key, value); err != nil {
+		return err
+	}
+	return hb.resolveTTL(tr.db.s.now()).replayInternal(func(i int, kt keyType, k, v []byte) error {
+		return tr.put(kt, k, v)
+	})
+}
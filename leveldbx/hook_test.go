@@ -0,0 +1,114 @@
+package leveldb
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/syndtr/goleveldb/leveldb/opt"
+)
+
+var errTestHook = errors.New("bad value")
+
+// Indexes the values of the keys "p/<id>" by the keys "i/<value>/<id>".
+type testIndexHook struct{}
+
+func (testIndexHook) OnWrite(key, old, value []byte, b *Batch) error {
+	if !bytes.HasPrefix(key, []byte("p/")) {
+		return nil
+	}
+	if string(value) == "bad" {
+		return errTestHook
+	}
+	id := key[2:]
+	if old != nil {
+		b.Delete([]byte("i/" + string(old) + "/" + string(id)))
+	}
+	if value != nil {
+		b.Put([]byte("i/"+string(value)+"/"+string(id)), nil)
+	}
+	return nil
+}
+
+func newHookHarness(t *testing.T, o *opt.Options) *dbHarness {
+	h := new(dbHarness)
+	h.conf = &Config{WriteHook: testIndexHook{}, Merge: testCounter{}}
+	h.init(t, o)
+	return h
+}
+
+func TestDB_WriteHook(t *testing.T) {
+	h := newHookHarness(t, &opt.Options{DisableLargeBatchTransaction: true})
+	defer h.close()
+
+	h.put("p/1", "red")
+	h.put("p/2", "blue")
+	h.compactMem()
+	h.put("p/1", "green")
+	h.delete("p/2")
+	h.getKeyVal("(i/green/1->)(p/1->green)")
+
+	// The records of a batch see the preceding ones. The range deletion
+	// isn't passed to the hook, so i/green/1 remains.
+	b := new(Batch)
+	b.Put([]byte("p/3"), []byte("red"))
+	b.Put([]byte("p/3"), []byte("blue"))
+	b.DeleteRange([]byte("p/1"), []byte("p/2"))
+	b.Put([]byte("p/1"), []byte("red"))
+	if err := h.db.Write(b, nil); err != nil {
+		t.Fatal(err)
+	}
+	h.getKeyVal("(i/blue/3->)(i/green/1->)(i/red/1->)(p/1->red)(p/3->blue)")
+
+	// A failing hook fails the whole write.
+	b.Reset()
+	b.Put([]byte("p/4"), []byte("red"))
+	b.Put([]byte("p/5"), []byte("bad"))
+	if err := h.db.Write(b, nil); err != errTestHook {
+		t.Fatalf("want %v, got %v", errTestHook, err)
+	}
+	h.get("p/4", false)
+
+	// Merges pass the merged value.
+	h.merge("p/6", "1")
+	h.merge("p/6", "2")
+	h.getKeyVal("(i/3/6->)(i/blue/3->)(i/green/1->)(i/red/1->)(p/1->red)(p/3->blue)(p/6->3)")
+
+	h.reopenDB()
+	h.getKeyVal("(i/3/6->)(i/blue/3->)(i/green/1->)(i/red/1->)(p/1->red)(p/3->blue)(p/6->3)")
+}
+
+func TestDB_WriteHookTransaction(t *testing.T) {
+	h := newHookHarness(t, &opt.Options{WriteBuffer: 1 << 10})
+	defer h.close()
+
+	h.put("p/1", "red")
+	tr, err := h.db.OpenTransaction()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tr.Put([]byte("p/1"), []byte("blue"), nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := tr.Put([]byte("p/2"), []byte("blue"), nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := tr.Put([]byte("p/3"), []byte("bad"), nil); err != errTestHook {
+		t.Fatalf("want %v, got %v", errTestHook, err)
+	}
+	if err := tr.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	h.getKeyVal("(i/blue/1->)(i/blue/2->)(p/1->blue)(p/2->blue)")
+
+	// A large batch is written by a transaction.
+	b := new(Batch)
+	for i := 0; i < 100; i++ {
+		b.Put([]byte("p/1"), bytes.Repeat([]byte("r"), 20))
+	}
+	b.Delete([]byte("p/2"))
+	if err := h.db.Write(b, nil); err != nil {
+		t.Fatal(err)
+	}
+	h.getKeyVal("(i/rrrrrrrrrrrrrrrrrrrr/1->)(p/1->rrrrrrrrrrrrrrrrrrrr)")
+}
@@ -0,0 +1,497 @@
+/*
+This is free and unencumbered software released into the public domain.
+
+Anyone is free to copy, modify, publish, use, compile, sell, or
+distribute this software, either in source code form or as a compiled
+binary, for any purpose, commercial or non-commercial, and by any
+means.
+
+In jurisdictions that recognize copyright laws, the author or authors
+of this software dedicate any and all copyright interest in the
+software to the public domain. We make this dedication for the benefit
+of the public at large and to the detriment of our heirs and
+successors. We intend this dedication to be an overt act of
+relinquishment in perpetuity of all present and future rights to this
+software under copyright law.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+IN NO EVENT SHALL THE AUTHORS BE LIABLE FOR ANY CLAIM, DAMAGES OR
+OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE,
+ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR
+OTHER DEALINGS IN THE SOFTWARE.
+
+For more information, please refer to <http://unlicense.org/>
+*/
+
+package leveldb
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/maxymania/storage-engines/leveldbx/table"
+	"github.com/syndtr/goleveldb/leveldb/iterator"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+	"github.com/syndtr/goleveldb/leveldb/storage"
+)
+
+/*
+Table ingestion.
+
+A TableBuilder writes the entries with the sequence number 0. IngestTables
+assigns a single sequence number to all tables of a call and writes it into
+the entries, as it copies the tables into the DB, so the tables are recovered
+by Recover like any other. Tables ingested by former versions kept the
+entries at 0 and recorded the sequence number in the manifest only; it is
+applied to their entries, as they are read, until a compaction rewrites them.
+For the subscribers, the entries are journaled as well, see DB.Subscribe.
+*/
+
+// TableBuilder builds a sorted table outside of a DB, to be added to a DB by
+// IngestTables. The keys must be added in strictly ascending order, according
+// to the comparer of the options, which must match the DB.
+type TableBuilder struct {
+	icmp *iComparer
+	tw   *table.Writer
+	last []byte
+	ikey []byte
+	err  error
+}
+
+// NewTableBuilder creates a TableBuilder writing into w. The options control
+// the comparer, the filter and the block layout of the table.
+func NewTableBuilder(w io.Writer, o *opt.Options) *TableBuilder {
+	no, icmp := internalOptions(o)
+	return &TableBuilder{icmp: icmp, tw: table.NewWriter(w, no)}
+}
+
+func (b *TableBuilder) append(kt keyType, key, value []byte) error {
+	if b.err != nil {
+		return b.err
+	}
+	if b.last != nil && b.icmp.uCompare(key, b.last) <= 0 {
+		return ErrKeyOrder
+	}
+	b.last = append(b.last[:0], key...)
+	if b.last == nil {
+		b.last = []byte{}
+	}
+	b.ikey = makeInternalKey(b.ikey, key, 0, kt)
+	b.err = b.tw.Append(b.ikey, value)
+	return b.err
+}
+
+// Put adds the value for the given key.
+func (b *TableBuilder) Put(key, value []byte) error {
+	return b.append(keyTypeVal, key, value)
+}
+
+// PutWithDeadline adds the value for the given key, that expires at the
+// given time.
+func (b *TableBuilder) PutWithDeadline(key, value []byte, deadline time.Time) error {
+	return b.append(keyTypeValTTL, key, appendTTLValue(nil, deadline.UnixNano(), value))
+}
+
+// Merge adds a merge operand for the given key, see DB.Merge.
+func (b *TableBuilder) Merge(key, operand []byte) error {
+	return b.append(keyTypeMerge, key, operand)
+}
+
+// Delete adds a deletion marker for the given key, which hides the older
+// values of the key in the DB.
+func (b *TableBuilder) Delete(key []byte) error {
+	return b.append(keyTypeDel, key, nil)
+}
+
+// Len returns the number of entries added.
+func (b *TableBuilder) Len() int {
+	return b.tw.EntriesLen()
+}
+
+// Close finishes the table. It does not close the underlying writer.
+func (b *TableBuilder) Close() error {
+	if b.err != nil {
+		return b.err
+	}
+	b.err = b.tw.Close()
+	return b.err
+}
+
+// Rewrites the sequence number of an internal key in place.
+func setInternalKeySeq(ikey []byte, seq uint64) {
+	n := len(ikey) - 8
+	kt := binary.LittleEndian.Uint64(ikey[n:]) & 0xff
+	binary.LittleEndian.PutUint64(ikey[n:], seq<<8|kt)
+}
+
+// tIngestedIter presents the entries of a table, ingested by a former
+// version, with the sequence number assigned to it.
+type tIngestedIter struct {
+	iterator.Iterator
+	icmp *iComparer
+	seq  uint64
+	key  []byte
+}
+
+func (i *tIngestedIter) Key() []byte {
+	key := i.Iterator.Key()
+	if key == nil {
+		return nil
+	}
+	i.key = append(i.key[:0], key...)
+	setInternalKeySeq(i.key, i.seq)
+	return i.key
+}
+
+func (i *tIngestedIter) Seek(key []byte) bool {
+	ukey, seq, _, err := parseInternalKey(key)
+	if err != nil || seq >= i.seq {
+		return i.Iterator.Seek(key)
+	}
+
+	// The entry of ukey sorts before the key; skip it.
+	if !i.Iterator.Seek(makeInternalKey(nil, ukey, 0, keyTypeDel)) {
+		return false
+	}
+	if i.icmp.uCompare(internalKey(i.Iterator.Key()).ukey(), ukey) == 0 {
+		return i.Iterator.Next()
+	}
+	return true
+}
+
+// Finds the entry of a table, ingested by a former version, whose key is greater than or equal to
+// the given key.
+func (t *tOps) findIngested(tr *table.Reader, f *tFile, key []byte, ro *opt.ReadOptions, noValue bool) (rkey, rvalue []byte, err error) {
+	if _, seq, _, kerr := parseInternalKey(key); kerr == nil && seq >= f.seq {
+		// The order within the user key is the same.
+		if noValue {
+			rkey, err = tr.FindKey(key, true, ro)
+		} else {
+			rkey, rvalue, err = tr.Find(key, true, ro)
+		}
+		if err == nil {
+			setInternalKeySeq(rkey, f.seq)
+		}
+		return
+	}
+
+	iter := &tIngestedIter{Iterator: tr.NewIterator(nil, ro), icmp: t.s.icmp, seq: f.seq}
+	defer iter.Release()
+	if !iter.Seek(key) {
+		if err = iter.Error(); err == nil {
+			err = ErrNotFound
+		}
+		return
+	}
+	rkey = append([]byte{}, iter.Key()...)
+	if !noValue {
+		rvalue = append([]byte{}, iter.Value()...)
+	}
+	return
+}
+
+// An ingested table, that was validated.
+type ingestTable struct {
+	path       string
+	size       int64
+	n          int
+	umin, umax []byte
+	t          *tFile
+}
+
+// Reads and validates a table built by a TableBuilder.
+func (db *DB) readIngestTable(path string) (it *ingestTable, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	tr, err := table.NewReader(f, fi.Size(), storage.FileDesc{}, nil, nil, db.s.o.Options)
+	if err != nil {
+		return nil, err
+	}
+	defer tr.Release()
+
+	it = &ingestTable{path: path, size: fi.Size()}
+	iter := tr.NewIterator(nil, &opt.ReadOptions{DontFillCache: true, Strict: opt.StrictAll})
+	defer iter.Release()
+	for iter.Next() {
+		ukey, seq, _, kerr := parseInternalKey(iter.Key())
+		if kerr != nil {
+			return nil, kerr
+		}
+		if seq != 0 {
+			return nil, fmt.Errorf("leveldb: table %s was not built by a TableBuilder", path)
+		}
+		if it.umax != nil && db.s.icmp.uCompare(ukey, it.umax) <= 0 {
+			return nil, ErrKeyOrder
+		}
+		if it.umin == nil {
+			it.umin = append([]byte{}, ukey...)
+		}
+		it.umax = append(it.umax[:0], ukey...)
+		it.n++
+	}
+	if err = iter.Error(); err != nil {
+		return nil, err
+	}
+	if it.umin == nil {
+		return nil, fmt.Errorf("leveldb: table %s is empty", path)
+	}
+	return it, nil
+}
+
+// Copies an ingested table into the storage of the DB, writing the sequence
+// number into its entries. The entries are appended to the batch, unless it
+// is nil.
+func (db *DB) writeIngestTable(it *ingestTable, level int, seq uint64, b *Batch) (err error) {
+	f, err := os.Open(it.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	tr, err := table.NewReader(f, it.size, storage.FileDesc{}, nil, nil, db.s.o.Options)
+	if err != nil {
+		return err
+	}
+	defer tr.Release()
+
+	w, err := db.s.tops.create(level)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			w.drop()
+		}
+	}()
+	iter := tr.NewIterator(nil, &opt.ReadOptions{DontFillCache: true, Strict: opt.StrictAll})
+	defer iter.Release()
+	var ikey []byte
+	for iter.Next() {
+		ukey, _, kt, kerr := parseInternalKey(iter.Key())
+		if kerr != nil {
+			return kerr
+		}
+		ikey = makeInternalKey(ikey, ukey, seq, kt)
+		if err = w.append(ikey, iter.Value()); err != nil {
+			return
+		}
+		if b != nil {
+			b.appendRec(kt, ukey, iter.Value())
+		}
+	}
+	if err = iter.Error(); err != nil {
+		return
+	}
+	it.t, err = w.finish()
+	return
+}
+
+type cIngest struct {
+	tables    []*ingestTable
+	seq       uint64
+	journaled bool
+	ackC      chan<- error
+}
+
+func (r cIngest) ack(err error) {
+	if r.ackC != nil {
+		defer func() {
+			recover()
+		}()
+		r.ackC <- err
+	}
+}
+
+// Send ingestion request.
+func (db *DB) compTriggerIngest(compC chan<- cCmd, tables []*ingestTable, seq uint64, journaled bool) (err error) {
+	ch := make(chan error)
+	defer close(ch)
+	// Send cmd.
+	select {
+	case compC <- cIngest{tables, seq, journaled, ch}:
+	case err := <-db.compErrC:
+		return err
+	case <-db.closeC:
+		return ErrClosed
+	}
+	// Wait cmd.
+	select {
+	case err = <-ch:
+	case err = <-db.compErrC:
+	case <-db.closeC:
+		return ErrClosed
+	}
+	return err
+}
+
+// The deepest level, an ingested table is placed into, unless the DB has
+// more levels.
+const ingestMaxLevel = 2
+
+// Picks the deepest level, whose tables and the tables of all levels above do
+// not overlap the given range.
+func (v *version) pickIngestLevel(umin, umax []byte) int {
+	maxLevel := len(v.levels) - 1
+	if maxLevel < ingestMaxLevel {
+		maxLevel = ingestMaxLevel
+	}
+	for level := 0; level <= maxLevel; level++ {
+		if level < len(v.levels) && v.levels[level].overlaps(v.s.icmp, umin, umax, level == 0) {
+			if level == 0 {
+				return 0
+			}
+			return level - 1
+		}
+	}
+	return maxLevel
+}
+
+// Adds the ingested tables to the version; runs in the table compaction
+// goroutine. The manifest commits the sequence number of journaled records,
+// see DB.writeJournalCommitted.
+func (db *DB) tableIngest(tables []*ingestTable, seq uint64, journaled bool) error {
+	v := db.s.version()
+	defer v.release()
+
+	rec := &sessionRecord{}
+	for _, it := range tables {
+		level := v.pickIngestLevel(it.umin, it.umax)
+		rec.addTableFile(level, it.t)
+		db.logf("table@ingest L%d@%d S·%s Q·%d %q:%q", level, it.t.fd.Num, shortenb(int(it.t.size)), seq, it.t.imin, it.t.imax)
+	}
+	if journaled {
+		rec.setSeqNum(seq)
+	}
+	db.compCommitLk.Lock()
+	defer db.compCommitLk.Unlock()
+	return db.s.commit(rec)
+}
+
+// IngestTables adds the tables at the given paths, built by TableBuilder, to
+// the DB. The tables are copied into the DB, with the sequence number of the
+// call written into their entries; the writes wait for the copy.
+//
+// The tables must not overlap each other. Their entries are applied
+// atomically, as if written by a single batch, and are placed into the
+// deepest level, that does not overlap them or any level above. The memdb is
+// flushed first, if it overlaps the tables.
+func (db *DB) IngestTables(paths []string) (err error) {
+	if err = db.ok(); err != nil || len(paths) == 0 {
+		return
+	}
+
+	tables := make([]*ingestTable, len(paths))
+	for i, path := range paths {
+		if tables[i], err = db.readIngestTable(path); err != nil {
+			return
+		}
+	}
+	sort.Slice(tables, func(i, j int) bool { return db.s.icmp.uCompare(tables[i].umin, tables[j].umin) < 0 })
+	for i := 1; i < len(tables); i++ {
+		if db.s.icmp.uCompare(tables[i].umin, tables[i-1].umax) <= 0 {
+			return ErrIngestOverlap
+		}
+	}
+
+	// Lock writer.
+	select {
+	case db.writeLockC <- struct{}{}:
+	case err := <-db.compPerErrC:
+		return err
+	case <-db.closeC:
+		return ErrClosed
+	}
+	defer func() { <-db.writeLockC }()
+
+	// Older entries of the memdb must not hide the tables.
+	mdb := db.getEffectiveMem()
+	if mdb == nil {
+		return ErrClosed
+	}
+	overlaps := false
+	for _, it := range tables {
+		if isMemOverlaps(db.s.icmp, mdb.DB, it.umin, it.umax) {
+			overlaps = true
+		}
+	}
+	mdb.decref()
+
+	// The journal must not hold older batches, than the journaled records,
+	// whose sequence number the manifest commits.
+	journaled := db.journalCommitted()
+	if overlaps || journaled {
+		if _, err = db.rotateMem(0, true); err != nil {
+			return
+		}
+	} else if err = db.compTriggerWait(db.mcompCmdC); err != nil {
+		return
+	}
+
+	// The records are journaled for the subscribers, taking a sequence
+	// number each; the tables apply the last one.
+	first := db.seq + 1
+	seq := first
+	var b *Batch
+	if journaled {
+		b = new(Batch)
+		for _, it := range tables {
+			seq += uint64(it.n)
+		}
+		seq--
+	}
+	keep := false
+	defer func() {
+		if keep {
+			return
+		}
+		for _, it := range tables {
+			if it.t != nil {
+				db.s.stor.Remove(it.t.fd)
+			}
+		}
+	}()
+	v := db.s.version()
+	for _, it := range tables {
+		// The codec of the level, the table is likely placed into.
+		level := v.pickIngestLevel(it.umin, it.umax)
+		if err = db.writeIngestTable(it, level, seq, b); err != nil {
+			break
+		}
+	}
+	v.release()
+	if err != nil {
+		return
+	}
+
+	// The sequence number is journaled by an empty batch, before the tables
+	// become visible.
+	if journaled {
+		err = db.writeJournalCommitted(b, first)
+	} else {
+		err = db.writeJournal([]*Batch{new(Batch)}, seq, true)
+	}
+	if err != nil {
+		return
+	}
+	db.setSeq(seq)
+	// Once sent, the tables may be committed, even if an error is returned.
+	keep = true
+	if err = db.compTriggerIngest(db.tcompCmdC, tables, seq, journaled); err != nil || !journaled {
+		return
+	}
+	db.rotateCommitted()
+	db.publish(first, []*Batch{b})
+	return nil
+}
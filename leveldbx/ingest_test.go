@@ -0,0 +1,225 @@
+package leveldb
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/syndtr/goleveldb/leveldb/opt"
+)
+
+// Builds a table from key/value pairs; the value "<del>" adds a deletion
+// marker.
+func buildIngestTable(t *testing.T, dir, name string, kvs ...string) string {
+	f, err := os.Create(filepath.Join(dir, name))
+	if err != nil {
+		t.Fatal("Create: got error: ", err)
+	}
+	defer f.Close()
+	b := NewTableBuilder(f, nil)
+	for i := 0; i < len(kvs); i += 2 {
+		if kvs[i+1] == "<del>" {
+			err = b.Delete([]byte(kvs[i]))
+		} else {
+			err = b.Put([]byte(kvs[i]), []byte(kvs[i+1]))
+		}
+		if err != nil {
+			t.Fatal("TableBuilder: got error: ", err)
+		}
+	}
+	if err := b.Close(); err != nil {
+		t.Fatal("TableBuilder.Close: got error: ", err)
+	}
+	return f.Name()
+}
+
+func (h *dbHarness) ingest(paths ...string) {
+	if err := h.db.IngestTables(paths); err != nil {
+		h.t.Fatal("IngestTables: got error: ", err)
+	}
+}
+
+func TestDB_IngestTables(t *testing.T) {
+	dir := checkpointTestDir(t, "Ingest")
+	os.MkdirAll(dir, 0755)
+	defer os.RemoveAll(dir)
+
+	h := newDbHarness(t)
+	defer h.close()
+
+	h.put("a", "v1")
+	h.put("c", "v1")
+	h.put("x", "v1")
+	h.compactMem()
+	h.compactRangeAt(0, "", "")
+	h.compactRangeAt(1, "", "")
+	h.tablesPerLevel("0,0,1")
+	snap := h.getSnapshot()
+
+	// The first table overlaps level-2, the second nothing.
+	h.ingest(
+		buildIngestTable(t, dir, "t1.ldb", "a", "v2", "b", "v2", "c", "<del>"),
+		buildIngestTable(t, dir, "t2.ldb", "y", "v2", "z", "v2"))
+	h.tablesPerLevel("0,1,2")
+	h.getVal("a", "v2")
+	h.get("c", false)
+	h.getKeyVal("(a->v2)(b->v2)(x->v1)(y->v2)(z->v2)")
+	h.getKeyValReverse("(a->v2)(b->v2)(x->v1)(y->v2)(z->v2)")
+	h.getValr(snap, "a", "v1")
+	h.getValr(snap, "c", "v1")
+	h.getr(snap, "b", false)
+	snap.Release()
+
+	// Newer writes hide the ingested entries.
+	h.put("b", "v3")
+	h.getVal("b", "v3")
+
+	h.reopenDB()
+	h.getKeyVal("(a->v2)(b->v3)(x->v1)(y->v2)(z->v2)")
+	h.put("a", "v4")
+	h.getVal("a", "v4")
+
+	h.compactMem()
+	h.compactRangeAt(0, "", "")
+	h.compactRangeAt(1, "", "")
+	h.allEntriesFor("a", "[ v4 ]")
+	h.getKeyVal("(a->v4)(b->v3)(x->v1)(y->v2)(z->v2)")
+}
+
+func TestDB_IngestTablesMemdb(t *testing.T) {
+	dir := checkpointTestDir(t, "IngestMemdb")
+	os.MkdirAll(dir, 0755)
+	defer os.RemoveAll(dir)
+
+	h := newDbHarness(t)
+	defer h.close()
+
+	h.put("a", "v1")
+	h.put("k", "v1")
+	h.ingest(buildIngestTable(t, dir, "t1.ldb", "a", "v2", "b", "v2"))
+	h.tablesPerLevel("2")
+	h.getKeyVal("(a->v2)(b->v2)(k->v1)")
+
+	// The table does not overlap the memdb.
+	h.put("m", "v1")
+	h.ingest(buildIngestTable(t, dir, "t2.ldb", "n", "v2"))
+	h.getKeyVal("(a->v2)(b->v2)(k->v1)(m->v1)(n->v2)")
+
+	h.reopenDB()
+	h.getKeyVal("(a->v2)(b->v2)(k->v1)(m->v1)(n->v2)")
+	h.put("n", "v3")
+	h.reopenDB()
+	h.getVal("n", "v3")
+}
+
+func TestDB_IngestTablesRecover(t *testing.T) {
+	dir := checkpointTestDir(t, "IngestRecover")
+	os.MkdirAll(dir, 0755)
+	defer os.RemoveAll(dir)
+
+	h := newDbHarness(t)
+	defer h.close()
+
+	h.put("a", "old")
+	h.put("c", "old")
+	h.compactMem()
+	h.compactRange("", "")
+	h.ingest(buildIngestTable(t, dir, "t1.ldb", "a", "new", "b", "new", "c", "<del>"))
+	h.put("d", "v1")
+	h.closeDB()
+
+	// The manifest is lost; the tables are recovered alone.
+	var err error
+	h.db, err = RecoverWithConfig(h.stor, h.o, h.conf)
+	if err != nil {
+		t.Fatal("Recover: got error: ", err)
+	}
+	h.getKeyVal("(a->new)(b->new)(d->v1)")
+	h.put("b", "v2")
+	h.getVal("b", "v2")
+
+	h.compactMem()
+	h.compactRange("", "")
+	h.getKeyVal("(a->new)(b->v2)(d->v1)")
+	h.reopenDB()
+	h.getKeyVal("(a->new)(b->v2)(d->v1)")
+}
+
+func TestDB_IngestTablesInvalid(t *testing.T) {
+	dir := checkpointTestDir(t, "IngestInvalid")
+	os.MkdirAll(dir, 0755)
+	defer os.RemoveAll(dir)
+
+	h := newDbHarness(t)
+	defer h.close()
+
+	t1 := buildIngestTable(t, dir, "t1.ldb", "a", "v1", "c", "v1")
+	t2 := buildIngestTable(t, dir, "t2.ldb", "b", "v1")
+	if err := h.db.IngestTables([]string{t1, t2}); err != ErrIngestOverlap {
+		t.Errorf("IngestTables: want=%v got=%v", ErrIngestOverlap, err)
+	}
+	h.getKeyVal("")
+	if err := h.db.IngestTables([]string{filepath.Join(dir, "missing.ldb")}); err == nil {
+		t.Error("IngestTables: want error for a missing table")
+	}
+
+	b := NewTableBuilder(new(bytes.Buffer), nil)
+	b.Put([]byte("b"), nil)
+	if err := b.Put([]byte("a"), nil); err != ErrKeyOrder {
+		t.Errorf("TableBuilder.Put: want=%v got=%v", ErrKeyOrder, err)
+	}
+}
+
+func TestDB_IngestTablesFile(t *testing.T) {
+	src := checkpointTestDir(t, "IngestFile")
+	defer os.RemoveAll(src)
+	dir := filepath.Join(src, "build")
+	os.MkdirAll(dir, 0755)
+
+	db, err := OpenFile(src, &opt.Options{DisableLargeBatchTransaction: true}, nil)
+	if err != nil {
+		t.Fatal("OpenFile: got error: ", err)
+	}
+	defer func() { db.Close() }()
+
+	path := filepath.Join(dir, "t1.ldb")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal("Create: got error: ", err)
+	}
+	b := NewTableBuilder(f, nil)
+	b.Put([]byte("a"), []byte("v1"))
+	b.PutWithDeadline([]byte("b"), []byte("v1"), time.Now().Add(time.Hour))
+	if err := b.Close(); err != nil {
+		t.Fatal("TableBuilder.Close: got error: ", err)
+	}
+	f.Close()
+
+	if err := db.IngestTables([]string{path}); err != nil {
+		t.Fatal("IngestTables: got error: ", err)
+	}
+	if got := dumpDB(t, db); got != "(a->v1)(b->v1)" {
+		t.Errorf("content: got=%q", got)
+	}
+	if _, ttl, err := db.GetWithExpiry([]byte("b"), nil); err != nil || ttl <= 0 {
+		t.Errorf("GetWithExpiry: ttl=%v err=%v", ttl, err)
+	}
+
+	// The DB keeps a copy of the table.
+	if err := os.Remove(path); err != nil {
+		t.Fatal("Remove: got error: ", err)
+	}
+	fds, err := filepath.Glob(filepath.Join(src, "*.ldb"))
+	if err != nil || len(fds) != 1 {
+		t.Fatalf("want one table, got %v (%v)", fds, err)
+	}
+	db.Close()
+	if db, err = OpenFile(src, nil, nil); err != nil {
+		t.Fatal("OpenFile: got error: ", err)
+	}
+	if got := dumpDB(t, db); got != "(a->v1)(b->v1)" {
+		t.Errorf("content after reopen: got=%q", got)
+	}
+}
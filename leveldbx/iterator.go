@@ -0,0 +1,112 @@
+/*
+This is free and unencumbered software released into the public domain.
+
+Anyone is free to copy, modify, publish, use, compile, sell, or
+distribute this software, either in source code form or as a compiled
+binary, for any purpose, commercial or non-commercial, and by any
+means.
+
+In jurisdictions that recognize copyright laws, the author or authors
+of this software dedicate any and all copyright interest in the
+software to the public domain. We make this dedication for the benefit
+of the public at large and to the detriment of our heirs and
+successors. We intend this dedication to be an overt act of
+relinquishment in perpetuity of all present and future rights to this
+software under copyright law.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+IN NO EVENT SHALL THE AUTHORS BE LIABLE FOR ANY CLAIM, DAMAGES OR
+OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE,
+ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR
+OTHER DEALINGS IN THE SOFTWARE.
+
+For more information, please refer to <http://unlicense.org/>
+*/
+
+package leveldb
+
+import (
+	"github.com/maxymania/storage-engines/leveldbx/table"
+	"github.com/syndtr/goleveldb/leveldb/iterator"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+/*
+IterOptions holds the options of NewIteratorWith.
+*/
+type IterOptions struct {
+	// LowerBound is the smallest key and UpperBound the limit of the iterator; nil is
+	// unbounded. The tables outside of the bounds are not opened at all.
+	LowerBound, UpperBound []byte
+
+	// Pinned keeps the table blocks read by the iterator, so that the slices returned by
+	// Key and Value remain valid until the iterator is released, rather than until it is
+	// moved. The values are not copied then, which makes scans, in particular backward
+	// scans, cheaper; the memory held grows with the entries visited.
+	Pinned bool
+
+	// Readahead is the number of bytes read at once from a table file, when a block is not
+	// cached; see table.IterOptions. Zero disables readahead.
+	Readahead int
+}
+
+func (o *IterOptions) slice() *util.Range {
+	if o == nil || (o.LowerBound == nil && o.UpperBound == nil) {
+		return nil
+	}
+	return &util.Range{Start: o.LowerBound, Limit: o.UpperBound}
+}
+
+func (o *IterOptions) tableOptions() *table.IterOptions {
+	if o == nil || (!o.Pinned && o.Readahead <= 0) {
+		return nil
+	}
+	return &table.IterOptions{Pinned: o.Pinned, Readahead: o.Readahead}
+}
+
+/*
+NewIteratorWith returns an iterator for the latest snapshot of the DB, like NewIterator,
+which is bounded and set up by iopt; iopt may be nil.
+*/
+func (db *DB) NewIteratorWith(iopt *IterOptions, ro *opt.ReadOptions) iterator.Iterator {
+	if err := db.ok(); err != nil {
+		return iterator.NewEmptyIterator(err)
+	}
+
+	se := db.acquireSnapshot()
+	defer db.releaseSnapshot(se)
+	return db.newIterator(nil, nil, se.seq, iopt.slice(), ro, iopt.tableOptions())
+}
+
+/*
+NewIteratorWith returns an iterator for the snapshot, like NewIterator, which is bounded
+and set up by iopt; iopt may be nil.
+*/
+func (snap *Snapshot) NewIteratorWith(iopt *IterOptions, ro *opt.ReadOptions) iterator.Iterator {
+	if err := snap.db.ok(); err != nil {
+		return iterator.NewEmptyIterator(err)
+	}
+	snap.mu.Lock()
+	defer snap.mu.Unlock()
+	if snap.released {
+		return iterator.NewEmptyIterator(ErrSnapshotReleased)
+	}
+	return snap.db.newIterator(nil, nil, snap.elem.seq, iopt.slice(), ro, iopt.tableOptions())
+}
+
+/*
+NewIteratorWith returns an iterator over the keyspace, see DB.NewIteratorWith. The bounds
+are keys of the keyspace.
+*/
+func (ks *Keyspace) NewIteratorWith(iopt *IterOptions, ro *opt.ReadOptions) iterator.Iterator {
+	kopt := IterOptions{}
+	if iopt != nil {
+		kopt = *iopt
+	}
+	r := ks.Range(&util.Range{Start: kopt.LowerBound, Limit: kopt.UpperBound})
+	kopt.LowerBound, kopt.UpperBound = r.Start, r.Limit
+	return ks.Strip(ks.db.NewIteratorWith(&kopt, ro))
+}
@@ -0,0 +1,165 @@
+package leveldb
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/syndtr/goleveldb/leveldb/iterator"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+	"github.com/syndtr/goleveldb/leveldb/storage"
+	"github.com/syndtr/goleveldb/leveldb/testutil"
+)
+
+func iterKeys(iter iterator.Iterator, reverse bool) string {
+	var keys []string
+	if reverse {
+		for ok := iter.Last(); ok; ok = iter.Prev() {
+			keys = append(keys, string(iter.Key()))
+		}
+	} else {
+		for iter.Next() {
+			keys = append(keys, string(iter.Key()))
+		}
+	}
+	return strings.Join(keys, ",")
+}
+
+func TestDB_IteratorBounds(t *testing.T) {
+	h := newDbHarness(t)
+	defer h.close()
+	h.db.memdbMaxLevel = 0
+
+	for _, p := range []string{"a", "b", "c"} {
+		for i := 0; i < 3; i++ {
+			h.put(fmt.Sprintf("%s%d", p, i), "v")
+		}
+		h.compactMem()
+	}
+	h.tablesPerLevel("3")
+	h.reopenDB()
+	h.put("b1", "v2")
+	h.delete("b2")
+
+	h.stor.ResetCounter(testutil.ModeOpen, storage.TypeTable)
+	iter := h.db.NewIteratorWith(&IterOptions{LowerBound: []byte("b"), UpperBound: []byte("c")}, nil)
+	if got := iterKeys(iter, false); got != "b0,b1" {
+		t.Errorf("forward: got %q", got)
+	}
+	if got := iterKeys(iter, true); got != "b1,b0" {
+		t.Errorf("backward: got %q", got)
+	}
+	if !iter.Seek([]byte("a")) || string(iter.Key()) != "b0" {
+		t.Errorf("Seek before the lower bound: got %q", iter.Key())
+	}
+	if iter.Seek([]byte("c")) {
+		t.Errorf("Seek to the upper bound: got %q", iter.Key())
+	}
+	iter.Release()
+	if n, _ := h.stor.Counter(testutil.ModeOpen, storage.TypeTable); n != 1 {
+		t.Errorf("want one table opened, got %d", n)
+	}
+
+	// Only the lower bound.
+	iter = h.db.NewIteratorWith(&IterOptions{LowerBound: []byte("c1")}, nil)
+	if got := iterKeys(iter, false); got != "c1,c2" {
+		t.Errorf("lower bound: got %q", got)
+	}
+	iter.Release()
+}
+
+func TestDB_IteratorPinned(t *testing.T) {
+	h := new(dbHarness)
+	h.init(t, &opt.Options{
+		DisableLargeBatchTransaction: true,
+		DisableBlockCache:            true,
+		BlockSize:                    256,
+	})
+	defer h.close()
+
+	value := func(i int) string { return fmt.Sprintf("%03d%s", i, strings.Repeat("v", 60)) }
+	for i := 0; i < 200; i++ {
+		h.put(fmt.Sprintf("k%03d", i), value(i))
+		if i == 150 {
+			h.compactMem()
+		}
+	}
+	h.delete("k100")
+
+	check := func(reverse bool) {
+		iter := h.db.NewIteratorWith(&IterOptions{Pinned: true}, nil)
+		defer iter.Release()
+		var keys, values [][]byte
+		next := iter.Next
+		if reverse {
+			iter.Last()
+			iter.Next()
+			next = iter.Prev
+		}
+		for next() {
+			keys = append(keys, iter.Key())
+			values = append(values, iter.Value())
+		}
+		if len(keys) != 199 {
+			t.Fatalf("reverse=%v: want 199 entries, got %d", reverse, len(keys))
+		}
+		for j := range keys {
+			i := j
+			if reverse {
+				i = 198 - j
+			}
+			if i >= 100 {
+				i++
+			}
+			if k := fmt.Sprintf("k%03d", i); string(keys[j]) != k || string(values[j]) != value(i) {
+				t.Fatalf("reverse=%v: entry %d: want %s, got %q->%q", reverse, j, k, keys[j], values[j])
+			}
+		}
+	}
+	check(false)
+	check(true)
+}
+
+func TestDB_IteratorReadahead(t *testing.T) {
+	h := new(dbHarness)
+	h.init(t, &opt.Options{
+		DisableLargeBatchTransaction: true,
+		DisableBlockCache:            true,
+		BlockSize:                    256,
+	})
+	defer h.close()
+
+	var want []string
+	for i := 0; i < 1000; i++ {
+		k := fmt.Sprintf("k%04d", i)
+		h.put(k, strings.Repeat("v", 100))
+		want = append(want, k)
+	}
+	h.compactMem()
+	h.reopenDB()
+
+	scan := func(iopt *IterOptions, reverse bool) int {
+		h.stor.ResetCounter(testutil.ModeRead, storage.TypeTable)
+		iter := h.db.NewIteratorWith(iopt, nil)
+		defer iter.Release()
+		if reverse {
+			for i := len(want)/2 - 1; i >= 0; i-- {
+				want[i], want[len(want)-1-i] = want[len(want)-1-i], want[i]
+			}
+		}
+		if got := iterKeys(iter, reverse); got != strings.Join(want, ",") {
+			t.Fatalf("readahead=%v reverse=%v: unexpected keys", iopt != nil, reverse)
+		}
+		n, _ := h.stor.Counter(testutil.ModeRead, storage.TypeTable)
+		return n
+	}
+	ra := &IterOptions{Readahead: 64 << 10}
+	n1 := scan(ra, false)
+	n2 := scan(nil, false)
+	if n1*10 > n2 {
+		t.Errorf("want fewer reads with readahead, got %d vs. %d", n1, n2)
+	}
+	if n := scan(ra, true); n*10 > n2 {
+		t.Errorf("backward: want fewer reads with readahead, got %d vs. %d", n, n2)
+	}
+}
@@ -36,6 +36,12 @@ func (kt keyType) String() string {
 		return "d"
 	case keyTypeVal:
 		return "v"
+	case keyTypeValTTL:
+		return "t"
+	case keyTypeMerge:
+		return "m"
+	case keyTypeRangeDel:
+		return "r"
 	}
 	return fmt.Sprintf("<invalid:%#x>", uint(kt))
 }
@@ -45,6 +51,13 @@ func (kt keyType) String() string {
 const (
 	keyTypeDel = keyType(0)
 	keyTypeVal = keyType(1)
+	// Extension: a value prefixed with its expiration deadline.
+	keyTypeValTTL = keyType(2)
+	// Extension: a merge operand.
+	keyTypeMerge = keyType(3)
+	// Extension: a range tombstone; the user key is the start of the range,
+	// the value its limit.
+	keyTypeRangeDel = keyType(4)
 )
 
 // keyTypeSeek defines the keyType that should be passed when constructing an
@@ -52,7 +65,7 @@ const (
 // sort sequence numbers in decreasing order and the value type is
 // embedded as the low 8 bits in the sequence number in internal keys,
 // we need to use the highest-numbered ValueType, not the lowest).
-const keyTypeSeek = keyTypeVal
+const keyTypeSeek = keyTypeRangeDel
 
 const (
 	// Maximum value possible for sequence number; the 8-bits are
@@ -75,7 +88,7 @@ type internalKey []byte
 func makeInternalKey(dst, ukey []byte, seq uint64, kt keyType) internalKey {
 	if seq > keyMaxSeq {
 		panic("leveldb: invalid sequence number")
-	} else if kt > keyTypeVal {
+	} else if kt > keyTypeRangeDel {
 		panic("leveldb: invalid type")
 	}
 
@@ -91,7 +104,7 @@ func parseInternalKey(ik []byte) (ukey []byte, seq uint64, kt keyType, err error
 	}
 	num := binary.LittleEndian.Uint64(ik[len(ik)-8:])
 	seq, kt = uint64(num>>8), keyType(num&0xff)
-	if kt > keyTypeVal {
+	if kt > keyTypeRangeDel {
 		return nil, 0, 0, newErrInternalKeyCorrupted(ik, "invalid type")
 	}
 	ukey = ik[:len(ik)-8]
@@ -125,7 +138,7 @@ func (ik internalKey) num() uint64 {
 func (ik internalKey) parseNum() (seq uint64, kt keyType) {
 	num := ik.num()
 	seq, kt = uint64(num>>8), keyType(num&0xff)
-	if kt > keyTypeVal {
+	if kt > keyTypeRangeDel {
 		panic(fmt.Sprintf("leveldb: internal key %q, len=%d: invalid type %#x", []byte(ik), len(ik), kt))
 	}
 	return
@@ -0,0 +1,455 @@
+/*
+This is free and unencumbered software released into the public domain.
+
+Anyone is free to copy, modify, publish, use, compile, sell, or
+distribute this software, either in source code form or as a compiled
+binary, for any purpose, commercial or non-commercial, and by any
+means.
+
+In jurisdictions that recognize copyright laws, the author or authors
+of this software dedicate any and all copyright interest in the
+software to the public domain. We make this dedication for the benefit
+of the public at large and to the detriment of our heirs and
+successors. We intend this dedication to be an overt act of
+relinquishment in perpetuity of all present and future rights to this
+software under copyright law.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+IN NO EVENT SHALL THE AUTHORS BE LIABLE FOR ANY CLAIM, DAMAGES OR
+OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE,
+ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR
+OTHER DEALINGS IN THE SOFTWARE.
+
+For more information, please refer to <http://unlicense.org/>
+*/
+
+package leveldb
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/syndtr/goleveldb/leveldb/comparer"
+	"github.com/syndtr/goleveldb/leveldb/iterator"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+/*
+Keyspaces.
+
+A named keyspace is a range of the key space of the DB, so all keyspaces share
+the journal, the session, the caches and the compaction goroutines. A key of
+the keyspace "name" is stored as
+
+	keyspaceMarker + "name" + ksMember + key
+
+and the keys with the marker ksStart and ksEnd in place of ksMember bound the
+keyspace. The default keyspace holds all other keys and sorts first, the
+named keyspaces follow in the byte order of their names. Within a keyspace,
+the keys are ordered by its comparer.
+*/
+
+// Keys starting with keyspaceMarker are reserved for the named keyspaces.
+const keyspaceMarker = "\xff\x00KS"
+
+const (
+	ksStart byte = iota
+	ksMember
+	ksEnd
+)
+
+/*
+KeyspaceOptions hold the options of a named keyspace. They must not change between two opens
+of the same database; Open fails, if a comparer does.
+*/
+type KeyspaceOptions struct {
+	// The comparer of the keyspace, or nil for comparer.DefaultComparer.
+	Comparer comparer.Comparer
+
+	// Decides whether or not the values of the keyspace are retained, like
+	// Expiration.AutoExpire. If nil, the values only expire by their
+	// time-to-live; the AutoExpire of the default keyspace does not apply.
+	AutoExpire AutoExpire
+
+	// The MergeOperator of the keyspace, or nil.
+	Merge MergeOperator
+
+	// The size of the tables built by compactions, or 0 for the size
+	// configured by opt.Options.
+	CompactionTableSize int
+}
+
+// Splits a key of a named keyspace into the name, its kind and the key within
+// the keyspace.
+func splitKeyspaceKey(key []byte) (name []byte, kind byte, ukey []byte, ok bool) {
+	if len(key) < len(keyspaceMarker) || string(key[:len(keyspaceMarker)]) != keyspaceMarker {
+		return
+	}
+	key = key[len(keyspaceMarker):]
+	for i, c := range key {
+		if c <= ksEnd {
+			return key[:i], c, key[i+1:], true
+		}
+	}
+	return
+}
+
+type keyspaceComparer struct {
+	def  comparer.Comparer
+	ks   map[string]KeyspaceOptions
+	name string
+}
+
+func newKeyspaceComparer(def comparer.Comparer, ks map[string]KeyspaceOptions) *keyspaceComparer {
+	names := make([]string, 0, len(ks))
+	for name, o := range ks {
+		if o.Comparer != nil && o.Comparer.Name() != comparer.DefaultComparer.Name() {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	c := &keyspaceComparer{def: def, ks: ks, name: def.Name()}
+	for _, name := range names {
+		c.name += fmt.Sprintf("+keyspace(%q,%s)", name, ks[name].Comparer.Name())
+	}
+	return c
+}
+
+func (c *keyspaceComparer) cmp(name []byte) comparer.Comparer {
+	if o := c.ks[string(name)].Comparer; o != nil {
+		return o
+	}
+	return comparer.DefaultComparer
+}
+
+// The name adds the keyspaces, that don't use comparer.DefaultComparer, to
+// the name of the default comparer; the manifest records it, so that a
+// database is not opened with other comparers. Existing databases can be
+// opened with keyspaces using the default comparer.
+func (c *keyspaceComparer) Name() string { return c.name }
+
+func (c *keyspaceComparer) Compare(a, b []byte) int {
+	an, ak, au, aok := splitKeyspaceKey(a)
+	bn, bk, bu, bok := splitKeyspaceKey(b)
+	switch {
+	case !aok && !bok:
+		return c.def.Compare(a, b)
+	case !aok:
+		return -1
+	case !bok:
+		return 1
+	}
+	if x := bytes.Compare(an, bn); x != 0 {
+		return x
+	}
+	if ak != bk {
+		if ak < bk {
+			return -1
+		}
+		return 1
+	}
+	if ak != ksMember {
+		return 0
+	}
+	return c.cmp(an).Compare(au, bu)
+}
+
+func (c *keyspaceComparer) Separator(dst, a, b []byte) []byte {
+	an, ak, au, aok := splitKeyspaceKey(a)
+	bn, bk, bu, bok := splitKeyspaceKey(b)
+	switch {
+	case !aok && !bok:
+		if sep := c.def.Separator(dst, a, b); sep != nil {
+			if _, _, _, ok := splitKeyspaceKey(sep); !ok {
+				return sep
+			}
+		}
+	case aok && bok && ak == ksMember && bk == ksMember && bytes.Equal(an, bn):
+		pfx := len(a) - len(au)
+		if sep := c.cmp(an).Separator(nil, au, bu); sep != nil {
+			return append(append(dst, a[:pfx]...), sep...)
+		}
+	}
+	return nil
+}
+
+func (c *keyspaceComparer) Successor(dst, b []byte) []byte {
+	bn, bk, bu, bok := splitKeyspaceKey(b)
+	switch {
+	case !bok:
+		if succ := c.def.Successor(dst, b); succ != nil {
+			if _, _, _, ok := splitKeyspaceKey(succ); !ok {
+				return succ
+			}
+		}
+	case bk == ksMember:
+		pfx := len(b) - len(bu)
+		if succ := c.cmp(bn).Successor(nil, bu); succ != nil {
+			return append(append(dst, b[:pfx]...), succ...)
+		}
+	}
+	return nil
+}
+
+// keyspaceExpire dispatches to the AutoExpire of the keyspace of a key.
+type keyspaceExpire struct {
+	def AutoExpire
+	ks  map[string]KeyspaceOptions
+}
+
+func (e *keyspaceExpire) lookup(key []byte) (AutoExpire, []byte) {
+	name, kind, ukey, ok := splitKeyspaceKey(key)
+	if !ok {
+		return e.def, key
+	}
+	if a := e.ks[string(name)].AutoExpire; a != nil && kind == ksMember {
+		return a, ukey
+	}
+	return defaultAutoExpire{}, ukey
+}
+
+func (e *keyspaceExpire) Retain(b []byte) bool { return e.def.Retain(b) }
+
+func (e *keyspaceExpire) RetainKV(key, value []byte, now time.Time) bool {
+	a, key := e.lookup(key)
+	if kv, ok := a.(AutoExpireKV); ok {
+		return kv.RetainKV(key, value, now)
+	}
+	return a.Retain(value)
+}
+
+func (e *keyspaceExpire) ExpireAt(key, value []byte) (time.Time, bool) {
+	a, key := e.lookup(key)
+	if at, ok := a.(AutoExpireAt); ok {
+		return at.ExpireAt(key, value)
+	}
+	return time.Time{}, false
+}
+
+// keyspaceMerge dispatches to the MergeOperator of the keyspace of a key.
+type keyspaceMerge struct {
+	def MergeOperator
+	ks  map[string]KeyspaceOptions
+}
+
+func (m *keyspaceMerge) lookup(key []byte) (MergeOperator, []byte) {
+	name, _, ukey, ok := splitKeyspaceKey(key)
+	if !ok {
+		return m.def, key
+	}
+	return m.ks[string(name)].Merge, ukey
+}
+
+func (m *keyspaceMerge) FullMerge(key, existing []byte, operands [][]byte) ([]byte, error) {
+	op, key := m.lookup(key)
+	if op == nil {
+		return nil, ErrNoMergeOperator
+	}
+	return op.FullMerge(key, existing, operands)
+}
+
+func (m *keyspaceMerge) PartialMerge(key, left, right []byte) ([]byte, bool) {
+	op, key := m.lookup(key)
+	if op == nil {
+		return nil, false
+	}
+	return op.PartialMerge(key, left, right)
+}
+
+func (s *session) setKeyspaces(ks map[string]KeyspaceOptions) {
+	if ks == nil {
+		return
+	}
+	s.keyspaces = ks
+	s.aexp = &keyspaceExpire{s.aexp, ks}
+	for _, o := range ks {
+		if o.Merge != nil {
+			s.merge = &keyspaceMerge{s.merge, ks}
+			break
+		}
+	}
+}
+
+// Returns the size of the tables built by compactions for the keyspace of the
+// given key.
+func (s *session) keyspaceTableSize(ukey []byte, size int) int {
+	if s.keyspaces == nil {
+		return size
+	}
+	if name, _, _, ok := splitKeyspaceKey(ukey); ok {
+		if n := s.keyspaces[string(name)].CompactionTableSize; n > 0 {
+			return n
+		}
+	}
+	return size
+}
+
+// Limits an unbounded slice to the default keyspace.
+func (s *session) keyspaceSlice(slice *util.Range) *util.Range {
+	if s.keyspaces == nil || (slice != nil && slice.Limit != nil) {
+		return slice
+	}
+	r := &util.Range{Limit: append([]byte(keyspaceMarker), ksStart)}
+	if slice != nil {
+		r.Start = slice.Start
+	}
+	return r
+}
+
+// Reports, whether two keys belong to different keyspaces; tables built by
+// compactions do not span keyspaces.
+func (s *session) keyspaceBoundary(a, b []byte) bool {
+	if s.keyspaces == nil {
+		return false
+	}
+	an, _, _, aok := splitKeyspaceKey(a)
+	bn, _, _, bok := splitKeyspaceKey(b)
+	return aok != bok || !bytes.Equal(an, bn)
+}
+
+/*
+Keyspace is a handle of a named keyspace within a DB. The keyspaces are enabled by
+Config.Keyspaces, which also holds their options; the keyspaces, that are not listed there,
+use the defaults. Once enabled, the iterators of the DB only cover the default keyspace,
+unless their range ends explicitly beyond it.
+
+Batches and transactions can span keyspaces, using the keys returned by Key.
+*/
+type Keyspace struct {
+	db     *DB
+	name   string
+	prefix []byte
+}
+
+// Keyspace returns the named keyspace. The name must not be empty and must not
+// contain the bytes 0x00 to 0x02. It returns ErrInvalidKeyspace, if the name is
+// invalid or the keyspaces are not enabled.
+func (db *DB) Keyspace(name string) (*Keyspace, error) {
+	if name == "" || db.s.keyspaces == nil {
+		return nil, ErrInvalidKeyspace
+	}
+	for i := 0; i < len(name); i++ {
+		if name[i] <= ksEnd {
+			return nil, ErrInvalidKeyspace
+		}
+	}
+	prefix := make([]byte, 0, len(keyspaceMarker)+len(name)+1)
+	prefix = append(append(append(prefix, keyspaceMarker...), name...), ksMember)
+	return &Keyspace{db, name, prefix}, nil
+}
+
+// Name returns the name of the keyspace.
+func (ks *Keyspace) Name() string { return ks.name }
+
+// Key returns the key of the DB, that stands for the given key of the
+// keyspace.
+func (ks *Keyspace) Key(key []byte) []byte {
+	return append(append(make([]byte, 0, len(ks.prefix)+len(key)), ks.prefix...), key...)
+}
+
+func (ks *Keyspace) bound(kind byte) []byte {
+	b := append([]byte{}, ks.prefix...)
+	b[len(b)-1] = kind
+	return b
+}
+
+// Range returns the range of the DB, that stands for the given range of the
+// keyspace. A nil range covers the whole keyspace.
+func (ks *Keyspace) Range(r *util.Range) *util.Range {
+	kr := &util.Range{Start: ks.bound(ksStart), Limit: ks.bound(ksEnd)}
+	if r != nil && r.Start != nil {
+		kr.Start = ks.Key(r.Start)
+	}
+	if r != nil && r.Limit != nil {
+		kr.Limit = ks.Key(r.Limit)
+	}
+	return kr
+}
+
+// Strip wraps an iterator over a Range of the keyspace, so that it presents
+// the keys of the keyspace.
+func (ks *Keyspace) Strip(iter iterator.Iterator) iterator.Iterator {
+	return &keyspaceIter{Iterator: iter, prefix: ks.prefix}
+}
+
+type keyspaceIter struct {
+	iterator.Iterator
+	prefix []byte
+	seek   []byte
+}
+
+func (i *keyspaceIter) Key() []byte {
+	key := i.Iterator.Key()
+	if len(key) < len(i.prefix) {
+		return nil
+	}
+	return key[len(i.prefix):]
+}
+
+func (i *keyspaceIter) Seek(key []byte) bool {
+	i.seek = append(append(i.seek[:0], i.prefix...), key...)
+	return i.Iterator.Seek(i.seek)
+}
+
+// Get gets the value for the given key, see DB.Get.
+func (ks *Keyspace) Get(key []byte, ro *opt.ReadOptions) ([]byte, error) {
+	return ks.db.Get(ks.Key(key), ro)
+}
+
+// GetWithExpiry gets the value for the given key together with its remaining
+// time-to-live, see DB.GetWithExpiry.
+func (ks *Keyspace) GetWithExpiry(key []byte, ro *opt.ReadOptions) ([]byte, time.Duration, error) {
+	return ks.db.GetWithExpiry(ks.Key(key), ro)
+}
+
+// Has returns true if the keyspace does contain the given key, see DB.Has.
+func (ks *Keyspace) Has(key []byte, ro *opt.ReadOptions) (bool, error) {
+	return ks.db.Has(ks.Key(key), ro)
+}
+
+// NewIterator returns an iterator over the keyspace, see DB.NewIterator.
+func (ks *Keyspace) NewIterator(slice *util.Range, ro *opt.ReadOptions) iterator.Iterator {
+	return ks.Strip(ks.db.NewIterator(ks.Range(slice), ro))
+}
+
+// Put sets the value for the given key, see DB.Put.
+func (ks *Keyspace) Put(key, value []byte, wo *opt.WriteOptions) error {
+	return ks.db.Put(ks.Key(key), value, wo)
+}
+
+// PutWithTTL sets the value for the given key, which expires after the given
+// time-to-live, see DB.PutWithTTL.
+func (ks *Keyspace) PutWithTTL(key, value []byte, ttl time.Duration, wo *opt.WriteOptions) error {
+	return ks.db.PutWithTTL(ks.Key(key), value, ttl, wo)
+}
+
+// Merge writes a merge operand for the given key, see DB.Merge. It returns
+// ErrNoMergeOperator, if the keyspace has no MergeOperator.
+func (ks *Keyspace) Merge(key, operand []byte, wo *opt.WriteOptions) error {
+	if ks.db.s.keyspaces[ks.name].Merge == nil {
+		return ErrNoMergeOperator
+	}
+	return ks.db.Merge(ks.Key(key), operand, wo)
+}
+
+// Delete deletes the value for the given key, see DB.Delete.
+func (ks *Keyspace) Delete(key []byte, wo *opt.WriteOptions) error {
+	return ks.db.Delete(ks.Key(key), wo)
+}
+
+// DeleteRange deletes the keys start <= key < limit of the keyspace, see
+// DB.DeleteRange. A nil start or limit stands for the bound of the keyspace.
+func (ks *Keyspace) DeleteRange(start, limit []byte, wo *opt.WriteOptions) error {
+	r := ks.Range(&util.Range{Start: start, Limit: limit})
+	return ks.db.DeleteRange(r.Start, r.Limit, wo)
+}
+
+// CompactRange compacts the given range of the keyspace, see DB.CompactRange.
+func (ks *Keyspace) CompactRange(r util.Range) error {
+	return ks.db.CompactRange(*ks.Range(&r))
+}
@@ -0,0 +1,206 @@
+package leveldb
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/syndtr/goleveldb/leveldb/errors"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+// testReverse orders keys in reverse byte order.
+type testReverse struct{}
+
+func (testReverse) Name() string                      { return "test.Reverse" }
+func (testReverse) Compare(a, b []byte) int           { return bytes.Compare(b, a) }
+func (testReverse) Separator(dst, a, b []byte) []byte { return nil }
+func (testReverse) Successor(dst, b []byte) []byte    { return nil }
+
+func newKeyspaceHarness(t *testing.T) (*dbHarness, *testClock) {
+	c := &testClock{now: 1000}
+	h := new(dbHarness)
+	h.conf = &Config{
+		Expiration: Expiration{Clock: c.get, OnRead: true},
+		Keyspaces: map[string]KeyspaceOptions{
+			"rev": {Comparer: testReverse{}, AutoExpire: new(testExpireKV)},
+			"cnt": {Merge: testCounter{}, CompactionTableSize: 1},
+		},
+	}
+	h.init(t, &opt.Options{DisableLargeBatchTransaction: true})
+	return h, c
+}
+
+func (h *dbHarness) keyspace(name string) *Keyspace {
+	ks, err := h.db.Keyspace(name)
+	if err != nil {
+		h.t.Fatal("Keyspace: got error: ", err)
+	}
+	return ks
+}
+
+func keyspaceKeyVal(t *testing.T, ks *Keyspace, slice *util.Range) string {
+	iter := ks.NewIterator(slice, nil)
+	defer iter.Release()
+	res := ""
+	for iter.Next() {
+		res += "(" + string(iter.Key()) + "->" + string(iter.Value()) + ")"
+	}
+	if err := iter.Error(); err != nil {
+		t.Error("iterator: got error: ", err)
+	}
+	return res
+}
+
+func (h *dbHarness) checkKeyspaces(want map[string]string) {
+	for name, w := range want {
+		if got := keyspaceKeyVal(h.t, h.keyspace(name), nil); got != w {
+			h.t.Errorf("keyspace %s: want=%q got=%q", name, w, got)
+		}
+	}
+}
+
+func TestDB_Keyspace(t *testing.T) {
+	h, _ := newKeyspaceHarness(t)
+	defer h.close()
+
+	rev, cnt, other := h.keyspace("rev"), h.keyspace("cnt"), h.keyspace("other")
+	h.put("a", "default")
+	h.put("b", "default")
+	for _, k := range []string{"a", "b", "c"} {
+		if err := rev.Put([]byte(k), []byte("rev"), nil); err != nil {
+			t.Fatal("Put: got error: ", err)
+		}
+	}
+	other.Put([]byte("a"), []byte("other"), nil)
+
+	// A batch spans keyspaces.
+	b := new(Batch)
+	b.Put([]byte("c"), []byte("default"))
+	b.Delete(rev.Key([]byte("b")))
+	b.Put(cnt.Key([]byte("a")), []byte("1"))
+	b.Merge(cnt.Key([]byte("a")), []byte("2"))
+	if err := h.db.Write(b, h.wo); err != nil {
+		t.Fatal("Write: got error: ", err)
+	}
+	if err := cnt.Merge([]byte("a"), []byte("3"), nil); err != nil {
+		t.Fatal("Merge: got error: ", err)
+	}
+	if err := other.Merge([]byte("a"), []byte("3"), nil); err != ErrNoMergeOperator {
+		t.Errorf("Merge: want=%v got=%v", ErrNoMergeOperator, err)
+	}
+
+	want := map[string]string{
+		"rev":   "(c->rev)(a->rev)",
+		"cnt":   "(a->6)",
+		"other": "(a->other)",
+	}
+	check := func() {
+		// The handles are bound to the current DB.
+		rev, cnt := h.keyspace("rev"), h.keyspace("cnt")
+		h.getKeyVal("(a->default)(b->default)(c->default)")
+		h.checkKeyspaces(want)
+		if v, err := cnt.Get([]byte("a"), nil); err != nil || string(v) != "6" {
+			t.Errorf("Get: want=6 got=%q err=%v", v, err)
+		}
+		if ok, err := rev.Has([]byte("b"), nil); err != nil || ok {
+			t.Errorf("Has: want=false got=%v err=%v", ok, err)
+		}
+		if got := keyspaceKeyVal(t, rev, &util.Range{Start: []byte("c"), Limit: []byte("a")}); got != "(c->rev)" {
+			t.Errorf("keyspace rev slice: got=%q", got)
+		}
+	}
+	check()
+
+	h.compactMem()
+	h.compactRangeAt(0, "", "")
+	check()
+
+	// The tables of level-1 do not span keyspaces.
+	v := h.db.s.version()
+	for _, t0 := range v.levels[1] {
+		if h.db.s.keyspaceBoundary(t0.imin.ukey(), t0.imax.ukey()) {
+			t.Errorf("table @%d spans keyspaces: %q:%q", t0.fd.Num, t0.imin, t0.imax)
+		}
+	}
+	v.release()
+	h.tablesPerLevel("0,4")
+
+	if err := rev.CompactRange(util.Range{}); err != nil {
+		t.Fatal("CompactRange: got error: ", err)
+	}
+	h.reopenDB()
+	check()
+
+	if _, err := h.db.Keyspace("a\x00"); err != ErrInvalidKeyspace {
+		t.Errorf("Keyspace: want=%v got=%v", ErrInvalidKeyspace, err)
+	}
+}
+
+func TestDB_KeyspaceExpire(t *testing.T) {
+	h, c := newKeyspaceHarness(t)
+	defer h.close()
+
+	rev, other := h.keyspace("rev"), h.keyspace("other")
+	h.put("tmp-a", "1010")
+	rev.Put([]byte("tmp-a"), []byte("1010"), nil)
+	rev.Put([]byte("keep"), []byte("1010"), nil)
+	other.Put([]byte("tmp-a"), []byte("1010"), nil)
+	h.compactMem()
+
+	c.set(1020)
+	h.getVal("tmp-a", "1010")
+	h.checkKeyspaces(map[string]string{
+		"rev":   "(keep->1010)",
+		"other": "(tmp-a->1010)",
+	})
+
+	h.compactRangeAt(0, "", "")
+	h.ro = &opt.ReadOptions{Strict: opt.StrictOverride}
+	h.checkKeyspaces(map[string]string{"rev": "(keep->1010)"})
+}
+
+func TestDB_KeyspaceExisting(t *testing.T) {
+	h := newDbHarness(t)
+	defer h.close()
+
+	h.put("a", "v1")
+	h.put("\xff", "v1")
+	h.compactMem()
+
+	h.conf = &Config{Keyspaces: map[string]KeyspaceOptions{"ks": {}}}
+	h.reopenDB()
+	ks := h.keyspace("ks")
+	ks.Put([]byte("a"), []byte("ks"), nil)
+	h.getKeyVal("(a->v1)(\xff->v1)")
+	h.checkKeyspaces(map[string]string{"ks": "(a->ks)"})
+}
+
+func TestDB_KeyspaceComparerMismatch(t *testing.T) {
+	h, _ := newKeyspaceHarness(t)
+	defer h.close()
+
+	h.keyspace("rev").Put([]byte("a"), []byte("v1"), nil)
+	h.closeDB()
+
+	conf := h.conf
+	h.conf = &Config{Keyspaces: map[string]KeyspaceOptions{"rev": {}, "cnt": {}}}
+	if err := h.openDB0(); !errors.IsCorrupted(err) {
+		t.Fatalf("Open: want a corrupted error, got %v", err)
+	}
+	h.db = nil
+
+	// Options other than the comparer may change.
+	h.conf = &Config{Keyspaces: map[string]KeyspaceOptions{"rev": {Comparer: conf.Keyspaces["rev"].Comparer}}}
+	h.openDB()
+	h.checkKeyspaces(map[string]string{"rev": "(a->v1)"})
+}
+
+func TestDB_KeyspaceDisabled(t *testing.T) {
+	h := newDbHarness(t)
+	defer h.close()
+
+	if _, err := h.db.Keyspace("ks"); err != ErrInvalidKeyspace {
+		t.Errorf("Keyspace: want=%v got=%v", ErrInvalidKeyspace, err)
+	}
+}
@@ -0,0 +1,220 @@
+/*
+This is free and unencumbered software released into the public domain.
+
+Anyone is free to copy, modify, publish, use, compile, sell, or
+distribute this software, either in source code form or as a compiled
+binary, for any purpose, commercial or non-commercial, and by any
+means.
+
+In jurisdictions that recognize copyright laws, the author or authors
+of this software dedicate any and all copyright interest in the
+software to the public domain. We make this dedication for the benefit
+of the public at large and to the detriment of our heirs and
+successors. We intend this dedication to be an overt act of
+relinquishment in perpetuity of all present and future rights to this
+software under copyright law.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+IN NO EVENT SHALL THE AUTHORS BE LIABLE FOR ANY CLAIM, DAMAGES OR
+OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE,
+ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR
+OTHER DEALINGS IN THE SOFTWARE.
+
+For more information, please refer to <http://unlicense.org/>
+*/
+
+package leveldb
+
+import (
+	"github.com/syndtr/goleveldb/leveldb/iterator"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+/*
+MergeOperator combines merge operands (see Batch.Merge and DB.Merge) with the value of a key.
+It lets counters and lists be updated without reading them first.
+
+The operator is consulted on every read of a key, that has pending merge operands, and by
+compaction, which collapses operand chains, that are no longer needed separately by any
+snapshot.
+*/
+type MergeOperator interface {
+	// Combines the existing value, which is nil if there is none, with the
+	// operands, oldest first. An error is returned by the read; compaction
+	// keeps the operands instead.
+	FullMerge(key, existing []byte, operands [][]byte) ([]byte, error)
+
+	// Combines two operands, left being the older one, into one, if possible.
+	// The result must have the same effect as applying both in order.
+	PartialMerge(key, left, right []byte) ([]byte, bool)
+}
+
+// Merges the entries of ukey, starting at the current entry of iter, which
+// must be the newest visible merge operand. The base value is subject to aexp
+// and its time-to-live, the operands outlive it. Entries older than cover are
+// deleted by a range tombstone.
+// On return, iter is positioned at the last entry of ukey, that was consumed.
+func (s *session) mergeEntries(iter iterator.Iterator, ukey []byte, cover uint64, aexp AutoExpire) (value []byte, err error) {
+	if s.merge == nil {
+		return nil, ErrNoMergeOperator
+	}
+	var ops [][]byte
+	var base []byte
+	for {
+		eukey, seq, kt, kerr := parseInternalKey(iter.Key())
+		if kerr != nil {
+			return nil, kerr
+		}
+		if s.icmp.uCompare(eukey, ukey) != 0 {
+			iter.Prev()
+			break
+		}
+		if seq < cover {
+			kt = keyTypeDel
+		}
+		if kt != keyTypeMerge {
+			if kt != keyTypeDel && kt != keyTypeRangeDel && s.retain(kt, ukey, iter.Value(), aexp) {
+				_, base = splitValue(kt, iter.Value())
+			}
+			break
+		}
+		ops = append(ops, append([]byte{}, iter.Value()...))
+		if !iter.Next() {
+			if err = iter.Error(); err != nil {
+				return nil, err
+			}
+			iter.Prev()
+			break
+		}
+	}
+
+	// The operands were collected newest first.
+	for i, j := 0, len(ops)-1; i < j; i, j = i+1, j-1 {
+		ops[i], ops[j] = ops[j], ops[i]
+	}
+	return s.merge.FullMerge(ukey, base, ops)
+}
+
+// Resolves a key, whose newest visible entry is a merge operand. The result
+// has no time-to-live.
+func (db *DB) mergeGet(auxm *memDB, auxt tFiles, key []byte, seq, cover uint64, ro *opt.ReadOptions, aexp AutoExpire) (value []byte, deadline int64, err error) {
+	slice := &util.Range{Start: makeInternalKey(nil, key, seq, keyTypeSeek)}
+	strict := opt.GetStrict(db.s.o.Options, ro, opt.StrictReader)
+	em, fm := db.getMems()
+	v := db.s.version()
+	defer v.release()
+
+	its := v.getIterators(slice, ro, nil)
+	if auxm != nil {
+		its = append(its, auxm.NewIterator(slice))
+	}
+	for _, t := range auxt {
+		its = append(its, v.s.tops.newIterator(t, slice, ro, nil))
+	}
+	for _, m := range [...]*memDB{em, fm} {
+		if m == nil {
+			continue
+		}
+		defer m.decref()
+		its = append(its, m.NewIterator(slice))
+	}
+	iter := iterator.NewMergedIterator(its, db.s.icmp, strict)
+	defer iter.Release()
+
+	if !iter.First() {
+		if err = iter.Error(); err == nil {
+			err = ErrNotFound
+		}
+		return
+	}
+	value, err = db.s.mergeEntries(iter, key, cover, aexp)
+	return append([]byte{}, value...), 0, err
+}
+
+// Merge appends the given merge operand for the key, which is combined with
+// the value of the key by the MergeOperator of the DB. It fails with
+// ErrNoMergeOperator, if the DB has no MergeOperator (see Config).
+//
+// It is safe to modify the contents of the arguments after Merge returns but
+// not before.
+func (db *DB) Merge(key, operand []byte, wo *opt.WriteOptions) error {
+	if db.s.merge == nil {
+		return ErrNoMergeOperator
+	}
+	return db.putRec(keyTypeMerge, key, operand, wo)
+}
+
+type mergeOperand struct {
+	seq   uint64
+	value []byte
+}
+
+// Writes the pending merge operands unchanged.
+func (b *tableCompactionBuilder) mergeWrite(ukey []byte) error {
+	for _, o := range b.mergeOps {
+		if err := b.appendKV(makeInternalKey(nil, ukey, o.seq, keyTypeMerge), o.value); err != nil {
+			return err
+		}
+	}
+	b.mergeOps = b.mergeOps[:0]
+	return nil
+}
+
+// Collapses the pending merge operands with the base value, which is nil, if
+// there is none, into a single value, that takes the sequence number of the
+// newest operand. If the MergeOperator fails, the operands are written
+// unchanged and false is returned.
+func (b *tableCompactionBuilder) mergeFull(ukey, base []byte) (bool, error) {
+	ops := make([][]byte, len(b.mergeOps))
+	for i, o := range b.mergeOps {
+		ops[len(ops)-1-i] = o.value
+	}
+	value, err := b.s.merge.FullMerge(ukey, base, ops)
+	if err != nil {
+		b.s.logf("table@build merge failed %q E·%q", ukey, err)
+		return false, b.mergeWrite(ukey)
+	}
+	seq := b.mergeOps[0].seq
+	b.mergeOps = b.mergeOps[:0]
+	return true, b.appendKV(makeInternalKey(nil, ukey, seq, keyTypeVal), value)
+}
+
+// Writes the pending merge operands, that reached the end of their user key
+// in this compaction. If older entries of the key may exist in higher
+// levels, the operands are combined with each other only, otherwise they are
+// collapsed into a value.
+func (b *tableCompactionBuilder) mergeFlush(ukey []byte) error {
+	if len(b.mergeOps) == 0 {
+		return nil
+	}
+	if b.c.baseLevelForKey(ukey) {
+		_, err := b.mergeFull(ukey, nil)
+		return err
+	}
+	return b.mergePartial(ukey)
+}
+
+// Writes the pending merge operands, combining adjacent ones using
+// PartialMerge.
+func (b *tableCompactionBuilder) mergePartial(ukey []byte) error {
+	// Fold from the oldest operand on; a combined operand takes the sequence
+	// number of the newer one.
+	ops := b.mergeOps
+	out := []mergeOperand{ops[len(ops)-1]}
+	for i := len(ops) - 2; i >= 0; i-- {
+		last := &out[len(out)-1]
+		if v, ok := b.s.merge.PartialMerge(ukey, last.value, ops[i].value); ok {
+			*last = mergeOperand{ops[i].seq, v}
+		} else {
+			out = append(out, ops[i])
+		}
+	}
+	for i, j := 0, len(out)-1; i < j; i, j = i+1, j-1 {
+		out[i], out[j] = out[j], out[i]
+	}
+	b.mergeOps = out
+	return b.mergeWrite(ukey)
+}
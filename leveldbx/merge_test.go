@@ -0,0 +1,294 @@
+package leveldb
+
+import (
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/syndtr/goleveldb/leveldb/opt"
+)
+
+// testCounter adds decimal operands to a decimal value. Operands with the
+// prefix "=" are not combined by PartialMerge, the operand "bad" fails.
+type testCounter struct{}
+
+var errTestCounter = errors.New("bad operand")
+
+func (testCounter) FullMerge(key, existing []byte, operands [][]byte) ([]byte, error) {
+	var n int64
+	if existing != nil {
+		n, _ = strconv.ParseInt(string(existing), 10, 64)
+	}
+	for _, op := range operands {
+		if string(op) == "bad" {
+			return nil, errTestCounter
+		}
+		if op[0] == '=' {
+			op = op[1:]
+		}
+		d, _ := strconv.ParseInt(string(op), 10, 64)
+		n += d
+	}
+	return []byte(strconv.FormatInt(n, 10)), nil
+}
+
+func (testCounter) PartialMerge(key, left, right []byte) ([]byte, bool) {
+	if left[0] == '=' || right[0] == '=' || string(left) == "bad" || string(right) == "bad" {
+		return nil, false
+	}
+	a, _ := strconv.ParseInt(string(left), 10, 64)
+	b, _ := strconv.ParseInt(string(right), 10, 64)
+	return []byte(strconv.FormatInt(a+b, 10)), true
+}
+
+func newMergeHarness(t *testing.T) (*dbHarness, *testClock) {
+	c := &testClock{now: 1000}
+	h := new(dbHarness)
+	h.conf = &Config{Expiration: Expiration{Clock: c.get}, Merge: testCounter{}}
+	h.init(t, &opt.Options{DisableLargeBatchTransaction: true})
+	return h, c
+}
+
+func (h *dbHarness) merge(key, operand string) {
+	if err := h.db.Merge([]byte(key), []byte(operand), h.wo); err != nil {
+		h.t.Error("Merge: got error: ", err)
+	}
+}
+
+func TestDB_Merge(t *testing.T) {
+	layouts := []struct {
+		name string
+		fn   func(h *dbHarness)
+	}{
+		{"memdb", func(h *dbHarness) {}},
+		{"level-0", func(h *dbHarness) {
+			h.compactMem()
+			h.tablesPerLevel("1")
+		}},
+		{"level-1", func(h *dbHarness) {
+			h.compactMem()
+			h.compactRangeAt(0, "", "")
+			h.tablesPerLevel("0,1")
+		}},
+	}
+	for _, layout := range layouts {
+		t.Run(layout.name, func(t *testing.T) {
+			h, _ := newMergeHarness(t)
+			defer h.close()
+
+			h.put("a", "1")
+			h.merge("a", "2")
+			h.merge("a", "3")
+			h.put("b", "x")
+			h.merge("c", "5")
+			h.put("d", "7")
+			h.delete("d")
+			h.merge("d", "1")
+			h.merge("e", "1")
+			h.put("e", "9")
+			layout.fn(h)
+
+			h.getVal("a", "6")
+			h.getVal("c", "5")
+			h.getVal("d", "1")
+			h.getVal("e", "9")
+			h.has("c", true)
+			h.getKeyVal("(a->6)(b->x)(c->5)(d->1)(e->9)")
+			h.getKeyValReverse("(a->6)(b->x)(c->5)(d->1)(e->9)")
+		})
+	}
+}
+
+func TestDB_MergeCompaction(t *testing.T) {
+	h, _ := newMergeHarness(t)
+	defer h.close()
+
+	h.put("a", "1")
+	h.merge("a", "2")
+	h.merge("b", "3")
+	h.merge("b", "4")
+	h.compactMem()
+	h.compactRangeAt(0, "", "")
+	h.tablesPerLevel("0,1")
+	h.allEntriesFor("a", "[ 3 ]")
+	h.allEntriesFor("b", "[ 7 ]")
+
+	// A base value may still exist in a higher level; the operands are
+	// only combined with each other.
+	h.merge("a", "10")
+	h.merge("a", "20")
+	h.merge("a", "=1")
+	h.merge("a", "5")
+	h.compactMem()
+	h.compactRangeAt(0, "", "")
+	h.compactRangeAt(1, "", "")
+	h.tablesPerLevel("0,0,1")
+	h.merge("a", "100")
+	h.merge("a", "200")
+	h.compactMem()
+	h.compactRangeAt(0, "", "")
+	h.tablesPerLevel("0,1,1")
+	h.allEntriesFor("a", "[ MERGE:300, 39 ]")
+	h.getVal("a", "339")
+
+	h.compactRangeAt(1, "", "")
+	h.allEntriesFor("a", "[ 339 ]")
+	h.getVal("a", "339")
+	h.getKeyVal("(a->339)(b->7)")
+}
+
+func TestDB_MergePartialCompaction(t *testing.T) {
+	h, _ := newMergeHarness(t)
+	defer h.close()
+
+	h.put("a", "1")
+	h.compactMem()
+	h.compactRangeAt(0, "", "")
+	h.compactRangeAt(1, "", "")
+	h.tablesPerLevel("0,0,1")
+
+	h.merge("a", "10")
+	h.merge("a", "20")
+	h.merge("a", "=1")
+	h.merge("a", "5")
+	h.compactMem()
+	h.compactRangeAt(0, "", "")
+	h.tablesPerLevel("0,1,1")
+	h.allEntriesFor("a", "[ MERGE:5, MERGE:=1, MERGE:30, 1 ]")
+	h.getVal("a", "37")
+	h.getKeyValReverse("(a->37)")
+}
+
+func TestDB_MergeSnapshot(t *testing.T) {
+	h, _ := newMergeHarness(t)
+	defer h.close()
+
+	h.put("a", "1")
+	snap := h.getSnapshot()
+	defer snap.Release()
+	h.merge("a", "2")
+	h.compactMem()
+	h.compactRangeAt(0, "", "")
+
+	h.allEntriesFor("a", "[ MERGE:2, 1 ]")
+	h.getValr(snap, "a", "1")
+	h.getVal("a", "3")
+}
+
+func TestDB_MergeError(t *testing.T) {
+	h, _ := newMergeHarness(t)
+	defer h.close()
+
+	h.put("a", "1")
+	h.merge("a", "bad")
+	if _, err := h.db.Get([]byte("a"), h.ro); err != errTestCounter {
+		t.Errorf("Get: want=%v got=%v", errTestCounter, err)
+	}
+
+	// Compaction keeps the operands.
+	h.compactMem()
+	h.compactRangeAt(0, "", "")
+	h.allEntriesFor("a", "[ MERGE:bad, 1 ]")
+
+	iter := h.db.NewIterator(nil, h.ro)
+	if iter.First() || iter.Error() != errTestCounter {
+		t.Errorf("iterator: want=%v got=%v", errTestCounter, iter.Error())
+	}
+	iter.Release()
+}
+
+func TestDB_MergeTTL(t *testing.T) {
+	h, c := newMergeHarness(t)
+	defer h.close()
+
+	h.putTTL("a", "1", 10*time.Second)
+	h.merge("a", "2")
+	h.getTTL("a", "3", 0)
+
+	h.compactMem()
+	h.compactRangeAt(0, "", "")
+	h.getVal("a", "3")
+
+	// The operands outlive the base value.
+	c.set(1020)
+	h.getVal("a", "2")
+	h.compactRangeAt(1, "", "")
+	h.allEntriesFor("a", "[ 2 ]")
+	h.getVal("a", "2")
+}
+
+func TestDB_MergeBatchTransaction(t *testing.T) {
+	h, _ := newMergeHarness(t)
+	defer h.close()
+
+	b := new(Batch)
+	b.Put([]byte("a"), []byte("1"))
+	b.Merge([]byte("a"), []byte("2"))
+	b.Merge([]byte("b"), []byte("3"))
+	if err := h.db.Write(b, h.wo); err != nil {
+		t.Fatal("Write: got error: ", err)
+	}
+	h.getKeyVal("(a->3)(b->3)")
+
+	tr, err := h.db.OpenTransaction()
+	if err != nil {
+		t.Fatal("OpenTransaction: got error: ", err)
+	}
+	tr.Merge([]byte("a"), []byte("4"), nil)
+	h.getValr(tr, "a", "7")
+	if err := tr.Commit(); err != nil {
+		t.Fatal("Commit: got error: ", err)
+	}
+	h.getVal("a", "7")
+
+	if err := b.Replay(new(testBatchReplay)); err != ErrNoMergeOperator {
+		t.Errorf("Replay: want=%v got=%v", ErrNoMergeOperator, err)
+	}
+}
+
+func TestDB_MergeTransactionCollapse(t *testing.T) {
+	h, _ := newMergeHarness(t)
+	defer h.close()
+
+	h.put("a", "1")
+	tr, err := h.db.OpenTransaction()
+	if err != nil {
+		t.Fatal("OpenTransaction: got error: ", err)
+	}
+	for _, op := range []string{"2", "3", "=4", "5"} {
+		tr.Merge([]byte("a"), []byte(op), nil)
+	}
+	tr.Merge([]byte("b"), []byte("1"), nil)
+	tr.DeleteRange([]byte("b"), []byte("c"), nil)
+	tr.Merge([]byte("b"), []byte("2"), nil)
+	tr.Merge([]byte("b"), []byte("3"), nil)
+	h.getValr(tr, "a", "15")
+	h.getValr(tr, "b", "5")
+	if err := tr.Commit(); err != nil {
+		t.Fatal("Commit: got error: ", err)
+	}
+
+	// The operands are collapsed up to the one, that doesn't combine, and
+	// up to the tombstone.
+	h.allEntriesFor("a", "[ MERGE:5, MERGE:=4, MERGE:5, 1 ]")
+	h.allEntriesFor("b", "[ MERGE:5, RANGEDEL, MERGE:1 ]")
+	h.getKeyVal("(a->15)(b->5)")
+}
+
+func TestDB_MergeNoOperator(t *testing.T) {
+	h := newDbHarness(t)
+	defer h.close()
+
+	if err := h.db.Merge([]byte("a"), []byte("1"), nil); err != ErrNoMergeOperator {
+		t.Errorf("Merge: want=%v got=%v", ErrNoMergeOperator, err)
+	}
+	tr, err := h.db.OpenTransaction()
+	if err != nil {
+		t.Fatal("OpenTransaction: got error: ", err)
+	}
+	defer tr.Discard()
+	if err := tr.Merge([]byte("a"), []byte("1"), nil); err != ErrNoMergeOperator {
+		t.Errorf("Transaction.Merge: want=%v got=%v", ErrNoMergeOperator, err)
+	}
+}
@@ -27,10 +27,19 @@ For more information, please refer to <http://unlicense.org/>
 
 package leveldb
 
+import (
+	"time"
+
+	"github.com/syndtr/goleveldb/leveldb/opt"
+)
+
 /*
 Auto-Expire offers a function, that decides, whether or not a record should be dropped.
+Records written with a time-to-live (DB.PutWithTTL, Batch.PutTTL) expire without one;
+it remains for values, that carry their own expiry, like those of the timefile and
+indexblob packages, whose stored format predates the time-to-live records.
 */
-type AutoExpire interface{
+type AutoExpire interface {
 	// This method is called on every value, deciding whether or not to drop it.
 	//
 	// If the method returns true, the database will retain the value, otherwise
@@ -43,9 +52,99 @@ type AutoExpire interface{
 	Retain(b []byte) bool
 }
 
+/*
+AutoExpireKV is an extended Auto-Expire, that also gets the user key and the current time,
+as reported by the clock of the database (see Expiration.Clock).
+If an AutoExpire implements AutoExpireKV, RetainKV is called instead of Retain.
+*/
+type AutoExpireKV interface {
+	AutoExpire
+
+	// Like Retain, but with the user key and the current time.
+	// The key and value must not be modified or retained.
+	RetainKV(key, value []byte, now time.Time) bool
+}
+
+/*
+AutoExpireAt is an extended Auto-Expire, that can tell in advance, when a value expires.
+The database uses it to estimate, how much of a table is expired (see Expiration.SweepInterval).
+*/
+type AutoExpireAt interface {
+	AutoExpire
+
+	// Returns the time, at which the value is expected to expire, and true,
+	// or false, if it does not expire by time.
+	// The key and value must not be modified or retained.
+	ExpireAt(key, value []byte) (time.Time, bool)
+}
+
+/*
+Expiration wraps an AutoExpire together with the options controlling, where it is applied.
+It is part of the Config, see OpenWithConfig.
+*/
+type Expiration struct {
+	AutoExpire
+
+	// If true, the AutoExpire is also applied on every read path (Get, Has,
+	// iterators, snapshots and transactions). An expired value is then treated
+	// like a deletion marker, even if it has not been compacted away yet.
+	OnRead bool
+
+	// If not nil, decides per read, whether the AutoExpire is applied, instead
+	// of OnRead. ro are the read options of the read, and may be nil; a
+	// dedicated *opt.ReadOptions can be recognized by its pointer.
+	OnReadFunc func(ro *opt.ReadOptions) bool
+
+	// The clock used for time-to-live and AutoExpireKV, or nil for time.Now.
+	Clock func() time.Time
+
+	// If not zero, the expiry sweep runs in this interval, compacting the
+	// tables, whose entries are estimated to be mostly expired. See
+	// DB.CompactExpired.
+	SweepInterval time.Duration
+
+	// The estimated fraction of expired entries, at which a table is swept.
+	// Defaults to 0.5.
+	SweepRatio float64
+}
+
 type defaultAutoExpire struct{}
+
 func (defaultAutoExpire) Retain(b []byte) bool { return true }
 func getAutoExpire(a AutoExpire) AutoExpire {
-	if a==nil { return defaultAutoExpire{} }
+	if a == nil {
+		return defaultAutoExpire{}
+	}
 	return a
 }
+
+func (s *session) setExpiration(e *Expiration) {
+	s.aexp = getAutoExpire(e.AutoExpire)
+	s.aexpRead = e.OnRead
+	s.aexpReadFn = e.OnReadFunc
+	s.clock = e.Clock
+	s.sweepInterval = e.SweepInterval
+	s.sweepRatio = e.SweepRatio
+	if s.sweepRatio <= 0 {
+		s.sweepRatio = 0.5
+	}
+}
+
+func (s *session) now() time.Time {
+	if s.clock != nil {
+		return s.clock()
+	}
+	return time.Now()
+}
+
+// Returns the AutoExpire, that applies to a read, or nil if expired values should be returned.
+func (db *DB) readExpire(ro *opt.ReadOptions) AutoExpire {
+	on := db.s.aexpRead
+	if db.s.aexpReadFn != nil {
+		on = db.s.aexpReadFn(ro)
+	}
+	if !on {
+		return nil
+	}
+	return db.s.aexp
+}
@@ -0,0 +1,304 @@
+package leveldb
+
+import (
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/syndtr/goleveldb/leveldb/opt"
+)
+
+// testExpire treats values as decimal expiration timestamps; non-numeric
+// values never expire.
+type testExpire struct {
+	now int64
+}
+
+func (e *testExpire) Retain(b []byte) bool {
+	n, err := strconv.ParseInt(string(b), 10, 64)
+	if err != nil {
+		return true
+	}
+	return n > atomic.LoadInt64(&e.now)
+}
+
+func (e *testExpire) setNow(now int64) {
+	atomic.StoreInt64(&e.now, now)
+}
+
+func newExpireHarness(t *testing.T, onRead bool) (*dbHarness, *testExpire) {
+	e := new(testExpire)
+	h := new(dbHarness)
+	h.conf = &Config{Expiration: Expiration{AutoExpire: e, OnRead: onRead}}
+	h.init(t, &opt.Options{DisableLargeBatchTransaction: true})
+	return h, e
+}
+
+func (h *dbHarness) getKeyValReverse(want string) {
+	iter := h.db.NewIterator(nil, h.ro)
+	defer iter.Release()
+	res := ""
+	for ok := iter.Last(); ok; ok = iter.Prev() {
+		res = "(" + string(iter.Key()) + "->" + string(iter.Value()) + ")" + res
+	}
+	if err := iter.Error(); err != nil {
+		h.t.Error("getKeyValReverse: ", err)
+	}
+	if res != want {
+		h.t.Errorf("getKeyValReverse: invalid key/value pair, want=%q, got=%q", want, res)
+	}
+}
+
+func (h *dbHarness) has(key string, want bool) {
+	ret, err := h.db.Has([]byte(key), h.ro)
+	if err != nil {
+		h.t.Error("Has: got error: ", err)
+	} else if ret != want {
+		h.t.Errorf("Has: key '%s', want=%v got=%v", key, want, ret)
+	}
+}
+
+// Checks the view of a DB containing a=10, b=30, c=10 (over an older 30),
+// d=30 and e=10, after the clock passed 20.
+func (h *dbHarness) expiredView() {
+	h.get("a", false)
+	h.getVal("b", "30")
+	h.get("c", false)
+	h.getVal("d", "30")
+	h.get("e", false)
+
+	h.has("a", false)
+	h.has("b", true)
+	h.has("c", false)
+	h.has("d", true)
+	h.has("e", false)
+
+	h.assertNumKeys(2)
+	h.getKeyVal("(b->30)(d->30)")
+	h.getKeyValReverse("(b->30)(d->30)")
+
+	snap := h.getSnapshot()
+	h.getValr(snap, "b", "30")
+	h.getr(snap, "c", false)
+	snap.Release()
+
+	tr, err := h.db.OpenTransaction()
+	if err != nil {
+		h.t.Fatal("OpenTransaction: got error: ", err)
+	}
+	h.getValr(tr, "d", "30")
+	h.getr(tr, "e", false)
+	tr.Discard()
+}
+
+func TestDB_ExpireOnRead(t *testing.T) {
+	layouts := []struct {
+		name string
+		fn   func(h *dbHarness)
+	}{
+		{"memdb", func(h *dbHarness) {}},
+		{"level-0", func(h *dbHarness) {
+			h.compactMem()
+			h.tablesPerLevel("1")
+		}},
+		{"level-2", func(h *dbHarness) {
+			h.compactMem()
+			h.compactRangeAt(0, "", "")
+			h.compactRangeAt(1, "", "")
+			h.tablesPerLevel("0,0,1")
+		}},
+	}
+	for _, layout := range layouts {
+		t.Run(layout.name, func(t *testing.T) {
+			h, e := newExpireHarness(t, true)
+			defer h.close()
+
+			h.put("c", "30")
+			h.put("a", "10")
+			h.put("b", "30")
+			h.put("c", "10")
+			h.put("d", "30")
+			h.put("e", "10")
+			layout.fn(h)
+
+			h.getVal("a", "10")
+			h.getVal("c", "10")
+			h.assertNumKeys(5)
+
+			e.setNow(20)
+			h.expiredView()
+		})
+	}
+}
+
+func TestDB_ExpireOnReadMixedLevels(t *testing.T) {
+	h, e := newExpireHarness(t, true)
+	defer h.close()
+
+	h.put("a", "10")
+	h.put("b", "10")
+	h.put("c", "30")
+	h.put("d", "30")
+	h.compactMem()
+	h.compactRangeAt(0, "", "")
+	h.compactRangeAt(1, "", "")
+	h.tablesPerLevel("0,0,1")
+
+	h.put("b", "30")
+	h.put("c", "10")
+	h.compactMem()
+	h.tablesPerLevel("1,0,1")
+
+	h.put("e", "10")
+
+	e.setNow(20)
+	h.expiredView()
+
+	h.reopenDB()
+	h.expiredView()
+}
+
+func TestDB_ExpireOnReadOptions(t *testing.T) {
+	// The filter applies to the reads with roExpire only.
+	roExpire := &opt.ReadOptions{}
+	e := new(testExpire)
+	h := new(dbHarness)
+	h.conf = &Config{Expiration: Expiration{AutoExpire: e, OnRead: true, OnReadFunc: func(ro *opt.ReadOptions) bool {
+		return ro == roExpire
+	}}}
+	h.init(t, &opt.Options{DisableLargeBatchTransaction: true})
+	defer h.close()
+	h.put("a", "10")
+	h.put("b", "30")
+	h.compactMem()
+	e.setNow(20)
+
+	h.getVal("a", "10")
+	h.assertNumKeys(2)
+
+	// The bits of opt.Strict don't affect the filter.
+	h.ro = &opt.ReadOptions{Strict: opt.NoStrict}
+	h.getVal("a", "10")
+	h.has("a", true)
+
+	h.ro = roExpire
+	h.get("a", false)
+	h.has("a", false)
+	h.getVal("b", "30")
+	h.assertNumKeys(1)
+}
+
+func TestDB_ExpireNoResurrection(t *testing.T) {
+	h, e := newExpireHarness(t, false)
+	defer h.close()
+
+	h.put("a", "30")
+	h.put("b", "30")
+	h.compactMem()
+	h.compactRangeAt(0, "", "")
+	h.compactRangeAt(1, "", "")
+	h.tablesPerLevel("0,0,1")
+
+	h.put("a", "10")
+	h.compactMem()
+	h.tablesPerLevel("1,0,1")
+	h.getVal("a", "10")
+
+	// Partial compaction; the older entry in level-2 is not involved.
+	e.setNow(20)
+	h.compactRangeAt(0, "", "")
+	h.tablesPerLevel("0,1,1")
+	h.get("a", false)
+	h.allEntriesFor("a", "[ DEL, 30 ]")
+	h.getKeyVal("(b->30)")
+
+	h.reopenDB()
+	h.get("a", false)
+	h.getKeyVal("(b->30)")
+
+	// Both entries meet in the bottom level and are removed.
+	h.compactRangeAt(1, "", "")
+	h.tablesPerLevel("0,0,1")
+	h.get("a", false)
+	h.allEntriesFor("a", "[ ]")
+	h.getKeyVal("(b->30)")
+}
+
+// testExpireKV expires keys with the prefix "tmp-", once the clock passed
+// the timestamp in their value.
+type testExpireKV struct {
+	calls int32
+}
+
+func (e *testExpireKV) Retain(b []byte) bool {
+	panic("Retain called instead of RetainKV")
+}
+
+func (e *testExpireKV) RetainKV(key, value []byte, now time.Time) bool {
+	atomic.AddInt32(&e.calls, 1)
+	if !strings.HasPrefix(string(key), "tmp-") {
+		return true
+	}
+	n, err := strconv.ParseInt(string(value), 10, 64)
+	return err != nil || n > now.Unix()
+}
+
+type testClock struct {
+	now int64
+}
+
+func (c *testClock) get() time.Time {
+	return time.Unix(atomic.LoadInt64(&c.now), 0)
+}
+
+func (c *testClock) set(now int64) {
+	atomic.StoreInt64(&c.now, now)
+}
+
+func TestDB_ExpireKVClock(t *testing.T) {
+	e := new(testExpireKV)
+	c := &testClock{now: 1000}
+	h := new(dbHarness)
+	h.conf = &Config{Expiration: Expiration{AutoExpire: e, OnRead: true, Clock: c.get}}
+	h.init(t, &opt.Options{DisableLargeBatchTransaction: true})
+	defer h.close()
+
+	h.put("tmp-a", "1010")
+	h.put("tmp-b", "1030")
+	h.put("keep", "1010")
+	h.putTTL("ttl", "v", 30*time.Second)
+	h.compactMem()
+
+	h.getVal("tmp-a", "1010")
+	h.getTTL("ttl", "v", 30*time.Second)
+	if atomic.LoadInt32(&e.calls) == 0 {
+		t.Error("RetainKV was not called")
+	}
+
+	c.set(1020)
+	h.get("tmp-a", false)
+	h.getVal("tmp-b", "1030")
+	h.getVal("keep", "1010")
+	h.getTTL("ttl", "v", 10*time.Second)
+	h.getKeyVal("(keep->1010)(tmp-b->1030)(ttl->v)")
+
+	c.set(1040)
+	h.get("tmp-b", false)
+	h.get("ttl", false)
+	h.getKeyVal("(keep->1010)")
+
+	h.compactRangeAt(0, "", "")
+	h.tablesPerLevel("0,1")
+	h.allEntriesFor("tmp-a", "[ ]")
+	h.allEntriesFor("ttl", "[ ]")
+
+	value, err := h.db.GetProperty("leveldb.expired")
+	if err != nil {
+		t.Fatal("GetProperty: got error: ", err)
+	}
+	if !strings.Contains(value, "   1   |          3\n") {
+		t.Errorf("GetProperty: invalid expired stats:\n%s", value)
+	}
+}
@@ -0,0 +1,304 @@
+/*
+This is free and unencumbered software released into the public domain.
+
+Anyone is free to copy, modify, publish, use, compile, sell, or
+distribute this software, either in source code form or as a compiled
+binary, for any purpose, commercial or non-commercial, and by any
+means.
+
+In jurisdictions that recognize copyright laws, the author or authors
+of this software dedicate any and all copyright interest in the
+software to the public domain. We make this dedication for the benefit
+of the public at large and to the detriment of our heirs and
+successors. We intend this dedication to be an overt act of
+relinquishment in perpetuity of all present and future rights to this
+software under copyright law.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+IN NO EVENT SHALL THE AUTHORS BE LIABLE FOR ANY CLAIM, DAMAGES OR
+OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE,
+ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR
+OTHER DEALINGS IN THE SOFTWARE.
+
+For more information, please refer to <http://unlicense.org/>
+*/
+
+package leveldb
+
+import (
+	"sort"
+	"time"
+
+	"github.com/syndtr/goleveldb/leveldb/opt"
+)
+
+/*
+OptimisticTransaction reads from a snapshot and buffers its writes, without blocking other
+writers. Commit fails with ErrConflict, if a key read by the transaction was written after
+the snapshot was taken; the caller may retry with a new transaction.
+
+Only the keys read with Get and Has are validated, a key inserted into a range is not
+detected. An OptimisticTransaction is not safe for concurrent use.
+*/
+type OptimisticTransaction struct {
+	db      *DB
+	snap    *Snapshot
+	reads   map[string]struct{}
+	pending map[string]*optPending
+	batch   Batch
+	closed  bool
+}
+
+// The buffered writes of a key.
+type optPending struct {
+	set      bool // The value or deletion below replaces the snapshot
+	del      bool
+	value    []byte
+	deadline int64
+	ops      [][]byte // Merge operands, oldest first
+}
+
+/*
+OpenOptimisticTransaction opens an optimistic transaction, reading from a snapshot of the
+current state of the DB. It coexists with the Transaction of OpenTransaction, which blocks
+its commit until done.
+
+The transaction must be committed or discarded.
+*/
+func (db *DB) OpenOptimisticTransaction() (*OptimisticTransaction, error) {
+	if err := db.ok(); err != nil {
+		return nil, err
+	}
+	snap, err := db.GetSnapshot()
+	if err != nil {
+		return nil, err
+	}
+	return &OptimisticTransaction{
+		db:      db,
+		snap:    snap,
+		reads:   make(map[string]struct{}),
+		pending: make(map[string]*optPending),
+	}, nil
+}
+
+func (tx *OptimisticTransaction) read(key []byte, ro *opt.ReadOptions) ([]byte, error) {
+	tx.reads[string(key)] = struct{}{}
+	return tx.snap.Get(key, ro)
+}
+
+/*
+Get gets the value for the given key, seeing the writes of the transaction. It returns
+ErrNotFound if the key does not exist. The key is validated on commit.
+
+The returned slice is its own copy, it is safe to modify the contents of the returned slice.
+It is safe to modify the contents of the argument after Get returns.
+*/
+func (tx *OptimisticTransaction) Get(key []byte, ro *opt.ReadOptions) ([]byte, error) {
+	if tx.closed {
+		return nil, errTransactionDone
+	}
+	p := tx.pending[string(key)]
+	if p == nil {
+		return tx.read(key, ro)
+	}
+	var base []byte
+	if p.set {
+		if p.del || (p.deadline != 0 && tx.db.s.now().UnixNano() >= p.deadline) {
+			if len(p.ops) == 0 {
+				return nil, ErrNotFound
+			}
+		} else {
+			base = append([]byte(nil), p.value...)
+		}
+	} else {
+		v, err := tx.read(key, ro)
+		if err != nil && err != ErrNotFound {
+			return nil, err
+		}
+		base = v
+	}
+	if len(p.ops) == 0 {
+		return base, nil
+	}
+	if tx.db.s.merge == nil {
+		return nil, ErrNoMergeOperator
+	}
+	return tx.db.s.merge.FullMerge(key, base, p.ops)
+}
+
+/*
+Has returns true if the key exists, seeing the writes of the transaction. The key is
+validated on commit.
+
+It is safe to modify the contents of the argument after Has returns.
+*/
+func (tx *OptimisticTransaction) Has(key []byte, ro *opt.ReadOptions) (bool, error) {
+	_, err := tx.Get(key, ro)
+	if err == ErrNotFound {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (tx *OptimisticTransaction) pend(key []byte) *optPending {
+	p := tx.pending[string(key)]
+	if p == nil {
+		p = new(optPending)
+		tx.pending[string(key)] = p
+	}
+	return p
+}
+
+/*
+Put sets the value for the given key on commit.
+
+It is safe to modify the contents of the arguments after Put returns.
+*/
+func (tx *OptimisticTransaction) Put(key, value []byte) error {
+	if tx.closed {
+		return errTransactionDone
+	}
+	tx.batch.Put(key, value)
+	*tx.pend(key) = optPending{set: true, value: append([]byte(nil), value...)}
+	return nil
+}
+
+/*
+PutWithTTL sets the value for the given key on commit, which expires after the given
+time-to-live.
+
+It is safe to modify the contents of the arguments after PutWithTTL returns.
+*/
+func (tx *OptimisticTransaction) PutWithTTL(key, value []byte, ttl time.Duration) error {
+	if tx.closed {
+		return errTransactionDone
+	}
+	deadline := tx.db.s.now().Add(ttl).UnixNano()
+	tx.batch.appendRec(keyTypeValTTL, key, appendTTLValue(nil, deadline, value))
+	*tx.pend(key) = optPending{set: true, value: append([]byte(nil), value...), deadline: deadline}
+	return nil
+}
+
+/*
+Delete deletes the value for the given key on commit.
+
+It is safe to modify the contents of the arguments after Delete returns.
+*/
+func (tx *OptimisticTransaction) Delete(key []byte) error {
+	if tx.closed {
+		return errTransactionDone
+	}
+	tx.batch.Delete(key)
+	*tx.pend(key) = optPending{set: true, del: true}
+	return nil
+}
+
+/*
+Merge appends the given merge operand for the key on commit, see DB.Merge.
+
+It is safe to modify the contents of the arguments after Merge returns.
+*/
+func (tx *OptimisticTransaction) Merge(key, operand []byte) error {
+	if tx.closed {
+		return errTransactionDone
+	}
+	if tx.db.s.merge == nil {
+		return ErrNoMergeOperator
+	}
+	tx.batch.Merge(key, operand)
+	p := tx.pend(key)
+	p.ops = append(p.ops, append([]byte(nil), operand...))
+	return nil
+}
+
+/*
+Commit validates the keys read by the transaction and writes its changes atomically. It
+returns ErrConflict, if one of the keys was written after the snapshot of the transaction.
+The transaction is closed in any case.
+*/
+func (tx *OptimisticTransaction) Commit(wo *opt.WriteOptions) error {
+	if tx.closed {
+		return errTransactionDone
+	}
+	defer tx.Discard()
+	db := tx.db
+	if err := db.ok(); err != nil {
+		return err
+	}
+	if tx.batch.Len() == 0 {
+		return nil
+	}
+	sync := wo.GetSync() && !db.s.o.GetNoSync()
+
+	// Acquire write lock.
+	select {
+	case db.writeLockC <- struct{}{}:
+	case err := <-db.compPerErrC:
+		return err
+	case <-db.closeC:
+		return ErrClosed
+	}
+
+	conflict, err := db.writtenSince(tx.reads, tx.snap.elem.seq)
+	if err == nil && conflict {
+		err = ErrConflict
+	}
+	if err != nil {
+		db.unlockWrite(false, 0, err)
+		return err
+	}
+	return db.writeLocked(&tx.batch, nil, false, sync)
+}
+
+/*
+Discard discards the transaction. It is a no-op, if the transaction is already closed.
+*/
+func (tx *OptimisticTransaction) Discard() {
+	if tx.closed {
+		return
+	}
+	tx.closed = true
+	tx.snap.Release()
+	tx.reads, tx.pending = nil, nil
+}
+
+// Reports whether one of the keys has an entry, or is covered by a range tombstone, newer
+// than seq; the write lock must be held.
+func (db *DB) writtenSince(keys map[string]struct{}, seq uint64) (bool, error) {
+	if len(keys) == 0 {
+		return false, nil
+	}
+	sorted := make([]string, 0, len(keys))
+	for k := range keys {
+		sorted = append(sorted, k)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		return db.s.icmp.uCompare([]byte(sorted[i]), []byte(sorted[j])) < 0
+	})
+	rdels := db.rangeDels(nil, nil)
+	iter := db.newRawIterator(nil, nil, nil, nil, nil)
+	defer iter.Release()
+	for _, k := range sorted {
+		// The first entry of the key is the newest one.
+		ukey := []byte(k)
+		if rdels.cover(db.s.icmp, ukey, keyMaxSeq) > seq {
+			return true, nil
+		}
+		if !iter.Seek(makeInternalKey(nil, ukey, keyMaxSeq, keyTypeSeek)) {
+			if err := iter.Error(); err != nil {
+				return false, err
+			}
+			continue
+		}
+		eukey, eseq, _, err := parseInternalKey(iter.Key())
+		if err != nil {
+			return false, err
+		}
+		if db.s.icmp.uCompare(eukey, ukey) == 0 && eseq > seq {
+			return true, nil
+		}
+	}
+	return false, iter.Error()
+}
@@ -0,0 +1,146 @@
+package leveldb
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/syndtr/goleveldb/leveldb/opt"
+)
+
+func (h *dbHarness) openOptimistic() *OptimisticTransaction {
+	tx, err := h.db.OpenOptimisticTransaction()
+	if err != nil {
+		h.t.Fatal("OpenOptimisticTransaction: got error: ", err)
+	}
+	return tx
+}
+
+func (h *dbHarness) optGet(tx *OptimisticTransaction, key, want string) {
+	v, err := tx.Get([]byte(key), h.ro)
+	if want == "" {
+		if err != ErrNotFound {
+			h.t.Errorf("Get %s: want not found, got=%q err=%v", key, v, err)
+		}
+	} else if err != nil || string(v) != want {
+		h.t.Errorf("Get %s: want=%q got=%q err=%v", key, want, v, err)
+	}
+}
+
+func TestDB_OptimisticTransaction(t *testing.T) {
+	h := newDbHarness(t)
+	defer h.close()
+
+	h.put("a", "v1")
+	h.put("c", "v1")
+	tx := h.openOptimistic()
+	h.optGet(tx, "a", "v1")
+	tx.Put([]byte("a"), []byte("v2"))
+	tx.Put([]byte("b"), []byte("v2"))
+	tx.Delete([]byte("c"))
+	h.optGet(tx, "a", "v2")
+	h.optGet(tx, "b", "v2")
+	h.optGet(tx, "c", "")
+	h.getVal("a", "v1")
+
+	// Writes to keys not read do not conflict.
+	h.put("x", "v1")
+	h.put("c", "v2")
+	if err := tx.Commit(h.wo); err != nil {
+		t.Fatal("Commit: got error: ", err)
+	}
+	h.getKeyVal("(a->v2)(b->v2)(x->v1)")
+	if err := tx.Commit(h.wo); err != errTransactionDone {
+		t.Errorf("Commit: want=%v got=%v", errTransactionDone, err)
+	}
+}
+
+func TestDB_OptimisticTransactionConflict(t *testing.T) {
+	h := newDbHarness(t)
+	defer h.close()
+
+	h.put("a", "v1")
+	tx := h.openOptimistic()
+	h.optGet(tx, "a", "v1")
+	tx.Put([]byte("b"), []byte("v2"))
+	h.put("a", "v2")
+	h.compactMem()
+	if err := tx.Commit(h.wo); err != ErrConflict {
+		t.Errorf("Commit: want=%v got=%v", ErrConflict, err)
+	}
+	h.get("b", false)
+
+	// Reading a missing key conflicts with its insertion.
+	tx = h.openOptimistic()
+	if ok, err := tx.Has([]byte("n"), h.ro); err != nil || ok {
+		t.Errorf("Has: want=false got=%v err=%v", ok, err)
+	}
+	tx.Put([]byte("b"), []byte("v2"))
+	h.put("n", "v1")
+	if err := tx.Commit(h.wo); err != ErrConflict {
+		t.Errorf("Commit: want=%v got=%v", ErrConflict, err)
+	}
+
+	// The pessimistic transaction blocks the commit.
+	tx = h.openOptimistic()
+	h.optGet(tx, "a", "v2")
+	tx.Put([]byte("b"), []byte("v3"))
+	tr, err := h.db.OpenTransaction()
+	if err != nil {
+		t.Fatal("OpenTransaction: got error: ", err)
+	}
+	errc := make(chan error)
+	go func() { errc <- tx.Commit(h.wo) }()
+	tr.Put([]byte("a"), []byte("v3"), nil)
+	if err := tr.Commit(); err != nil {
+		t.Fatal("Transaction.Commit: got error: ", err)
+	}
+	if err := <-errc; err != ErrConflict {
+		t.Errorf("Commit: want=%v got=%v", ErrConflict, err)
+	}
+	h.getKeyVal("(a->v3)(n->v1)")
+}
+
+type reverseComparer struct{}
+
+func (reverseComparer) Name() string                      { return "test.ReverseComparer" }
+func (reverseComparer) Compare(a, b []byte) int           { return bytes.Compare(b, a) }
+func (reverseComparer) Separator(dst, a, b []byte) []byte { return nil }
+func (reverseComparer) Successor(dst, b []byte) []byte    { return nil }
+
+func TestDB_OptimisticTransactionComparer(t *testing.T) {
+	h := new(dbHarness)
+	h.init(t, &opt.Options{Comparer: reverseComparer{}, DisableLargeBatchTransaction: true})
+	defer h.close()
+
+	// In the order of the comparer, "z" precedes "a". Nothing follows "a",
+	// which must not end the validation before "z" is checked.
+	h.put("z", "v1")
+	tx := h.openOptimistic()
+	h.optGet(tx, "a", "")
+	h.optGet(tx, "z", "v1")
+	tx.Put([]byte("b"), []byte("v2"))
+	h.put("z", "v2")
+	if err := tx.Commit(h.wo); err != ErrConflict {
+		t.Errorf("Commit: want=%v got=%v", ErrConflict, err)
+	}
+	h.get("b", false)
+}
+
+func TestDB_OptimisticTransactionMerge(t *testing.T) {
+	h, _ := newMergeHarness(t)
+	defer h.close()
+
+	h.put("a", "1")
+	tx := h.openOptimistic()
+	tx.Merge([]byte("a"), []byte("2"))
+	tx.Merge([]byte("b"), []byte("5"))
+	h.optGet(tx, "a", "3")
+	h.optGet(tx, "b", "5")
+	tx.Put([]byte("b"), []byte("1"))
+	tx.Merge([]byte("b"), []byte("1"))
+	h.optGet(tx, "b", "2")
+	if err := tx.Commit(h.wo); err != nil {
+		t.Fatal("Commit: got error: ", err)
+	}
+	h.getKeyVal("(a->3)(b->2)")
+}
@@ -22,7 +22,9 @@ func dupOptions(o *opt.Options) *opt.Options {
 	return newo
 }
 
-func (s *session) setOptions(o *opt.Options) {
+// Returns a copy of the options, whose comparer and filters operate on
+// internal keys.
+func internalOptions(o *opt.Options) (*opt.Options, *iComparer) {
 	no := dupOptions(o)
 	// Alternative filters.
 	if filters := o.GetAltFilters(); len(filters) > 0 {
@@ -32,13 +34,23 @@ func (s *session) setOptions(o *opt.Options) {
 		}
 	}
 	// Comparer.
-	s.icmp = &iComparer{o.GetComparer()}
-	no.Comparer = s.icmp
+	icmp := &iComparer{o.GetComparer()}
+	no.Comparer = icmp
 	// Filter.
 	if filter := o.GetFilter(); filter != nil {
 		no.Filter = &iFilter{filter}
 	}
+	return no, icmp
+}
 
+func (s *session) setOptions(o *opt.Options) {
+	if s.keyspaces != nil {
+		o = dupOptions(o)
+		o.Comparer = newKeyspaceComparer(o.GetComparer(), s.keyspaces)
+	}
+	var no *opt.Options
+	no, s.icmp = internalOptions(o)
+	s.setPrefixFilter(o, no)
 	s.o = &cachedOptions{Options: no}
 	s.o.cache()
 }
@@ -0,0 +1,183 @@
+/*
+This is free and unencumbered software released into the public domain.
+
+Anyone is free to copy, modify, publish, use, compile, sell, or
+distribute this software, either in source code form or as a compiled
+binary, for any purpose, commercial or non-commercial, and by any
+means.
+
+In jurisdictions that recognize copyright laws, the author or authors
+of this software dedicate any and all copyright interest in the
+software to the public domain. We make this dedication for the benefit
+of the public at large and to the detriment of our heirs and
+successors. We intend this dedication to be an overt act of
+relinquishment in perpetuity of all present and future rights to this
+software under copyright law.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+IN NO EVENT SHALL THE AUTHORS BE LIABLE FOR ANY CLAIM, DAMAGES OR
+OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE,
+ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR
+OTHER DEALINGS IN THE SOFTWARE.
+
+For more information, please refer to <http://unlicense.org/>
+*/
+
+package leveldb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strconv"
+
+	"github.com/maxymania/storage-engines/leveldbx/table"
+	"github.com/syndtr/goleveldb/leveldb/comparer"
+	"github.com/syndtr/goleveldb/leveldb/filter"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+/*
+PrefixExtractor derives the prefix of a key, which is added to the filters of the tables
+along with the key itself (see Config). An iterator over the range util.BytesPrefix(p),
+where p is the prefix of itself, skips the tables whose filter excludes p.
+
+Prefix filtering requires the default comparer; the keys having a prefix must begin with it.
+*/
+type PrefixExtractor interface {
+	// The name of the extractor, stored with the filters. The name must change, if the
+	// extracted prefixes change.
+	Name() string
+
+	// Returns the prefix of the key, or nil if it has none.
+	Prefix(key []byte) []byte
+}
+
+/*
+FixedPrefix is a PrefixExtractor, returning the first n bytes of the keys, that are at least
+n bytes long.
+*/
+type FixedPrefix int
+
+func (n FixedPrefix) Name() string { return "leveldbx.FixedPrefix." + strconv.Itoa(int(n)) }
+func (n FixedPrefix) Prefix(key []byte) []byte {
+	if len(key) < int(n) {
+		return nil
+	}
+	return key[:n]
+}
+
+// The probe key of a prefix sorts before all internal keys of the prefix. Its key type is
+// invalid, so it cannot be mistaken for a lookup.
+func makePrefixProbe(prefix []byte) internalKey {
+	ik := make([]byte, len(prefix)+8)
+	copy(ik, prefix)
+	binary.LittleEndian.PutUint64(ik[len(prefix):], ^uint64(0))
+	return internalKey(ik)
+}
+
+func isPrefixProbe(ik []byte) bool {
+	return len(ik) >= 8 && binary.LittleEndian.Uint64(ik[len(ik)-8:]) == ^uint64(0)
+}
+
+/*
+iPrefixFilter adds the prefixes of the keys to the filter.
+*/
+type iPrefixFilter struct {
+	filter.Filter
+	ext PrefixExtractor
+}
+
+func (f iPrefixFilter) Name() string {
+	return "leveldbx.Prefix." + f.ext.Name() + "." + f.Filter.Name()
+}
+func (f iPrefixFilter) Contains(filter, key []byte) bool {
+	return f.Filter.Contains(filter, internalKey(key).ukey())
+}
+func (f iPrefixFilter) NewGenerator() filter.FilterGenerator {
+	return &iPrefixFilterGenerator{FilterGenerator: f.Filter.NewGenerator(), ext: f.ext}
+}
+
+type iPrefixFilterGenerator struct {
+	filter.FilterGenerator
+	ext  PrefixExtractor
+	last []byte // The prefix added last
+}
+
+func (g *iPrefixFilterGenerator) Add(key []byte) {
+	ukey := internalKey(key).ukey()
+	g.FilterGenerator.Add(ukey)
+	p := g.ext.Prefix(ukey)
+	if p == nil || (g.last != nil && bytes.Equal(p, g.last)) {
+		return
+	}
+	g.FilterGenerator.Add(p)
+	g.last = append(g.last[:0], p...)
+}
+func (g *iPrefixFilterGenerator) Generate(b filter.Buffer) {
+	g.last = g.last[:0]
+	g.FilterGenerator.Generate(b)
+}
+
+// Sets up the prefix filter of the internal options no, derived from o; the whole key
+// filter remains an alternative for older tables.
+func (s *session) setPrefixFilter(o, no *opt.Options) {
+	if s.prefix == nil {
+		return
+	}
+	base := o.GetFilter()
+	if base == nil {
+		base = filter.NewBloomFilter(10)
+	}
+	no.AltFilters = append(no.AltFilters, &iFilter{base})
+	no.Filter = &iPrefixFilter{base, s.prefix}
+	s.prefixSeek = o.GetComparer().Name() == comparer.DefaultComparer.Name()
+}
+
+// Returns the prefix p, if the internal key slice is util.BytesPrefix(p) and p is a prefix of
+// itself.
+func (s *session) prefixOf(islice *util.Range) []byte {
+	if !s.prefixSeek || islice == nil || len(islice.Start) <= 8 || len(islice.Limit) <= 8 {
+		return nil
+	}
+	start, limit := internalKey(islice.Start).ukey(), internalKey(islice.Limit).ukey()
+	if s.keyspaces != nil && bytes.HasPrefix(start, []byte(keyspaceMarker)) {
+		return nil
+	}
+	p := s.prefix.Prefix(start)
+	if p == nil || !bytes.Equal(p, start) || !bytes.Equal(util.BytesPrefix(p).Limit, limit) {
+		return nil
+	}
+	return p
+}
+
+// Returns the tables, that may hold keys of the prefix.
+func (t *tOps) prefixTables(tables tFiles, prefix []byte, ro *opt.ReadOptions) (r tFiles) {
+	limit := util.BytesPrefix(prefix).Limit
+	for _, f := range tables {
+		if f.overlaps(t.s.icmp, prefix, limit) && t.mayContainPrefix(f, prefix, ro) {
+			r = append(r, f)
+		}
+	}
+	return
+}
+
+// Probes the table for the prefix. The filter of the data block, where the prefix would
+// start, is consulted first.
+func (t *tOps) mayContainPrefix(f *tFile, prefix []byte, ro *opt.ReadOptions) bool {
+	ch, err := t.open(f)
+	if err != nil {
+		return true
+	} // The iterator reports the error.
+	defer ch.Release()
+	rkey, err := ch.Value().(*table.Reader).FindKey(makePrefixProbe(prefix), true, ro)
+	if err == ErrNotFound {
+		return false
+	}
+	if err != nil {
+		return true
+	}
+	return bytes.HasPrefix(internalKey(rkey).ukey(), prefix)
+}
@@ -0,0 +1,110 @@
+package leveldb
+
+import (
+	"testing"
+
+	"github.com/syndtr/goleveldb/leveldb/filter"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+func (h *dbHarness) prefixIterators(prefix string, want int) {
+	v := h.db.s.version()
+	defer v.release()
+	slice := util.BytesPrefix([]byte(prefix))
+	its := v.getIterators(&util.Range{
+		Start: makeInternalKey(nil, slice.Start, keyMaxSeq, keyTypeSeek),
+		Limit: makeInternalKey(nil, slice.Limit, keyMaxSeq, keyTypeSeek),
+	}, h.ro, nil)
+	for _, it := range its {
+		it.Release()
+	}
+	if len(its) != want {
+		h.t.Errorf("prefix %q: want %d table iterators, got %d", prefix, want, len(its))
+	}
+}
+
+func (h *dbHarness) prefixKeyVal(prefix, want string) {
+	iter := h.db.NewIterator(util.BytesPrefix([]byte(prefix)), h.ro)
+	defer iter.Release()
+	got := ""
+	for iter.Next() {
+		got += "(" + string(iter.Key()) + "->" + string(iter.Value()) + ")"
+	}
+	if err := iter.Error(); err != nil {
+		h.t.Error("iterator: got error: ", err)
+	}
+	if got != want {
+		h.t.Errorf("prefix %q: want=%q got=%q", prefix, want, got)
+	}
+}
+
+func TestPrefixFilter(t *testing.T) {
+	f := iPrefixFilter{filter.NewBloomFilter(10), FixedPrefix(2)}
+	g := f.NewGenerator()
+	for _, k := range []string{"aa1", "aa2", "bb1", "c"} {
+		g.Add(makeInternalKey(nil, []byte(k), 1, keyTypeVal))
+	}
+	b := new(util.Buffer)
+	g.Generate(b)
+	for _, p := range []string{"aa", "bb"} {
+		if !f.Contains(b.Bytes(), makePrefixProbe([]byte(p))) {
+			t.Errorf("prefix %q: not contained", p)
+		}
+	}
+	if f.Contains(b.Bytes(), makePrefixProbe([]byte("cc"))) {
+		t.Error("prefix \"cc\": contained")
+	}
+	if !f.Contains(b.Bytes(), makeInternalKey(nil, []byte("aa2"), 1, keyTypeSeek)) {
+		t.Error("key \"aa2\": not contained")
+	}
+}
+
+func TestDB_PrefixSeek(t *testing.T) {
+	h := new(dbHarness)
+	h.conf = &Config{PrefixExtractor: FixedPrefix(2)}
+	h.init(t, &opt.Options{DisableLargeBatchTransaction: true})
+	defer h.close()
+
+	h.put("aa1", "v1")
+	h.put("cc1", "v1")
+	h.compactMem()
+	h.put("bb1", "v1")
+	h.compactMem()
+	h.put("bb2", "v1")
+	h.tablesPerLevel("2")
+
+	// The first table spans the prefix "bb", but does not hold it.
+	h.prefixIterators("bb", 1)
+	h.prefixIterators("aa", 1)
+	h.prefixIterators("zz", 0)
+	h.prefixIterators("b", 2)
+	h.prefixKeyVal("bb", "(bb1->v1)(bb2->v1)")
+	h.prefixKeyVal("b", "(bb1->v1)(bb2->v1)")
+	h.prefixKeyVal("cc", "(cc1->v1)")
+	h.getVal("cc1", "v1")
+
+	h.compactMem()
+	h.compactRangeAt(0, "", "")
+	h.tablesPerLevel("0,1")
+	h.prefixIterators("bb", 1)
+	h.prefixIterators("ab", 0)
+	h.prefixKeyVal("bb", "(bb1->v1)(bb2->v1)")
+}
+
+func TestDB_PrefixSeekExisting(t *testing.T) {
+	h := newDbHarness(t)
+	defer h.close()
+
+	h.put("aa1", "v1")
+	h.put("cc1", "v1")
+	h.compactMem()
+
+	// The table has no prefix filter.
+	h.conf = &Config{PrefixExtractor: FixedPrefix(2)}
+	h.reopenDB()
+	h.prefixIterators("bb", 0)
+	h.prefixIterators("cc", 1)
+	h.prefixKeyVal("aa", "(aa1->v1)")
+	h.getVal("cc1", "v1")
+}
@@ -0,0 +1,352 @@
+/*
+This is free and unencumbered software released into the public domain.
+
+Anyone is free to copy, modify, publish, use, compile, sell, or
+distribute this software, either in source code form or as a compiled
+binary, for any purpose, commercial or non-commercial, and by any
+means.
+
+In jurisdictions that recognize copyright laws, the author or authors
+of this software dedicate any and all copyright interest in the
+software to the public domain. We make this dedication for the benefit
+of the public at large and to the detriment of our heirs and
+successors. We intend this dedication to be an overt act of
+relinquishment in perpetuity of all present and future rights to this
+software under copyright law.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+IN NO EVENT SHALL THE AUTHORS BE LIABLE FOR ANY CLAIM, DAMAGES OR
+OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE,
+ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR
+OTHER DEALINGS IN THE SOFTWARE.
+
+For more information, please refer to <http://unlicense.org/>
+*/
+
+package leveldb
+
+import (
+	"sort"
+
+	"github.com/syndtr/goleveldb/leveldb/memdb"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+)
+
+/*
+Range tombstones.
+
+A range tombstone (see Batch.DeleteRange) is an entry of the user key start, whose value is
+the limit of the range. It deletes every entry of the keys start <= key < limit, that is older
+than the tombstone itself, at each snapshot that sees it.
+
+The tombstones are written to the memdbs and tables like any other entry, but reads must
+find the tombstones covering a key without scanning for them. Each memDB therefore keeps a
+list of its tombstones, and each table lists them in the manifest, next to the largest
+sequence number of its entries; a version gathers the tombstones of its tables. The lists are
+split into sorted, non-overlapping fragments, which reads search by binary search; a version
+fragments its tombstones once, a memDB whenever a tombstone was added since.
+
+Compaction drops the entries covered by a tombstone, that is visible to every snapshot, and
+drops whole tables covered that way, without reading them. A tombstone itself is dropped,
+once no table outside the compaction may hold an entry of its range. Tombstones don't expire
+and are never subject to the AutoExpire; the values they delete are gone regardless of their
+time-to-live.
+*/
+
+/*
+DeleteRange deletes the keys start <= key < limit, see Batch.DeleteRange. The deleted
+entries are dropped by compaction; whole tables are dropped without being read.
+
+It is safe to modify the contents of the arguments after DeleteRange returns but not
+before.
+*/
+func (db *DB) DeleteRange(start, limit []byte, wo *opt.WriteOptions) error {
+	return db.putRec(keyTypeRangeDel, start, limit, wo)
+}
+
+type rangeTombstone struct {
+	start, limit []byte
+	seq          uint64
+}
+
+// Reports whether the tombstone covers the user key.
+func (t *rangeTombstone) covers(icmp *iComparer, ukey []byte) bool {
+	return icmp.uCompare(t.start, ukey) <= 0 && icmp.uCompare(ukey, t.limit) < 0
+}
+
+// Reports whether the tombstone covers all the user keys umin to umax.
+func (t *rangeTombstone) contains(icmp *iComparer, umin, umax []byte) bool {
+	return icmp.uCompare(t.start, umin) <= 0 && icmp.uCompare(umax, t.limit) < 0
+}
+
+// Reports whether the range of the tombstone overlaps the user keys umin to umax.
+func (t *rangeTombstone) overlaps(icmp *iComparer, umin, umax []byte) bool {
+	return icmp.uCompare(t.start, umax) <= 0 && icmp.uCompare(umin, t.limit) < 0
+}
+
+type rangeTombstones []rangeTombstone
+
+// A span of user keys start <= key < limit and the sequence numbers of the tombstones
+// covering it, newest first.
+type rangeFragment struct {
+	start, limit []byte
+	seqs         []uint64
+}
+
+// Tombstones split at their bounds into sorted, non-overlapping fragments.
+type rangeFragments []rangeFragment
+
+// Splits the tombstones into fragments.
+func (ts rangeTombstones) fragment(icmp *iComparer) rangeFragments {
+	if len(ts) == 0 {
+		return nil
+	}
+	bounds := make([][]byte, 0, 2*len(ts))
+	for i := range ts {
+		bounds = append(bounds, ts[i].start, ts[i].limit)
+	}
+	sort.Slice(bounds, func(i, j int) bool { return icmp.uCompare(bounds[i], bounds[j]) < 0 })
+	n := 1
+	for _, b := range bounds[1:] {
+		if icmp.uCompare(b, bounds[n-1]) != 0 {
+			bounds[n] = b
+			n++
+		}
+	}
+	fs := make(rangeFragments, n-1)
+	for i := range fs {
+		fs[i].start, fs[i].limit = bounds[i], bounds[i+1]
+	}
+	for i := range ts {
+		t := &ts[i]
+		j := sort.Search(len(fs), func(j int) bool { return icmp.uCompare(fs[j].start, t.start) >= 0 })
+		for ; j < len(fs) && icmp.uCompare(fs[j].start, t.limit) < 0; j++ {
+			fs[j].seqs = append(fs[j].seqs, t.seq)
+		}
+	}
+
+	// Drop the gaps between the tombstones.
+	n = 0
+	for _, f := range fs {
+		if len(f.seqs) == 0 {
+			continue
+		}
+		sort.Slice(f.seqs, func(i, j int) bool { return f.seqs[i] > f.seqs[j] })
+		fs[n] = f
+		n++
+	}
+	return fs[:n]
+}
+
+// Returns the sequence number of the newest tombstone, that covers ukey and is visible at
+// seq, or 0 if there is none.
+func (fs rangeFragments) cover(icmp *iComparer, ukey []byte, seq uint64) uint64 {
+	i := sort.Search(len(fs), func(i int) bool { return icmp.uCompare(ukey, fs[i].limit) < 0 })
+	if i == len(fs) || icmp.uCompare(fs[i].start, ukey) > 0 {
+		return 0
+	}
+	seqs := fs[i].seqs
+	if j := sort.Search(len(seqs), func(j int) bool { return seqs[j] <= seq }); j < len(seqs) {
+		return seqs[j]
+	}
+	return 0
+}
+
+// The tombstones seen by a read, gathered from the memdbs and tables.
+type rangeDelSet []rangeFragments
+
+func (set rangeDelSet) cover(icmp *iComparer, ukey []byte, seq uint64) (n uint64) {
+	for _, fs := range set {
+		if c := fs.cover(icmp, ukey, seq); c > n {
+			n = c
+		}
+	}
+	return
+}
+
+func (set rangeDelSet) add(fs rangeFragments) rangeDelSet {
+	if len(fs) == 0 {
+		return set
+	}
+	return append(set, fs)
+}
+
+// Returns the tombstones of the memdb, fragmented.
+func (m *memDB) rangeDels() rangeFragments {
+	m.rdMu.Lock()
+	defer m.rdMu.Unlock()
+	if m.rfrags == nil && len(m.rdels) > 0 {
+		m.rfrags = m.rdels.fragment(m.db.s.icmp)
+	}
+	return m.rfrags
+}
+
+// Lists a tombstone written to the memdb. Readers hold on to the old slice, so the
+// entries are never changed in place.
+func (m *memDB) addRangeDel(start, limit []byte, seq uint64) {
+	t := rangeTombstone{append([]byte{}, start...), append([]byte{}, limit...), seq}
+	m.rdMu.Lock()
+	defer m.rdMu.Unlock()
+	m.rdels = append(m.rdels, t)
+	m.rfrags = nil
+}
+
+// Unlists the tombstones written with the sequence number seq or later.
+func (m *memDB) revertRangeDels(seq uint64) {
+	m.rdMu.Lock()
+	defer m.rdMu.Unlock()
+	n := len(m.rdels)
+	for n > 0 && m.rdels[n-1].seq >= seq {
+		n--
+	}
+	m.rdels = m.rdels[:n:n]
+	m.rfrags = nil
+}
+
+// Reset resets the memdb and its tombstones.
+func (m *memDB) Reset() {
+	m.DB.Reset()
+	m.rdMu.Lock()
+	defer m.rdMu.Unlock()
+	m.rdels = nil
+	m.rfrags = nil
+}
+
+// Scans a memdb for its tombstones.
+func memRangeDels(mdb *memdb.DB) (ts rangeTombstones) {
+	iter := mdb.NewIterator(nil)
+	defer iter.Release()
+	for iter.Next() {
+		ukey, seq, kt, err := parseInternalKey(iter.Key())
+		if err == nil && kt == keyTypeRangeDel {
+			ts = append(ts, rangeTombstone{append([]byte{}, ukey...), append([]byte{}, iter.Value()...), seq})
+		}
+	}
+	return
+}
+
+// Gathers the tombstones of the tables of a new version.
+func (v *version) collectRangeDels() {
+	for _, tables := range v.levels {
+		for _, t := range tables {
+			v.rdels = append(v.rdels, t.rdels...)
+		}
+	}
+	v.rfrags = v.rdels.fragment(v.s.icmp)
+}
+
+// Returns the tombstones seen by a read of the DB, and of an open transaction.
+func (db *DB) rangeDels(auxm *memDB, auxt tFiles) (set rangeDelSet) {
+	if auxm != nil {
+		set = set.add(auxm.rangeDels())
+	}
+	for _, t := range auxt {
+		set = set.add(t.rdels.fragment(db.s.icmp))
+	}
+	// The memdbs go first, so that no tombstone is missed when a flush commits meanwhile.
+	em, fm := db.getMems()
+	for _, m := range [...]*memDB{em, fm} {
+		if m == nil {
+			continue
+		}
+		set = set.add(m.rangeDels())
+		m.decref()
+	}
+	v := db.s.version()
+	set = set.add(v.rfrags)
+	v.release()
+	return
+}
+
+// Reports whether a table overlapping the user keys umin to umax holds range tombstones.
+func (tf tFiles) hasRangeDels(icmp *iComparer, umin, umax []byte) bool {
+	for _, t := range tf {
+		if len(t.rdels) > 0 && t.overlaps(icmp, umin, umax) {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the largest sequence number of the entries of the table, or 0 if it is unknown.
+func (t *tFile) newest() uint64 {
+	if t.seq != 0 {
+		return t.seq
+	}
+	return t.maxSeq
+}
+
+// Reports whether all entries of the table are deleted by a tombstone, that is visible at
+// seq. The tombstones of the table must lie within it as well, since they are dropped
+// together with the table.
+func (ts rangeTombstones) coversTable(icmp *iComparer, f *tFile, seq uint64) bool {
+	newest := f.newest()
+	if newest == 0 {
+		return false
+	}
+	umin, umax := f.imin.ukey(), f.imax.ukey()
+	for i := range ts {
+		t := &ts[i]
+		if t.seq > seq || t.seq <= newest || !t.contains(icmp, umin, umax) {
+			continue
+		}
+		inner := true
+		for _, r := range f.rdels {
+			if icmp.uCompare(r.start, t.start) < 0 || icmp.uCompare(r.limit, t.limit) > 0 {
+				inner = false
+			}
+		}
+		if inner {
+			return true
+		}
+	}
+	return false
+}
+
+// Drops the tables, whose entries are all deleted by a tombstone, that is visible to every
+// snapshot, without reading them.
+func (db *DB) tableDropCovered() {
+	v := db.s.version()
+	defer v.release()
+	if len(v.rdels) == 0 {
+		return
+	}
+	minSeq := db.minSeq()
+	rec := &sessionRecord{}
+	for level, tables := range v.levels {
+		for _, t := range tables {
+			if v.rdels.coversTable(db.s.icmp, t, minSeq) {
+				db.logf("table@drop L%d@%d S·%s %q:%q", level, t.fd.Num, shortenb(int(t.size)), t.imin, t.imax)
+				rec.delTable(level, t.fd.Num)
+			}
+		}
+	}
+	if len(rec.deletedTables) > 0 {
+		db.compactionCommit("table-drop", rec)
+	}
+}
+
+// Reports whether the tombstone may be dropped by the compaction: no table outside of it
+// may hold an entry of its range.
+func (c *compaction) rangeDelObsolete(icmp *iComparer, start, limit []byte) bool {
+	t := rangeTombstone{start: start, limit: limit}
+	inputs := make(map[int64]struct{})
+	for _, tables := range c.levels {
+		for _, f := range tables {
+			inputs[f.fd.Num] = struct{}{}
+		}
+	}
+	for _, tables := range c.v.levels {
+		for _, f := range tables {
+			if _, ok := inputs[f.fd.Num]; ok {
+				continue
+			}
+			if t.overlaps(icmp, f.imin.ukey(), f.imax.ukey()) {
+				return false
+			}
+		}
+	}
+	return true
+}
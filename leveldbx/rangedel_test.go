@@ -0,0 +1,359 @@
+package leveldb
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"testing"
+
+	"github.com/syndtr/goleveldb/leveldb/storage"
+	"github.com/syndtr/goleveldb/leveldb/testutil"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+func (h *dbHarness) deleteRange(start, limit string) {
+	if err := h.db.DeleteRange([]byte(start), []byte(limit), h.wo); err != nil {
+		h.t.Error("DeleteRange: got error: ", err)
+	}
+}
+
+func (h *dbHarness) numRangeDels() int {
+	v := h.db.s.version()
+	defer v.release()
+	return len(v.rdels)
+}
+
+func TestDB_DeleteRange(t *testing.T) {
+	trun(t, func(h *dbHarness) {
+		h.put("a", "v1")
+		h.put("b", "v2")
+		h.put("c", "v3")
+		h.put("d", "v4")
+		snap := h.getSnapshot()
+		defer snap.Release()
+		h.deleteRange("b", "d")
+		h.put("c", "v5")
+
+		check := func() {
+			h.getVal("a", "v1")
+			h.get("b", false)
+			h.getVal("c", "v5")
+			h.getVal("d", "v4")
+			h.has("b", false)
+			h.has("c", true)
+			h.getKeyVal("(a->v1)(c->v5)(d->v4)")
+			h.getKeyValReverse("(a->v1)(c->v5)(d->v4)")
+			h.getValr(snap, "b", "v2")
+			h.getValr(snap, "c", "v3")
+		}
+		check()
+		h.reopenDB()
+		h.getVal("a", "v1")
+		h.get("b", false)
+		h.getVal("c", "v5")
+		h.getKeyVal("(a->v1)(c->v5)(d->v4)")
+
+		h.compactMem()
+		h.get("b", false)
+		h.getKeyVal("(a->v1)(c->v5)(d->v4)")
+		h.reopenDB()
+		h.get("b", false)
+		h.getKeyVal("(a->v1)(c->v5)(d->v4)")
+	})
+}
+
+func TestDB_DeleteRangeSnapshot(t *testing.T) {
+	h := newDbHarness(t)
+	defer h.close()
+
+	h.put("a", "v1")
+	h.put("b", "v2")
+	h.compactMem()
+	snap := h.getSnapshot()
+	h.deleteRange("a", "z")
+	h.compactMem()
+
+	// The snapshot pins the entries and the tombstone.
+	h.compactRange("", "")
+	h.get("a", false)
+	h.getValr(snap, "a", "v1")
+	h.getValr(snap, "b", "v2")
+	h.allEntriesFor("a", "[ RANGEDEL, v1 ]")
+	if h.numRangeDels() != 1 {
+		t.Fatalf("want the tombstone kept, got %d", h.numRangeDels())
+	}
+
+	snap.Release()
+	h.compactRange("", "")
+	h.allEntriesFor("a", "[ ]")
+	h.allEntriesFor("b", "[ ]")
+	h.getKeyVal("")
+	if h.numRangeDels() != 0 {
+		t.Fatalf("want the tombstone dropped, got %d", h.numRangeDels())
+	}
+	if n := h.totalTables(); n != 0 {
+		t.Fatalf("want no tables, got %d", n)
+	}
+}
+
+func TestDB_DeleteRangeDropTables(t *testing.T) {
+	h := newDbHarness(t)
+	defer h.close()
+	h.db.memdbMaxLevel = 2
+
+	for b := 0; b < 3; b++ {
+		for i := 0; i < 10; i++ {
+			h.put(fmt.Sprintf("k%d%d", b, i), "v")
+		}
+		h.compactMem()
+	}
+	h.put("z", "v")
+	h.compactMem()
+	h.tablesPerLevel("0,0,4")
+
+	h.stor.ResetCounter(testutil.ModeRead, storage.TypeTable)
+	h.deleteRange("k1", "k3")
+	h.compactMem()
+	h.waitCompaction()
+
+	// The covered tables are gone without a read, the others remain.
+	if n, _ := h.stor.Counter(testutil.ModeRead, storage.TypeTable); n != 0 {
+		t.Errorf("want no table reads, got %d", n)
+	}
+	v := h.db.s.version()
+	for _, f := range v.levels[2] {
+		if ukey := string(f.imin.ukey()); ukey > "k1" && ukey < "k3" {
+			t.Errorf("table @%d %q wasn't dropped", f.fd.Num, ukey)
+		}
+	}
+	v.release()
+	h.tablesPerLevel("0,0,3")
+	h.getKeyVal("(k00->v)(k01->v)(k02->v)(k03->v)(k04->v)(k05->v)(k06->v)(k07->v)(k08->v)(k09->v)(z->v)")
+	h.get("k15", false)
+	h.reopenDB()
+	h.get("k25", false)
+	h.getVal("k05", "v")
+}
+
+func TestDB_DeleteRangeMerge(t *testing.T) {
+	h, _ := newMergeHarness(t)
+	defer h.close()
+
+	h.merge("a", "1")
+	h.merge("a", "2")
+	h.compactMem()
+	h.deleteRange("a", "b")
+	h.merge("a", "5")
+	h.getVal("a", "5")
+	h.getKeyVal("(a->5)")
+
+	h.compactMem()
+	h.compactRange("", "")
+	h.getVal("a", "5")
+	h.allEntriesFor("a", "[ 5 ]")
+}
+
+func TestDB_DeleteRangeExpire(t *testing.T) {
+	h, e := newExpireHarness(t, true)
+	defer h.close()
+	e.setNow(10)
+
+	// The limit of the tombstone looks like an expired value.
+	h.put("2", "x")
+	h.put("7", "y")
+	h.compactMem()
+	h.compactRangeAt(0, "", "")
+	h.deleteRange("1", "5")
+	h.compactMem()
+	if h.numRangeDels() != 1 {
+		t.Fatalf("want the tombstone kept, got %d", h.numRangeDels())
+	}
+	h.get("2", false)
+	h.getKeyVal("(7->y)")
+}
+
+func TestDB_DeleteRangeTransaction(t *testing.T) {
+	h := newDbHarness(t)
+	defer h.close()
+
+	h.put("a", "v1")
+	h.put("b", "v2")
+	tr, err := h.db.OpenTransaction()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tr.DeleteRange([]byte("a"), []byte("b"), nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tr.Get([]byte("a"), nil); err != ErrNotFound {
+		t.Errorf("transaction Get: want %v, got %v", ErrNotFound, err)
+	}
+	h.getVal("a", "v1")
+	if err := tr.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	h.get("a", false)
+	h.getVal("b", "v2")
+	h.reopenDB()
+	h.get("a", false)
+}
+
+func TestKeyspace_DeleteRange(t *testing.T) {
+	h, _ := newKeyspaceHarness(t)
+	defer h.close()
+
+	// The range follows the order of the keyspace.
+	rev := h.keyspace("rev")
+	for _, k := range []string{"a", "b", "c", "d"} {
+		rev.Put([]byte(k), []byte("v"+k), nil)
+	}
+	h.put("b", "v1")
+	if err := rev.DeleteRange([]byte("c"), []byte("a"), nil); err != nil {
+		t.Fatal(err)
+	}
+	if got := keyspaceKeyVal(t, rev, nil); got != "(d->vd)(a->va)" {
+		t.Errorf("keyspace: got %q", got)
+	}
+	h.compactMem()
+	if err := rev.DeleteRange(nil, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if got := keyspaceKeyVal(t, rev, nil); got != "" {
+		t.Errorf("keyspace: want no keys, got %q", got)
+	}
+	h.getVal("b", "v1")
+}
+
+type testReplayRange struct {
+	ranges []string
+}
+
+func (r *testReplayRange) Put(key, value []byte) {}
+func (r *testReplayRange) Delete(key []byte)     {}
+
+type testReplayPlain struct{}
+
+func (testReplayPlain) Put(key, value []byte) {}
+func (testReplayPlain) Delete(key []byte)     {}
+
+func (r *testReplayRange) DeleteRange(start, limit []byte) {
+	r.ranges = append(r.ranges, string(start)+":"+string(limit))
+}
+
+func TestBatch_DeleteRange(t *testing.T) {
+	b := new(Batch)
+	b.Put([]byte("a"), []byte("v"))
+	b.DeleteRange([]byte("b"), []byte("c"))
+	if b.Len() != 2 {
+		t.Fatalf("want 2 records, got %d", b.Len())
+	}
+
+	b2 := new(Batch)
+	if err := b2.Load(b.Dump()); err != nil {
+		t.Fatal(err)
+	}
+	if err := b2.Replay(testReplayPlain{}); err != ErrNoDeleteRange {
+		t.Errorf("Replay: want %v, got %v", ErrNoDeleteRange, err)
+	}
+	r := new(testReplayRange)
+	if err := b2.Replay(r); err != nil {
+		t.Fatal(err)
+	}
+	if len(r.ranges) != 1 || r.ranges[0] != "b:c" {
+		t.Errorf("Replay: unexpected ranges %q", r.ranges)
+	}
+}
+
+func TestSessionRecord_TableRangeDels(t *testing.T) {
+	v := &sessionRecord{}
+	v.addTableFile(1, &tFile{fd: storage.FileDesc{Type: storage.TypeTable, Num: 7}, size: 10, maxSeq: 42,
+		imin:  makeInternalKey(nil, []byte("a"), 1, keyTypeVal),
+		imax:  makeInternalKey(nil, []byte("b"), 42, keyTypeRangeDel),
+		rdels: rangeTombstones{{[]byte("b"), []byte("x"), 42}}})
+
+	b := new(bytes.Buffer)
+	if err := v.encode(b); err != nil {
+		t.Fatal("encode: got error: ", err)
+	}
+	v2 := &sessionRecord{}
+	if err := v2.decode(b); err != nil {
+		t.Fatal("decode: got error: ", err)
+	}
+	f := tableFileFromRecord(v2.addedTables[0])
+	if f.maxSeq != 42 || len(f.rdels) != 1 {
+		t.Fatalf("decode: want max seq 42 and a tombstone, got %d %v", f.maxSeq, f.rdels)
+	}
+	if r := f.rdels[0]; string(r.start) != "b" || string(r.limit) != "x" || r.seq != 42 {
+		t.Errorf("decode: unexpected tombstone %q:%q@%d", r.start, r.limit, r.seq)
+	}
+}
+
+func randomRangeDels(rnd *rand.Rand, n, keys, width int) rangeTombstones {
+	ts := make(rangeTombstones, n)
+	for i := range ts {
+		a := rnd.Intn(keys)
+		b := a + rnd.Intn(width)
+		ts[i] = rangeTombstone{[]byte(fmt.Sprintf("%06d", a)), []byte(fmt.Sprintf("%06d", b)), uint64(rnd.Intn(2*n) + 1)}
+	}
+	return ts
+}
+
+func TestRangeFragments_Cover(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	for round := 0; round < 20; round++ {
+		ts := randomRangeDels(rnd, 1+rnd.Intn(50), 100, 50)
+		fs := ts.fragment(defaultIComparer)
+		for k := -1; k <= 100; k++ {
+			ukey := []byte(fmt.Sprintf("%06d", k))
+			for _, seq := range []uint64{0, 1, 10, 50, keyMaxSeq} {
+				var want uint64
+				for i := range ts {
+					if t := &ts[i]; t.seq <= seq && t.seq > want && t.covers(defaultIComparer, ukey) {
+						want = t.seq
+					}
+				}
+				if got := fs.cover(defaultIComparer, ukey, seq); got != want {
+					t.Fatalf("cover(%s, %d): want=%d got=%d", ukey, seq, want, got)
+				}
+			}
+		}
+	}
+}
+
+func BenchmarkRangeDelCover(b *testing.B) {
+	rnd := rand.New(rand.NewSource(1))
+	fs := randomRangeDels(rnd, 10000, 1000000, 1000).fragment(defaultIComparer)
+	keys := make([][]byte, 1024)
+	for i := range keys {
+		keys[i] = []byte(fmt.Sprintf("%06d", rnd.Intn(1000000)))
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		fs.cover(defaultIComparer, keys[i%len(keys)], keyMaxSeq)
+	}
+}
+
+func BenchmarkDBGetRangeDels(b *testing.B) {
+	p := openDBBench(b, false)
+	defer p.close()
+	for i := 0; i < 1000; i++ {
+		start := []byte(fmt.Sprintf("r%06d", 2*i))
+		limit := []byte(fmt.Sprintf("r%06d", 2*i+1))
+		if err := p.db.DeleteRange(start, limit, nil); err != nil {
+			b.Fatal("DeleteRange: got error: ", err)
+		}
+		if i == 500 {
+			p.db.CompactRange(util.Range{})
+		}
+	}
+	key := []byte("k")
+	if err := p.db.Put(key, key, nil); err != nil {
+		b.Fatal("Put: got error: ", err)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := p.db.Get(key, nil); err != nil {
+			b.Fatal("Get: got error: ", err)
+		}
+	}
+}
@@ -0,0 +1,170 @@
+/*
+This is free and unencumbered software released into the public domain.
+
+Anyone is free to copy, modify, publish, use, compile, sell, or
+distribute this software, either in source code form or as a compiled
+binary, for any purpose, commercial or non-commercial, and by any
+means.
+
+In jurisdictions that recognize copyright laws, the author or authors
+of this software dedicate any and all copyright interest in the
+software to the public domain. We make this dedication for the benefit
+of the public at large and to the detriment of our heirs and
+successors. We intend this dedication to be an overt act of
+relinquishment in perpetuity of all present and future rights to this
+software under copyright law.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+IN NO EVENT SHALL THE AUTHORS BE LIABLE FOR ANY CLAIM, DAMAGES OR
+OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE,
+ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR
+OTHER DEALINGS IN THE SOFTWARE.
+
+For more information, please refer to <http://unlicense.org/>
+*/
+
+package leveldb
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/syndtr/goleveldb/leveldb/storage"
+)
+
+// The longest sleep of a waiting writer, before it looks at the rate again.
+const rateLimitSlice = 100 * time.Millisecond
+
+/*
+RateLimiter is a token bucket limiting the bytes per second written by flushes and
+compactions, see Config.RateLimiter. The bucket holds one second worth of bytes. A
+RateLimiter may be shared by several databases, and by other writers through Wait.
+*/
+type RateLimiter struct {
+	mu     sync.Mutex
+	rate   int64 // Bytes per second, or 0 if unlimited
+	tokens float64
+	last   time.Time
+}
+
+/*
+NewRateLimiter returns a RateLimiter passing the given bytes per second; 0 or less is
+unlimited.
+*/
+func NewRateLimiter(bytesPerSec int64) *RateLimiter {
+	r := new(RateLimiter)
+	r.SetRate(bytesPerSec)
+	return r
+}
+
+/*
+SetRate changes the bytes per second; 0 or less is unlimited. Waiting writers adopt the
+new rate within a fraction of a second. A formerly unlimited bucket starts full.
+*/
+func (r *RateLimiter) SetRate(bytesPerSec int64) {
+	if bytesPerSec < 0 {
+		bytesPerSec = 0
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.refill(time.Now())
+	if r.rate == 0 {
+		r.tokens = float64(bytesPerSec)
+	}
+	r.rate = bytesPerSec
+	if r.tokens > float64(r.rate) {
+		r.tokens = float64(r.rate)
+	}
+}
+
+// Rate returns the bytes per second, or 0 if unlimited.
+func (r *RateLimiter) Rate() int64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.rate
+}
+
+func (r *RateLimiter) refill(now time.Time) {
+	if d := now.Sub(r.last); d > 0 {
+		r.tokens += d.Seconds() * float64(r.rate)
+		r.last = now
+	}
+	if r.tokens > float64(r.rate) {
+		r.tokens = float64(r.rate)
+	}
+}
+
+/*
+Takes n bytes from the bucket, or returns how long to wait before trying again. Requests
+larger than the bucket pass once it is full, and leave it in debt.
+*/
+func (r *RateLimiter) take(n int, now time.Time) time.Duration {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.rate <= 0 {
+		return 0
+	}
+	r.refill(now)
+	need := float64(n)
+	if need > float64(r.rate) {
+		need = float64(r.rate)
+	}
+	if r.tokens >= need {
+		r.tokens -= float64(n)
+		return 0
+	}
+	d := time.Duration((need - r.tokens) / float64(r.rate) * float64(time.Second))
+	if d < time.Millisecond {
+		d = time.Millisecond
+	}
+	return d
+}
+
+// Blocks until n bytes may pass; returns false if done was closed first.
+func (r *RateLimiter) wait(n int, done <-chan struct{}) bool {
+	for {
+		d := r.take(n, time.Now())
+		if d == 0 {
+			return true
+		}
+		if d > rateLimitSlice {
+			d = rateLimitSlice
+		}
+		t := time.NewTimer(d)
+		select {
+		case <-t.C:
+		case <-done:
+			t.Stop()
+			return false
+		}
+	}
+}
+
+/*
+Wait blocks until n bytes may pass, or the context is done.
+*/
+func (r *RateLimiter) Wait(ctx context.Context, n int) error {
+	if !r.wait(n, ctx.Done()) {
+		return ctx.Err()
+	}
+	return nil
+}
+
+/*
+limitedWriter charges the bytes written to a table against the RateLimiter.
+*/
+type limitedWriter struct {
+	storage.Writer
+	r    *RateLimiter
+	done <-chan struct{}
+}
+
+func (w limitedWriter) Write(p []byte) (int, error) {
+	if !w.r.wait(len(p), w.done) {
+		return 0, ErrClosed
+	}
+	return w.Writer.Write(p)
+}
@@ -0,0 +1,123 @@
+package leveldb
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/syndtr/goleveldb/leveldb/opt"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+func TestRateLimiter_Take(t *testing.T) {
+	r := NewRateLimiter(1000)
+	now := time.Now()
+	if d := r.take(1000, now); d != 0 {
+		t.Fatalf("full bucket: want no wait, got %v", d)
+	}
+	if d := r.take(500, now); d != 500*time.Millisecond {
+		t.Fatalf("empty bucket: want 500ms, got %v", d)
+	}
+	if d := r.take(500, now.Add(500*time.Millisecond)); d != 0 {
+		t.Fatalf("refilled bucket: want no wait, got %v", d)
+	}
+
+	// Requests above the burst pass on a full bucket, and leave it in debt.
+	now = now.Add(2 * time.Second)
+	if d := r.take(3000, now); d != 0 {
+		t.Fatalf("oversize request: want no wait, got %v", d)
+	}
+	if d := r.take(1, now.Add(time.Second)); d < time.Second || d > time.Second+time.Millisecond {
+		t.Fatalf("debt: want 1.001s, got %v", d)
+	}
+
+	r.SetRate(0)
+	if d := r.take(1<<30, now); d != 0 {
+		t.Fatalf("unlimited: want no wait, got %v", d)
+	}
+	if r.Rate() != 0 {
+		t.Fatalf("want rate 0, got %d", r.Rate())
+	}
+}
+
+func TestRateLimiter_Wait(t *testing.T) {
+	r := NewRateLimiter(1 << 20)
+	if err := r.Wait(context.Background(), 1<<20); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := r.Wait(ctx, 1<<20); err != context.DeadlineExceeded {
+		t.Fatalf("want %v, got %v", context.DeadlineExceeded, err)
+	}
+
+	// A raised rate releases the waiting writers.
+	r.SetRate(1)
+	errc := make(chan error)
+	go func() { errc <- r.Wait(context.Background(), 1) }()
+	time.Sleep(20 * time.Millisecond)
+	r.SetRate(0)
+	select {
+	case err := <-errc:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Wait did not return after SetRate")
+	}
+}
+
+func TestDB_RateLimitedFlush(t *testing.T) {
+	limiter := NewRateLimiter(64 << 10)
+	h := new(dbHarness)
+	h.conf = &Config{RateLimiter: limiter}
+	h.init(t, &opt.Options{DisableLargeBatchTransaction: true, Compression: opt.NoCompression})
+	defer h.close()
+
+	value := strings.Repeat("v", 1000)
+	flush := func() time.Duration {
+		for i := 0; i < 200; i++ {
+			h.put(fmt.Sprintf("k%04d", i), value)
+		}
+		start := time.Now()
+		h.compactMem()
+		return time.Since(start)
+	}
+
+	// About 200kB pass a 64kB/s bucket after its initial burst.
+	if d := flush(); d < time.Second {
+		t.Errorf("limited flush: want at least 1s, took %v", d)
+	}
+	limiter.SetRate(0)
+	if d := flush(); d > time.Second {
+		t.Errorf("unlimited flush: want less than 1s, took %v", d)
+	}
+	h.getVal("k0100", value)
+}
+
+func TestDB_RateLimitedClose(t *testing.T) {
+	h := new(dbHarness)
+	h.conf = &Config{RateLimiter: NewRateLimiter(1)}
+	h.init(t, &opt.Options{DisableLargeBatchTransaction: true, Compression: opt.NoCompression})
+	defer h.close()
+
+	for i := 0; i < 100; i++ {
+		h.put(fmt.Sprintf("k%04d", i), strings.Repeat("v", 1000))
+	}
+	go h.db.CompactRange(util.Range{})
+	time.Sleep(50 * time.Millisecond)
+
+	done := make(chan struct{})
+	go func() {
+		h.closeDB0()
+		close(done)
+	}()
+	select {
+	case <-done:
+		h.db = nil
+	case <-time.After(5 * time.Second):
+		t.Fatal("Close hangs on a rate limited flush")
+	}
+}
@@ -11,7 +11,9 @@ import (
 	"io"
 	"os"
 	"sync"
+	"time"
 
+	"github.com/maxymania/storage-engines/leveldbx/table"
 	"github.com/syndtr/goleveldb/leveldb/errors"
 	"github.com/syndtr/goleveldb/leveldb/journal"
 	"github.com/syndtr/goleveldb/leveldb/opt"
@@ -56,12 +58,30 @@ type session struct {
 	stCompPtrs []internalKey // compaction pointers; need external synchronization
 	stVersion  *version      // current version
 	vmu        sync.Mutex
-	
-	aexp AutoExpire          // Auto-Expiration filter
+
+	aexp       AutoExpire                     // Auto-Expiration filter
+	aexpRead   bool                           // Apply the Auto-Expiration filter on reads
+	aexpReadFn func(ro *opt.ReadOptions) bool // Decides per read, whether the filter applies, or nil
+	clock      func() time.Time               // Clock for expiration, or nil
+
+	sweepInterval time.Duration // Interval of the expiry sweep, or 0
+	sweepRatio    float64       // Estimated expired fraction, at which a table is swept
+
+	merge          MergeOperator              // Resolves merge operands, or nil
+	keyspaces      map[string]KeyspaceOptions // Options of the named keyspaces, or nil
+	retainJournals bool                       // Hold obsolete journals for the subscribers
+	prefix         PrefixExtractor            // Adds prefixes to the filters, or nil
+	prefixSeek     bool                       // Whether prefix ranges skip tables
+	codecs         []table.Codec              // Block codec of each level, or nil
+	limiter        *RateLimiter               // Limits the table writes, or nil
+	events         *EventListener             // Receives the events, or nil
+	enc            *encStorage                // Encrypts the files, or nil
+	hook           WriteHook                  // Called on every write, or nil
+	budget         *MemoryBudget              // Shared memory budget, or nil
 }
 
 // Creates new initialized session instance.
-func newSession(stor storage.Storage, o *opt.Options, aexp AutoExpire) (s *session, err error) {
+func newSession(stor storage.Storage, o *opt.Options, c *Config) (s *session, err error) {
 	if stor == nil {
 		return nil, os.ErrInvalid
 	}
@@ -73,12 +93,12 @@ func newSession(stor storage.Storage, o *opt.Options, aexp AutoExpire) (s *sessi
 		stor:     newIStorage(stor),
 		storLock: storLock,
 		fileRef:  make(map[int64]int),
-		aexp: getAutoExpire(aexp),
 	}
+	s.setConfig(c)
 	s.setOptions(o)
 	s.tops = newTableOps(s)
 	s.setVersion(newVersion(s))
-	s.log("log@legend F·NumFile S·FileSize N·Entry C·BadEntry B·BadBlock Ke·KeyError D·DroppedEntry L·Level Q·SeqNum T·TimeElapsed")
+	s.log("log@legend F·NumFile S·FileSize N·Entry C·BadEntry B·BadBlock Ke·KeyError D·DroppedEntry E·ExpiredEntry L·Level Q·SeqNum T·TimeElapsed")
 	return
 }
 
@@ -21,28 +21,38 @@ func (s *session) pickMemdbLevel(umin, umax []byte, maxLevel int) int {
 }
 
 func (s *session) flushMemdb(rec *sessionRecord, mdb *memdb.DB, maxLevel int) (int, error) {
+	// Pick level other than zero can cause compaction issue with large
+	// bulk insert and delete on strictly incrementing key-space. The
+	// problem is that the small deletion markers trapped at lower level,
+	// while key/value entries keep growing at higher level. Since the
+	// key-space is strictly incrementing it will not overlaps with
+	// higher level, thus maximum possible level is always picked, while
+	// overlapping deletion marker pushed into lower level.
+	// See: https://github.com/syndtr/goleveldb/issues/127.
+	// The level is picked ahead, since it decides the codec of the table.
+	var umin, umax []byte
+	biter := mdb.NewIterator(nil)
+	if biter.First() {
+		umin = append(umin, internalKey(biter.Key()).ukey()...)
+		biter.Last()
+		umax = append(umax, internalKey(biter.Key()).ukey()...)
+	}
+	biter.Release()
+	flushLevel := s.pickMemdbLevel(umin, umax, maxLevel)
+
 	// Create sorted table.
 	iter := mdb.NewIterator(nil)
 	defer iter.Release()
-	
+
 	// We wanna drop expired items!
 	//niter := &expireIterator{iter,s.aexp}
 	// This feature is commented out, because it causes LevelDB to panic.
-	
-	t, n, err := s.tops.createFrom(iter)
+
+	t, n, err := s.tops.createFrom(iter, flushLevel)
 	if err != nil {
 		return 0, err
 	}
 
-	// Pick level other than zero can cause compaction issue with large
-	// bulk insert and delete on strictly incrementing key-space. The
-	// problem is that the small deletion markers trapped at lower level,
-	// while key/value entries keep growing at higher level. Since the
-	// key-space is strictly incrementing it will not overlaps with
-	// higher level, thus maximum possible level is always picked, while
-	// overlapping deletion marker pushed into lower level.
-	// See: https://github.com/syndtr/goleveldb/issues/127.
-	flushLevel := s.pickMemdbLevel(t.imin.ukey(), t.imax.ukey(), maxLevel)
 	rec.addTableFile(flushLevel, t)
 
 	s.logf("memdb@flush created L%d@%d N·%d S·%s %q:%q", flushLevel, t.fd.Num, n, shortenb(int(t.size)), t.imin, t.imax)
@@ -122,6 +132,7 @@ func newCompaction(s *session, v *version, sourceLevel int, t0 tFiles) *compacti
 		s:             s,
 		v:             v,
 		sourceLevel:   sourceLevel,
+		targetLevel:   sourceLevel + 1,
 		levels:        [2]tFiles{t0, nil},
 		maxGPOverlaps: int64(s.o.GetCompactionGPOverlaps(sourceLevel)),
 		tPtrs:         make([]int, len(v.levels)),
@@ -137,6 +148,7 @@ type compaction struct {
 	v *version
 
 	sourceLevel   int
+	targetLevel   int
 	levels        [2]tFiles
 	maxGPOverlaps int64
 
@@ -223,13 +235,15 @@ func (c *compaction) expand() {
 	c.imin, c.imax = imin, imax
 }
 
-// Check whether compaction is trivial.
+// Check whether compaction is trivial. A table holding range tombstones is
+// rewritten instead, to apply them.
 func (c *compaction) trivial() bool {
-	return len(c.levels[0]) == 1 && len(c.levels[1]) == 0 && c.gp.size() <= c.maxGPOverlaps
+	return len(c.levels[0]) == 1 && len(c.levels[1]) == 0 && c.gp.size() <= c.maxGPOverlaps &&
+		len(c.levels[0][0].rdels) == 0
 }
 
 func (c *compaction) baseLevelForKey(ukey []byte) bool {
-	for level := c.sourceLevel + 2; level < len(c.v.levels); level++ {
+	for level := c.targetLevel + 1; level < len(c.v.levels); level++ {
 		tables := c.v.levels[level]
 		for c.tPtrs[level] < len(tables) {
 			t := tables[c.tPtrs[level]]
@@ -295,10 +309,10 @@ func (c *compaction) newIterator() iterator.Iterator {
 		// Level-0 is not sorted and may overlaps each other.
 		if c.sourceLevel+i == 0 {
 			for _, t := range tables {
-				its = append(its, c.s.tops.newIterator(t, nil, ro))
+				its = append(its, c.s.tops.newIterator(t, nil, ro, nil))
 			}
 		} else {
-			it := iterator.NewIndexedIterator(tables.newIndexIterator(c.s.tops, c.s.icmp, nil, ro), strict)
+			it := iterator.NewIndexedIterator(tables.newIndexIterator(c.s.tops, c.s.icmp, nil, ro, nil), strict)
 			its = append(its, it)
 		}
 	}
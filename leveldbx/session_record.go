@@ -32,6 +32,21 @@ const (
 	recAddTable    = 7
 	// 8 was used for large value refs
 	recPrevJournalNum = 9
+
+	// Extension: expiry profile of an added table, follows its recAddTable.
+	recTableExpiry = 100
+	// Extension: sequence number of an added ingested table, follows its
+	// recAddTable.
+	recTableSeq = 101
+	// Extension: size of an added table without compression, follows its
+	// recAddTable.
+	recTableRawSize = 102
+	// Extension: largest sequence number of the entries of an added table,
+	// follows its recAddTable.
+	recTableMaxSeq = 103
+	// Extension: a range tombstone of an added table, follows its
+	// recAddTable.
+	recTableRangeDel = 104
 )
 
 type cpRecord struct {
@@ -40,11 +55,16 @@ type cpRecord struct {
 }
 
 type atRecord struct {
-	level int
-	num   int64
-	size  int64
-	imin  internalKey
-	imax  internalKey
+	level  int
+	num    int64
+	size   int64
+	imin   internalKey
+	imax   internalKey
+	exp    tExpiry
+	seq    uint64
+	raw    int64
+	maxSeq uint64
+	rdels  rangeTombstones
 }
 
 type dtRecord struct {
@@ -108,11 +128,61 @@ func (p *sessionRecord) resetCompPtrs() {
 
 func (p *sessionRecord) addTable(level int, num, size int64, imin, imax internalKey) {
 	p.hasRec |= 1 << recAddTable
-	p.addedTables = append(p.addedTables, atRecord{level, num, size, imin, imax})
+	p.addedTables = append(p.addedTables, atRecord{level: level, num: num, size: size, imin: imin, imax: imax})
 }
 
 func (p *sessionRecord) addTableFile(level int, t *tFile) {
 	p.addTable(level, t.fd.Num, t.size, t.imin, t.imax)
+	p.addedTables[len(p.addedTables)-1].exp = t.exp
+	p.addedTables[len(p.addedTables)-1].seq = t.seq
+	p.addedTables[len(p.addedTables)-1].raw = t.raw
+	p.addedTables[len(p.addedTables)-1].maxSeq = t.maxSeq
+	p.addedTables[len(p.addedTables)-1].rdels = t.rdels
+}
+
+func (p *sessionRecord) setTableExpiry(num int64, exp tExpiry) {
+	for i := len(p.addedTables) - 1; i >= 0; i-- {
+		if p.addedTables[i].num == num {
+			p.addedTables[i].exp = exp
+			return
+		}
+	}
+}
+
+func (p *sessionRecord) setTableSeq(num int64, seq uint64) {
+	for i := len(p.addedTables) - 1; i >= 0; i-- {
+		if p.addedTables[i].num == num {
+			p.addedTables[i].seq = seq
+			return
+		}
+	}
+}
+
+func (p *sessionRecord) setTableRawSize(num int64, raw int64) {
+	for i := len(p.addedTables) - 1; i >= 0; i-- {
+		if p.addedTables[i].num == num {
+			p.addedTables[i].raw = raw
+			return
+		}
+	}
+}
+
+func (p *sessionRecord) setTableMaxSeq(num int64, maxSeq uint64) {
+	for i := len(p.addedTables) - 1; i >= 0; i-- {
+		if p.addedTables[i].num == num {
+			p.addedTables[i].maxSeq = maxSeq
+			return
+		}
+	}
+}
+
+func (p *sessionRecord) addTableRangeDel(num int64, t rangeTombstone) {
+	for i := len(p.addedTables) - 1; i >= 0; i-- {
+		if p.addedTables[i].num == num {
+			p.addedTables[i].rdels = append(p.addedTables[i].rdels, t)
+			return
+		}
+	}
 }
 
 func (p *sessionRecord) resetAddedTables() {
@@ -191,6 +261,36 @@ func (p *sessionRecord) encode(w io.Writer) error {
 		p.putVarint(w, r.size)
 		p.putBytes(w, r.imin)
 		p.putBytes(w, r.imax)
+		if r.exp.n > 0 {
+			p.putUvarint(w, recTableExpiry)
+			p.putVarint(w, r.num)
+			p.putVarint(w, r.exp.n)
+			p.putVarint(w, r.exp.total)
+			p.putVarint(w, r.exp.min)
+			p.putVarint(w, r.exp.max)
+		}
+		if r.seq != 0 {
+			p.putUvarint(w, recTableSeq)
+			p.putVarint(w, r.num)
+			p.putUvarint(w, r.seq)
+		}
+		if r.raw != 0 {
+			p.putUvarint(w, recTableRawSize)
+			p.putVarint(w, r.num)
+			p.putVarint(w, r.raw)
+		}
+		if r.maxSeq != 0 {
+			p.putUvarint(w, recTableMaxSeq)
+			p.putVarint(w, r.num)
+			p.putUvarint(w, r.maxSeq)
+		}
+		for _, t := range r.rdels {
+			p.putUvarint(w, recTableRangeDel)
+			p.putVarint(w, r.num)
+			p.putUvarint(w, t.seq)
+			p.putBytes(w, t.start)
+			p.putBytes(w, t.limit)
+		}
 	}
 	return p.err
 }
@@ -310,6 +410,42 @@ func (p *sessionRecord) decode(r io.Reader) error {
 			if p.err == nil {
 				p.addTable(level, num, size, imin, imax)
 			}
+		case recTableExpiry:
+			var exp tExpiry
+			num := p.readVarint("table-expiry.num", br)
+			exp.n = p.readVarint("table-expiry.n", br)
+			exp.total = p.readVarint("table-expiry.total", br)
+			exp.min = p.readVarint("table-expiry.min", br)
+			exp.max = p.readVarint("table-expiry.max", br)
+			if p.err == nil {
+				p.setTableExpiry(num, exp)
+			}
+		case recTableSeq:
+			num := p.readVarint("table-seq.num", br)
+			seq := p.readUvarint("table-seq.seq", br)
+			if p.err == nil {
+				p.setTableSeq(num, seq)
+			}
+		case recTableRawSize:
+			num := p.readVarint("table-raw-size.num", br)
+			raw := p.readVarint("table-raw-size.raw", br)
+			if p.err == nil {
+				p.setTableRawSize(num, raw)
+			}
+		case recTableMaxSeq:
+			num := p.readVarint("table-max-seq.num", br)
+			maxSeq := p.readUvarint("table-max-seq.seq", br)
+			if p.err == nil {
+				p.setTableMaxSeq(num, maxSeq)
+			}
+		case recTableRangeDel:
+			num := p.readVarint("table-range-del.num", br)
+			seq := p.readUvarint("table-range-del.seq", br)
+			start := p.readBytes("table-range-del.start", br)
+			limit := p.readBytes("table-range-del.limit", br)
+			if p.err == nil {
+				p.addTableRangeDel(num, rangeTombstone{start, limit, seq})
+			}
 		case recDelTable:
 			level := p.readLevel("del-table.level", br)
 			num := p.readVarint("del-table.num", br)
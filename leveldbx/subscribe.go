@@ -0,0 +1,589 @@
+/*
+This is free and unencumbered software released into the public domain.
+
+Anyone is free to copy, modify, publish, use, compile, sell, or
+distribute this software, either in source code form or as a compiled
+binary, for any purpose, commercial or non-commercial, and by any
+means.
+
+In jurisdictions that recognize copyright laws, the author or authors
+of this software dedicate any and all copyright interest in the
+software to the public domain. We make this dedication for the benefit
+of the public at large and to the detriment of our heirs and
+successors. We intend this dedication to be an overt act of
+relinquishment in perpetuity of all present and future rights to this
+software under copyright law.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+IN NO EVENT SHALL THE AUTHORS BE LIABLE FOR ANY CLAIM, DAMAGES OR
+OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE,
+ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR
+OTHER DEALINGS IN THE SOFTWARE.
+
+For more information, please refer to <http://unlicense.org/>
+*/
+
+package leveldb
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/syndtr/goleveldb/leveldb/journal"
+	"github.com/syndtr/goleveldb/leveldb/storage"
+)
+
+/*
+Change is a batch of writes streamed by a Subscription. Its records were committed with the
+sequence numbers Seq to Seq+Batch.Len()-1. The Batch is shared between the subscribers and
+must not be modified.
+*/
+type Change struct {
+	Seq   uint64
+	Batch *Batch
+}
+
+// The number of changes buffered for a subscriber; a subscriber falling further behind
+// reads the journal instead.
+const subscribeQueueLen = 1024
+
+type subscriptions struct {
+	mu       sync.Mutex
+	list     map[*Subscription]struct{}
+	seq      uint64            // The last published sequence number
+	first    uint64            // The first sequence number held by the journals
+	retained []retainedJournal // Obsolete journals, held for the subscribers
+	hold     bool              // Holds the journals of the previous session, see DB.ReleaseJournals
+	jlast    map[int64]uint64  // The last sequence number written to the journals not yet obsolete
+}
+
+type retainedJournal struct {
+	fd  storage.FileDesc
+	seq uint64 // The last sequence number written to the journal, or 0 if none
+}
+
+func (p *subscriptions) init(seq uint64) {
+	p.seq = seq
+	if len(p.retained) == 0 {
+		p.first = seq + 1
+	} else {
+		p.hold = true
+	}
+}
+
+// Records a batch written to the journal.
+func (p *subscriptions) journaled(num int64, seq uint64, n int) {
+	if n == 0 {
+		return
+	}
+	p.mu.Lock()
+	if p.jlast == nil {
+		p.jlast = make(map[int64]uint64)
+	}
+	p.jlast[num] = seq + uint64(n) - 1
+	p.mu.Unlock()
+}
+
+// Reports whether there are subscribers.
+func (p *subscriptions) active() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.list) > 0
+}
+
+// Reports whether the journal is held for the subscribers.
+func (p *subscriptions) holds(fd storage.FileDesc) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, j := range p.retained {
+		if j.fd == fd {
+			return true
+		}
+	}
+	return false
+}
+
+// Reports whether a subscriber has not acknowledged the given sequence number.
+func (p *subscriptions) needs(seq uint64) bool {
+	for sub := range p.list {
+		if sub.acked < seq {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+Subscription streams the batches committed to a DB, see DB.Subscribe.
+*/
+type Subscription struct {
+	db     *DB
+	next   uint64 // The next sequence number to deliver
+	acked  uint64 // The last acknowledged sequence number
+	live   bool   // Whether the writer feeds the queue
+	closed bool
+	queue  []Change
+	notify chan struct{}
+
+	// The journal cursor.
+	jmu     sync.Mutex
+	jclosed bool
+	jfd     storage.FileDesc // The journal read, or the next one
+	jrd     *journal.Reader
+}
+
+/*
+Subscribe streams the batches committed to the DB, starting with the batch holding the
+sequence number fromSeq, or with the next write if fromSeq is 0. Batches written
+concurrently, or caught up from the memdb, may be merged into one Change.
+
+The subscription catches up by reading the journal files, so it can resume from any
+sequence number still held by them; ErrNotRetained is returned otherwise. Obsolete journal
+files are removed after a memdb compaction, unless Config.RetainJournals holds them until
+all subscribers have acknowledged their batches. The journals retained when the DB is
+closed are held by the next open, until the first subscriber or DB.ReleaseJournals.
+
+Transactions and ingested tables write their records into tables directly. While there
+are subscribers, or Config.RetainJournals is set, their records are journaled as well, so
+they are streamed like any other batch. The records of an ingestion take one sequence
+number each in the stream, while the ingested tables apply the last one to all of them.
+Subscribe waits for an open transaction.
+
+The subscription must be closed after use.
+*/
+func (db *DB) Subscribe(fromSeq uint64) (*Subscription, error) {
+	if err := db.ok(); err != nil {
+		return nil, err
+	}
+
+	// Lock writer, so no transaction is open, that doesn't journal its batch.
+	select {
+	case db.writeLockC <- struct{}{}:
+	case err := <-db.compPerErrC:
+		return nil, err
+	case <-db.closeC:
+		return nil, ErrClosed
+	}
+	defer func() { <-db.writeLockC }()
+
+	sub := &Subscription{db: db, notify: make(chan struct{}, 1)}
+	p := &db.subs
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if fromSeq == 0 {
+		fromSeq = p.seq + 1
+	}
+	if fromSeq < p.first {
+		return nil, ErrNotRetained
+	}
+	sub.next = fromSeq
+	sub.acked = fromSeq - 1
+	sub.live = fromSeq > p.seq
+	if p.list == nil {
+		p.list = make(map[*Subscription]struct{})
+	}
+	p.list[sub] = struct{}{}
+	p.hold = false
+	return sub, nil
+}
+
+func (sub *Subscription) wake() {
+	select {
+	case sub.notify <- struct{}{}:
+	default:
+	}
+}
+
+/*
+Next returns the next Change, blocking until it is committed, the context is done, or the
+DB or the subscription is closed. Next must not be called concurrently.
+*/
+func (sub *Subscription) Next(ctx context.Context) (*Change, error) {
+	db := sub.db
+	p := &db.subs
+	var eof bool
+	for {
+		p.mu.Lock()
+		if sub.closed {
+			p.mu.Unlock()
+			return nil, ErrClosed
+		}
+		for len(sub.queue) > 0 {
+			c := sub.queue[0]
+			sub.queue[0] = Change{}
+			sub.queue = sub.queue[1:]
+			if end := c.Seq + uint64(c.Batch.Len()); end > sub.next {
+				sub.next = end
+				p.mu.Unlock()
+				return &c, nil
+			}
+		}
+		if sub.live || sub.next > p.seq {
+			sub.live = true
+			p.mu.Unlock()
+			select {
+			case <-sub.notify:
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			case <-db.closeC:
+				return nil, ErrClosed
+			}
+			continue
+		}
+		missing := sub.next < p.first
+		p.mu.Unlock()
+
+		// Behind the writer: catch up from the journals.
+		if missing {
+			return nil, ErrNotRetained
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		c, err := sub.readJournal()
+		if err != nil {
+			return nil, err
+		}
+		if c != nil {
+			return c, nil
+		}
+
+		// The end of the journals was reached twice, without getting the published batches.
+		if eof {
+			return nil, ErrNotRetained
+		}
+		eof = true
+	}
+}
+
+// Returns the next batch from the journals, or nil at their end. The journals are read
+// as a whole, the batches of the current journal are taken from the memdb.
+func (sub *Subscription) readJournal() (*Change, error) {
+	sub.jmu.Lock()
+	defer sub.jmu.Unlock()
+	if sub.jclosed {
+		return nil, ErrClosed
+	}
+	db := sub.db
+	for {
+		if sub.jrd == nil {
+			db.memMu.RLock()
+			live := db.journalFd
+			db.memMu.RUnlock()
+			fd, ok, err := sub.nextJournal(live)
+			if err != nil {
+				return nil, err
+			}
+			if !ok {
+				c, rotated, err := sub.readMem(live)
+				if rotated {
+					continue
+				}
+				return c, err
+			}
+			r, err := db.s.stor.Open(fd)
+			if os.IsNotExist(err) {
+				sub.jfd.Num = fd.Num + 1
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			data, err := ioutil.ReadAll(r)
+			r.Close()
+			if err != nil {
+				return nil, err
+			}
+			sub.jfd = fd
+			sub.jrd = journal.NewReader(bytes.NewReader(data), nil, false, true)
+		}
+		r, err := sub.jrd.Next()
+		if err == io.EOF {
+			sub.jrd = nil
+			sub.jfd.Num++
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		data, err := ioutil.ReadAll(r)
+		if err != nil {
+			return nil, err
+		}
+		seq, n, err := decodeBatchHeader(data)
+		if err != nil {
+			return nil, err
+		}
+		if n == 0 || seq+uint64(n) <= sub.next {
+			continue
+		}
+		b := new(Batch)
+		if err := b.decode(data[batchHeaderLen:], n); err != nil {
+			return nil, err
+		}
+		sub.next = seq + uint64(n)
+		return &Change{Seq: seq, Batch: b}, nil
+	}
+}
+
+// Returns the first journal preceding the current one, that is not read yet.
+func (sub *Subscription) nextJournal(live storage.FileDesc) (fd storage.FileDesc, ok bool, err error) {
+	fds, err := sub.db.s.stor.List(storage.TypeJournal)
+	if err != nil {
+		return
+	}
+	for _, x := range fds {
+		if x.Num < sub.jfd.Num || x.Num >= live.Num {
+			continue
+		}
+		if !ok || x.Num < fd.Num {
+			fd, ok = x, true
+		}
+	}
+	return
+}
+
+// Returns the published batches of the current journal from the memdb, split where the
+// sequence numbers have gaps; rotated is set if the journal is no longer current.
+func (sub *Subscription) readMem(live storage.FileDesc) (c *Change, rotated bool, err error) {
+	db := sub.db
+	p := &db.subs
+	p.mu.Lock()
+	last := p.seq
+	p.mu.Unlock()
+	if sub.next > last {
+		return
+	}
+	db.memMu.RLock()
+	if db.journalFd != live || db.mem == nil {
+		db.memMu.RUnlock()
+		return nil, true, nil
+	}
+	mem := db.mem
+	mem.incref()
+	db.memMu.RUnlock()
+	defer mem.decref()
+	sub.jfd = live
+
+	type rec struct {
+		seq  uint64
+		kt   keyType
+		k, v []byte
+	}
+	var recs []rec
+	iter := mem.NewIterator(nil)
+	for iter.Next() {
+		ukey, seq, kt, err := parseInternalKey(iter.Key())
+		if err != nil {
+			iter.Release()
+			return nil, false, err
+		}
+		if seq < sub.next || seq > last {
+			continue
+		}
+		recs = append(recs, rec{seq, kt, append([]byte(nil), ukey...), append([]byte(nil), iter.Value()...)})
+	}
+	iter.Release()
+	if len(recs) == 0 {
+		return
+	}
+	sort.Slice(recs, func(i, j int) bool { return recs[i].seq < recs[j].seq })
+
+	c = &Change{Seq: recs[0].seq, Batch: new(Batch)}
+	for _, r := range recs {
+		if r.seq != c.Seq+uint64(c.Batch.Len()) {
+			break
+		}
+		c.Batch.appendRec(r.kt, r.k, r.v)
+	}
+	sub.next = c.Seq + uint64(c.Batch.Len())
+	return
+}
+
+/*
+Ack acknowledges all batches up to the sequence number seq, allowing the journals holding
+them to be removed.
+*/
+func (sub *Subscription) Ack(seq uint64) {
+	p := &sub.db.subs
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if sub.closed || seq <= sub.acked {
+		return
+	}
+	sub.acked = seq
+	sub.db.pruneJournals()
+}
+
+/*
+Close ends the subscription and releases its journals. A blocked Next returns ErrClosed.
+*/
+func (sub *Subscription) Close() error {
+	p := &sub.db.subs
+	p.mu.Lock()
+	if !sub.closed {
+		sub.closed = true
+		sub.queue = nil
+		delete(p.list, sub)
+		sub.db.pruneJournals()
+		sub.wake()
+	}
+	p.mu.Unlock()
+
+	sub.jmu.Lock()
+	sub.jclosed = true
+	sub.jrd = nil
+	sub.jmu.Unlock()
+	return nil
+}
+
+// Feeds a committed write to the subscribers.
+func (db *DB) publish(seq uint64, batches []*Batch) {
+	n := batchesLen(batches)
+	if n == 0 {
+		return
+	}
+	p := &db.subs
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.seq = seq + uint64(n) - 1
+	var c *Change
+	for sub := range p.list {
+		if !sub.live {
+			continue
+		}
+		sub.wake()
+		if len(sub.queue) >= subscribeQueueLen {
+			// Too far behind, read the journal instead.
+			sub.live = false
+			continue
+		}
+		if c == nil {
+			b := new(Batch)
+			for _, x := range batches {
+				b.append(x)
+			}
+			c = &Change{Seq: seq, Batch: b}
+		}
+		sub.queue = append(sub.queue, *c)
+	}
+}
+
+// Removes an obsolete journal, or holds it for the subscribers.
+func (db *DB) releaseJournal(fd storage.FileDesc) {
+	p := &db.subs
+	p.mu.Lock()
+	seq := p.jlast[fd.Num]
+	delete(p.jlast, fd.Num)
+	p.retained = append(p.retained, retainedJournal{fd, seq})
+	db.pruneJournals()
+	p.mu.Unlock()
+}
+
+/*
+ReleaseJournals releases the journals, that Config.RetainJournals held for the subscribers
+when the DB was closed. The next open holds them until the first call to Subscribe or
+ReleaseJournals, so a subscriber can resume from them; afterwards they are removed once
+acknowledged by all subscribers.
+*/
+func (db *DB) ReleaseJournals() {
+	p := &db.subs
+	p.mu.Lock()
+	p.hold = false
+	db.pruneJournals()
+	p.mu.Unlock()
+}
+
+// Holds an obsolete journal found on open for the subscribers, if Config.RetainJournals is
+// set, or removes it. A journal that can't be read releases the older ones, so the
+// subscribers never skip batches.
+func (db *DB) holdJournal(fd storage.FileDesc) {
+	p := &db.subs
+	if db.s.retainJournals {
+		first, last, err := db.journalSeqs(fd)
+		if err == nil && last >= first {
+			if len(p.retained) == 0 {
+				p.first = first
+			}
+			p.retained = append(p.retained, retainedJournal{fd, last})
+			return
+		}
+		if err != nil {
+			db.logf("journal@hold reading @%d %q", fd.Num, err)
+			for _, j := range p.retained {
+				db.s.stor.Remove(j.fd)
+			}
+			p.retained = nil
+		}
+	}
+	db.s.stor.Remove(fd)
+}
+
+// Returns the first and last sequence numbers written to the journal; last is less than
+// first if it holds no batch.
+func (db *DB) journalSeqs(fd storage.FileDesc) (first, last uint64, err error) {
+	r, err := db.s.stor.Open(fd)
+	if err != nil {
+		return
+	}
+	defer r.Close()
+	first = 1
+	jr := journal.NewReader(r, nil, false, true)
+	for {
+		var jr1 io.Reader
+		if jr1, err = jr.Next(); err != nil {
+			if err == io.EOF {
+				err = nil
+			}
+			return
+		}
+		var data []byte
+		if data, err = ioutil.ReadAll(jr1); err != nil {
+			return
+		}
+		seq, n, err1 := decodeBatchHeader(data)
+		if err1 != nil {
+			err = err1
+			return
+		}
+		if n == 0 {
+			continue
+		}
+		if last < first {
+			first = seq
+		}
+		last = seq + uint64(n) - 1
+	}
+}
+
+// Removes the retained journals acknowledged by all subscribers; db.subs.mu must be held.
+// The journals of a closed DB are left to the next open.
+func (db *DB) pruneJournals() {
+	p := &db.subs
+	if db.isClosed() || p.hold {
+		return
+	}
+	n := 0
+	for ; n < len(p.retained); n++ {
+		j := p.retained[n]
+		if db.s.retainJournals && p.needs(j.seq) {
+			break
+		}
+		if j.seq != 0 {
+			p.first = j.seq + 1
+		}
+		if err := db.s.stor.Remove(j.fd); err != nil {
+			db.logf("journal@remove removing @%d %q", j.fd.Num, err)
+		} else {
+			db.logf("journal@remove removed @%d", j.fd.Num)
+		}
+	}
+	p.retained = append(p.retained[:0], p.retained[n:]...)
+}
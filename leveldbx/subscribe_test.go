@@ -0,0 +1,338 @@
+package leveldb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/syndtr/goleveldb/leveldb/opt"
+	"github.com/syndtr/goleveldb/leveldb/storage"
+	"github.com/syndtr/goleveldb/leveldb/testutil"
+)
+
+type testChangeReplay struct {
+	s string
+}
+
+func (r *testChangeReplay) Put(key, value []byte) { r.s += fmt.Sprintf("put(%s=%s)", key, value) }
+func (r *testChangeReplay) Delete(key []byte)     { r.s += fmt.Sprintf("del(%s)", key) }
+
+func (h *dbHarness) subscribe(fromSeq uint64) *Subscription {
+	sub, err := h.db.Subscribe(fromSeq)
+	if err != nil {
+		h.t.Fatal("Subscribe: got error: ", err)
+	}
+	return sub
+}
+
+func (h *dbHarness) nextChange(sub *Subscription, seq uint64, want string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	c, err := sub.Next(ctx)
+	if err != nil {
+		h.t.Fatal("Next: got error: ", err)
+	}
+	r := new(testChangeReplay)
+	c.Batch.Replay(r)
+	if c.Seq != seq || r.s != want {
+		h.t.Errorf("Next: want=%d:%s got=%d:%s", seq, want, c.Seq, r.s)
+	}
+}
+
+func (h *dbHarness) numJournals(want int) {
+	fds, err := h.stor.List(storage.TypeJournal)
+	if err != nil {
+		h.t.Fatal("List: got error: ", err)
+	}
+	if len(fds) != want {
+		h.t.Errorf("journals: want=%d got=%d", want, len(fds))
+	}
+}
+
+func TestDB_Subscribe(t *testing.T) {
+	h := newDbHarness(t)
+	defer h.close()
+
+	sub := h.subscribe(0)
+	defer sub.Close()
+	h.put("a", "v1")
+	b := new(Batch)
+	b.Put([]byte("b"), []byte("v1"))
+	b.Delete([]byte("a"))
+	h.write(b)
+	h.nextChange(sub, 1, "put(a=v1)")
+	h.nextChange(sub, 2, "put(b=v1)del(a)")
+
+	// Catch up from the memdb.
+	sub2 := h.subscribe(2)
+	h.nextChange(sub2, 2, "put(b=v1)del(a)")
+	h.put("c", "v1")
+	h.nextChange(sub2, 4, "put(c=v1)")
+	h.nextChange(sub, 4, "put(c=v1)")
+	sub2.Close()
+	if _, err := sub2.Next(context.Background()); err != ErrClosed {
+		t.Errorf("Next: want=%v got=%v", ErrClosed, err)
+	}
+
+	// The journal is removed with the flushed memdb.
+	h.compactMem()
+	h.numJournals(1)
+	if _, err := h.db.Subscribe(1); err != ErrNotRetained {
+		t.Errorf("Subscribe: want=%v got=%v", ErrNotRetained, err)
+	}
+	h.put("d", "v1")
+	h.nextChange(sub, 5, "put(d=v1)")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := sub.Next(ctx); err != context.Canceled {
+		t.Errorf("Next: want=%v got=%v", context.Canceled, err)
+	}
+}
+
+func TestDB_SubscribeRetain(t *testing.T) {
+	h := new(dbHarness)
+	h.conf = &Config{RetainJournals: true}
+	h.init(t, &opt.Options{DisableLargeBatchTransaction: true})
+	defer h.close()
+
+	sub := h.subscribe(0)
+	defer sub.Close()
+	h.put("a", "v1")
+	h.compactMem()
+	h.put("b", "v1")
+	h.compactMem()
+	h.numJournals(3)
+
+	// Catch up from the retained journals, then from the memdb.
+	sub2 := h.subscribe(1)
+	defer sub2.Close()
+	h.put("c", "v1")
+	h.nextChange(sub2, 1, "put(a=v1)")
+	h.nextChange(sub2, 2, "put(b=v1)")
+	h.nextChange(sub2, 3, "put(c=v1)")
+	h.put("d", "v1")
+	h.nextChange(sub2, 4, "put(d=v1)")
+
+	sub.Ack(2)
+	h.numJournals(3)
+	sub2.Ack(4)
+	h.numJournals(1)
+	if _, err := h.db.Subscribe(2); err != ErrNotRetained {
+		t.Errorf("Subscribe: want=%v got=%v", ErrNotRetained, err)
+	}
+	h.nextChange(h.subscribe(3), 3, "put(c=v1)put(d=v1)")
+}
+
+func TestDB_SubscribeOverflow(t *testing.T) {
+	h := new(dbHarness)
+	h.conf = &Config{RetainJournals: true}
+	h.init(t, &opt.Options{DisableLargeBatchTransaction: true})
+	defer h.close()
+
+	sub := h.subscribe(0)
+	defer sub.Close()
+	n := subscribeQueueLen * 2
+	for i := 0; i < n; i++ {
+		h.put(fmt.Sprintf("k%04d", i), "v")
+		if i == n/4 || i == n/2 {
+			h.compactMem()
+		}
+	}
+	// The batches caught up from the memdb are merged.
+	want, got := "", ""
+	for seq := uint64(1); seq <= uint64(n); {
+		c, err := sub.Next(context.Background())
+		if err != nil {
+			t.Fatal("Next: got error: ", err)
+		}
+		if c.Seq != seq {
+			t.Fatalf("Next: want seq %d, got %d", seq, c.Seq)
+		}
+		r := new(testChangeReplay)
+		c.Batch.Replay(r)
+		got += r.s
+		seq += uint64(c.Batch.Len())
+	}
+	for i := 0; i < n; i++ {
+		want += fmt.Sprintf("put(k%04d=v)", i)
+	}
+	if got != want {
+		t.Errorf("changes: want=%q got=%q", want, got)
+	}
+	h.put("x", "v")
+	h.nextChange(sub, uint64(n+1), "put(x=v)")
+}
+
+func TestDB_SubscribeCommitted(t *testing.T) {
+	dir := checkpointTestDir(t, "SubscribeIngest")
+	os.MkdirAll(dir, 0755)
+	defer os.RemoveAll(dir)
+
+	h := new(dbHarness)
+	h.conf = &Config{RetainJournals: true}
+	h.init(t, &opt.Options{WriteBuffer: 64 << 10})
+	defer h.close()
+
+	sub := h.subscribe(0)
+	h.put("a", "v1")
+	tr, err := h.db.OpenTransaction()
+	if err != nil {
+		t.Fatal("OpenTransaction: got error: ", err)
+	}
+	tr.Put([]byte("b"), []byte("v1"), nil)
+	tr.Delete([]byte("a"), nil)
+	if err := tr.Commit(); err != nil {
+		t.Fatal("Commit: got error: ", err)
+	}
+	h.ingest(buildIngestTable(t, dir, "t1", "x", "v1", "y", "v1"))
+
+	// The large batch is written by a transaction.
+	large := strings.Repeat("v", 128<<10)
+	b := new(Batch)
+	b.Put([]byte("c"), []byte(large))
+	h.write(b)
+	h.put("d", "v1")
+
+	changes := func(sub *Subscription) {
+		h.nextChange(sub, 1, "put(a=v1)")
+		h.nextChange(sub, 2, "put(b=v1)del(a)")
+		h.nextChange(sub, 4, "put(x=v1)put(y=v1)")
+		h.nextChange(sub, 6, "put(c="+large+")")
+		h.nextChange(sub, 7, "put(d=v1)")
+	}
+	changes(sub)
+	want := "(b->v1)(c->" + large + ")(d->v1)(x->v1)(y->v1)"
+	h.getKeyVal(want)
+
+	// The committed records are not replayed, but still streamed.
+	h.reopenDB()
+	sub.Close()
+	h.getKeyVal(want)
+	h.allEntriesFor("b", "[ v1 ]")
+	h.allEntriesFor("x", "[ v1 ]")
+	sub = h.subscribe(1)
+	defer sub.Close()
+	changes(sub)
+}
+
+func TestDB_SubscribeCommittedRedo(t *testing.T) {
+	h := new(dbHarness)
+	h.conf = &Config{RetainJournals: true}
+	h.init(t, &opt.Options{DisableLargeBatchTransaction: true})
+	defer h.close()
+
+	sub := h.subscribe(0)
+	h.put("a", "v1")
+	tr, err := h.db.OpenTransaction()
+	if err != nil {
+		t.Fatal("OpenTransaction: got error: ", err)
+	}
+	tr.Put([]byte("b"), []byte("v1"), nil)
+	tr.Put([]byte("c"), []byte("v1"), nil)
+
+	// The journal commits the transaction, when the manifest fails.
+	h.stor.EmulateError(testutil.ModeWrite, storage.TypeManifest, errors.New("manifest write error"))
+	if err := tr.Commit(); err != nil {
+		t.Fatal("Commit: got error: ", err)
+	}
+	h.getKeyVal("(a->v1)(b->v1)(c->v1)")
+	h.nextChange(sub, 1, "put(a=v1)")
+	h.nextChange(sub, 2, "put(b=v1)put(c=v1)")
+	h.db.Close()
+	sub.Close()
+	h.stor.EmulateError(testutil.ModeWrite, storage.TypeManifest, nil)
+
+	// And the next open replays it.
+	h.openDB()
+	h.getKeyVal("(a->v1)(b->v1)(c->v1)")
+	h.allEntriesFor("b", "[ v1 ]")
+	sub = h.subscribe(1)
+	defer sub.Close()
+	h.nextChange(sub, 1, "put(a=v1)")
+	h.nextChange(sub, 2, "put(b=v1)put(c=v1)")
+}
+
+func TestDB_SubscribeReopen(t *testing.T) {
+	h := new(dbHarness)
+	h.conf = &Config{RetainJournals: true}
+	h.init(t, &opt.Options{DisableLargeBatchTransaction: true})
+	defer h.close()
+
+	sub := h.subscribe(0)
+	h.put("a", "v1")
+	h.compactMem()
+	h.nextChange(sub, 1, "put(a=v1)")
+	sub.Ack(1)
+	h.put("b", "v1")
+	h.put("c", "v1")
+	h.compactMem()
+	h.put("d", "v1")
+	h.numJournals(2)
+
+	// The retained journal and the replayed one are held by the next open,
+	// until the first subscriber.
+	h.reopenDB()
+	sub.Close()
+	h.numJournals(3)
+	h.put("e", "v1")
+	h.compactMem()
+	h.numJournals(4)
+	if _, err := h.db.Subscribe(1); err != ErrNotRetained {
+		t.Errorf("Subscribe: want=%v got=%v", ErrNotRetained, err)
+	}
+	sub = h.subscribe(3)
+	h.nextChange(sub, 3, "put(c=v1)")
+	h.nextChange(sub, 4, "put(d=v1)")
+	h.nextChange(sub, 6, "put(e=v1)")
+	h.put("f", "v1")
+	h.nextChange(sub, 7, "put(f=v1)")
+	h.getKeyVal("(a->v1)(b->v1)(c->v1)(d->v1)(e->v1)(f->v1)")
+
+	// And again, while resuming.
+	h.reopenDB()
+	sub.Close()
+	h.numJournals(5)
+	sub = h.subscribe(2)
+	defer sub.Close()
+	h.nextChange(sub, 2, "put(b=v1)")
+	h.nextChange(sub, 3, "put(c=v1)")
+	h.nextChange(sub, 4, "put(d=v1)")
+	h.nextChange(sub, 6, "put(e=v1)")
+	h.nextChange(sub, 7, "put(f=v1)")
+	sub.Ack(7)
+
+	// The journals are removed, once acknowledged.
+	h.compactMem()
+	h.numJournals(1)
+	h.reopenDB()
+	h.numJournals(1)
+	if _, err := h.db.Subscribe(7); err != ErrNotRetained {
+		t.Errorf("Subscribe: want=%v got=%v", ErrNotRetained, err)
+	}
+}
+
+func TestDB_ReleaseJournals(t *testing.T) {
+	h := new(dbHarness)
+	h.conf = &Config{RetainJournals: true}
+	h.init(t, &opt.Options{DisableLargeBatchTransaction: true})
+	defer h.close()
+
+	sub := h.subscribe(0)
+	h.put("a", "v1")
+	h.compactMem()
+	h.numJournals(2)
+	h.reopenDB()
+	sub.Close()
+	h.numJournals(2)
+
+	h.db.ReleaseJournals()
+	h.numJournals(1)
+	if _, err := h.db.Subscribe(1); err != ErrNotRetained {
+		t.Errorf("Subscribe: want=%v got=%v", ErrNotRetained, err)
+	}
+}
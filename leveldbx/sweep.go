@@ -0,0 +1,249 @@
+/*
+This is free and unencumbered software released into the public domain.
+
+Anyone is free to copy, modify, publish, use, compile, sell, or
+distribute this software, either in source code form or as a compiled
+binary, for any purpose, commercial or non-commercial, and by any
+means.
+
+In jurisdictions that recognize copyright laws, the author or authors
+of this software dedicate any and all copyright interest in the
+software to the public domain. We make this dedication for the benefit
+of the public at large and to the detriment of our heirs and
+successors. We intend this dedication to be an overt act of
+relinquishment in perpetuity of all present and future rights to this
+software under copyright law.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+IN NO EVENT SHALL THE AUTHORS BE LIABLE FOR ANY CLAIM, DAMAGES OR
+OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE,
+ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR
+OTHER DEALINGS IN THE SOFTWARE.
+
+For more information, please refer to <http://unlicense.org/>
+*/
+package leveldb
+
+import (
+	"context"
+	"time"
+)
+
+/*
+The expiry sweep.
+
+Expired entries are only dropped, when a compaction touches their table. The
+size-triggered compactions may never touch a cold table, so every table
+records an estimated expiry profile, when it is built, and the sweep compacts
+the tables, whose entries are estimated to be mostly expired.
+
+Tables of level-0 are compacted into level-1 as usual, tables of the other
+levels are rewritten into their own level.
+*/
+
+// tExpiry is the expiry profile of a table.
+type tExpiry struct {
+	n, total int64 // entries with an expiration deadline, all entries
+	min, max int64 // earliest and latest deadline, in nanoseconds since the unix epoch
+}
+
+func (e *tExpiry) add(deadline int64) {
+	e.total++
+	if deadline == 0 {
+		return
+	}
+	if e.n == 0 || deadline < e.min {
+		e.min = deadline
+	}
+	if e.n == 0 || deadline > e.max {
+		e.max = deadline
+	}
+	e.n++
+}
+
+// Estimates the fraction of entries, that are expired at the given time,
+// assuming the deadlines are evenly spread between min and max.
+func (e *tExpiry) ratio(now int64) float64 {
+	if e.n == 0 || now < e.min {
+		return 0
+	}
+	r := float64(e.n) / float64(e.total)
+	if now >= e.max {
+		return r
+	}
+	return r * float64(now-e.min) / float64(e.max-e.min)
+}
+
+// Returns the expiration deadline of an entry, in nanoseconds since the unix
+// epoch, or 0 if it is unknown.
+func (s *session) deadline(kt keyType, ukey, v []byte) int64 {
+	if kt == keyTypeDel || kt == keyTypeMerge || kt == keyTypeRangeDel {
+		return 0
+	}
+	deadline, value := splitValue(kt, v)
+	if at, ok := s.aexp.(AutoExpireAt); ok {
+		if t, ok := at.ExpireAt(ukey, value); ok {
+			n := t.UnixNano()
+			if n < 1 {
+				n = 1
+			}
+			if deadline == 0 || n < deadline {
+				deadline = n
+			}
+		}
+	}
+	return deadline
+}
+
+type sweepCandidate struct {
+	level int
+	num   int64
+}
+
+// Returns the tables, that should be swept.
+func (s *session) sweepCandidates(ratio float64) (c []sweepCandidate) {
+	v := s.version()
+	defer v.release()
+	now := s.now().UnixNano()
+	for level, tables := range v.levels {
+		for _, t := range tables {
+			if r := t.exp.ratio(now); r > 0 && r >= ratio {
+				c = append(c, sweepCandidate{level, t.fd.Num})
+			}
+		}
+	}
+	return
+}
+
+// Creates a compaction sweeping the given table, or nil if the table does not
+// exist anymore; need external synchronization.
+func (s *session) getSweepCompaction(level int, num int64) *compaction {
+	v := s.version()
+	if level < len(v.levels) {
+		for _, t := range v.levels[level] {
+			if t.fd.Num != num {
+				continue
+			}
+			if level == 0 {
+				return newCompaction(s, v, level, tFiles{t})
+			}
+
+			// Adjacent tables may share the boundary user keys.
+			t0 := v.levels[level].getOverlaps(nil, s.icmp, t.imin.ukey(), t.imax.ukey(), false)
+			c := &compaction{
+				s:           s,
+				v:           v,
+				sourceLevel: level,
+				targetLevel: level,
+				levels:      [2]tFiles{t0, nil},
+				tPtrs:       make([]int, len(v.levels)),
+			}
+			c.imin, c.imax = t0.getRange(s.icmp)
+			c.save()
+			return c
+		}
+	}
+	v.release()
+	return nil
+}
+
+// Reports the estimated number of bytes, that are held by expired entries.
+func (v *version) reclaimable(now int64) (n int64) {
+	for _, tables := range v.levels {
+		for _, t := range tables {
+			n += int64(float64(t.size) * t.exp.ratio(now))
+		}
+	}
+	return
+}
+
+func (db *DB) tableSweepCompaction(ctx context.Context, ratio float64) error {
+	// Each candidate is swept once; tables created by the sweep are not
+	// revisited, even if the estimate was wrong.
+	for _, sc := range db.s.sweepCandidates(ratio) {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if c := db.s.getSweepCompaction(sc.level, sc.num); c != nil {
+			db.logf("table@sweep L%d@%d", sc.level, sc.num)
+			db.tableCompaction(c, true)
+		}
+	}
+	return nil
+}
+
+type cSweep struct {
+	ctx   context.Context
+	ratio float64
+	ackC  chan<- error
+}
+
+func (r cSweep) ack(err error) {
+	if r.ackC != nil {
+		defer func() {
+			recover()
+		}()
+		r.ackC <- err
+	}
+}
+
+// Send sweep compaction request.
+func (db *DB) compTriggerSweep(compC chan<- cCmd, ctx context.Context, ratio float64) (err error) {
+	ch := make(chan error)
+	defer close(ch)
+	// Send cmd.
+	select {
+	case compC <- cSweep{ctx, ratio, ch}:
+	case err := <-db.compErrC:
+		return err
+	case <-db.closeC:
+		return ErrClosed
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	// Wait cmd.
+	select {
+	case err = <-ch:
+	case err = <-db.compErrC:
+	case <-db.closeC:
+		return ErrClosed
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	return err
+}
+
+// CompactExpired compacts the tables, whose entries are estimated to be
+// mostly expired, as configured by Expiration.SweepRatio. The estimate is
+// based on the time-to-live of the entries and, if the AutoExpire implements
+// AutoExpireAt, on the expiration times it reports. Tables built by older
+// versions carry no estimate and are never swept.
+//
+// The context only cancels the sweep between two tables.
+func (db *DB) CompactExpired(ctx context.Context) error {
+	if err := db.ok(); err != nil {
+		return err
+	}
+	return db.compTriggerSweep(db.tcompCmdC, ctx, db.s.sweepRatio)
+}
+
+// Runs the expiry sweep periodically.
+func (db *DB) tSweep() {
+	defer db.closeW.Done()
+
+	ticker := time.NewTicker(db.s.sweepInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			err := db.compTriggerSweep(db.tcompCmdC, context.Background(), db.s.sweepRatio)
+			if err != nil && err != ErrClosed {
+				db.logf("table@sweep error E·%q", err)
+			}
+		case <-db.closeC:
+			return
+		}
+	}
+}
@@ -0,0 +1,246 @@
+package leveldb
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/syndtr/goleveldb/leveldb/opt"
+	"github.com/syndtr/goleveldb/leveldb/storage"
+)
+
+// testExpireAt treats values as decimal expiration timestamps and reports
+// them through ExpireAt; non-numeric values never expire.
+type testExpireAt struct{}
+
+func (e testExpireAt) Retain(b []byte) bool {
+	panic("Retain called instead of RetainKV")
+}
+
+func (e testExpireAt) RetainKV(key, value []byte, now time.Time) bool {
+	n, err := strconv.ParseInt(string(value), 10, 64)
+	return err != nil || n > now.Unix()
+}
+
+func (e testExpireAt) ExpireAt(key, value []byte) (time.Time, bool) {
+	n, err := strconv.ParseInt(string(value), 10, 64)
+	return time.Unix(n, 0), err == nil
+}
+
+func newSweepHarness(t *testing.T, aexp AutoExpire, interval time.Duration) (*dbHarness, *testClock) {
+	c := &testClock{now: 1000}
+	h := new(dbHarness)
+	h.conf = &Config{Expiration: Expiration{AutoExpire: aexp, Clock: c.get, SweepInterval: interval}}
+	h.init(t, &opt.Options{DisableLargeBatchTransaction: true})
+	return h, c
+}
+
+func (h *dbHarness) reclaimable() int64 {
+	value, err := h.db.GetProperty("leveldb.reclaimable")
+	if err != nil {
+		h.t.Fatal("GetProperty: got error: ", err)
+	}
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		h.t.Fatal("GetProperty: invalid reclaimable bytes: ", value)
+	}
+	return n
+}
+
+func (h *dbHarness) sstables() string {
+	value, err := h.db.GetProperty("leveldb.sstables")
+	if err != nil {
+		h.t.Fatal("GetProperty: got error: ", err)
+	}
+	return value
+}
+
+func (h *dbHarness) compactExpired() {
+	if err := h.db.CompactExpired(context.Background()); err != nil {
+		h.t.Fatal("CompactExpired: got error: ", err)
+	}
+}
+
+func TestDB_CompactExpired(t *testing.T) {
+	h, c := newSweepHarness(t, nil, 0)
+	defer h.close()
+
+	for i := 0; i < 20; i++ {
+		h.putTTL(fmt.Sprintf("ttl%02d", i), "v", time.Duration(10+i)*time.Second)
+	}
+	h.put("keep", "v")
+	h.compactMem()
+	h.compactRangeAt(0, "", "")
+	h.compactRangeAt(1, "", "")
+	h.tablesPerLevel("0,0,1")
+
+	if n := h.reclaimable(); n != 0 {
+		t.Errorf("reclaimable: want=0 got=%d", n)
+	}
+	h.compactExpired()
+	h.tablesPerLevel("0,0,1")
+
+	// A quarter of the deadlines passed; the estimate is below the ratio.
+	c.set(1015)
+	if n := h.reclaimable(); n == 0 {
+		t.Error("reclaimable: want >0 got=0")
+	}
+	before := h.sstables()
+	h.compactExpired()
+	if after := h.sstables(); after != before {
+		t.Errorf("table was swept below the ratio:\n%s\n%s", before, after)
+	}
+
+	// The expiry profile is persisted in the manifest.
+	h.reopenDB()
+	c.set(1030)
+	if n := h.reclaimable(); n == 0 {
+		t.Error("reclaimable after reopen: want >0 got=0")
+	}
+	h.compactExpired()
+	h.tablesPerLevel("0,0,1")
+	h.allEntriesFor("ttl00", "[ ]")
+	h.allEntriesFor("ttl19", "[ ]")
+	h.getKeyVal("(keep->v)")
+	if n := h.reclaimable(); n != 0 {
+		t.Errorf("reclaimable after sweep: want=0 got=%d", n)
+	}
+}
+
+func TestDB_CompactExpiredLevel0(t *testing.T) {
+	h, c := newSweepHarness(t, testExpireAt{}, 0)
+	defer h.close()
+
+	h.put("a", "1010")
+	h.put("b", "1010")
+	h.put("c", "x")
+	h.compactMem()
+	h.tablesPerLevel("1")
+
+	c.set(1020)
+	h.compactExpired()
+	h.tablesPerLevel("0,1")
+	h.allEntriesFor("a", "[ ]")
+	h.getKeyVal("(c->x)")
+}
+
+func TestDB_ExpirySweep(t *testing.T) {
+	h, c := newSweepHarness(t, nil, 10*time.Millisecond)
+	defer h.close()
+
+	h.putTTL("a", "v", 10*time.Second)
+	h.putTTL("b", "v", 10*time.Second)
+	h.compactMem()
+	h.compactRangeAt(0, "", "")
+	h.tablesPerLevel("0,1")
+
+	c.set(1020)
+	for i := 0; h.reclaimable() != 0; i++ {
+		if i > 500 {
+			t.Fatal("tables were not swept in time")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	h.allEntriesFor("a", "[ ]")
+	if n := h.totalTables(); n != 0 {
+		t.Errorf("want no tables, got %d", n)
+	}
+}
+
+func TestDB_CompactExpiredCanceled(t *testing.T) {
+	h, _ := newSweepHarness(t, nil, 0)
+	defer h.close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := h.db.CompactExpired(ctx); err != context.Canceled {
+		t.Errorf("CompactExpired: want=%v got=%v", context.Canceled, err)
+	}
+}
+
+func TestDB_RecoverTableExpiry(t *testing.T) {
+	h, c := newSweepHarness(t, nil, 0)
+	defer h.close()
+
+	for i := 0; i < 20; i++ {
+		h.putTTL(fmt.Sprintf("ttl%02d", i), "v", time.Duration(10+i)*time.Second)
+	}
+	h.put("keep", "v")
+	h.compactMem()
+	h.tablesPerLevel("1")
+	v := h.db.s.version()
+	want := *v.levels[0][0]
+	v.release()
+	if want.exp.n != 20 || want.raw == 0 {
+		t.Fatalf("table: want 20 deadlines and a raw size, got %+v %d", want.exp, want.raw)
+	}
+	h.closeDB()
+
+	var err error
+	h.db, err = RecoverWithConfig(h.stor, h.o, h.conf)
+	if err != nil {
+		t.Fatal("Recover: got error: ", err)
+	}
+	v = h.db.s.version()
+	got := *v.levels[0][0]
+	v.release()
+	if got.exp != want.exp {
+		t.Errorf("expiry: want=%+v got=%+v", want.exp, got.exp)
+	}
+	if got.raw != want.raw {
+		t.Errorf("raw size: want=%d got=%d", want.raw, got.raw)
+	}
+	c.set(1040)
+	if n := h.reclaimable(); n == 0 {
+		t.Error("reclaimable: want the expired table")
+	}
+}
+
+func TestSessionRecord_TableExpiry(t *testing.T) {
+	exp := tExpiry{n: 2, total: 3, min: 100, max: 200}
+	v := &sessionRecord{}
+	v.addTableFile(1, &tFile{fd: storage.FileDesc{Type: storage.TypeTable, Num: 7}, size: 10,
+		imin: makeInternalKey(nil, []byte("a"), 1, keyTypeVal),
+		imax: makeInternalKey(nil, []byte("b"), 2, keyTypeVal),
+		exp:  exp})
+	v.addTable(1, 8, 10,
+		makeInternalKey(nil, []byte("c"), 3, keyTypeVal),
+		makeInternalKey(nil, []byte("d"), 4, keyTypeVal))
+
+	b := new(bytes.Buffer)
+	if err := v.encode(b); err != nil {
+		t.Fatal("encode: got error: ", err)
+	}
+	v2 := &sessionRecord{}
+	if err := v2.decode(b); err != nil {
+		t.Fatal("decode: got error: ", err)
+	}
+	if len(v2.addedTables) != 2 {
+		t.Fatalf("decode: want 2 tables, got %d", len(v2.addedTables))
+	}
+	if got := v2.addedTables[0].exp; got != exp {
+		t.Errorf("decode: want=%+v got=%+v", exp, got)
+	}
+	if got := v2.addedTables[1].exp; got != (tExpiry{}) {
+		t.Errorf("decode: want no expiry, got=%+v", got)
+	}
+}
+
+func TestTableExpiry_Ratio(t *testing.T) {
+	var e tExpiry
+	e.add(0)
+	e.add(0)
+	e.add(100)
+	e.add(300)
+	for _, c := range []struct {
+		now  int64
+		want float64
+	}{{50, 0}, {100, 0}, {200, 0.25}, {300, 0.5}, {400, 0.5}} {
+		if got := e.ratio(c.now); got != c.want {
+			t.Errorf("ratio(%d): want=%v got=%v", c.now, c.want, got)
+		}
+	}
+}
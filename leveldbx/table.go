@@ -8,14 +8,15 @@ package leveldb
 
 import (
 	"fmt"
+	"io"
 	"sort"
 	"sync/atomic"
 
+	"github.com/maxymania/storage-engines/leveldbx/table"
 	"github.com/syndtr/goleveldb/leveldb/cache"
 	"github.com/syndtr/goleveldb/leveldb/iterator"
 	"github.com/syndtr/goleveldb/leveldb/opt"
 	"github.com/syndtr/goleveldb/leveldb/storage"
-	"github.com/syndtr/goleveldb/leveldb/table"
 	"github.com/syndtr/goleveldb/leveldb/util"
 )
 
@@ -25,6 +26,12 @@ type tFile struct {
 	seekLeft   int32
 	size       int64
 	imin, imax internalKey
+	exp        tExpiry
+	seq        uint64 // Sequence number of an ingested table, or 0.
+	raw        int64  // Size without compression, or 0 if unknown.
+	maxSeq     uint64 // Largest sequence number of the entries, or 0 if unknown.
+	rdels      rangeTombstones
+	quarantine bool // Move to a temp file instead of removing, see DB.Repair.
 }
 
 // Returns true if given key is after largest key of this table.
@@ -78,7 +85,13 @@ func newTableFile(fd storage.FileDesc, size int64, imin, imax internalKey) *tFil
 }
 
 func tableFileFromRecord(r atRecord) *tFile {
-	return newTableFile(storage.FileDesc{storage.TypeTable, r.num}, r.size, r.imin, r.imax)
+	t := newTableFile(storage.FileDesc{storage.TypeTable, r.num}, r.size, r.imin, r.imax)
+	t.exp = r.exp
+	t.seq = r.seq
+	t.raw = r.raw
+	t.maxSeq = r.maxSeq
+	t.rdels = r.rdels
+	return t
 }
 
 // tFiles hold multiple tFile.
@@ -134,6 +147,19 @@ func (tf tFiles) size() (sum int64) {
 	return sum
 }
 
+// Returns total size of tables without compression; tables of unknown
+// raw size count with their actual size.
+func (tf tFiles) rawSize() (sum int64) {
+	for _, t := range tf {
+		if t.raw > 0 {
+			sum += t.raw
+		} else {
+			sum += t.size
+		}
+	}
+	return sum
+}
+
 // Searches smallest index of tables whose its smallest
 // key is after or equal with given key.
 func (tf tFiles) searchMin(icmp *iComparer, ikey internalKey) int {
@@ -227,7 +253,7 @@ func (tf tFiles) getRange(icmp *iComparer) (imin, imax internalKey) {
 }
 
 // Creates iterator index from tables.
-func (tf tFiles) newIndexIterator(tops *tOps, icmp *iComparer, slice *util.Range, ro *opt.ReadOptions) iterator.IteratorIndexer {
+func (tf tFiles) newIndexIterator(tops *tOps, icmp *iComparer, slice *util.Range, ro *opt.ReadOptions, to *table.IterOptions) iterator.IteratorIndexer {
 	if slice != nil {
 		var start, limit int
 		if slice.Start != nil {
@@ -246,6 +272,7 @@ func (tf tFiles) newIndexIterator(tops *tOps, icmp *iComparer, slice *util.Range
 		icmp:   icmp,
 		slice:  slice,
 		ro:     ro,
+		to:     to,
 	})
 }
 
@@ -256,6 +283,7 @@ type tFilesArrayIndexer struct {
 	icmp  *iComparer
 	slice *util.Range
 	ro    *opt.ReadOptions
+	to    *table.IterOptions
 }
 
 func (a *tFilesArrayIndexer) Search(key []byte) int {
@@ -264,9 +292,9 @@ func (a *tFilesArrayIndexer) Search(key []byte) int {
 
 func (a *tFilesArrayIndexer) Get(i int) iterator.Iterator {
 	if i == 0 || i == a.Len()-1 {
-		return a.tops.newIterator(a.tFiles[i], a.slice, a.ro)
+		return a.tops.newIterator(a.tFiles[i], a.slice, a.ro, a.to)
 	}
-	return a.tops.newIterator(a.tFiles[i], nil, a.ro)
+	return a.tops.newIterator(a.tFiles[i], nil, a.ro, a.to)
 }
 
 // Helper type for sortByKey.
@@ -295,26 +323,35 @@ type tOps struct {
 	cache  *cache.Cache
 	bcache *cache.Cache
 	bpool  *util.BufferPool
+	closeC <-chan struct{} // Cancels the rate limited writes
 }
 
-// Creates an empty table and returns table writer.
-func (t *tOps) create() (*tWriter, error) {
+// Creates an empty table for the given level and returns table writer.
+func (t *tOps) create(level int) (*tWriter, error) {
 	fd := storage.FileDesc{storage.TypeTable, t.s.allocFileNum()}
 	fw, err := t.s.stor.Create(fd)
 	if err != nil {
 		return nil, err
 	}
+	var tfw io.Writer = fw
+	if t.s.limiter != nil {
+		tfw = limitedWriter{fw, t.s.limiter, t.closeC}
+	}
+	tw := table.NewWriter(tfw, t.s.o.Options)
+	if c := t.s.levelCodec(level); c != nil {
+		tw.SetCodec(c)
+	}
 	return &tWriter{
 		t:  t,
 		fd: fd,
 		w:  fw,
-		tw: table.NewWriter(fw, t.s.o.Options),
+		tw: tw,
 	}, nil
 }
 
-// Builds table from src iterator.
-func (t *tOps) createFrom(src iterator.Iterator) (f *tFile, n int, err error) {
-	w, err := t.create()
+// Builds table for the given level from src iterator.
+func (t *tOps) createFrom(src iterator.Iterator, level int) (f *tFile, n int, err error) {
+	w, err := t.create(level)
 	if err != nil {
 		return
 	}
@@ -379,6 +416,9 @@ func (t *tOps) find(f *tFile, key []byte, ro *opt.ReadOptions) (rkey, rvalue []b
 		return nil, nil, err
 	}
 	defer ch.Release()
+	if f.seq != 0 {
+		return t.findIngested(ch.Value().(*table.Reader), f, key, ro, false)
+	}
 	return ch.Value().(*table.Reader).Find(key, true, ro)
 }
 
@@ -389,6 +429,10 @@ func (t *tOps) findKey(f *tFile, key []byte, ro *opt.ReadOptions) (rkey []byte,
 		return nil, err
 	}
 	defer ch.Release()
+	if f.seq != 0 {
+		rkey, _, err = t.findIngested(ch.Value().(*table.Reader), f, key, ro, true)
+		return
+	}
 	return ch.Value().(*table.Reader).FindKey(key, true, ro)
 }
 
@@ -402,14 +446,17 @@ func (t *tOps) offsetOf(f *tFile, key []byte) (offset int64, err error) {
 	return ch.Value().(*table.Reader).OffsetOf(key)
 }
 
-// Creates an iterator from the given table.
-func (t *tOps) newIterator(f *tFile, slice *util.Range, ro *opt.ReadOptions) iterator.Iterator {
+// Creates an iterator from the given table; to may be nil.
+func (t *tOps) newIterator(f *tFile, slice *util.Range, ro *opt.ReadOptions, to *table.IterOptions) iterator.Iterator {
 	ch, err := t.open(f)
 	if err != nil {
 		return iterator.NewEmptyIterator(err)
 	}
-	iter := ch.Value().(*table.Reader).NewIterator(slice, ro)
+	iter := ch.Value().(*table.Reader).NewIteratorWith(slice, ro, to)
 	iter.SetReleaser(ch)
+	if f.seq != 0 {
+		return &tIngestedIter{Iterator: iter, icmp: t.s.icmp, seq: f.seq}
+	}
 	return iter
 }
 
@@ -417,7 +464,14 @@ func (t *tOps) newIterator(f *tFile, slice *util.Range, ro *opt.ReadOptions) ite
 // no one use the the table.
 func (t *tOps) remove(f *tFile) {
 	t.cache.Delete(0, uint64(f.fd.Num), func() {
-		if err := t.s.stor.Remove(f.fd); err != nil {
+		if f.quarantine {
+			qfd := storage.FileDesc{Type: storage.TypeTemp, Num: f.fd.Num}
+			if err := t.s.stor.Rename(f.fd, qfd); err != nil {
+				t.s.logf("table@quarantine @%d %q", f.fd.Num, err)
+			} else {
+				t.s.logf("table@quarantine moved @%d to %s", f.fd.Num, qfd)
+			}
+		} else if err := t.s.stor.Remove(f.fd); err != nil {
 			t.s.logf("table@remove removing @%d %q", f.fd.Num, err)
 		} else {
 			t.s.logf("table@remove removed @%d", f.fd.Num)
@@ -477,6 +531,9 @@ type tWriter struct {
 	tw *table.Writer
 
 	first, last []byte
+	exp         tExpiry
+	maxSeq      uint64
+	rdels       rangeTombstones
 }
 
 // Append key/value pair to the table.
@@ -485,6 +542,15 @@ func (w *tWriter) append(key, value []byte) error {
 		w.first = append([]byte{}, key...)
 	}
 	w.last = append(w.last[:0], key...)
+	if ukey, seq, kt, err := parseInternalKey(key); err == nil {
+		w.exp.add(w.t.s.deadline(kt, ukey, value))
+		if seq > w.maxSeq {
+			w.maxSeq = seq
+		}
+		if kt == keyTypeRangeDel {
+			w.rdels = append(w.rdels, rangeTombstone{append([]byte{}, ukey...), append([]byte{}, value...), seq})
+		}
+	}
 	return w.tw.Append(key, value)
 }
 
@@ -515,6 +581,10 @@ func (w *tWriter) finish() (f *tFile, err error) {
 		}
 	}
 	f = newTableFile(w.fd, int64(w.tw.BytesLen()), internalKey(w.first), internalKey(w.last))
+	f.exp = w.exp
+	f.raw = int64(w.tw.RawBytesLen())
+	f.maxSeq = w.maxSeq
+	f.rdels = w.rdels
 	return
 }
 
@@ -0,0 +1,129 @@
+/*
+This is free and unencumbered software released into the public domain.
+
+Anyone is free to copy, modify, publish, use, compile, sell, or
+distribute this software, either in source code form or as a compiled
+binary, for any purpose, commercial or non-commercial, and by any
+means.
+
+In jurisdictions that recognize copyright laws, the author or authors
+of this software dedicate any and all copyright interest in the
+software to the public domain. We make this dedication for the benefit
+of the public at large and to the detriment of our heirs and
+successors. We intend this dedication to be an overt act of
+relinquishment in perpetuity of all present and future rights to this
+software under copyright law.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+IN NO EVENT SHALL THE AUTHORS BE LIABLE FOR ANY CLAIM, DAMAGES OR
+OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE,
+ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR
+OTHER DEALINGS IN THE SOFTWARE.
+
+For more information, please refer to <http://unlicense.org/>
+*/
+
+package table
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/golang/snappy"
+	"github.com/maxymania/storage-engines/leveldbx/compress/lz4"
+	"github.com/maxymania/storage-engines/leveldbx/compress/zstd"
+)
+
+/*
+Codec compresses the blocks of a table. The codec of each block is recorded by its block
+type, so tables mixing several codecs stay readable, as long as the codecs are registered.
+*/
+type Codec interface {
+	// BlockType identifies the codec in the block trailer; it must never change.
+	BlockType() byte
+	Name() string
+
+	// Encode returns the compressed form of src. The returned slice may be a sub-slice of
+	// dst, if dst was large enough.
+	Encode(dst, src []byte) []byte
+
+	// DecodedLen returns the length of the decompressed form of src, or -1 if unknown.
+	DecodedLen(src []byte) (int, error)
+
+	// Decode returns the decompressed form of src. The returned slice may be a sub-slice of
+	// dst, if dst was large enough.
+	Decode(dst, src []byte) ([]byte, error)
+}
+
+type noCodec struct{}
+
+func (noCodec) BlockType() byte                        { return blockTypeNoCompression }
+func (noCodec) Name() string                           { return "none" }
+func (noCodec) Encode(dst, src []byte) []byte          { return append(dst[:0], src...) }
+func (noCodec) DecodedLen(src []byte) (int, error)     { return len(src), nil }
+func (noCodec) Decode(dst, src []byte) ([]byte, error) { return append(dst[:0], src...), nil }
+
+type snappyCodec struct{}
+
+func (snappyCodec) BlockType() byte                        { return blockTypeSnappyCompression }
+func (snappyCodec) Name() string                           { return "snappy" }
+func (snappyCodec) Encode(dst, src []byte) []byte          { return snappy.Encode(dst[:cap(dst)], src) }
+func (snappyCodec) DecodedLen(src []byte) (int, error)     { return snappy.DecodedLen(src) }
+func (snappyCodec) Decode(dst, src []byte) ([]byte, error) { return snappy.Decode(dst, src) }
+
+type lz4Codec struct{}
+
+func (lz4Codec) BlockType() byte                        { return blockTypeLZ4Compression }
+func (lz4Codec) Name() string                           { return "lz4" }
+func (lz4Codec) Encode(dst, src []byte) []byte          { return lz4.Encode(dst, src) }
+func (lz4Codec) DecodedLen(src []byte) (int, error)     { return lz4.DecodedLen(src) }
+func (lz4Codec) Decode(dst, src []byte) ([]byte, error) { return lz4.Decode(dst, src) }
+
+type zstdCodec struct{}
+
+func (zstdCodec) BlockType() byte                        { return blockTypeZstdCompression }
+func (zstdCodec) Name() string                           { return "zstd" }
+func (zstdCodec) Encode(dst, src []byte) []byte          { return zstd.Encode(dst, src) }
+func (zstdCodec) DecodedLen(src []byte) (int, error)     { return zstd.DecodedLen(src) }
+func (zstdCodec) Decode(dst, src []byte) ([]byte, error) { return zstd.Decode(dst, src) }
+
+// The built-in codecs.
+var (
+	NoCodec     Codec = noCodec{}
+	SnappyCodec Codec = snappyCodec{}
+	LZ4Codec    Codec = lz4Codec{}
+	ZstdCodec   Codec = zstdCodec{}
+)
+
+var codecs struct {
+	sync.RWMutex
+	m [256]Codec
+}
+
+func init() {
+	for _, c := range []Codec{NoCodec, SnappyCodec, LZ4Codec, ZstdCodec} {
+		codecs.m[c.BlockType()] = c
+	}
+}
+
+/*
+RegisterCodec makes a codec available to all table readers. It panics, if the block type
+is taken by another codec.
+*/
+func RegisterCodec(c Codec) {
+	codecs.Lock()
+	defer codecs.Unlock()
+	if o := codecs.m[c.BlockType()]; o != nil && o != c {
+		panic(fmt.Sprintf("leveldb/table: block type %#x is taken by codec %q", c.BlockType(), o.Name()))
+	}
+	codecs.m[c.BlockType()] = c
+}
+
+// CodecOf returns the codec of the block type, or nil if it is not registered.
+func CodecOf(blockType byte) Codec {
+	codecs.RLock()
+	defer codecs.RUnlock()
+	return codecs.m[blockType]
+}
@@ -0,0 +1,71 @@
+package table
+
+import (
+	"bytes"
+	"fmt"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/syndtr/goleveldb/leveldb/opt"
+	"github.com/syndtr/goleveldb/leveldb/storage"
+	"github.com/syndtr/goleveldb/leveldb/testutil"
+)
+
+type testCodec struct{ noCodec }
+
+func (testCodec) BlockType() byte { return blockTypeLZ4Compression }
+
+var _ = testutil.Defer(func() {
+	Describe("Table codecs", func() {
+		It("Should read tables mixing several codecs", func() {
+			buf := &bytes.Buffer{}
+			o := &opt.Options{BlockSize: 512, Compression: opt.NoCompression}
+			codecs := []Codec{NoCodec, SnappyCodec, LZ4Codec, ZstdCodec, nil}
+			tw := NewWriter(buf, o)
+			for i := 0; i < 2000; i++ {
+				if i%100 == 0 {
+					tw.SetCodec(codecs[i/100%len(codecs)])
+				}
+				key := fmt.Sprintf("key%06d", i)
+				value := fmt.Sprintf("value of %s, the quick brown fox", key)
+				Expect(tw.Append([]byte(key), []byte(value))).ShouldNot(HaveOccurred())
+			}
+			Expect(tw.Close()).ShouldNot(HaveOccurred())
+			Expect(tw.RawBytesLen()).Should(BeNumerically(">", tw.BytesLen()*3/2))
+
+			tr, err := NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()), storage.FileDesc{}, nil, nil, o)
+			Expect(err).ShouldNot(HaveOccurred())
+			iter := tr.NewIterator(nil, nil)
+			n := 0
+			for ; iter.Next(); n++ {
+				key := fmt.Sprintf("key%06d", n)
+				Expect(string(iter.Key())).Should(Equal(key))
+				Expect(string(iter.Value())).Should(Equal(fmt.Sprintf("value of %s, the quick brown fox", key)))
+			}
+			Expect(iter.Error()).ShouldNot(HaveOccurred())
+			iter.Release()
+			Expect(n).Should(Equal(2000))
+			value, err := tr.Get([]byte("key001234"), nil)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(string(value)).Should(Equal("value of key001234, the quick brown fox"))
+		})
+
+		It("Should keep incompressible blocks uncompressed", func() {
+			buf := &bytes.Buffer{}
+			tw := NewWriter(buf, &opt.Options{Compression: opt.SnappyCompression})
+			tw.SetCodec(ZstdCodec)
+			value := make([]byte, 4000)
+			testutil.NewRand().Read(value)
+			Expect(tw.Append([]byte("k"), value)).ShouldNot(HaveOccurred())
+			Expect(tw.Close()).ShouldNot(HaveOccurred())
+			Expect(tw.BytesLen()).Should(Equal(tw.RawBytesLen()))
+		})
+
+		It("Should refuse to register a taken block type", func() {
+			RegisterCodec(LZ4Codec)
+			Expect(CodecOf(blockTypeLZ4Compression)).Should(Equal(LZ4Codec))
+			Expect(func() { RegisterCodec(testCodec{}) }).Should(Panic())
+		})
+	})
+})
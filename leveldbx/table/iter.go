@@ -0,0 +1,111 @@
+/*
+This is free and unencumbered software released into the public domain.
+
+Anyone is free to copy, modify, publish, use, compile, sell, or
+distribute this software, either in source code form or as a compiled
+binary, for any purpose, commercial or non-commercial, and by any
+means.
+
+In jurisdictions that recognize copyright laws, the author or authors
+of this software dedicate any and all copyright interest in the
+software to the public domain. We make this dedication for the benefit
+of the public at large and to the detriment of our heirs and
+successors. We intend this dedication to be an overt act of
+relinquishment in perpetuity of all present and future rights to this
+software under copyright law.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+IN NO EVENT SHALL THE AUTHORS BE LIABLE FOR ANY CLAIM, DAMAGES OR
+OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE,
+ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR
+OTHER DEALINGS IN THE SOFTWARE.
+
+For more information, please refer to <http://unlicense.org/>
+*/
+
+package table
+
+import (
+	"io"
+)
+
+/*
+IterOptions holds the options of Reader.NewIteratorWith.
+*/
+type IterOptions struct {
+	// Pinned keeps every data block read by the iterator until the iterator is released,
+	// so the slices returned by Value remain valid until then. The keys are assembled in
+	// a buffer of the iterator and are not pinned.
+	Pinned bool
+
+	// Readahead is the number of bytes read at once, when a data block is read from the
+	// file; the following (or, scanning backward, preceding) blocks are served from that
+	// buffer. Zero disables readahead.
+	Readahead int
+}
+
+func (o *IterOptions) GetPinned() bool {
+	if o == nil {
+		return false
+	}
+	return o.Pinned
+}
+
+func (o *IterOptions) GetReadahead() int {
+	if o == nil || o.Readahead < 0 {
+		return 0
+	}
+	return o.Readahead
+}
+
+// The readahead buffer of an iterator.
+type readahead struct {
+	size int
+	off  int64 // The file offset of buf
+	buf  []byte
+}
+
+func newReadahead(o *IterOptions) *readahead {
+	size := o.GetReadahead()
+	if size == 0 {
+		return nil
+	}
+	return &readahead{size: size}
+}
+
+// Reads p at off from r, through the buffer. A read preceding the buffer fills the buffer
+// up to its end, assuming a backward scan.
+func (ra *readahead) readAt(r io.ReaderAt, p []byte, off int64) (n int, err error) {
+	if len(p) >= ra.size {
+		return r.ReadAt(p, off)
+	}
+	end := off + int64(len(p))
+	if off < ra.off || end > ra.off+int64(len(ra.buf)) {
+		start := off
+		if off < ra.off {
+			start = end - int64(ra.size)
+			if start < 0 {
+				start = 0
+			}
+		}
+		if ra.buf == nil {
+			ra.buf = make([]byte, ra.size)
+		}
+		n, err = r.ReadAt(ra.buf[:ra.size], start)
+		if err != nil && err != io.EOF {
+			ra.off, ra.buf = 0, ra.buf[:0]
+			return 0, err
+		}
+		ra.off, ra.buf = start, ra.buf[:n]
+	}
+	if off-ra.off >= int64(len(ra.buf)) {
+		return 0, io.EOF
+	}
+	n = copy(p, ra.buf[off-ra.off:])
+	if n < len(p) {
+		err = io.EOF
+	}
+	return
+}
@@ -0,0 +1,11 @@
+package table
+
+import (
+	"testing"
+
+	"github.com/syndtr/goleveldb/leveldb/testutil"
+)
+
+func TestTable(t *testing.T) {
+	testutil.RunSuite(t, "Table Suite")
+}
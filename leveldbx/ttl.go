@@ -0,0 +1,120 @@
+/*
+This is free and unencumbered software released into the public domain.
+
+Anyone is free to copy, modify, publish, use, compile, sell, or
+distribute this software, either in source code form or as a compiled
+binary, for any purpose, commercial or non-commercial, and by any
+means.
+
+In jurisdictions that recognize copyright laws, the author or authors
+of this software dedicate any and all copyright interest in the
+software to the public domain. We make this dedication for the benefit
+of the public at large and to the detriment of our heirs and
+successors. We intend this dedication to be an overt act of
+relinquishment in perpetuity of all present and future rights to this
+software under copyright law.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+IN NO EVENT SHALL THE AUTHORS BE LIABLE FOR ANY CLAIM, DAMAGES OR
+OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE,
+ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR
+OTHER DEALINGS IN THE SOFTWARE.
+
+For more information, please refer to <http://unlicense.org/>
+*/
+
+package leveldb
+
+import (
+	"encoding/binary"
+	"time"
+
+	"github.com/syndtr/goleveldb/leveldb/opt"
+)
+
+// Length of the expiration deadline, that prefixes values of type keyTypeValTTL.
+const ttlValueLen = 8
+
+func appendTTLValue(dst []byte, deadline int64, value []byte) []byte {
+	var buf [ttlValueLen]byte
+	binary.LittleEndian.PutUint64(buf[:], uint64(deadline))
+	dst = append(dst, buf[:]...)
+	return append(dst, value...)
+}
+
+// Splits the value of an entry into its expiration deadline (in nanoseconds
+// since the unix epoch, or 0 if none) and the user value.
+// Malformed values of type keyTypeValTTL never expire.
+func splitValue(kt keyType, v []byte) (deadline int64, value []byte) {
+	if kt != keyTypeValTTL || len(v) < ttlValueLen {
+		return 0, v
+	}
+	return int64(binary.LittleEndian.Uint64(v)), v[ttlValueLen:]
+}
+
+// Decides whether or not a value of the given type is retained.
+// Entries with an elapsed time-to-live are always dropped, the AutoExpire,
+// if not nil, is consulted with the user value otherwise.
+// Merge operands and range tombstones never expire by themselves.
+func (s *session) retain(kt keyType, ukey, v []byte, aexp AutoExpire) bool {
+	if kt == keyTypeMerge || kt == keyTypeRangeDel {
+		return true
+	}
+	deadline, value := splitValue(kt, v)
+	if deadline != 0 && deadline <= s.now().UnixNano() {
+		return false
+	}
+	if kv, ok := aexp.(AutoExpireKV); ok {
+		return kv.RetainKV(ukey, value, s.now())
+	}
+	return aexp == nil || aexp.Retain(value)
+}
+
+// Checks the result of a lookup against the expiration rules and strips
+// the expiration deadline from the value.
+func (s *session) lookupResult(kt keyType, ukey, v []byte, err error, aexp AutoExpire) (value []byte, deadline int64, rerr error) {
+	if err != nil {
+		return nil, 0, err
+	}
+	if !s.retain(kt, ukey, v, aexp) {
+		return nil, 0, ErrNotFound
+	}
+	deadline, value = splitValue(kt, v)
+	return value, deadline, nil
+}
+
+// PutWithTTL sets the value for the given key, which expires after the given
+// time-to-live. Expired values are neither returned by reads nor retained by
+// compaction, regardless of the AutoExpire in use.
+//
+// It is safe to modify the contents of the arguments after PutWithTTL returns
+// but not before.
+func (db *DB) PutWithTTL(key, value []byte, ttl time.Duration, wo *opt.WriteOptions) error {
+	deadline := db.s.now().Add(ttl).UnixNano()
+	return db.putRec(keyTypeValTTL, key, appendTTLValue(nil, deadline, value), wo)
+}
+
+// GetWithExpiry gets the value for the given key together with its remaining
+// time-to-live. The returned ttl is zero, if the value does not expire by
+// itself. It returns ErrNotFound if the DB does not contains the key or if
+// the value expired.
+//
+// The returned slice is its own copy, it is safe to modify the contents
+// of the returned slice.
+// It is safe to modify the contents of the argument after GetWithExpiry returns.
+func (db *DB) GetWithExpiry(key []byte, ro *opt.ReadOptions) (value []byte, ttl time.Duration, err error) {
+	err = db.ok()
+	if err != nil {
+		return
+	}
+
+	se := db.acquireSnapshot()
+	defer db.releaseSnapshot(se)
+	value, deadline, err := db.getEx(nil, nil, key, se.seq, ro)
+	if err == nil && deadline != 0 {
+		ttl = time.Unix(0, deadline).Sub(db.s.now())
+	}
+	return
+}
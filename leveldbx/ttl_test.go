@@ -0,0 +1,229 @@
+package leveldb
+
+import (
+	"testing"
+	"time"
+
+	"github.com/syndtr/goleveldb/leveldb/memdb"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+)
+
+func (h *dbHarness) putTTL(key, value string, ttl time.Duration) {
+	if err := h.db.PutWithTTL([]byte(key), []byte(value), ttl, h.wo); err != nil {
+		h.t.Error("PutWithTTL: got error: ", err)
+	}
+}
+
+func (h *dbHarness) getTTL(key, value string, maxTTL time.Duration) {
+	v, ttl, err := h.db.GetWithExpiry([]byte(key), h.ro)
+	if err != nil {
+		h.t.Errorf("GetWithExpiry: key '%s' got error: %v", key, err)
+		return
+	}
+	if string(v) != value {
+		h.t.Errorf("GetWithExpiry: invalid value, got '%s', want '%s'", v, value)
+	}
+	if ttl < 0 || ttl > maxTTL || (maxTTL > 0 && ttl == 0) {
+		h.t.Errorf("GetWithExpiry: key '%s' invalid ttl %v, want up to %v", key, ttl, maxTTL)
+	}
+}
+
+func TestDB_PutWithTTL(t *testing.T) {
+	trun(t, func(h *dbHarness) {
+		h.put("a", "old")
+		h.putTTL("a", "new", -time.Second)
+		h.putTTL("b", "v1", time.Hour)
+		h.put("c", "v2")
+		h.putTTL("d", "v3", -time.Second)
+
+		h.get("a", false)
+		h.getVal("b", "v1")
+		h.getTTL("b", "v1", time.Hour)
+		h.getTTL("c", "v2", 0)
+		h.get("d", false)
+		h.has("a", false)
+		h.has("b", true)
+		h.has("d", false)
+		h.getKeyVal("(b->v1)(c->v2)")
+		h.getKeyValReverse("(b->v1)(c->v2)")
+
+		h.reopenDB()
+		h.get("a", false)
+		h.getTTL("b", "v1", time.Hour)
+		h.getKeyVal("(b->v1)(c->v2)")
+
+		h.compactMem()
+		h.get("a", false)
+		h.has("a", false)
+		h.has("b", true)
+		h.getKeyVal("(b->v1)(c->v2)")
+
+		h.compactRange("", "")
+		h.allEntriesFor("a", "[ ]")
+		h.allEntriesFor("d", "[ ]")
+		h.getTTL("b", "v1", time.Hour)
+		h.getKeyVal("(b->v1)(c->v2)")
+	})
+}
+
+type testReplayTTL struct {
+	puts, ttls int
+	deadline   time.Time
+	value      string
+}
+
+func (r *testReplayTTL) Put(key, value []byte) { r.puts++; r.value = string(value) }
+func (r *testReplayTTL) Delete(key []byte)     {}
+func (r *testReplayTTL) PutExpireAt(key, value []byte, expireAt time.Time) {
+	r.ttls++
+	r.value = string(value)
+	r.deadline = expireAt
+}
+
+func TestBatch_PutExpireAt(t *testing.T) {
+	deadline := time.Unix(1500000000, 0)
+	b := new(Batch)
+	b.PutExpireAt([]byte("key"), []byte("value"), deadline)
+
+	b2 := new(Batch)
+	if err := b2.Load(b.Dump()); err != nil {
+		t.Fatal("Load: got error: ", err)
+	}
+
+	r := new(testReplayTTL)
+	b2.Replay(r)
+	if r.ttls != 1 || r.puts != 0 || r.value != "value" || !r.deadline.Equal(deadline) {
+		t.Errorf("Replay: invalid result %+v", r)
+	}
+
+	plain := new(testBatchReplay)
+	b2.Replay(plain)
+	if len(plain.kv) != 1 || string(plain.kv[0].v) != "value" {
+		t.Errorf("Replay: invalid plain result %+v", plain.kv)
+	}
+}
+
+func (r *testReplayTTL) PutTTL(key, value []byte, ttl time.Duration) {
+	r.ttls++
+	r.value = string(value)
+	r.deadline = time.Unix(0, int64(ttl))
+}
+
+func TestBatch_PutTTL(t *testing.T) {
+	b := new(Batch)
+	b.PutTTL([]byte("key"), []byte("value"), time.Minute)
+
+	b2 := new(Batch)
+	if err := b2.Load(b.Dump()); err != nil {
+		t.Fatal("Load: got error: ", err)
+	}
+	r := new(testReplayTTL)
+	b2.Replay(r)
+	if r.ttls != 1 || r.puts != 0 || r.value != "value" || r.deadline.UnixNano() != int64(time.Minute) {
+		t.Errorf("Replay: invalid result %+v", r)
+	}
+
+	now := time.Unix(1500000000, 0)
+	rb := b2.resolveTTL(now)
+	r = new(testReplayTTL)
+	rb.Replay(r)
+	if r.ttls != 1 || !r.deadline.Equal(now.Add(time.Minute)) {
+		t.Errorf("Replay: invalid resolved result %+v", r)
+	}
+	if rb.resolveTTL(now) != rb {
+		t.Error("resolveTTL: copied a resolved batch")
+	}
+
+	// Journals hold resolved records only.
+	data := append(encodeBatchHeader(nil, 1, b.Len()), b.Dump()...)
+	if _, _, err := decodeBatchToMem(data, 0, memdb.New(defaultIComparer, 0)); err == nil {
+		t.Error("decodeBatchToMem: want error for an unresolved time-to-live")
+	}
+}
+
+func TestDB_WriteBatchPutTTL(t *testing.T) {
+	c := &testClock{now: 1000}
+	h := new(dbHarness)
+	h.conf = &Config{Expiration: Expiration{Clock: c.get}}
+	h.init(t, &opt.Options{DisableLargeBatchTransaction: true})
+	defer h.close()
+
+	b := new(Batch)
+	b.PutTTL([]byte("a"), []byte("v1"), time.Minute)
+	c.set(1100)
+	h.write(b)
+	h.getTTL("a", "v1", time.Minute)
+	c.set(1159)
+	h.getVal("a", "v1")
+
+	// The deadline is resolved again, as the batch is written again.
+	h.write(b)
+	c.set(1200)
+	h.getVal("a", "v1")
+	c.set(1220)
+	h.get("a", false)
+
+	tr, err := h.db.OpenTransaction()
+	if err != nil {
+		t.Fatal("OpenTransaction: got error: ", err)
+	}
+	b = new(Batch)
+	b.PutTTL([]byte("b"), []byte("v2"), time.Minute)
+	if err := tr.Write(b, nil); err != nil {
+		t.Fatal("Transaction.Write: got error: ", err)
+	}
+	if err := tr.Commit(); err != nil {
+		t.Fatal("Commit: got error: ", err)
+	}
+	h.getTTL("b", "v2", time.Minute)
+	c.set(1300)
+	h.get("b", false)
+
+	h.write(b)
+	h.reopenDB()
+	h.getTTL("b", "v2", time.Minute)
+	c.set(1400)
+	h.get("b", false)
+}
+
+type testBatchReplay struct {
+	kv []batchKV
+}
+
+func (r *testBatchReplay) Put(key, value []byte) {
+	r.kv = append(r.kv, batchKV{kt: keyTypeVal, k: key, v: value})
+}
+
+func (r *testBatchReplay) Delete(key []byte) {
+	r.kv = append(r.kv, batchKV{kt: keyTypeDel, k: key})
+}
+
+func TestDB_WriteBatchTTL(t *testing.T) {
+	trun(t, func(h *dbHarness) {
+		b := new(Batch)
+		b.PutExpireAt([]byte("a"), []byte("v1"), time.Now().Add(time.Hour))
+		b.PutExpireAt([]byte("b"), []byte("v2"), time.Now().Add(-time.Second))
+		b.Put([]byte("c"), []byte("v3"))
+		h.write(b)
+
+		h.getTTL("a", "v1", time.Hour)
+		h.get("b", false)
+		h.getKeyVal("(a->v1)(c->v3)")
+
+		tr, err := h.db.OpenTransaction()
+		if err != nil {
+			t.Fatal("OpenTransaction: got error: ", err)
+		}
+		b = new(Batch)
+		b.PutExpireAt([]byte("c"), []byte("v4"), time.Now().Add(-time.Second))
+		if err := tr.Write(b, nil); err != nil {
+			t.Fatal("Transaction.Write: got error: ", err)
+		}
+		h.getr(tr, "c", false)
+		if err := tr.Commit(); err != nil {
+			t.Fatal("Commit: got error: ", err)
+		}
+		h.get("c", false)
+		h.getKeyVal("(a->v1)")
+	})
+}
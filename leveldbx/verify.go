@@ -0,0 +1,324 @@
+/*
+This is free and unencumbered software released into the public domain.
+
+Anyone is free to copy, modify, publish, use, compile, sell, or
+distribute this software, either in source code form or as a compiled
+binary, for any purpose, commercial or non-commercial, and by any
+means.
+
+In jurisdictions that recognize copyright laws, the author or authors
+of this software dedicate any and all copyright interest in the
+software to the public domain. We make this dedication for the benefit
+of the public at large and to the detriment of our heirs and
+successors. We intend this dedication to be an overt act of
+relinquishment in perpetuity of all present and future rights to this
+software under copyright law.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT.
+IN NO EVENT SHALL THE AUTHORS BE LIABLE FOR ANY CLAIM, DAMAGES OR
+OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE,
+ARISING FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR
+OTHER DEALINGS IN THE SOFTWARE.
+
+For more information, please refer to <http://unlicense.org/>
+*/
+
+package leveldb
+
+import (
+	"context"
+
+	"github.com/maxymania/storage-engines/leveldbx/table"
+	"github.com/syndtr/goleveldb/leveldb/errors"
+	"github.com/syndtr/goleveldb/leveldb/iterator"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+	"github.com/syndtr/goleveldb/leveldb/storage"
+)
+
+var errVerifyBounds = errors.New("leveldb: table keys out of bounds")
+
+/*
+VerifyReport lists the damaged tables found by DB.Verify or DB.Repair.
+*/
+type VerifyReport struct {
+	Tables  int   // The live tables verified
+	Entries int64 // The readable entries
+	Damaged []TableDamage
+}
+
+/*
+TableDamage describes a damaged table. Ranges lists the user key ranges, whose entries
+may be lost; the bounds are inclusive, and nil stands for the start or end of the table.
+*/
+type TableDamage struct {
+	Level int
+	Num   int64
+
+	Ranges []KeyRange
+	Blocks int   // The corrupted blocks
+	Keys   int   // The invalid, misordered or out of bounds keys
+	Err    error // The first error
+
+	// Set by Repair: the readable entries written into the replacement table, and the
+	// temp file, the damaged table was moved to.
+	Salvaged   int
+	Quarantine storage.FileDesc
+}
+
+// KeyRange is a range of user keys.
+type KeyRange struct {
+	Start, Limit []byte
+}
+
+/*
+Scans the entries of a table, skipping the damaged blocks and keys; fn, if not nil,
+receives the good entries. It returns the damage, or nil if the table is intact.
+*/
+func (db *DB) scanTable(ctx context.Context, level int, f *tFile, fn func(key, value []byte) error) (d *TableDamage, n int64, err error) {
+	dmg := &TableDamage{Level: level, Num: f.fd.Num}
+	var (
+		prev, last []byte
+		open       bool // A damaged range waits for its limit
+	)
+	damage := func(derr error) {
+		if dmg.Err == nil {
+			dmg.Err = derr
+		}
+		if !open {
+			dmg.Ranges = append(dmg.Ranges, KeyRange{Start: last})
+		}
+		open = true
+	}
+
+	reader, err := db.s.stor.Open(f.fd)
+	if err != nil {
+		if !errors.IsCorrupted(err) {
+			return nil, 0, err
+		}
+		damage(err)
+		return dmg, 0, nil
+	}
+	o := *db.s.o.Options
+	o.Strict |= opt.StrictBlockChecksum
+	tr, err := table.NewReader(reader, f.size, f.fd, nil, nil, &o)
+	if err != nil {
+		reader.Close()
+		if !errors.IsCorrupted(err) {
+			return nil, 0, err
+		}
+		damage(err)
+		return dmg, 0, nil
+	}
+	// Closes the reader as well.
+	defer tr.Release()
+
+	var iter iterator.Iterator = tr.NewIterator(nil, &opt.ReadOptions{DontFillCache: true})
+	if ec, ok := iter.(iterator.ErrorCallbackSetter); ok {
+		ec.SetErrorCallback(func(err error) {
+			if errors.IsCorrupted(err) {
+				dmg.Blocks++
+				damage(err)
+			}
+		})
+	}
+	if f.seq != 0 {
+		iter = &tIngestedIter{Iterator: iter, icmp: db.s.icmp, seq: f.seq}
+	}
+	defer iter.Release()
+
+	icmp := db.s.icmp
+	umin, umax := f.imin.ukey(), f.imax.ukey()
+	for iter.Next() {
+		if n&0x3ff == 0 {
+			if err = ctx.Err(); err != nil {
+				return nil, 0, err
+			}
+		}
+		key := iter.Key()
+		ukey, _, _, kerr := parseInternalKey(key)
+		switch {
+		case kerr != nil:
+			dmg.Keys++
+			damage(kerr)
+			continue
+		case prev != nil && icmp.Compare(prev, key) >= 0:
+			dmg.Keys++
+			damage(errors.NewErrCorrupted(f.fd, ErrKeyOrder))
+			continue
+		case icmp.uCompare(ukey, umin) < 0 || icmp.uCompare(ukey, umax) > 0:
+			dmg.Keys++
+			damage(errors.NewErrCorrupted(f.fd, errVerifyBounds))
+			continue
+		}
+		if open {
+			dmg.Ranges[len(dmg.Ranges)-1].Limit = append([]byte{}, ukey...)
+			open = false
+		}
+		prev = append(prev[:0], key...)
+		last = append([]byte{}, ukey...)
+		n++
+		if fn != nil {
+			if err = fn(key, iter.Value()); err != nil {
+				return nil, 0, err
+			}
+		}
+	}
+	if err = iter.Error(); err != nil {
+		if !errors.IsCorrupted(err) {
+			return nil, 0, err
+		}
+		damage(err)
+	}
+	if dmg.Err == nil {
+		return nil, n, nil
+	}
+	return dmg, n, nil
+}
+
+// Verifies the tables of the version.
+func (db *DB) verifyVersion(ctx context.Context, v *version) (*VerifyReport, error) {
+	rep := new(VerifyReport)
+	for level, tables := range v.levels {
+		for _, t := range tables {
+			d, n, err := db.scanTable(ctx, level, t, nil)
+			if err != nil {
+				return nil, err
+			}
+			rep.Tables++
+			rep.Entries += n
+			if d != nil {
+				db.logf("table@verify damaged L%d@%d Cb·%d Ck·%d E·%q", level, t.fd.Num, d.Blocks, d.Keys, d.Err)
+				rep.Damaged = append(rep.Damaged, *d)
+			}
+		}
+	}
+	return rep, nil
+}
+
+/*
+Verify reads every block of every live table, checks the block checksums, the order of
+the keys and the bounds of the tables, and reports the damaged tables and the key ranges
+affected. Damage is not an error of Verify; see DB.Repair to remove it.
+*/
+func (db *DB) Verify(ctx context.Context) (*VerifyReport, error) {
+	if err := db.ok(); err != nil {
+		return nil, err
+	}
+	v := db.s.version()
+	defer v.release()
+	return db.verifyVersion(ctx, v)
+}
+
+/*
+Repair verifies the database like Verify, and replaces each damaged table by a table of
+its readable entries. The damaged table is quarantined as a temp file of the same number,
+which is neither read nor removed by the database.
+*/
+func (db *DB) Repair(ctx context.Context) (*VerifyReport, error) {
+	if err := db.ok(); err != nil {
+		return nil, err
+	}
+	rep := new(VerifyReport)
+	if err := db.compTriggerRepair(db.tcompCmdC, ctx, rep); err != nil {
+		return nil, err
+	}
+	return rep, nil
+}
+
+// Salvages the readable entries of a damaged table into a new one, or returns nil if
+// there are none.
+func (db *DB) salvageTable(ctx context.Context, level int, t *tFile) (f *tFile, n int64, err error) {
+	w, err := db.s.tops.create(level)
+	if err != nil {
+		return nil, 0, err
+	}
+	_, n, err = db.scanTable(ctx, level, t, w.append)
+	if err != nil || w.empty() {
+		w.drop()
+		return nil, 0, err
+	}
+	if f, err = w.finish(); err != nil {
+		w.drop()
+		return nil, 0, err
+	}
+	return f, n, nil
+}
+
+func (db *DB) tableRepair(ctx context.Context, rep *VerifyReport) error {
+	v := db.s.version()
+	defer v.release()
+	r, err := db.verifyVersion(ctx, v)
+	if err != nil {
+		return err
+	}
+	*rep = *r
+	if len(rep.Damaged) == 0 {
+		return nil
+	}
+
+	rec := &sessionRecord{}
+	for i := range rep.Damaged {
+		d := &rep.Damaged[i]
+		var t *tFile
+		for _, x := range v.levels[d.Level] {
+			if x.fd.Num == d.Num {
+				t = x
+			}
+		}
+		f, n, err := db.salvageTable(ctx, d.Level, t)
+		if err != nil {
+			return err
+		}
+		rec.delTable(d.Level, t.fd.Num)
+		if f != nil {
+			rec.addTableFile(d.Level, f)
+			d.Salvaged = int(n)
+		}
+		t.quarantine = true
+		d.Quarantine = storage.FileDesc{Type: storage.TypeTemp, Num: t.fd.Num}
+		db.logf("table@repair L%d@%d salvaged N·%d", d.Level, t.fd.Num, n)
+	}
+	db.compactionCommit("repair", rec)
+	return nil
+}
+
+type cRepair struct {
+	ctx  context.Context
+	rep  *VerifyReport
+	ackC chan<- error
+}
+
+func (r cRepair) ack(err error) {
+	if r.ackC != nil {
+		defer func() {
+			recover()
+		}()
+		r.ackC <- err
+	}
+}
+
+// Send repair request.
+func (db *DB) compTriggerRepair(compC chan<- cCmd, ctx context.Context, rep *VerifyReport) (err error) {
+	ch := make(chan error)
+	defer close(ch)
+	// Send cmd.
+	select {
+	case compC <- cRepair{ctx, rep, ch}:
+	case err := <-db.compErrC:
+		return err
+	case <-db.closeC:
+		return ErrClosed
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	// Wait cmd.
+	select {
+	case err = <-ch:
+	case err = <-db.compErrC:
+	case <-db.closeC:
+		return ErrClosed
+	}
+	return err
+}
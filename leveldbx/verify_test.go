@@ -0,0 +1,103 @@
+package leveldb
+
+import (
+	"context"
+	"testing"
+
+	"github.com/syndtr/goleveldb/leveldb/storage"
+)
+
+func (h *dbCorruptHarness) verify() *VerifyReport {
+	rep, err := h.db.Verify(context.Background())
+	if err != nil {
+		h.t.Fatal("Verify: got error: ", err)
+	}
+	return rep
+}
+
+func TestDB_Verify(t *testing.T) {
+	h := newDbCorruptHarness(t)
+	defer h.close()
+
+	h.build(100)
+	h.compactMem()
+	h.compactRangeAt(0, "", "")
+	h.compactRangeAt(1, "", "")
+	if rep := h.verify(); len(rep.Damaged) != 0 || rep.Tables != 1 || rep.Entries != 100 {
+		t.Fatalf("intact DB: got %+v", rep)
+	}
+	h.closeDB()
+	h.corrupt(storage.TypeTable, -1, 100, 1)
+	h.openDB()
+
+	rep := h.verify()
+	// The corrupted block holds the first 5 entries.
+	if len(rep.Damaged) != 1 || rep.Entries != 95 {
+		t.Fatalf("want a damaged table, got %+v", rep)
+	}
+	d := rep.Damaged[0]
+	if d.Level != 2 || d.Blocks != 1 || d.Keys != 0 || d.Err == nil || len(d.Ranges) != 1 {
+		t.Fatalf("unexpected damage %+v", d)
+	}
+	if r := d.Ranges[0]; r.Start != nil || string(r.Limit) != string(tkey(5)) {
+		t.Errorf("want the range up to %q, got %q:%q", tkey(5), r.Start, r.Limit)
+	}
+	h.check(99, 99)
+}
+
+func TestDB_Repair(t *testing.T) {
+	h := newDbCorruptHarness(t)
+	defer h.close()
+
+	h.build(100)
+	h.compactMem()
+	h.compactRangeAt(0, "", "")
+	h.compactRangeAt(1, "", "")
+	h.closeDB()
+	h.corrupt(storage.TypeTable, -1, 100, 1)
+	h.openDB()
+
+	rep, err := h.db.Repair(context.Background())
+	if err != nil {
+		t.Fatal("Repair: got error: ", err)
+	}
+	if len(rep.Damaged) != 1 || rep.Damaged[0].Salvaged != 95 {
+		t.Fatalf("want 95 entries salvaged, got %+v", rep)
+	}
+	q := rep.Damaged[0].Quarantine
+	if rep := h.verify(); len(rep.Damaged) != 0 || rep.Entries != 95 {
+		t.Fatalf("repaired DB: got %+v", rep)
+	}
+	h.check(95, 95)
+
+	// The quarantined table survives a reopen.
+	h.reopenDB()
+	h.check(95, 95)
+	fds, err := h.stor.List(storage.TypeTemp)
+	if err != nil || len(fds) != 1 || fds[0] != q {
+		t.Fatalf("want the quarantined table %s, got %v %v", q, fds, err)
+	}
+}
+
+func TestDB_RepairIndex(t *testing.T) {
+	h := newDbCorruptHarness(t)
+	defer h.close()
+
+	h.build(10000)
+	h.compactMem()
+	h.closeDB()
+	h.corrupt(storage.TypeTable, -1, -2000, 500)
+	h.openDB()
+
+	rep, err := h.db.Repair(context.Background())
+	if err != nil {
+		t.Fatal("Repair: got error: ", err)
+	}
+	if len(rep.Damaged) != 1 || rep.Damaged[0].Ranges[0].Start != nil || rep.Damaged[0].Ranges[0].Limit != nil {
+		t.Fatalf("want an unreadable table, got %+v", rep)
+	}
+	if rep := h.verify(); len(rep.Damaged) != 0 {
+		t.Fatalf("repaired DB: got %+v", rep)
+	}
+	h.check(5000, 9999)
+}
@@ -11,6 +11,7 @@ import (
 	"sync/atomic"
 	"unsafe"
 
+	"github.com/maxymania/storage-engines/leveldbx/table"
 	"github.com/syndtr/goleveldb/leveldb/iterator"
 	"github.com/syndtr/goleveldb/leveldb/opt"
 	"github.com/syndtr/goleveldb/leveldb/util"
@@ -26,6 +27,10 @@ type version struct {
 
 	levels []tFiles
 
+	// Extension: the range tombstones of the tables, and their fragments.
+	rdels  rangeTombstones
+	rfrags rangeFragments
+
 	// Level that should be compacted next and its compaction score.
 	// Score < 1 means compaction is not strictly needed. These fields
 	// are initialized by computeCompaction()
@@ -135,9 +140,10 @@ func (v *version) walkOverlapping(aux tFiles, ikey internalKey, f func(level int
 	}
 }
 
-func (v *version) get(aux tFiles, ikey internalKey, ro *opt.ReadOptions, noValue bool) (value []byte, tcomp bool, err error) {
+// Entries older than cover are deleted by a range tombstone.
+func (v *version) get(aux tFiles, ikey internalKey, cover uint64, ro *opt.ReadOptions, noValue bool) (value []byte, kt keyType, tcomp bool, err error) {
 	if v.closing {
-		return nil, false, ErrClosed
+		return nil, 0, false, ErrClosed
 	}
 
 	ukey := ikey.ukey()
@@ -196,11 +202,15 @@ func (v *version) get(aux tFiles, ikey internalKey, ro *opt.ReadOptions, noValue
 						zval = fval
 					}
 				} else {
+					if fseq < cover {
+						fkt = keyTypeDel
+					}
 					switch fkt {
-					case keyTypeVal:
+					case keyTypeVal, keyTypeValTTL, keyTypeMerge:
 						value = fval
+						kt = fkt
 						err = nil
-					case keyTypeDel:
+					case keyTypeDel, keyTypeRangeDel:
 					default:
 						panic("leveldb: invalid internalKey type")
 					}
@@ -215,11 +225,15 @@ func (v *version) get(aux tFiles, ikey internalKey, ro *opt.ReadOptions, noValue
 		return true
 	}, func(level int) bool {
 		if zfound {
+			if zseq < cover {
+				zkt = keyTypeDel
+			}
 			switch zkt {
-			case keyTypeVal:
+			case keyTypeVal, keyTypeValTTL, keyTypeMerge:
 				value = zval
+				kt = zkt
 				err = nil
-			case keyTypeDel:
+			case keyTypeDel, keyTypeRangeDel:
 			default:
 				panic("leveldb: invalid internalKey type")
 			}
@@ -253,16 +267,34 @@ func (v *version) sampleSeek(ikey internalKey) (tcomp bool) {
 	return
 }
 
-func (v *version) getIterators(slice *util.Range, ro *opt.ReadOptions) (its []iterator.Iterator) {
+func (v *version) getIterators(slice *util.Range, ro *opt.ReadOptions, to *table.IterOptions) (its []iterator.Iterator) {
 	strict := opt.GetStrict(v.s.o.Options, ro, opt.StrictReader)
+	prefix := v.s.prefixOf(slice)
+	var umin, umax []byte
+	if slice != nil {
+		if slice.Start != nil {
+			umin = internalKey(slice.Start).ukey()
+		}
+		if slice.Limit != nil {
+			umax = internalKey(slice.Limit).ukey()
+		}
+	}
 	for level, tables := range v.levels {
+		if prefix != nil {
+			// Skip the tables without the prefix.
+			tables = v.s.tops.prefixTables(tables, prefix, ro)
+		}
 		if level == 0 {
 			// Merge all level zero files together since they may overlap.
 			for _, t := range tables {
-				its = append(its, v.s.tops.newIterator(t, slice, ro))
+				// Skip the tables outside of the slice, without opening them.
+				if slice != nil && !t.overlaps(v.s.icmp, umin, umax) {
+					continue
+				}
+				its = append(its, v.s.tops.newIterator(t, slice, ro, to))
 			}
 		} else if len(tables) != 0 {
-			its = append(its, iterator.NewIndexedIterator(tables.newIndexIterator(v.s.tops, v.s.icmp, slice, ro), strict))
+			its = append(its, iterator.NewIndexedIterator(tables.newIndexIterator(v.s.tops, v.s.icmp, slice, ro, to), strict))
 		}
 	}
 	return
@@ -508,6 +540,7 @@ func (p *versionStaging) finish() *version {
 
 	// Compute compaction score for new version.
 	nv.computeCompaction()
+	nv.collectRangeDels()
 
 	return nv
 }
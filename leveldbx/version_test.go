@@ -19,7 +19,7 @@ func TestVersionStaging(t *testing.T) {
 	gomega.RegisterTestingT(t)
 	stor := testutil.NewStorage()
 	defer stor.Close()
-	s, err := newSession(stor, nil)
+	s, err := newSession(stor, nil, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -35,8 +35,11 @@ type cElement struct{
 	refc  int64
 	value Releaser
 }
+func (c *cElement) acquire(){
+	atomic.AddInt64(&(c.refc),1)
+}
 func (c *cElement) release(){
-	if atomic.AddInt64(&(c.refc),-1)<1 { return }
+	if atomic.AddInt64(&(c.refc),-1)>0 { return }
 	c.value.Release()
 }
 func cElementEvict(k, v interface{}) {
@@ -63,7 +66,7 @@ func (c *cCache) get(k interface{}) *cElement {
 	ve := c.vG(k)
 	if ve==nil { return nil }
 	elem := &cElement{value:ve}
-	atomic.StoreInt64(&(elem.refc),1)
+	atomic.StoreInt64(&(elem.refc),2) // One reference for the cache, one for the caller.
 	c.lru.Add(k,elem)
 	return elem
 }
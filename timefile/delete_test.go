@@ -0,0 +1,66 @@
+package timefile
+
+import "testing"
+
+func TestStore_Delete(t *testing.T) {
+	dir := t.TempDir()
+	s := openTestStore(t, dir, nil)
+	exp := current + 3600
+
+	if err := s.Insert([]byte("a"), []byte("hello"), exp); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Insert([]byte("b"), []byte("world!"), exp); err != nil {
+		t.Fatal(err)
+	}
+	h, _ := s.Stat([]byte("a"))
+	if err := s.Delete([]byte("a")); err != nil {
+		t.Fatal("Delete: got error: ", err)
+	}
+	getError(t, s, "a", ENotFound)
+	getValue(t, s, "b", "world!")
+	if err := s.Delete([]byte("a")); err != ENotFound {
+		t.Fatalf("Delete of a deleted key: want %v, got %v", ENotFound, err)
+	}
+
+	// The key can be re-inserted; the deleted BLOB remains as dead space.
+	if err := s.Insert([]byte("a"), []byte("again"), exp); err != nil {
+		t.Fatal("re-Insert: got error: ", err)
+	}
+	getValue(t, s, "a", "again")
+	if n, err := s.Alloc.DeadBytes(h.FileID); err != nil || n != 5 {
+		t.Fatalf("DeadBytes: want 5, got %d %v", n, err)
+	}
+
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	s = openTestStore(t, dir, nil)
+	defer s.Close()
+	if err := s.Delete([]byte("b")); err != nil {
+		t.Fatal(err)
+	}
+	m, err := s.DeadSpace()
+	if err != nil || len(m) != 1 || m[h.FileID] != 11 {
+		t.Fatalf("DeadSpace: want %d:11, got %v %v", h.FileID, m, err)
+	}
+}
+
+func TestStore_DeleteExpired(t *testing.T) {
+	s := openTestStore(t, t.TempDir(), nil)
+	defer s.Close()
+
+	// Dead space of expired time-files is neither accounted nor reported.
+	if err := s.Alloc.AddDeadBytes(current-3600, 10); err != nil {
+		t.Fatal(err)
+	}
+	if m, err := s.DeadSpace(); err != nil || len(m) != 0 {
+		t.Fatalf("DeadSpace: want none, got %v %v", m, err)
+	}
+	if err := s.addDead(storeHeader{FileID: current - 3600, Length: 10}); err != nil {
+		t.Fatal(err)
+	}
+	if n, _ := s.Alloc.DeadBytes(current - 3600); n != 10 {
+		t.Fatalf("DeadBytes: want the expired file unaccounted, got %d", n)
+	}
+}
@@ -0,0 +1,200 @@
+/*
+Copyright (c) 2018 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+package timefile
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/maxymania/storage-engines/leveldbx"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+)
+
+// Controls, when the BLOBs and their index entries are written to stable storage.
+type Durability int
+const (
+	// Nothing is synced. A crash may leave index entries pointing beyond the end of a time-file.
+	DurabilityNone Durability = iota
+	
+	// Every BLOB is fsync-ed, before its index entry is written. The index entry is synced as well.
+	DurabilitySync
+	
+	/*
+	Like DurabilitySync, but the writers of a GroupCommit interval are committed together:
+	Every time-file is fsync-ed once per group, and the index entries of the group are written
+	in a single synced write.
+	*/
+	DurabilityGroup
+)
+
+const defGroupCommit = 10*time.Millisecond
+
+var wsync = &opt.WriteOptions{ Sync:true, }
+
+func (s *Store) writeOptions() *opt.WriteOptions {
+	if s.Durability==DurabilityNone { return wopt }
+	return wsync
+}
+
+/*
+Writes the index records, after the data appended to f (if any) has been made durable according
+to s.Durability.
+*/
+func (s *Store) commit(f *iFile, rec func(b *leveldb.Batch)) error {
+	switch s.Durability {
+	case DurabilityGroup:
+		return s.group.commit(s.DB,f,rec,s.GroupCommit)
+	case DurabilitySync:
+		if f!=nil {
+			err := f.Sync()
+			if err!=nil { return err }
+		}
+	}
+	var b leveldb.Batch
+	rec(&b)
+	return s.DB.Write(&b,s.writeOptions())
+}
+
+type syncGroup struct{
+	files map[*iFile]bool
+	batch leveldb.Batch
+	done  chan struct{}
+	err   error
+}
+
+/*
+Group commit: The first writer of a group waits for the interval, then it syncs the files of all
+writers, that joined the group in the meantime, and writes their index records in a single synced
+write. The writers hold their files and key locks, until the group is done.
+*/
+type groupCommit struct{
+	sync.Mutex
+	cur *syncGroup
+}
+func (g *groupCommit) commit(db *leveldb.DB, f *iFile, rec func(b *leveldb.Batch), interval time.Duration) error {
+	if interval<=0 { interval = defGroupCommit }
+	g.Lock()
+	grp := g.cur
+	leader := grp==nil
+	if leader {
+		grp = &syncGroup{files:make(map[*iFile]bool),done:make(chan struct{})}
+		g.cur = grp
+	}
+	if f!=nil { grp.files[f] = true }
+	rec(&grp.batch)
+	g.Unlock()
+	
+	if !leader {
+		<- grp.done
+		return grp.err
+	}
+	time.Sleep(interval)
+	g.Lock()
+	g.cur = nil
+	g.Unlock()
+	for f := range grp.files {
+		if e := f.Sync(); e!=nil && grp.err==nil { grp.err = e }
+	}
+	if grp.err==nil { grp.err = db.Write(&grp.batch,wsync) }
+	close(grp.done)
+	return grp.err
+}
+
+/*
+Drops all index entries, whose BLOBs exceed the actual size of their time-file, as it happens,
+if the time-file has not been synced before a crash. Returns the number of dropped entries.
+OpenStore calls Recover, if the store has not been closed cleanly.
+*/
+func (s *Store) Recover() (n int, err error) {
+	sizes := make(map[uint64]int64)
+	var b leveldb.Batch
+	iter := s.DB.NewIterator(nil,nil)
+	defer iter.Release()
+	for iter.Next() {
+		var p storeHeader
+		if p.decode(iter.Value())!=nil { continue }
+		size,ok := sizes[p.FileID]
+		if !ok {
+			fi,e := os.Stat(s.Alloc.GetPath(p.FileID))
+			if e!=nil && !os.IsNotExist(e) { return n,e }
+			if e==nil { size = fi.Size() }
+			sizes[p.FileID] = size
+		}
+		if p.Offset+p.Length <= size { continue }
+		b.Delete(iter.Key())
+		n++
+		if b.Len()>=1024 {
+			err = s.DB.Write(&b,s.writeOptions())
+			if err!=nil { return }
+			b.Reset()
+		}
+	}
+	err = iter.Error()
+	if err!=nil { return }
+	if b.Len()>0 { err = s.DB.Write(&b,s.writeOptions()) }
+	return
+}
+
+// Written by Store.Close and removed by OpenStore. Without it, the store has not been closed cleanly.
+const cleanMarker = "clean.tf"
+
+func syncDir(dir string) error {
+	d,err := os.Open(dir)
+	if err!=nil { return err }
+	defer d.Close()
+	return d.Sync()
+}
+
+/*
+Removes the clean-close marker. Returns true, if the store has been closed cleanly. The removal
+is synced, so that a crash of this instance is not mistaken for a clean close.
+*/
+func takeCleanMarker(base string) bool {
+	if os.Remove(filepath.Join(base,cleanMarker))!=nil { return false }
+	return syncDir(base)==nil
+}
+
+func putCleanMarker(base string) error {
+	f,err := os.Create(filepath.Join(base,cleanMarker))
+	if err!=nil { return err }
+	err = f.Sync()
+	if e := f.Close(); err==nil { err = e }
+	if err!=nil { return err }
+	return syncDir(base)
+}
+
+/*
+Closes the store. The unexpired time-files are synced, so that the next OpenStore does not need
+to recover the index.
+*/
+func (s *Store) Close() error {
+	s.files.purge()
+	err := s.Alloc.SyncFiles()
+	if e := s.DB.Close(); err==nil { err = e }
+	if e := s.Alloc.DB.Close(); err==nil { err = e }
+	if err!=nil { return err }
+	return putCleanMarker(s.Alloc.Path)
+}
@@ -0,0 +1,112 @@
+package timefile
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestStore_Recover(t *testing.T) {
+	dir := t.TempDir()
+	s := openTestStore(t, dir, nil)
+	exp := current + 3600
+	for i := 0; i < 10; i++ {
+		if err := s.Insert([]byte(fmt.Sprint("k", i)), []byte("0123456789"), exp); err != nil {
+			t.Fatal(err)
+		}
+	}
+	h, _ := s.Stat([]byte("k0"))
+	crashStore(s)
+
+	// The last three BLOBs did not reach the disk.
+	if err := os.Truncate(s.Alloc.GetPath(h.FileID), 75); err != nil {
+		t.Fatal(err)
+	}
+	s = openTestStore(t, dir, nil)
+	defer s.Close()
+	for i := 0; i < 10; i++ {
+		if key := fmt.Sprint("k", i); i < 7 {
+			getValue(t, s, key, "0123456789")
+		} else {
+			getError(t, s, key, ENotFound)
+		}
+	}
+	if n, err := s.Recover(); n != 0 || err != nil {
+		t.Fatalf("Recover: want nothing to drop, got %d %v", n, err)
+	}
+}
+
+func TestStore_CleanClose(t *testing.T) {
+	dir := t.TempDir()
+	s := openTestStore(t, dir, nil)
+	s.Insert([]byte("k"), []byte("0123456789"), current+3600)
+	h, _ := s.Stat([]byte("k"))
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, cleanMarker)); err != nil {
+		t.Fatal("want a clean-close marker, got: ", err)
+	}
+
+	// After a clean close, the index is not checked against the time-files.
+	os.Truncate(s.Alloc.GetPath(h.FileID), 0)
+	s = openTestStore(t, dir, nil)
+	if _, err := s.Stat([]byte("k")); err != nil {
+		t.Fatal("want the index unchanged, got: ", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, cleanMarker)); !os.IsNotExist(err) {
+		t.Fatal("want the clean-close marker removed, got: ", err)
+	}
+	crashStore(s)
+
+	s = openTestStore(t, dir, nil)
+	defer s.Close()
+	if _, err := s.Stat([]byte("k")); err != ENotFound {
+		t.Fatalf("after a crash: want %v, got %v", ENotFound, err)
+	}
+}
+
+func TestStore_Durability(t *testing.T) {
+	for _, d := range []Durability{DurabilityNone, DurabilitySync, DurabilityGroup} {
+		dir := t.TempDir()
+		const interval = 50 * time.Millisecond
+		s := openTestStore(t, dir, &Options{Durability: d, GroupCommit: interval})
+		exp := current + 3600
+
+		var wg sync.WaitGroup
+		start := time.Now()
+		for i := 0; i < 32; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				key := []byte(fmt.Sprint("k", i))
+				if err := s.Insert(key, []byte(fmt.Sprint("v", i)), exp); err != nil {
+					t.Error(err)
+				}
+				if i%2 == 1 {
+					if err := s.Delete(key); err != nil {
+						t.Error(err)
+					}
+				}
+			}(i)
+		}
+		wg.Wait()
+		if d == DurabilityGroup && time.Since(start) > 16*interval {
+			t.Errorf("want the writers committed in groups, took %v", time.Since(start))
+		}
+		crashStore(s)
+
+		s = openTestStore(t, dir, &Options{Durability: d})
+		for i := 0; i < 32; i++ {
+			if key := fmt.Sprint("k", i); i%2 == 0 {
+				getValue(t, s, key, fmt.Sprint("v", i))
+			} else {
+				getError(t, s, key, ENotFound)
+			}
+		}
+		s.Close()
+	}
+}
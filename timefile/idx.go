@@ -29,9 +29,12 @@ import (
 	"os"
 	"io"
 	"sync"
+	"time"
 
-	"bytes"
 	"encoding/binary"
+	"hash/crc32"
+	"hash/fnv"
+	"math"
 	ldb_errors "github.com/syndtr/goleveldb/leveldb/errors"
 	"github.com/syndtr/goleveldb/leveldb/opt"
 )
@@ -40,48 +43,154 @@ var (
 	EExist = errors.New("EExist")
 	ENotFound = ldb_errors.ErrNotFound
 	EOverSize = errors.New("EOverSize")
+	EMismatch = errors.New("EMismatch")
+	EChecksum = errors.New("EChecksum")
 )
 
 type Getter interface{
 	SetValue(f io.ReaderAt,off int64,lng int32) error
 }
+// Receives a BLOB as a section of its time-file. The section is only valid during SetSection.
+type SectionGetter interface{
+	SetSection(r *io.SectionReader) error
+}
 type Unwrapper_os_File interface {
 	Unwrap_os_File() *os.File
 }
 
-var readerPool = sync.Pool{New: func() interface{} { return new(bytes.Reader) }}
-func reclaimReader(b *bytes.Reader) {
-	b.Reset(nil)
-	readerPool.Put(b)
+// The position of a BLOB: the time-file, the offset within the time-file and the length.
+type Header struct{
+	FileID uint64
+	Offset int64
+	Length int64
 }
 
+const (
+	putInsert = iota
+	putOverwrite
+	putCAS
+)
+
+// The mode of Store.Put.
+type PutMode struct{
+	kind int
+	prev Header
+}
+var (
+	PutInsert = PutMode{kind:putInsert}       // Fails with EExist, if the key exists.
+	PutOverwrite = PutMode{kind:putOverwrite} // Inserts or replaces the BLOB.
+)
+/*
+Replaces the BLOB, only if it is still located at prev. Put fails with EMismatch, if the BLOB has been
+replaced in the meantime, and with ENotFound, if the key does not exist.
+*/
+func PutCompareAndSwap(prev Header) PutMode { return PutMode{kind:putCAS,prev:prev} }
+
+/*
+The index entry of a BLOB. Two formats are in use:
+
+	legacy (20 bytes): FileID(8) Offset(8) Length(4)
+	v2     (26 bytes): Version(1)=2 FileID(8) Offset(8) Length(4) Flags(1) Checksum(4)
+	v3     (30 bytes): Version(1)=3 FileID(8) Offset(8) Length(8) Flags(1) Checksum(4)
+
+All fields are big endian. Entries without a checksum are written in the legacy format,
+entries longer than 2 GiB in the v3 format.
+*/
 type storeHeader struct{
 	FileID uint64
 	Offset int64
-	Length int32
+	Length int64
+	HasSum bool
+	Sum    uint32 // CRC32C of the BLOB, if HasSum
 }
+
+const (
+	headerLegacyLen = 20
+	headerV2 = 2
+	headerV2Len = 26
+	headerV3 = 3
+	headerV3Len = 30
+	hfChecksum = 1
+)
+
 func (s *storeHeader) decode(b []byte) error {
-	r := readerPool.Get().(*bytes.Reader)
-	r.Reset(b)
-	defer reclaimReader(r)
-	return binary.Read(r,binary.BigEndian,s)
+	var tail []byte
+	*s = storeHeader{}
+	switch {
+	case len(b)==headerLegacyLen:
+		s.Length = int64(int32(binary.BigEndian.Uint32(b[16:])))
+	case len(b)==headerV2Len && b[0]==headerV2:
+		b = b[1:]
+		s.Length = int64(int32(binary.BigEndian.Uint32(b[16:])))
+		tail = b[20:]
+	case len(b)==headerV3Len && b[0]==headerV3:
+		b = b[1:]
+		s.Length = int64(binary.BigEndian.Uint64(b[16:]))
+		tail = b[24:]
+	default:
+		return ECorrupted
+	}
+	s.FileID = binary.BigEndian.Uint64(b)
+	s.Offset = int64(binary.BigEndian.Uint64(b[8:]))
+	if len(tail)==5 {
+		s.HasSum = (tail[0]&hfChecksum)!=0
+		s.Sum = binary.BigEndian.Uint32(tail[1:])
+	}
+	return nil
+}
+func (s storeHeader) header() Header {
+	return Header{s.FileID,s.Offset,s.Length}
 }
 func (s storeHeader) encode() []byte {
-	var buf bytes.Buffer
-	binary.Write(&buf,binary.BigEndian,s)
-	return buf.Bytes()
+	var b []byte
+	switch {
+	case s.Length>math.MaxInt32:
+		b = make([]byte,headerV3Len)
+		b[0] = headerV3
+		binary.BigEndian.PutUint64(b[17:],uint64(s.Length))
+		b[25] = byte(s.flags())
+		binary.BigEndian.PutUint32(b[26:],s.Sum)
+	case s.HasSum:
+		b = make([]byte,headerV2Len)
+		b[0] = headerV2
+		binary.BigEndian.PutUint32(b[17:],uint32(s.Length))
+		b[21] = byte(s.flags())
+		binary.BigEndian.PutUint32(b[22:],s.Sum)
+	default:
+		b = make([]byte,headerLegacyLen+1)
+		binary.BigEndian.PutUint32(b[17:],uint32(s.Length))
+	}
+	binary.BigEndian.PutUint64(b[1:],s.FileID)
+	binary.BigEndian.PutUint64(b[9:],uint64(s.Offset))
+	if b[0]==0 { b = b[1:] }
+	return b
+}
+func (s storeHeader) flags() (f int) {
+	if s.HasSum { f |= hfChecksum }
+	return
 }
 
 type AutoExpire struct{}
 func (AutoExpire) Retain(b []byte) bool {
 	var s storeHeader
 	if s.decode(b)!=nil { return true }
-	return s.FileID < current
+	return s.FileID >= current
+}
+func (AutoExpire) RetainKV(k, b []byte, now time.Time) bool {
+	var s storeHeader
+	if s.decode(b)!=nil { return true }
+	return s.FileID >= uint64(now.Unix())
+}
+func (AutoExpire) ExpireAt(k, b []byte) (time.Time,bool) {
+	var s storeHeader
+	if s.decode(b)!=nil { return time.Time{},false }
+	return time.Unix(int64(s.FileID)+1,0),true
 }
 
 
 type iFile struct{
 	*os.File
+	id     uint64
 	length int64
 	lock sync.Mutex
 }
@@ -115,12 +224,40 @@ func (i *iFile) Append(b []byte) (int64,error) {
 	return cur,nil
 }
 
+/*
+Reserves n bytes at the end of the file, that are written later, without holding the lock.
+*/
+func (i *iFile) Reserve(n, max int64) (int64,error) {
+	i.lock.Lock(); defer i.lock.Unlock()
+	cur := i.length
+	nwl := cur + n
+	if max>0 && nwl>max { return 0,EOverSize }
+	i.length = nwl
+	return cur,nil
+}
+/*
+Reverts a reservation. This is only possible, if the file has not grown since.
+Returns false, if the reserved range remains.
+*/
+func (i *iFile) Unreserve(off, n int64) bool {
+	i.lock.Lock(); defer i.lock.Unlock()
+	if i.length!=off+n { return false }
+	i.Truncate(off) // Revert growth, if any!
+	i.length = off
+	return true
+}
+
 type Store struct{
 	Alloc *Allocator
 	DB    *leveldb.DB
 	MaxSizePerFile int64 // Maximum file size or 0
 	MaxDayOffset   int   // Maximum days of later expiration
+	Checksum       bool  // Store a CRC32C of every BLOB, verified on Get
+	Durability     Durability
+	GroupCommit    time.Duration // Interval of DurabilityGroup, or 0 for default
 	files cCache
+	group groupCommit
+	klocks [64]sync.Mutex
 }
 func (s *Store) getfile(k interface{}) Releaser {
 	fn := s.Alloc.GetPath(k.(uint64))
@@ -128,6 +265,7 @@ func (s *Store) getfile(k interface{}) Releaser {
 	if e!=nil { return nil }
 	r := new(iFile)
 	r.File = f
+	r.id = k.(uint64)
 	r.length,e = f.Seek(0,2)
 	if e!=nil {
 		f.Close()
@@ -135,6 +273,11 @@ func (s *Store) getfile(k interface{}) Releaser {
 	}
 	return r
 }
+func (s *Store) keyLock(k []byte) *sync.Mutex {
+	h := fnv.New32a()
+	h.Write(k)
+	return &s.klocks[h.Sum32()%uint32(len(s.klocks))]
+}
 func (s *Store) Init(size int) {
 	if size<=0 { size = 1024 }
 	s.files.init(size,s.getfile)
@@ -151,26 +294,88 @@ func (s *Store) CleanupInstance() {
 
 var wopt = &opt.WriteOptions{ Sync:false, }
 
-var bufferPool = sync.Pool{ New: func() interface{} { return new(bytes.Buffer) } }
-func reclaimBuffer(b *bytes.Buffer) {
-	b.Reset()
-	bufferPool.Put(b)
+// Inserts a BLOB. Returns EExist, if the key already exists. Equivalent to Put(k,v,expireAt,PutInsert).
+func (s *Store) Insert(k, v []byte, expireAt uint64) error {
+	return s.Put(k, v, expireAt, PutInsert)
+}
+
+/*
+Stores a BLOB under the given key according to the mode. Puts, Inserts and Deletes of the same key
+are serialized, so that concurrent inserts of the same key cannot both succeed.
+
+If an existing BLOB is replaced, it is accounted as dead space of its time-file.
+*/
+func (s *Store) Put(k, v []byte, expireAt uint64, mode PutMode) error {
+	var sum uint32
+	if s.Checksum { sum = crc32.Checksum(v,castagnoli) }
+	return s.put(k, int64(len(v)), expireAt, mode, func(f *iFile, max int64) (int64,uint32,error) {
+		pos,err := f.AppendMz(v,max)
+		return pos,sum,err
+	})
 }
 
-func (s *Store) indexPut(k []byte, v storeHeader, o *opt.WriteOptions) error {
-	buf := bufferPool.Get().(*bytes.Buffer)
-	defer reclaimBuffer(buf)
-	binary.Write(buf,binary.BigEndian,v)
-	return s.DB.Put(k,buf.Bytes(),o)
+/*
+Inserts a BLOB of the given size, that is read from r. Unlike Insert, the BLOB is not buffered
+in memory, so BLOBs larger than 2 GiB can be stored. Returns EExist, if the key already exists,
+and io.ErrUnexpectedEOF, if r ends before size bytes are read.
+*/
+func (s *Store) InsertFrom(k []byte, r io.Reader, size int64, expireAt uint64) error {
+	return s.put(k, size, expireAt, PutInsert, func(f *iFile, max int64) (int64,uint32,error) {
+		return s.stream(f,r,size,max)
+	})
 }
-func (s *Store) Insert(k, v []byte, expireAt uint64) error {
-	return s.insert_2(k, v, expireAt)
+
+// Appends a BLOB to the time-file and returns its offset and checksum.
+type appender func(f *iFile, max int64) (int64,uint32,error)
+
+func (s *Store) put(k []byte, size int64, expireAt uint64, mode PutMode, app appender) error {
+	l := s.keyLock(k)
+	l.Lock(); defer l.Unlock()
+	
+	old,ok,err := s.lookup(k)
+	if err!=nil { return err }
+	switch mode.kind {
+	case putInsert:
+		if ok { return EExist }
+	case putCAS:
+		if !ok { return ENotFound }
+		if old.header()!=mode.prev { return EMismatch }
+	}
+	
+	p,ce,err := s.insert_2(size, expireAt, app)
+	if err!=nil { return err }
+	defer ce.release()
+	err = s.commit(ce.value.(*iFile),func(b *leveldb.Batch){ b.Put(k,p.encode()) })
+	if err!=nil { return err }
+	if !ok { return nil }
+	return s.addDead(old)
 }
 
-func (s *Store) insert_2(k, v []byte, expireAt uint64) error {
+// Copies the BLOB from r into a reserved range of the time-file.
+func (s *Store) stream(f *iFile, r io.Reader, size, max int64) (int64,uint32,error) {
+	pos,err := f.Reserve(size,max)
+	if err!=nil { return 0,0,err }
+	buf := copyPool.Get().([]byte)
+	defer copyPool.Put(buf)
+	h := crc32.New(castagnoli)
+	var w io.Writer = io.NewOffsetWriter(f.File,pos)
+	if s.Checksum { w = io.MultiWriter(w,h) }
+	n,err := io.CopyBuffer(w,io.LimitReader(r,size),buf)
+	if err==nil && n<size { err = io.ErrUnexpectedEOF }
+	if err!=nil {
+		// If other BLOBs have been appended in the meantime, the range remains as dead space.
+		if !f.Unreserve(pos,size) { s.Alloc.AddDeadBytes(f.id,size) }
+		return 0,0,err
+	}
+	return pos,h.Sum32(),nil
+}
+
+/*
+Appends the BLOB to a suitable time-file. The time-file is returned, and must be released by the caller.
+*/
+func (s *Store) insert_2(size int64, expireAt uint64, app appender) (storeHeader,*cElement,error) {
+	if s.MaxSizePerFile>0 && size>s.MaxSizePerFile { return storeHeader{},nil,EOverSize }
 	
-	ok,err := s.DB.Has(k,nil)
-	if ok && err==nil { return EExist }
 	tfn,err := s.Alloc.AllocateTimeFile(expireAt)
 	nExp := expireAt
 	
@@ -179,15 +384,15 @@ func (s *Store) insert_2(k, v []byte, expireAt uint64) error {
 	
 	for {
 		
-		if err!=nil { return err }
+		if err!=nil { return storeHeader{},nil,err }
 		ce := s.files.get(tfn)
-		if ce==nil { return EFalse }
+		if ce==nil { return storeHeader{},nil,EFalse }
 		defer ce.release()
-		pos,err := ce.value.(*iFile).AppendMz(v,s.MaxSizePerFile)
+		pos,sum,err := app(ce.value.(*iFile),s.MaxSizePerFile)
 		if err==EOverSize {
 			for {
-				if cnt>128 { return err } /* Limit the iterations! */
-				if dayoff>s.MaxDayOffset { return err } /* Maximum day-offset reached! */
+				if cnt>128 { return storeHeader{},nil,err } /* Limit the iterations! */
+				if dayoff>s.MaxDayOffset { return storeHeader{},nil,err } /* Maximum day-offset reached! */
 				tfn,err = s.Alloc.GrabAnotherFile(nExp,tfn)
 				cnt++
 				if err==EOptionsExhausted {
@@ -198,33 +403,107 @@ func (s *Store) insert_2(k, v []byte, expireAt uint64) error {
 					dayoff++
 					continue
 				}
-				if err!=nil { return err }
+				if err!=nil { return storeHeader{},nil,err }
 				break
 			}
 			continue
 		}
-		if err!=nil { return err }
+		if err!=nil { return storeHeader{},nil,err }
 		
-		//return s.DB.Put(k,storeHeader{tfn,pos,int32(len(v))}.encode(),wopt)
-		return s.indexPut(k,storeHeader{tfn,pos,int32(len(v))},wopt)
+		ce.acquire()
+		return storeHeader{FileID:tfn,Offset:pos,Length:size,HasSum:s.Checksum,Sum:sum},ce,nil
 	}
 	panic("unreachable")
 }
 
+// Looks up the index entry of the key. ok is false, if the key does not exist.
+func (s *Store) lookup(k []byte) (p storeHeader,ok bool,err error) {
+	pos,err := s.DB.Get(k,nil)
+	if err==ENotFound { return p,false,nil }
+	if err!=nil { return }
+	err = p.decode(pos)
+	ok = err==nil
+	return
+}
+
+// Accounts the BLOB as dead space of its time-file.
+func (s *Store) addDead(p storeHeader) error {
+	// Entries of expired time-files are not accounted, their files are going to be swept anyway.
+	if p.FileID<current { return nil }
+	return s.Alloc.AddDeadBytes(p.FileID,p.Length)
+}
+
+/*
+Returns the position of the BLOB stored under the given key.
+The result can be used with PutCompareAndSwap.
+*/
+func (s *Store) Stat(key []byte) (Header,error) {
+	p,ok,err := s.lookup(key)
+	if err!=nil { return Header{},err }
+	if !ok { return Header{},ENotFound }
+	return p.header(),nil
+}
+
+/*
+Reads the BLOB stored under the given key. If the BLOB has a checksum, it is verified first,
+and EChecksum is returned on a mismatch. Returns EOverSize, if the BLOB is larger than 2 GiB;
+such BLOBs can only be read using GetSection.
+*/
 func (s *Store) Get(key []byte, value Getter) error {
+	return s.get(key,func(f *iFile, p storeHeader) error {
+		if p.Length>math.MaxInt32 { return EOverSize }
+		if p.HasSum {
+			err := p.verify(f)
+			if err!=nil { return err }
+		}
+		return value.SetValue(f,p.Offset,int32(p.Length))
+	})
+}
+
+/*
+Reads the BLOB stored under the given key as a section of its time-file.
+Unlike Get, the checksum is not verified, as the section is usually read partially.
+Use Scrub to verify whole BLOBs.
+*/
+func (s *Store) GetSection(key []byte, value SectionGetter) error {
+	return s.get(key,func(f *iFile, p storeHeader) error {
+		return value.SetSection(io.NewSectionReader(f,p.Offset,p.Length))
+	})
+}
+
+func (s *Store) get(key []byte, fn func(f *iFile, p storeHeader) error) error {
 	//defer s.CleanupInstance()
 	pos,err := s.DB.Get(key,nil)
 	if err!=nil { return err }
 	var p storeHeader
 	err = p.decode(pos)
 	if err!=nil { return err }
-	if p.FileID < current { return ldb_errors.ErrNotFound }
 	
 	ce := s.files.get(p.FileID)
 	if ce==nil { return EFalse }
 	defer ce.release()
 	
-	return value.SetValue(ce.value.(*iFile),p.Offset,p.Length)
+	return fn(ce.value.(*iFile),p)
 }
 
+/*
+Deletes the BLOB stored under the given key. The key is removed from the index, so that
+Get returns ENotFound and the key can be re-inserted. The space occupied by the BLOB is not
+freed, but accounted as dead space of its time-file until the time-file expires.
+Returns ENotFound, if the key does not exist.
+*/
+func (s *Store) Delete(key []byte) error {
+	l := s.keyLock(key)
+	l.Lock(); defer l.Unlock()
+	p,ok,err := s.lookup(key)
+	if err!=nil { return err }
+	if !ok { return ENotFound }
+	err = s.commit(nil,func(b *leveldb.Batch){ b.Delete(key) })
+	if err!=nil { return err }
+	return s.addDead(p)
+}
 
+// Returns the number of deleted bytes (dead space) per time-file.
+func (s *Store) DeadSpace() (map[uint64]int64,error) {
+	return s.Alloc.DeadSpace()
+}
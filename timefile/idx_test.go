@@ -0,0 +1,72 @@
+package timefile
+
+import (
+	"io"
+	"testing"
+)
+
+type bytesGetter struct{ b []byte }
+
+func (g *bytesGetter) SetValue(f io.ReaderAt, off int64, lng int32) error {
+	g.b = make([]byte, lng)
+	_, err := f.ReadAt(g.b, off)
+	return err
+}
+
+func openTestStore(t *testing.T, dir string, o *Options) *Store {
+	s, err := OpenStore(dir, o)
+	if err != nil {
+		t.Fatal("OpenStore: got error: ", err)
+	}
+	return s
+}
+
+// Closes the store without syncing the time-files or marking it as closed cleanly.
+func crashStore(s *Store) {
+	s.files.purge()
+	s.DB.Close()
+	s.Alloc.DB.Close()
+}
+
+func getValue(t *testing.T, s *Store, key string, want string) {
+	g := new(bytesGetter)
+	if err := s.Get([]byte(key), g); err != nil {
+		t.Fatalf("Get %q: got error: %v", key, err)
+	}
+	if string(g.b) != want {
+		t.Fatalf("Get %q: want %q, got %q", key, want, g.b)
+	}
+}
+
+func getError(t *testing.T, s *Store, key string, want error) {
+	if err := s.Get([]byte(key), new(bytesGetter)); err != want {
+		t.Fatalf("Get %q: want %v, got %v", key, want, err)
+	}
+}
+
+func TestAutoExpire(t *testing.T) {
+	live := storeHeader{FileID: current + 3600, Length: 1}.encode()
+	dead := storeHeader{FileID: current - 3600, Length: 1}.encode()
+	var ae AutoExpire
+	if !ae.Retain(live) || ae.Retain(dead) {
+		t.Fatal("Retain: want the entries of unexpired time-files retained only")
+	}
+	if !ae.Retain([]byte("garbage")) {
+		t.Fatal("Retain: want undecodable entries retained")
+	}
+	if at, ok := ae.ExpireAt(nil, live); !ok || uint64(at.Unix()) != current+3601 {
+		t.Fatalf("ExpireAt: got %v %v", at, ok)
+	}
+
+	// Entries of expired time-files are hidden from readers.
+	s := openTestStore(t, t.TempDir(), nil)
+	defer s.Close()
+	if err := s.DB.Put([]byte("dead"), dead, nil); err != nil {
+		t.Fatal(err)
+	}
+	getError(t, s, "dead", ENotFound)
+	if err := s.Insert([]byte("dead"), []byte("v"), current+3600); err != nil {
+		t.Fatal("Insert over an expired entry: got error: ", err)
+	}
+	getValue(t, s, "dead", "v")
+}
@@ -42,10 +42,11 @@ Facts:
  - Unlike badger or WiscKey, timefile is not designed for SSDs
  - Timefile is designed to store millions (!) of GB
  - With timefile, you can not overwrite BLOBs.
- - With timefile, you can not delete BLOBs¹.
+ - With timefile, you can delete BLOBs only logically¹.
 
-¹ The ability to "delete" BLOBs by deleting their keys is planned, but freeing space by deleting
-BLOBs prior to their expiration won't be supported!
+¹ BLOBs are "deleted" by deleting their keys (see Store.Delete). Freeing space by deleting
+BLOBs prior to their expiration won't be supported! Instead, the deleted bytes are accounted
+as dead space per time-file (see Store.DeadSpace), until the time-file expires.
 
 Timefile utilizes two different embedded key-value databases: bolt, a LMDB-workalike written in Go
 (github.com/boltdb/bolt) and LevelDB-go (github.com/syndtr/goleveldb/leveldb) with modifications
@@ -67,6 +68,7 @@ import (
 
 	"github.com/syndtr/goleveldb/leveldb/opt"
 	"path/filepath"
+	"time"
 )
 
 type Options struct{
@@ -75,8 +77,18 @@ type Options struct{
 	Files int           // Approximate number of open files, or 0 for default.
 	MaxSizePerFile int64 // Maximum file size or 0
 	MaxDayOffset   int   // Maximum days of later expiration
+	Checksum       bool  // Store a CRC32C of every BLOB, verified on Get
+	Durability     Durability
+	GroupCommit    time.Duration // Interval of DurabilityGroup, or 0 for default
+	SkipRecovery   bool          // Don't check the index against the time-files after an unclean shutdown
 }
 
+// Entries refering expired time-files are hidden from readers as well.
+var idxConfig = &leveldb.Config{Expiration: leveldb.Expiration{AutoExpire: AutoExpire{}, OnRead: true}}
+
+// The index is synced, unless the durability mode is DurabilityNone.
+var syncIndex = &opt.Options{}
+
 var defOptions = Options{
 	Index: &opt.Options{
 		NoSync: true,
@@ -91,13 +103,18 @@ func OpenStore(base string,opt *Options) (*Store,error){
 	
 	if opt!=nil { lopt = *opt }
 	
-	if lopt.Index==nil { lopt.Index = defOptions.Index }
+	if lopt.Index==nil {
+		lopt.Index = defOptions.Index
+		if lopt.Durability!=DurabilityNone { lopt.Index = syncIndex }
+	}
+	
+	clean := takeCleanMarker(base)
 	
 	b,e := bolt.Open(alloc,0644, lopt.Alloc)
 	if e!=nil { return nil,e }
-	l,e := leveldb.OpenFile(index, lopt.Index, AutoExpire{})
+	l,e := leveldb.OpenFileWithConfig(index, lopt.Index, idxConfig)
 	if errors.IsCorrupted(e) {
-		l,e = leveldb.RecoverFile(index, lopt.Index, AutoExpire{})
+		l,e = leveldb.RecoverFileWithConfig(index, lopt.Index, idxConfig)
 	}
 	if e!=nil { return nil,e }
 	
@@ -107,8 +124,20 @@ func OpenStore(base string,opt *Options) (*Store,error){
 	s.DB = l
 	s.MaxSizePerFile = lopt.MaxSizePerFile
 	s.MaxDayOffset   = lopt.MaxDayOffset
+	s.Checksum       = lopt.Checksum
+	s.Durability     = lopt.Durability
+	s.GroupCommit    = lopt.GroupCommit
 	s.Init(lopt.Files)
 	
+	if !clean && !lopt.SkipRecovery {
+		_,e = s.Recover()
+		if e!=nil {
+			l.Close()
+			b.Close()
+			return nil,e
+		}
+	}
+	
 	return s,e
 }
 
@@ -0,0 +1,108 @@
+package timefile
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestStore_PutModes(t *testing.T) {
+	s := openTestStore(t, t.TempDir(), nil)
+	defer s.Close()
+	exp := current + 3600
+
+	if err := s.Put([]byte("k"), []byte("abc"), exp, PutInsert); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Put([]byte("k"), []byte("xyz"), exp, PutInsert); err != EExist {
+		t.Fatalf("PutInsert of an existing key: want %v, got %v", EExist, err)
+	}
+	getValue(t, s, "k", "abc")
+
+	if err := s.Put([]byte("k"), []byte("defg"), exp, PutOverwrite); err != nil {
+		t.Fatal(err)
+	}
+	getValue(t, s, "k", "defg")
+	if err := s.Put([]byte("n"), []byte("new"), exp, PutOverwrite); err != nil {
+		t.Fatal("PutOverwrite of a new key: got error: ", err)
+	}
+	getValue(t, s, "n", "new")
+
+	// The replaced BLOB is dead space.
+	h, _ := s.Stat([]byte("k"))
+	if n, _ := s.Alloc.DeadBytes(h.FileID); n != 3 {
+		t.Fatalf("DeadBytes: want 3, got %d", n)
+	}
+}
+
+func TestStore_PutCompareAndSwap(t *testing.T) {
+	s := openTestStore(t, t.TempDir(), nil)
+	defer s.Close()
+	exp := current + 3600
+
+	if err := s.Put([]byte("k"), []byte("x"), exp, PutCompareAndSwap(Header{})); err != ENotFound {
+		t.Fatalf("CAS of a missing key: want %v, got %v", ENotFound, err)
+	}
+	s.Insert([]byte("k"), []byte("v1"), exp)
+	h1, err := s.Stat([]byte("k"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Put([]byte("k"), []byte("v2"), exp, PutCompareAndSwap(h1)); err != nil {
+		t.Fatal("CAS: got error: ", err)
+	}
+	getValue(t, s, "k", "v2")
+
+	// The BLOB has been replaced since h1 was taken.
+	if err := s.Put([]byte("k"), []byte("v3"), exp, PutCompareAndSwap(h1)); err != EMismatch {
+		t.Fatalf("CAS conflict: want %v, got %v", EMismatch, err)
+	}
+	getValue(t, s, "k", "v2")
+}
+
+func TestStore_PutConcurrent(t *testing.T) {
+	s := openTestStore(t, t.TempDir(), nil)
+	defer s.Close()
+	exp := current + 3600
+
+	// Of concurrent inserts of the same key, exactly one succeeds.
+	var wins int32
+	var wg sync.WaitGroup
+	for i := 0; i < 32; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			err := s.Insert([]byte("k"), []byte(fmt.Sprint("v", i)), exp)
+			if err == nil {
+				atomic.AddInt32(&wins, 1)
+			} else if err != EExist {
+				t.Error(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	if wins != 1 {
+		t.Fatalf("want one successful insert, got %d", wins)
+	}
+
+	// Of concurrent compare-and-swaps against the same header, exactly one succeeds.
+	h, _ := s.Stat([]byte("k"))
+	wins = 0
+	for i := 0; i < 32; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			err := s.Put([]byte("k"), []byte(fmt.Sprint("w", i)), exp, PutCompareAndSwap(h))
+			if err == nil {
+				atomic.AddInt32(&wins, 1)
+			} else if err != EMismatch {
+				t.Error(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	if wins != 1 {
+		t.Fatalf("want one successful compare-and-swap, got %d", wins)
+	}
+}
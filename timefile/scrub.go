@@ -0,0 +1,94 @@
+/*
+Copyright (c) 2018 Simon Schmidt
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+
+package timefile
+
+import (
+	"context"
+	"hash/crc32"
+	"io"
+	"sync"
+)
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+var copyPool = sync.Pool{ New: func() interface{} { return make([]byte,32<<10) } }
+
+// Verifies the checksum of the BLOB. Returns EChecksum, if it does not match.
+func (s storeHeader) verify(r io.ReaderAt) error {
+	buf := copyPool.Get().([]byte)
+	defer copyPool.Put(buf)
+	h := crc32.New(castagnoli)
+	n,err := io.CopyBuffer(h,io.NewSectionReader(r,s.Offset,s.Length),buf)
+	if err!=nil { return err }
+	if n!=s.Length || h.Sum32()!=s.Sum { return EChecksum }
+	return nil
+}
+
+// The result of Store.Scrub.
+type ScrubReport struct{
+	Keys      int   // Number of live keys
+	Unchecked int   // Number of BLOBs without a checksum
+	Bytes     int64 // Number of bytes verified
+	Errors    []ScrubError
+}
+
+// A BLOB, that failed verification.
+type ScrubError struct{
+	Key    []byte
+	Header Header
+	Err    error // EChecksum, ECorrupted or the I/O error
+}
+
+/*
+Walks all live keys and verifies the checksums of their BLOBs. BLOBs stored without a checksum
+are counted, but not verified. Mismatches are reported, not returned as error.
+*/
+func (s *Store) Scrub(ctx context.Context) (*ScrubReport,error) {
+	rep := new(ScrubReport)
+	iter := s.DB.NewIterator(nil,nil)
+	defer iter.Release()
+	for iter.Next() {
+		if err := ctx.Err(); err!=nil { return rep,err }
+		rep.Keys++
+		var p storeHeader
+		err := p.decode(iter.Value())
+		if err==nil {
+			if !p.HasSum { rep.Unchecked++; continue }
+			err = s.scrubOne(p)
+		}
+		if err!=nil {
+			key := append([]byte(nil),iter.Key()...)
+			rep.Errors = append(rep.Errors,ScrubError{key,p.header(),err})
+			continue
+		}
+		rep.Bytes += p.Length
+	}
+	return rep,iter.Error()
+}
+func (s *Store) scrubOne(p storeHeader) error {
+	ce := s.files.get(p.FileID)
+	if ce==nil { return EFalse }
+	defer ce.release()
+	return p.verify(ce.value.(*iFile))
+}
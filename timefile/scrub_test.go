@@ -0,0 +1,118 @@
+package timefile
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"os"
+	"testing"
+)
+
+func TestStoreHeader(t *testing.T) {
+	// A header as written by older versions.
+	var legacy bytes.Buffer
+	binary.Write(&legacy, binary.BigEndian, struct {
+		FileID uint64
+		Offset int64
+		Length int32
+	}{7, 1 << 40, 1000})
+	var v3 bytes.Buffer
+	binary.Write(&v3, binary.BigEndian, struct {
+		Version uint8
+		FileID  uint64
+		Offset  int64
+		Length  int64
+		Flags   uint8
+		Sum     uint32
+	}{3, 7, 1 << 40, 1 << 32, 1, 42})
+
+	for _, c := range []struct {
+		name string
+		b    []byte
+		want storeHeader
+	}{
+		{"legacy", legacy.Bytes(), storeHeader{FileID: 7, Offset: 1 << 40, Length: 1000}},
+		{"v2", append([]byte{2}, append(legacy.Bytes(), 1, 0xca, 0xfe, 0xba, 0xbe)...),
+			storeHeader{FileID: 7, Offset: 1 << 40, Length: 1000, HasSum: true, Sum: 0xcafebabe}},
+		{"v3", v3.Bytes(), storeHeader{FileID: 7, Offset: 1 << 40, Length: 1 << 32, HasSum: true, Sum: 42}},
+	} {
+		var p storeHeader
+		if err := p.decode(c.b); err != nil || p != c.want {
+			t.Errorf("%s: want %+v, got %+v %v", c.name, c.want, p, err)
+		}
+		if b := c.want.encode(); !bytes.Equal(b, c.b) {
+			t.Errorf("%s: encode: want %x, got %x", c.name, c.b, b)
+		}
+	}
+
+	// Long BLOBs without checksum use the v3 format as well.
+	p := storeHeader{FileID: 7, Offset: 1 << 40, Length: 1 << 32}
+	var q storeHeader
+	if b := p.encode(); len(b) != headerV3Len || q.decode(b) != nil || q != p {
+		t.Errorf("v3 round trip: want %+v, got %+v (%d bytes)", p, q, len(b))
+	}
+
+	for _, b := range [][]byte{nil, make([]byte, 19), make([]byte, headerV2Len), append([]byte{9}, make([]byte, headerV3Len-1)...)} {
+		var p storeHeader
+		if err := p.decode(b); err != ECorrupted {
+			t.Errorf("decode %x: want %v, got %v", b, ECorrupted, err)
+		}
+	}
+}
+
+func corruptBlob(t *testing.T, s *Store, key string) {
+	h, err := s.Stat([]byte(key))
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.OpenFile(s.Alloc.GetPath(h.FileID), os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := f.WriteAt([]byte("X"), h.Offset); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStore_Checksum(t *testing.T) {
+	s := openTestStore(t, t.TempDir(), &Options{Checksum: true})
+	defer s.Close()
+	exp := current + 3600
+
+	s.Checksum = false
+	s.Insert([]byte("old"), []byte("unchecked"), exp)
+	if v, _ := s.DB.Get([]byte("old"), nil); len(v) != headerLegacyLen {
+		t.Fatalf("want a legacy header without checksum, got %d bytes", len(v))
+	}
+	s.Checksum = true
+	s.Insert([]byte("a"), []byte("hello"), exp)
+	s.Insert([]byte("b"), []byte("world"), exp)
+	if v, _ := s.DB.Get([]byte("a"), nil); len(v) != headerV2Len {
+		t.Fatalf("want a v2 header, got %d bytes", len(v))
+	}
+	getValue(t, s, "a", "hello")
+	getValue(t, s, "old", "unchecked")
+
+	corruptBlob(t, s, "b")
+	corruptBlob(t, s, "old")
+	getError(t, s, "b", EChecksum)
+	getValue(t, s, "old", "Xnchecked")
+
+	rep, err := s.Scrub(context.Background())
+	if err != nil {
+		t.Fatal("Scrub: got error: ", err)
+	}
+	if rep.Keys != 3 || rep.Unchecked != 1 || rep.Bytes != 5 || len(rep.Errors) != 1 {
+		t.Fatalf("Scrub: got %+v", rep)
+	}
+	if e := rep.Errors[0]; string(e.Key) != "b" || e.Err != EChecksum || e.Header.Length != 5 {
+		t.Fatalf("Scrub: got %+v", e)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := s.Scrub(ctx); err != context.Canceled {
+		t.Fatalf("Scrub: want %v, got %v", context.Canceled, err)
+	}
+}
@@ -0,0 +1,114 @@
+package timefile
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"strings"
+	"testing"
+)
+
+type getterFunc func(r *io.SectionReader) error
+
+func (f getterFunc) SetSection(r *io.SectionReader) error { return f(r) }
+
+func readSection(t *testing.T, s *Store, key string) []byte {
+	var b []byte
+	err := s.GetSection([]byte(key), getterFunc(func(r *io.SectionReader) (err error) {
+		b, err = io.ReadAll(r)
+		return
+	}))
+	if err != nil {
+		t.Fatalf("GetSection %q: got error: %v", key, err)
+	}
+	return b
+}
+
+func TestStore_InsertFrom(t *testing.T) {
+	for _, checksum := range []bool{false, true} {
+		s := openTestStore(t, t.TempDir(), &Options{Checksum: checksum})
+		exp := current + 3600
+
+		data := bytes.Repeat([]byte("0123456789"), 10000)
+		if err := s.InsertFrom([]byte("a"), bytes.NewReader(data), int64(len(data)), exp); err != nil {
+			t.Fatal("InsertFrom: got error: ", err)
+		}
+		if err := s.InsertFrom([]byte("a"), bytes.NewReader(data), int64(len(data)), exp); err != EExist {
+			t.Fatalf("InsertFrom of an existing key: want %v, got %v", EExist, err)
+		}
+		if err := s.InsertFrom([]byte("b"), strings.NewReader("short"), 10, exp); err != io.ErrUnexpectedEOF {
+			t.Fatalf("InsertFrom of a short reader: want %v, got %v", io.ErrUnexpectedEOF, err)
+		}
+		getError(t, s, "b", ENotFound)
+		if b := readSection(t, s, "a"); !bytes.Equal(b, data) {
+			t.Fatalf("checksum=%v: GetSection: got %d bytes", checksum, len(b))
+		}
+		getValue(t, s, "a", string(data))
+
+		// The reservation of the failed insert has been reverted.
+		s.Insert([]byte("c"), []byte("small"), exp)
+		if h, _ := s.Stat([]byte("c")); h.Offset != int64(len(data)) {
+			t.Fatalf("want the next BLOB at %d, got %d", len(data), h.Offset)
+		}
+		if m, _ := s.DeadSpace(); len(m) != 0 {
+			t.Fatalf("want no dead space, got %v", m)
+		}
+		s.Close()
+	}
+}
+
+func TestStore_LargeBlob(t *testing.T) {
+	s := openTestStore(t, t.TempDir(), nil)
+	defer s.Close()
+	exp := current + 3600
+
+	// A sparse time-file holding a BLOB of 5 GiB at 5 GiB.
+	const off, size = 5 << 30, 5 << 30
+	fi, err := s.Alloc.AllocateTimeFile(exp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Create(s.Alloc.GetPath(fi))
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteAt([]byte("begin"), off)
+	_, err = f.WriteAt([]byte("end!"), off+size-4)
+	f.Close()
+	if err != nil {
+		t.Skip("no support for large sparse files: ", err)
+	}
+	if err := s.DB.Put([]byte("big"), storeHeader{FileID: fi, Offset: off, Length: size}.encode(), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if h, err := s.Stat([]byte("big")); err != nil || h.Offset != off || h.Length != size {
+		t.Fatalf("Stat: got %+v %v", h, err)
+	}
+	getError(t, s, "big", EOverSize)
+	err = s.GetSection([]byte("big"), getterFunc(func(r *io.SectionReader) error {
+		b := make([]byte, 5)
+		if r.Size() != size {
+			t.Fatalf("GetSection: want %d bytes, got %d", int64(size), r.Size())
+		}
+		if _, err := r.ReadAt(b, 0); err != nil || string(b) != "begin" {
+			t.Fatalf("GetSection: got %q %v at the start", b, err)
+		}
+		if _, err := r.ReadAt(b[:4], size-4); err != nil || string(b[:4]) != "end!" {
+			t.Fatalf("GetSection: got %q %v at the end", b[:4], err)
+		}
+		return nil
+	}))
+	if err != nil {
+		t.Fatal("GetSection: got error: ", err)
+	}
+
+	// Further BLOBs are appended beyond 4 GiB.
+	if err := s.InsertFrom([]byte("next"), strings.NewReader("next"), 4, exp); err != nil {
+		t.Fatal(err)
+	}
+	if h, _ := s.Stat([]byte("next")); h.FileID != fi || h.Offset != off+size {
+		t.Fatalf("want the BLOB at %d, got %+v", int64(off+size), h)
+	}
+	getValue(t, s, "next", "next")
+}
@@ -32,6 +32,7 @@ import (
 )
 
 var allocator = []byte("alloc")
+var deadbytes = []byte("dead")
 
 const secDay uint64 = 60*60*24
 
@@ -97,6 +98,7 @@ func (a *Allocator) erase(bkt *bolt.Bucket,n int) {
 		}
 		fi := binary.BigEndian.Uint64(k)
 		if fi<current {
+			if dead := bkt.Tx().Bucket(deadbytes); dead!=nil { dead.Delete(k) }
 			cur.Delete()
 			k,_ = cur.Next()
 			os.Remove(filepath.Join(a.Path,ts2fn(fi))) // Also remove the file.
@@ -232,4 +234,73 @@ func (a *Allocator) Comb() {
 	}
 }
 
+/*
+Adds n bytes to the dead space of the time-file fi. The dead space is the number of bytes
+of a time-file, that are occupied by deleted BLOBs.
+*/
+func (a *Allocator) AddDeadBytes(fi uint64, n int64) error {
+	return a.DB.Batch(func(tx *bolt.Tx) error {
+		var key,val [8]byte
+		binary.BigEndian.PutUint64(key[:],fi)
+		bkt,err := tx.CreateBucketIfNotExists(deadbytes)
+		if err!=nil { return err }
+		total := n
+		if v := bkt.Get(key[:]); len(v)==8 { total += int64(binary.BigEndian.Uint64(v)) }
+		binary.BigEndian.PutUint64(val[:],uint64(total))
+		return bkt.Put(key[:],val[:])
+	})
+}
+
+// Returns the dead space of the time-file fi in bytes.
+func (a *Allocator) DeadBytes(fi uint64) (n int64,err error) {
+	err = a.DB.View(func(tx *bolt.Tx) error {
+		var key [8]byte
+		binary.BigEndian.PutUint64(key[:],fi)
+		bkt := tx.Bucket(deadbytes)
+		if bkt==nil { return nil }
+		if v := bkt.Get(key[:]); len(v)==8 { n = int64(binary.BigEndian.Uint64(v)) }
+		return nil
+	})
+	return
+}
 
+// Returns the dead space of all unexpired time-files, that carry deleted BLOBs.
+func (a *Allocator) DeadSpace() (m map[uint64]int64,err error) {
+	m = make(map[uint64]int64)
+	err = a.DB.View(func(tx *bolt.Tx) error {
+		bkt := tx.Bucket(deadbytes)
+		if bkt==nil { return nil }
+		return bkt.ForEach(func(k, v []byte) error {
+			if len(k)<8 || len(v)<8 { return nil }
+			fi := binary.BigEndian.Uint64(k)
+			if fi<current { return nil }
+			m[fi] = int64(binary.BigEndian.Uint64(v))
+			return nil
+		})
+	})
+	return
+}
+
+// Syncs all unexpired time-files.
+func (a *Allocator) SyncFiles() error {
+	var fis []uint64
+	err := a.DB.View(func(tx *bolt.Tx) error {
+		bkt := tx.Bucket(allocator)
+		if bkt==nil { return nil }
+		return bkt.ForEach(func(k, v []byte) error {
+			if len(k)<8 { return nil }
+			if fi := binary.BigEndian.Uint64(k); fi>=current { fis = append(fis,fi) }
+			return nil
+		})
+	})
+	if err!=nil { return err }
+	for _,fi := range fis {
+		f,e := os.OpenFile(a.GetPath(fi),os.O_RDWR,0)
+		if os.IsNotExist(e) { continue }
+		if e!=nil { return e }
+		e = f.Sync()
+		f.Close()
+		if e!=nil { return e }
+	}
+	return nil
+}